@@ -0,0 +1,225 @@
+// gRPC contract for the long-polling service, served on GRPC_ADDR.
+//
+// The RPCs map onto the HTTP endpoints and share the same subscriber and
+// upstream machinery. Regenerate the Go stubs with `make proto`.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: api/longpoll/v1/longpoll.proto
+
+package longpollv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	LongPoll_GetAccessToken_FullMethodName = "/longpoll.v1.LongPoll/GetAccessToken"
+	LongPoll_GetUpdates_FullMethodName     = "/longpoll.v1.LongPoll/GetUpdates"
+	LongPoll_StreamUpdates_FullMethodName  = "/longpoll.v1.LongPoll/StreamUpdates"
+)
+
+// LongPollClient is the client API for LongPoll service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LongPollClient interface {
+	// Issues a JWT for one or more channels, like POST /getAccessToken
+	GetAccessToken(ctx context.Context, in *GetAccessTokenRequest, opts ...grpc.CallOption) (*GetAccessTokenResponse, error)
+	// Holds until events are available or the poll times out, like GET /getUpdates
+	GetUpdates(ctx context.Context, in *GetUpdatesRequest, opts ...grpc.CallOption) (*GetUpdatesResponse, error)
+	// Streams events as they arrive, resuming from the given offsets
+	StreamUpdates(ctx context.Context, in *GetUpdatesRequest, opts ...grpc.CallOption) (LongPoll_StreamUpdatesClient, error)
+}
+
+type longPollClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLongPollClient(cc grpc.ClientConnInterface) LongPollClient {
+	return &longPollClient{cc}
+}
+
+func (c *longPollClient) GetAccessToken(ctx context.Context, in *GetAccessTokenRequest, opts ...grpc.CallOption) (*GetAccessTokenResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetAccessTokenResponse)
+	err := c.cc.Invoke(ctx, LongPoll_GetAccessToken_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *longPollClient) GetUpdates(ctx context.Context, in *GetUpdatesRequest, opts ...grpc.CallOption) (*GetUpdatesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUpdatesResponse)
+	err := c.cc.Invoke(ctx, LongPoll_GetUpdates_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *longPollClient) StreamUpdates(ctx context.Context, in *GetUpdatesRequest, opts ...grpc.CallOption) (LongPoll_StreamUpdatesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LongPoll_ServiceDesc.Streams[0], LongPoll_StreamUpdates_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &longPollStreamUpdatesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LongPoll_StreamUpdatesClient interface {
+	Recv() (*GetUpdatesResponse, error)
+	grpc.ClientStream
+}
+
+type longPollStreamUpdatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *longPollStreamUpdatesClient) Recv() (*GetUpdatesResponse, error) {
+	m := new(GetUpdatesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LongPollServer is the server API for LongPoll service.
+// All implementations must embed UnimplementedLongPollServer
+// for forward compatibility
+type LongPollServer interface {
+	// Issues a JWT for one or more channels, like POST /getAccessToken
+	GetAccessToken(context.Context, *GetAccessTokenRequest) (*GetAccessTokenResponse, error)
+	// Holds until events are available or the poll times out, like GET /getUpdates
+	GetUpdates(context.Context, *GetUpdatesRequest) (*GetUpdatesResponse, error)
+	// Streams events as they arrive, resuming from the given offsets
+	StreamUpdates(*GetUpdatesRequest, LongPoll_StreamUpdatesServer) error
+	mustEmbedUnimplementedLongPollServer()
+}
+
+// UnimplementedLongPollServer must be embedded to have forward compatible implementations.
+type UnimplementedLongPollServer struct {
+}
+
+func (UnimplementedLongPollServer) GetAccessToken(context.Context, *GetAccessTokenRequest) (*GetAccessTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAccessToken not implemented")
+}
+func (UnimplementedLongPollServer) GetUpdates(context.Context, *GetUpdatesRequest) (*GetUpdatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUpdates not implemented")
+}
+func (UnimplementedLongPollServer) StreamUpdates(*GetUpdatesRequest, LongPoll_StreamUpdatesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamUpdates not implemented")
+}
+func (UnimplementedLongPollServer) mustEmbedUnimplementedLongPollServer() {}
+
+// UnsafeLongPollServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LongPollServer will
+// result in compilation errors.
+type UnsafeLongPollServer interface {
+	mustEmbedUnimplementedLongPollServer()
+}
+
+func RegisterLongPollServer(s grpc.ServiceRegistrar, srv LongPollServer) {
+	s.RegisterService(&LongPoll_ServiceDesc, srv)
+}
+
+func _LongPoll_GetAccessToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAccessTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LongPollServer).GetAccessToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LongPoll_GetAccessToken_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LongPollServer).GetAccessToken(ctx, req.(*GetAccessTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LongPoll_GetUpdates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUpdatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LongPollServer).GetUpdates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LongPoll_GetUpdates_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LongPollServer).GetUpdates(ctx, req.(*GetUpdatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LongPoll_StreamUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetUpdatesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LongPollServer).StreamUpdates(m, &longPollStreamUpdatesServer{ServerStream: stream})
+}
+
+type LongPoll_StreamUpdatesServer interface {
+	Send(*GetUpdatesResponse) error
+	grpc.ServerStream
+}
+
+type longPollStreamUpdatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *longPollStreamUpdatesServer) Send(m *GetUpdatesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// LongPoll_ServiceDesc is the grpc.ServiceDesc for LongPoll service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LongPoll_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "longpoll.v1.LongPoll",
+	HandlerType: (*LongPollServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetAccessToken",
+			Handler:    _LongPoll_GetAccessToken_Handler,
+		},
+		{
+			MethodName: "GetUpdates",
+			Handler:    _LongPoll_GetUpdates_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamUpdates",
+			Handler:       _LongPoll_StreamUpdates_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/longpoll/v1/longpoll.proto",
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/engine"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+)
+
+// benchEventType marks the synthetic events published by the benchmark
+const benchEventType = "longpoll.bench"
+
+// benchStats collects the outcome of every poll
+type benchStats struct {
+	mu        sync.Mutex
+	polls     []time.Duration
+	latencies []time.Duration
+	events    int
+	published int
+	errors    map[string]int
+}
+
+func (s *benchStats) poll(d time.Duration, latencies []time.Duration, events int) {
+	s.mu.Lock()
+	s.polls = append(s.polls, d)
+	s.latencies = append(s.latencies, latencies...)
+	s.events += events
+	s.mu.Unlock()
+}
+
+func (s *benchStats) fail(reason string) {
+	s.mu.Lock()
+	s.errors[reason]++
+	s.mu.Unlock()
+}
+
+// runBench holds -clients polls open against a running instance for
+// -duration and reports poll and delivery latency percentiles. With -rate it
+// also publishes synthetic events through Redis; the target serves them from
+// its push buffer, so it needs PUSH_BUFFER_SIZE > 0.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	target := fs.String("url", "", "base URL of the instance (default: derived from HTTP_ADDR)")
+	clients := fs.Int("clients", 100, "number of simulated polling clients")
+	channels := fs.Int("channels", 10, "number of channels the clients are spread over")
+	prefix := fs.String("channel-prefix", "bench-", "prefix of the channel IDs")
+	duration := fs.Duration("duration", 30*time.Second, "how long to run")
+	wait := fs.Int("wait", -1, "poll wait in seconds (default: the server's POLL_TIMEOUT)")
+	rate := fs.Float64("rate", 0, "synthetic events published per second over all channels (0 publishes none)")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *clients < 1 || *channels < 1 {
+		return errors.New("-clients and -channels must be at least 1")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	service, err := engine.NewJWTService(cfg)
+	if err != nil {
+		return err
+	}
+
+	baseURL := *target
+	if baseURL == "" {
+		baseURL = "http://127.0.0.1" + portOf(cfg.HTTPAddr) + strings.TrimRight(cfg.HTTPBasePath, "/")
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	// Event IDs above anything Laravel has issued, so the first poll of
+	// each channel waits for the published events
+	firstID := time.Now().UnixMicro()
+
+	channelIDs := make([]string, *channels)
+	tokens := make([]string, *channels)
+	for i := range channelIDs {
+		channelIDs[i] = *prefix + strconv.Itoa(i)
+		if tokens[i], err = service.GenerateToken(channelIDs[i]); err != nil {
+			return err
+		}
+	}
+
+	stats := &benchStats{errors: make(map[string]int)}
+	httpClient := &nethttp.Client{Transport: &nethttp.Transport{
+		MaxIdleConns:        *clients,
+		MaxIdleConnsPerHost: *clients,
+	}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < *clients; i++ {
+		channel := i % *channels
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchClient(ctx, httpClient, baseURL, channelIDs[channel], tokens[channel], firstID, *wait, stats)
+		}()
+	}
+
+	if *rate > 0 {
+		logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+		client, err := provideRedisClient(cfg, logger)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		publisher := redis.NewSubscriber(client, cfg.RedisChannel, 0, 0, nil, nil, nil, logger)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			benchPublish(ctx, publisher, channelIDs, firstID, *rate, stats)
+		}()
+	}
+
+	started := time.Now()
+	wg.Wait()
+
+	report := benchReport(stats, time.Since(started), *clients, *channels)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printBenchReport(report)
+	return nil
+}
+
+// benchClient polls one channel until ctx is done, continuing from each
+// response's next_offset like a real client
+func benchClient(ctx context.Context, client *nethttp.Client, baseURL, channelID, token string, offset int64, wait int, stats *benchStats) {
+	for ctx.Err() == nil {
+		query := url.Values{
+			"token":   {token},
+			"channel": {channelID},
+			"offset":  {strconv.FormatInt(offset, 10)},
+		}
+		if wait >= 0 {
+			query.Set("wait", strconv.Itoa(wait))
+		}
+		req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, baseURL+"/getUpdates?"+query.Encode(), nil)
+		if err != nil {
+			stats.fail("request")
+			return
+		}
+		req.Header.Set("Accept", "application/json")
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				stats.fail("network")
+				time.Sleep(100 * time.Millisecond)
+			}
+			continue
+		}
+
+		var body struct {
+			Events     []core.Event `json:"events"`
+			NextOffset int64        `json:"next_offset"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		received := time.Now()
+
+		switch {
+		case ctx.Err() != nil:
+			// Cut short by the end of the run, not a result
+		case resp.StatusCode != nethttp.StatusOK:
+			stats.fail(strconv.Itoa(resp.StatusCode))
+			time.Sleep(100 * time.Millisecond)
+		case err != nil:
+			stats.fail("invalid_response")
+		default:
+			var latencies []time.Duration
+			for _, event := range body.Events {
+				if sentAt, ok := benchSentAt(event); ok {
+					latencies = append(latencies, received.Sub(sentAt))
+				}
+			}
+			stats.poll(received.Sub(start), latencies, len(body.Events))
+			if body.NextOffset > offset {
+				offset = body.NextOffset
+			}
+		}
+	}
+}
+
+// benchSentAt returns when a synthetic event was published
+func benchSentAt(event core.Event) (time.Time, bool) {
+	if eventType, _ := event.Event["type"].(string); eventType != benchEventType {
+		return time.Time{}, false
+	}
+	sentAt, ok := event.Event["sent_at"].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(int64(sentAt)), true
+}
+
+// benchPublish publishes events carried in their notifications, round-robin
+// over the channels, at rate per second
+func benchPublish(ctx context.Context, publisher *redis.Subscriber, channelIDs []string, firstID int64, rate float64, stats *benchStats) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	nextIDs := make([]int64, len(channelIDs))
+	for i := range nextIDs {
+		nextIDs[i] = firstID
+	}
+
+	for i := 0; ; i = (i + 1) % len(channelIDs) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		event := core.Event{
+			ID: nextIDs[i],
+			Event: map[string]interface{}{
+				"type":    benchEventType,
+				"sent_at": now.UnixMicro(),
+			},
+			CreatedAt: now.Unix(),
+		}
+		err := publisher.Publish(ctx, redis.EventNotification{
+			ChannelID: channelIDs[i],
+			EventID:   event.ID,
+			Timestamp: now.Unix(),
+			Events:    []core.Event{event},
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				stats.fail("publish")
+			}
+			continue
+		}
+		nextIDs[i]++
+
+		stats.mu.Lock()
+		stats.published++
+		stats.mu.Unlock()
+	}
+}
+
+// benchPercentiles summarizes durations in milliseconds
+type benchPercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+type benchResult struct {
+	Duration  string           `json:"duration"`
+	Clients   int              `json:"clients"`
+	Channels  int              `json:"channels"`
+	Polls     benchPercentiles `json:"polls"`
+	Delivery  benchPercentiles `json:"delivery"`
+	Events    int              `json:"events"`
+	Published int              `json:"published"`
+	Errors    map[string]int   `json:"errors"`
+}
+
+func benchReport(stats *benchStats, elapsed time.Duration, clients, channels int) benchResult {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	return benchResult{
+		Duration:  elapsed.Round(time.Millisecond).String(),
+		Clients:   clients,
+		Channels:  channels,
+		Polls:     percentiles(stats.polls),
+		Delivery:  percentiles(stats.latencies),
+		Events:    stats.events,
+		Published: stats.published,
+		Errors:    stats.errors,
+	}
+}
+
+func percentiles(durations []time.Duration) benchPercentiles {
+	if len(durations) == 0 {
+		return benchPercentiles{}
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(p float64) float64 {
+		i := int(p * float64(len(sorted)-1))
+		return float64(sorted[i].Microseconds()) / 1000
+	}
+	return benchPercentiles{
+		Count: len(sorted),
+		P50:   at(0.50),
+		P90:   at(0.90),
+		P99:   at(0.99),
+		Max:   at(1),
+	}
+}
+
+func printBenchReport(r benchResult) {
+	fmt.Printf("duration:   %s, %d clients on %d channels\n", r.Duration, r.Clients, r.Channels)
+	fmt.Printf("polls:      %d completed, %d events received\n", r.Polls.Count, r.Events)
+	fmt.Printf("poll time:  p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms\n", r.Polls.P50, r.Polls.P90, r.Polls.P99, r.Polls.Max)
+	if r.Published > 0 {
+		fmt.Printf("published:  %d events, %d deliveries\n", r.Published, r.Delivery.Count)
+		fmt.Printf("delivery:   p50 %.1fms  p90 %.1fms  p99 %.1fms  max %.1fms\n", r.Delivery.P50, r.Delivery.P90, r.Delivery.P99, r.Delivery.Max)
+	}
+	if len(r.Errors) > 0 {
+		reasons := make([]string, 0, len(r.Errors))
+		for reason := range r.Errors {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			fmt.Printf("errors:     %s x%d\n", reason, r.Errors[reason])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/engine"
+	"github.com/levskiy0/go-laravel-long-polling/internal/errreport"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+// runConfig handles "config check"
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errors.New("usage: config check [-connect] [-timeout 5s]")
+	}
+	return runConfigCheck(args[1:])
+}
+
+// runConfigCheck loads the configuration as the server would, runs every
+// validation, and with -connect also reaches Redis and Laravel. It prints one
+// line per check and fails if any did, for use as a pre-deploy gate.
+func runConfigCheck(args []string) error {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	connect := fs.Bool("connect", false, "also check that Redis and Laravel (or the event store) are reachable")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each connectivity check")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	failed := 0
+	report := func(name string, err error) {
+		if err == nil {
+			fmt.Printf("ok    %s\n", name)
+			return
+		}
+		failed++
+		var validation *config.ValidationError
+		if errors.As(err, &validation) {
+			fmt.Printf("FAIL  %s\n", name)
+			for _, problem := range validation.Problems {
+				fmt.Printf("      - %s\n", problem)
+			}
+			return
+		}
+		fmt.Printf("FAIL  %s: %v\n", name, err)
+	}
+
+	cfg, err := config.Load()
+	report("configuration", err)
+	if err != nil {
+		return errors.New("configuration is invalid")
+	}
+
+	mode := "laravel " + cfg.LaravelAddr
+	if cfg.Standalone() {
+		mode = "standalone"
+	}
+	fmt.Printf("      app_env=%s http_addr=%s redis_addr=%s mode=%s\n", cfg.AppEnv, cfg.HTTPAddr, cfg.RedisAddr, mode)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	_, err = engine.NewJWTService(cfg)
+	report("jwt", err)
+
+	_, err = errreport.NewReporter(cfg.ErrorReportingDSN, cfg.ErrorReportingEnv, time.Second, logger)
+	report("error reporting", err)
+
+	_, err = engine.NewSealer(cfg)
+	report("encryption", err)
+
+	if _, err = metrics.ParseOTelPairs(cfg.OTLPHeaders); err == nil {
+		_, err = metrics.ParseOTelPairs(cfg.OTelResourceAttrs)
+	}
+	report("otlp", err)
+
+	if cfg.RedisTLS {
+		_, err = redisTLSConfig(cfg)
+		report("redis tls", err)
+	}
+
+	if cfg.TenantsFile != "" {
+		_, err = loadTenants(cfg.TenantsFile)
+		report("tenants", err)
+	}
+
+	if *connect {
+		client, err := provideRedisClient(cfg, logger)
+		if err == nil {
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			err = client.Ping(ctx).Err()
+			cancel()
+		}
+		report("redis", err)
+
+		if client != nil {
+			cfg.HealthProbeTimeout = *timeout
+			probe := engine.NewProbe(cfg, client, logger)
+
+			name := "laravel"
+			if cfg.Standalone() {
+				name = "event store"
+			}
+			report(name, probeError(probe.Refresh(context.Background())))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	fmt.Println("configuration is valid")
+	return nil
+}
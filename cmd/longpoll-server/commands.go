@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/access"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/engine"
+)
+
+// subcommands are run instead of the server when named as the first argument
+var subcommands = map[string]func(args []string) error{
+	"demo":             runDemo,
+	"bench":            runBench,
+	"replay":           runReplay,
+	"config":           runConfig,
+	"--validate":       runConfigCheck,
+	"generate-token":   runGenerateToken,
+	"decode-token":     runDecodeToken,
+	"generate-api-key": runGenerateAPIKey,
+	"healthcheck":      runHealthcheck,
+}
+
+// runGenerateToken prints a token for the given channels, signed with the
+// configured JWT_SECRET
+func runGenerateToken(args []string) error {
+	fs := flag.NewFlagSet("generate-token", flag.ExitOnError)
+	channels := fs.String("channel", "", "comma-separated channel IDs to authorize")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var channelIDs []string
+	for _, channelID := range strings.Split(*channels, ",") {
+		if channelID = strings.TrimSpace(channelID); channelID != "" {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		return errors.New("-channel is required")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	service, err := engine.NewJWTService(cfg)
+	if err != nil {
+		return err
+	}
+
+	var token string
+	if len(channelIDs) == 1 {
+		token, err = service.GenerateToken(channelIDs[0])
+	} else {
+		token, err = service.GenerateMultiChannelToken(channelIDs)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(token)
+	return nil
+}
+
+// runGenerateAPIKey prints a random API key and the digest to configure it
+// under, so the key itself never has to be stored server-side
+func runGenerateAPIKey(args []string) error {
+	fs := flag.NewFlagSet("generate-api-key", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	key := "lp_" + base64.RawURLEncoding.EncodeToString(raw)
+
+	fmt.Println("key:   ", key)
+	fmt.Println("sha256:", access.HashAPIKey(key))
+	return nil
+}
+
+// runDecodeToken prints a token's header and claims and whether it is valid
+// for the configured JWT_SECRET
+func runDecodeToken(args []string) error {
+	fs := flag.NewFlagSet("decode-token", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: decode-token <token>")
+	}
+	token := fs.Arg(0)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("token must have three dot-separated parts")
+	}
+
+	output := make(map[string]interface{})
+	for i, name := range []string{"header", "claims"} {
+		raw, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return fmt.Errorf("invalid %s encoding: %w", name, err)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			return fmt.Errorf("invalid %s JSON: %w", name, err)
+		}
+		output[name] = decoded
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	service, err := engine.NewJWTService(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := service.ValidateToken(token); err != nil {
+		output["valid"] = false
+		output["error"] = err.Error()
+	} else {
+		output["valid"] = true
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(output)
+}
+
+// runHealthcheck queries /health (or /ready) of a running instance and fails
+// unless it answers 200. It is suitable as a container HEALTHCHECK.
+func runHealthcheck(args []string) error {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	target := fs.String("url", "", "base URL of the instance (default: derived from HTTP_ADDR)")
+	ready := fs.Bool("ready", false, "check /ready instead of /health")
+	deep := fs.Bool("deep", false, "also probe Laravel through /health?deep=1")
+	timeout := fs.Duration("timeout", 3*time.Second, "request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	client := &nethttp.Client{Timeout: *timeout}
+	baseURL := *target
+	if baseURL == "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		baseURL = "http://127.0.0.1" + portOf(cfg.HTTPAddr)
+		if socketPath, ok := strings.CutPrefix(cfg.HTTPAddr, "unix://"); ok {
+			baseURL = "http://unix"
+			client.Transport = &nethttp.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			}
+		}
+		baseURL += strings.TrimRight(cfg.HTTPBasePath, "/")
+	}
+
+	path := "/health"
+	if *ready {
+		path = "/ready"
+	} else if *deep {
+		path = "/health?deep=1"
+	}
+
+	resp, err := client.Get(strings.TrimRight(baseURL, "/") + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	fmt.Println(strings.TrimSpace(string(body)))
+	if resp.StatusCode != nethttp.StatusOK {
+		return fmt.Errorf("%s responded %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// portOf returns the ":port" part of a listen address such as ":8085"
+func portOf(addr string) string {
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		return ":" + port
+	}
+	return ":8085"
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/pkg/longpolltest/harness"
+)
+
+//go:embed demo/index.html
+var demoPage []byte
+
+// runDemo serves the whole stack on one port: the long-polling endpoints,
+// the mock Laravel upstream, the in-memory broker and a browser client
+func runDemo(args []string) error {
+	fs := flag.NewFlagSet("demo", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8085", "address to listen on")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *addr, err)
+	}
+	baseURL := "http://" + listener.Addr().String()
+
+	stack, err := harness.NewStack(harness.Options{
+		PollTimeout: 25 * time.Second,
+		UpstreamURL: baseURL,
+		Logger:      logger,
+	})
+	if err != nil {
+		return err
+	}
+	defer stack.Close()
+
+	mux := nethttp.NewServeMux()
+	mux.Handle("/api/long-polling/getEvents", stack.Upstream)
+	mux.HandleFunc("/demo/token", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		channelID := r.URL.Query().Get("channel_id")
+		if channelID == "" {
+			channelID = "demo"
+		}
+		token, err := stack.Token(channelID)
+		if err != nil {
+			nethttp.Error(w, err.Error(), nethttp.StatusInternalServerError)
+			return
+		}
+		writeDemoJSON(w, map[string]string{"token": token, "channel_id": channelID})
+	})
+	mux.HandleFunc("/demo/publish", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Method != nethttp.MethodPost {
+			nethttp.Error(w, "method not allowed", nethttp.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ChannelID string                 `json:"channel_id"`
+			Payload   map[string]interface{} `json:"payload"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChannelID == "" {
+			nethttp.Error(w, "channel_id is required", nethttp.StatusBadRequest)
+			return
+		}
+		event := stack.Upstream.Push(req.ChannelID, req.Payload)
+		stack.Broker.Notify(req.ChannelID, event.ID)
+		writeDemoJSON(w, event)
+	})
+	mux.HandleFunc("/demo", func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(demoPage)
+	})
+	mux.Handle("/", stack.Handler)
+
+	server := &nethttp.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("demo running", "url", baseURL+"/demo")
+	if err := server.Serve(listener); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func writeDemoJSON(w nethttp.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
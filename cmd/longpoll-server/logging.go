@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/logging"
+	"github.com/levskiy0/go-laravel-long-polling/internal/systemd"
+	"go.uber.org/fx"
+)
+
+// provideLogOutput returns where logs are written, closing it when the
+// service stops
+func provideLogOutput(lc fx.Lifecycle, cfg *config.Config) (io.Writer, error) {
+	out, closeOutput, err := openLogOutput(cfg)
+	if err != nil {
+		return nil, err
+	}
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return closeOutput()
+		},
+	})
+	return out, nil
+}
+
+// logIdentifier tags entries sent to syslog and the journal
+const logIdentifier = "longpoll-server"
+
+// openLogOutput returns where LOG_OUTPUT sends logs. A file is rotated and
+// reopened on SIGHUP for external tools like logrotate. closeOutput stops
+// watching for SIGHUP and closes the file or connection.
+func openLogOutput(cfg *config.Config) (out io.Writer, closeOutput func() error, err error) {
+	switch cfg.LogOutput {
+	case "syslog":
+		w, err := logging.DialSyslog(cfg.LogSyslogAddr, logIdentifier)
+		if err != nil {
+			return nil, nil, err
+		}
+		return w, w.Close, nil
+	case "journald":
+		w, err := systemd.DialJournal(logIdentifier)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connect to journald: %w", err)
+		}
+		return w, w.Close, nil
+	case "file":
+	default:
+		return os.Stdout, func() error { return nil }, nil
+	}
+
+	file, err := logging.OpenRotatingFile(cfg.LogFilePath, int64(cfg.LogFileMaxSize)<<20, cfg.LogFileMaxAge, cfg.LogFileMaxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hangup:
+				// The logger writes to the file, so failures go to stderr
+				if err := file.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "reopen log file: %v\n", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return file, func() error {
+		signal.Stop(hangup)
+		close(done)
+		return file.Close()
+	}, nil
+}
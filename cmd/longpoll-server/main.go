@@ -2,186 +2,266 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"os"
 	"time"
 
-	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/buildinfo"
 	"github.com/levskiy0/go-laravel-long-polling/internal/config"
-	"github.com/levskiy0/go-laravel-long-polling/internal/core"
-	"github.com/levskiy0/go-laravel-long-polling/internal/http"
-	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+	"github.com/levskiy0/go-laravel-long-polling/internal/engine"
+	"github.com/levskiy0/go-laravel-long-polling/internal/errreport"
+	"github.com/levskiy0/go-laravel-long-polling/internal/logging"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+	"github.com/levskiy0/go-laravel-long-polling/internal/rpc"
+	"github.com/levskiy0/go-laravel-long-polling/internal/systemd"
 	goredis "github.com/redis/go-redis/v9"
 	"go.uber.org/fx"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.TenantsFile != "" {
+		if err := runTenants(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "tenants: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	app := fx.New(
-		fx.Provide(config.Load),
+		fx.StartTimeout(startTimeout(cfg)),
+		fx.Supply(cfg),
+		fx.Provide(provideLogOutput),
+		fx.Provide(provideLogLevel),
 		fx.Provide(provideLogger),
 		fx.Provide(provideRedisClient),
-		fx.Provide(provideJWTService),
-		fx.Provide(provideLaravelUpstreamPool),
-		fx.Provide(provideRedisSubscriber),
-		fx.Provide(provideHTTPHandlers),
-		fx.Provide(provideHTTPServer),
+		fx.Provide(provideErrorReporter),
+		fx.Provide(provideMetricsExporter),
+		fx.Provide(provideEngine),
+		fx.Provide(provideGRPCServer),
 		fx.Invoke(registerHooks),
 	)
 
 	app.Run()
 }
 
-func provideLogger(cfg *config.Config) *slog.Logger {
-	var handler slog.Handler
+// provideLogLevel holds the log level, which PUT /admin/loglevel changes at
+// runtime
+func provideLogLevel(cfg *config.Config) *slog.LevelVar {
+	level := new(slog.LevelVar)
+	level.Set(cfg.GetLogLevel())
+	return level
+}
 
+func provideLogger(cfg *config.Config, level *slog.LevelVar, out io.Writer) *slog.Logger {
 	opts := &slog.HandlerOptions{
-		Level: cfg.GetLogLevel(),
-	}
-
-	if cfg.LogFormat == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
-	} else {
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		Level: level,
 	}
+	handler := logging.NewHandler(out, cfg.LogFormat, opts)
 
-	return slog.New(handler)
+	return slog.New(logging.NewSamplingHandler(handler, cfg.LogSampleRate))
 }
 
-func provideRedisClient(cfg *config.Config, logger *slog.Logger) *goredis.Client {
-	client := goredis.NewClient(&goredis.Options{
+func provideRedisClient(cfg *config.Config, logger *slog.Logger) (*goredis.Client, error) {
+	opts := &goredis.Options{
 		Addr:     cfg.RedisAddr,
+		Username: cfg.RedisUsername,
 		Password: cfg.RedisPassword,
 		DB:       cfg.RedisDB,
-	})
+	}
+
+	if cfg.RedisTLS {
+		tlsConfig, err := redisTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
 
-	logger.Info("Redis client created", "addr", cfg.RedisAddr)
-	return client
+	client := goredis.NewClient(opts)
+
+	logger.Info("Redis client created", "addr", cfg.RedisAddr, "tls", cfg.RedisTLS)
+	return client, nil
+}
+
+// redisTLSConfig verifies the server against the system roots, or only
+// against REDIS_TLS_CA when set
+func redisTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if host, _, err := net.SplitHostPort(cfg.RedisAddr); err == nil {
+		tlsConfig.ServerName = host
+	}
+
+	if cfg.RedisTLSCA != "" {
+		pem, err := os.ReadFile(cfg.RedisTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read REDIS_TLS_CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA contains no PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
 }
 
-func provideJWTService(cfg *config.Config, logger *slog.Logger) (*auth.JWTService, error) {
-	service, err := auth.NewJWTService(cfg.JWTSecret, cfg.JWTExpiresIn, cfg.JWTAlgo)
+func provideErrorReporter(cfg *config.Config, logger *slog.Logger) (*errreport.Reporter, error) {
+	reporter, err := errreport.NewReporter(cfg.ErrorReportingDSN, cfg.ErrorReportingEnv, 5*time.Second, logger)
 	if err != nil {
 		return nil, err
 	}
-	logger.Info("JWT service created")
-	return service, nil
+	if reporter.Enabled() {
+		logger.Info("error reporting enabled", "environment", cfg.ErrorReportingEnv)
+	}
+	return reporter, nil
 }
 
-func provideLaravelUpstreamPool(cfg *config.Config, logger *slog.Logger) *core.LaravelUpstreamPool {
-	pool := core.NewLaravelUpstreamPool(
-		cfg.LaravelAddr,
-		cfg.AccessTokenSecret,
-		cfg.MaxLimit,
-		cfg.LaravelUpstreamWorkers,
-		cfg.LaravelRequestTimeout,
-		cfg.HTTPMaxIdleConns,
-		cfg.HTTPMaxConnsPerHost,
-		cfg.HTTPIdleConnTimeout,
-		logger,
-	)
-	logger.Info("Laravel upstream pool created",
-		"addr", cfg.LaravelAddr,
-		"workers", cfg.LaravelUpstreamWorkers,
-		"request_timeout", cfg.LaravelRequestTimeout,
-		"max_idle_conns", cfg.HTTPMaxIdleConns,
-		"max_conns_per_host", cfg.HTTPMaxConnsPerHost,
-	)
-	return pool
-}
+// provideMetricsExporter pushes metrics to an OTLP collector when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set; it is nil otherwise
+func provideMetricsExporter(cfg *config.Config, logger *slog.Logger) (*metrics.OTLPExporter, error) {
+	headers, err := metrics.ParseOTelPairs(cfg.OTLPHeaders)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %w", err)
+	}
+	resource, err := metrics.ParseOTelPairs(cfg.OTelResourceAttrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	resource["service.name"] = cfg.OTelServiceName
+	if _, ok := resource["service.version"]; !ok {
+		resource["service.version"] = buildinfo.Get().Version
+	}
 
-func provideRedisSubscriber(client *goredis.Client, cfg *config.Config, logger *slog.Logger) *redis.Subscriber {
-	subscriber := redis.NewSubscriber(client, cfg.RedisChannel, logger)
-	logger.Info("Redis subscriber created", "channel", cfg.RedisChannel)
-	return subscriber
+	exporter := metrics.NewOTLPExporter(metrics.Default, cfg.OTLPMetricsEndpoint, headers, resource, cfg.OTLPExportInterval, cfg.OTLPTimeout, logger)
+	if exporter != nil {
+		logger.Info("OTLP metrics export enabled", "endpoint", cfg.OTLPMetricsEndpoint, "interval", cfg.OTLPExportInterval)
+	}
+	return exporter, nil
 }
 
-func provideHTTPHandlers(
-	jwtService *auth.JWTService,
-	upstreamPool *core.LaravelUpstreamPool,
-	subscriber *redis.Subscriber,
+func provideEngine(
+	client *goredis.Client,
+	reporter *errreport.Reporter,
+	logLevel *slog.LevelVar,
+	logOutput io.Writer,
 	cfg *config.Config,
 	logger *slog.Logger,
-) *http.Handlers {
-	return http.NewHandlers(
-		jwtService,
-		upstreamPool,
-		subscriber,
-		cfg.AccessTokenSecret,
-		cfg.PollTimeout,
-		cfg.MaxLimit,
-		logger,
-	)
+) (*engine.Engine, error) {
+	return engine.New(cfg, engine.Options{
+		Redis:     client,
+		Logger:    logger,
+		LogLevel:  logLevel,
+		AccessLog: logOutput,
+		Reporter:  reporter,
+	})
 }
 
-func provideHTTPServer(
-	cfg *config.Config,
-	handlers *http.Handlers,
-	logger *slog.Logger,
-) *http.Server {
-	return http.NewServer(
-		cfg.HTTPAddr,
-		cfg.HTTPReadTimeout,
-		cfg.HTTPWriteTimeout,
-		handlers,
-		cfg,
-		logger,
-	)
+// provideGRPCServer serves the gRPC API through the engine's HTTP handler
+// when GRPC_ADDR is set; it is nil otherwise
+func provideGRPCServer(eng *engine.Engine, cfg *config.Config, logger *slog.Logger) *rpc.Server {
+	if cfg.GRPCAddr == "" {
+		return nil
+	}
+	return rpc.NewServer(cfg.GRPCAddr, eng.Server.Handler(), cfg.HTTPBasePath, logger)
 }
 
 func registerHooks(
 	lc fx.Lifecycle,
-	server *http.Server,
-	subscriber *redis.Subscriber,
+	eng *engine.Engine,
+	grpcServer *rpc.Server,
 	redisClient *goredis.Client,
+	exporter *metrics.OTLPExporter,
+	cfg *config.Config,
 	logger *slog.Logger,
 ) {
+	notifyCtx, cancelNotify := context.WithCancel(context.Background())
+
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
-			logger.Info("starting long-polling service")
+			build := buildinfo.Get()
+			logger.Info("starting long-polling service",
+				"version", build.Version,
+				"commit", build.Commit,
+				"build_date", build.Date,
+				"go_version", build.GoVersion,
+			)
 
-			// Start Redis subscriber with auto-reconnect
-			go func() {
-				backoff := time.Second
-				maxBackoff := time.Minute
-				for {
-					err := subscriber.Start(context.Background())
-					if err != nil && err != context.Canceled {
-						logger.Error("Redis subscriber stopped, reconnecting",
-							"error", err,
-							"retry_in", backoff)
-						time.Sleep(backoff)
-						// Exponential backoff
-						backoff *= 2
-						if backoff > maxBackoff {
-							backoff = maxBackoff
-						}
-					} else {
-						// Reset backoff on successful connection or graceful shutdown
-						backoff = time.Second
-						if err == context.Canceled {
-							logger.Info("Redis subscriber shutdown gracefully")
-							return
-						}
-					}
-				}
-			}()
+			if err := waitForDependencies(ctx, redisClient, eng.Probe, cfg, logger); err != nil {
+				return err
+			}
+			if err := eng.Load(ctx); err != nil {
+				return err
+			}
+
+			eng.Start(context.Background())
 
 			go func() {
-				if err := server.Start(); err != nil {
+				if err := eng.Server.Start(); err != nil {
 					logger.Error("HTTP server stopped", "error", err)
 				}
 			}()
+			if grpcServer != nil {
+				go func() {
+					if err := grpcServer.Start(); err != nil {
+						logger.Error("gRPC server stopped", "error", err)
+					}
+				}()
+			}
+
+			go notifySystemd(notifyCtx, eng.Server, eng.Subscriber, logger)
+			go exporter.Run(notifyCtx)
 
 			return nil
 		},
 		OnStop: func(ctx context.Context) error {
 			logger.Info("stopping long-polling service")
 
-			subscriber.Stop()
+			cancelNotify()
+			if _, err := systemd.Notify(systemd.Stopping); err != nil {
+				logger.Error("failed to notify systemd", "error", err)
+			}
+
+			// Hands channels over and answers waiting polls before the
+			// subscriber stops dispatching
+			eng.Stop()
 
-			if err := server.Stop(ctx); err != nil {
+			if grpcServer != nil {
+				if err := grpcServer.Stop(ctx); err != nil {
+					logger.Error("failed to stop gRPC server", "error", err)
+				}
+			}
+			if err := eng.Server.Stop(ctx); err != nil {
 				logger.Error("failed to stop HTTP server", "error", err)
 			}
+			if err := eng.Close(); err != nil {
+				logger.Error("failed to close recording or audit log", "error", err)
+			}
+
+			// Push the final counts, including the drained polls
+			if err := exporter.Export(ctx); err != nil {
+				logger.Error("failed to export metrics", "error", err)
+			}
 
 			if err := redisClient.Close(); err != nil {
 				logger.Error("failed to close Redis client", "error", err)
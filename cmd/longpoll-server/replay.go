@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/recording"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+)
+
+// runReplay re-publishes notifications recorded with RECORD_SINK through the
+// configured Redis, keeping their original spacing, so an incident can be
+// reproduced against a staging instance
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("file", "", "recording written with RECORD_SINK=file")
+	stream := fs.String("stream", "", "Redis stream written with RECORD_SINK=redis (default: RECORD_REDIS_KEY)")
+	speed := fs.Float64("speed", 1, "playback speed; 2 replays twice as fast, 0 without pauses")
+	from := fs.String("from", "", "skip notifications recorded before this RFC 3339 time")
+	until := fs.String("until", "", "stop at notifications recorded after this RFC 3339 time")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *speed < 0 {
+		return errors.New("-speed must not be negative")
+	}
+
+	var start, end time.Time
+	var err error
+	if *from != "" {
+		if start, err = time.Parse(time.RFC3339, *from); err != nil {
+			return fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if *until != "" {
+		if end, err = time.Parse(time.RFC3339, *until); err != nil {
+			return fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client, err := provideRedisClient(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	publisher := redis.NewSubscriber(client, cfg.RedisChannel, 0, 0, nil, nil, nil, logger)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var first time.Time
+	var started time.Time
+	published := 0
+	errDone := errors.New("reached -until")
+
+	replay := func(record recording.Record) error {
+		if !start.IsZero() && record.Time.Before(start) {
+			return nil
+		}
+		if !end.IsZero() && record.Time.After(end) {
+			return errDone
+		}
+
+		if first.IsZero() {
+			first, started = record.Time, time.Now()
+		} else if *speed > 0 {
+			due := started.Add(time.Duration(float64(record.Time.Sub(first)) / *speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err := publisher.Publish(ctx, record.Notification); err != nil {
+			return fmt.Errorf("failed to publish notification recorded at %s: %w", record.Time.Format(time.RFC3339Nano), err)
+		}
+		published++
+		return nil
+	}
+
+	if *file != "" {
+		err = recording.ReadFile(*file, replay)
+	} else {
+		key := *stream
+		if key == "" {
+			key = cfg.RecordRedisKey
+		}
+		err = recording.ReadStream(ctx, client, key, replay)
+	}
+
+	fmt.Printf("replayed %d notifications\n", published)
+	if errors.Is(err, errDone) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	goredis "github.com/redis/go-redis/v9"
+	"go.uber.org/fx"
+)
+
+// startupRetryInterval is the delay between dependency checks at startup
+const startupRetryInterval = time.Second
+
+// startTimeout gives fx room for the dependency wait on top of its default
+// start timeout
+func startTimeout(cfg *config.Config) time.Duration {
+	return fx.DefaultTimeout + cfg.StartupWaitTimeout
+}
+
+// waitForDependencies blocks until Redis answers and the upstream probe
+// succeeds, retrying for up to STARTUP_WAIT_TIMEOUT. When they are still down
+// it fails with STRICT_STARTUP and otherwise warns, so the service starts
+// degraded as it used to. A nil probe only waits for Redis.
+func waitForDependencies(ctx context.Context, client *goredis.Client, probe *core.UpstreamProbe, cfg *config.Config, logger *slog.Logger) error {
+	if cfg.StartupWaitTimeout <= 0 && !cfg.StrictStartup {
+		return nil
+	}
+
+	start := time.Now()
+	deadline := start.Add(cfg.StartupWaitTimeout)
+	for {
+		err := checkDependencies(ctx, client, probe, cfg.HealthProbeTimeout)
+		if err == nil {
+			logger.Info("dependencies reachable", "waited", time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if cfg.StrictStartup {
+				return fmt.Errorf("dependencies unavailable at startup: %w", err)
+			}
+			logger.Warn("starting with dependencies unavailable", "error", err, "waited", time.Since(start).Round(time.Millisecond))
+			return nil
+		}
+
+		logger.Info("waiting for dependencies", "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(startupRetryInterval, remaining)):
+		}
+	}
+}
+
+// checkDependencies pings Redis, then probes the upstream
+func checkDependencies(ctx context.Context, client *goredis.Client, probe *core.UpstreamProbe, timeout time.Duration) error {
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := client.Ping(pingCtx).Err(); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+
+	if probe == nil {
+		return nil
+	}
+	return probeError(probe.Refresh(ctx))
+}
+
+// probeError describes a failed upstream probe, or returns nil
+func probeError(result core.ProbeResult) error {
+	if result.OK {
+		return nil
+	}
+	if result.StatusCode != 0 {
+		return fmt.Errorf("upstream probe failed: %s %d", result.Reason, result.StatusCode)
+	}
+	return fmt.Errorf("upstream probe failed: %s", result.Reason)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/http"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+	"github.com/levskiy0/go-laravel-long-polling/internal/systemd"
+)
+
+// statusInterval is how often the Redis subscription state is checked for
+// the status line
+const statusInterval = time.Second
+
+// notifySystemd reports readiness once the HTTP server is listening, keeps
+// the status line in step with the Redis subscription, and pings the
+// watchdog for as long as the subscriber loop keeps making progress. Redis
+// being down doesn't hold back readiness: the service answers polls degraded
+// meanwhile.
+func notifySystemd(ctx context.Context, server *http.Server, subscriber *redis.Subscriber, logger *slog.Logger) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-server.Listening():
+	}
+
+	sent, err := systemd.Notify(systemd.Ready)
+	if err != nil {
+		logger.Error("failed to notify systemd", "error", err)
+		return
+	}
+	if !sent {
+		return
+	}
+	logger.Info("notified systemd of readiness")
+
+	healthy := subscriber.Healthy()
+	notifyStatus(healthy, logger)
+
+	status := time.NewTicker(statusInterval)
+	defer status.Stop()
+
+	// A nil channel never fires when the watchdog is off
+	var watchdog <-chan time.Time
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-status.C:
+			if now := subscriber.Healthy(); now != healthy {
+				healthy = now
+				notifyStatus(healthy, logger)
+			}
+		case <-watchdog:
+			if !subscriber.Alive() {
+				logger.Warn("subscriber loop is stuck, skipping watchdog ping")
+				continue
+			}
+			if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				logger.Error("failed to ping systemd watchdog", "error", err)
+			}
+		}
+	}
+}
+
+// notifyStatus sets the status line to the Redis subscription state
+func notifyStatus(healthy bool, logger *slog.Logger) {
+	text := "Serving; Redis subscription down, reconnecting"
+	if healthy {
+		text = "Serving"
+	}
+	if _, err := systemd.Notify(systemd.Status(text)); err != nil {
+		logger.Error("failed to notify systemd", "error", err)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	nethttp "net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/buildinfo"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/pkg/longpoll"
+)
+
+// AppIDHeader selects the tenant of a request in multi-tenant mode
+const AppIDHeader = "X-App-Id"
+
+// tenantConfig is one entry of TENANTS_FILE. Settings left empty fall back
+// to the environment.
+type tenantConfig struct {
+	AppID                string `json:"app_id"`
+	JWTSecret            string `json:"jwt_secret"`
+	AccessTokenSecret    string `json:"access_token_secret"`
+	AdminSecret          string `json:"admin_secret"`
+	LaravelAddr          string `json:"laravel_addr"`
+	RedisChannel         string `json:"redis_channel"`
+	PollTimeout          string `json:"poll_timeout"`
+	MaxLimit             int    `json:"max_limit"`
+	MaxPollersPerChannel int    `json:"max_pollers_per_channel"`
+	MaxWaitingPolls      int    `json:"max_waiting_polls"`
+
+	// Secrets accepted as well while the tenant's secrets are rotated
+	AccessTokenSecretNext string   `json:"access_token_secret_next"`
+	JWTSecretNext         string   `json:"jwt_secret_next"`
+	JWTKeys               []string `json:"jwt_keys"`
+	JWTActiveKID          string   `json:"jwt_active_kid"`
+}
+
+func loadTenants(path string) ([]tenantConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenants: %w", err)
+	}
+
+	var tenants []tenantConfig
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("failed to parse tenants: %w", err)
+	}
+
+	seen := make(map[string]bool, len(tenants))
+	for _, tenant := range tenants {
+		if tenant.AppID == "" {
+			return nil, errors.New("every tenant needs an app_id")
+		}
+		if seen[tenant.AppID] {
+			return nil, fmt.Errorf("duplicate tenant %q", tenant.AppID)
+		}
+		if tenant.JWTSecret == "" || tenant.AccessTokenSecret == "" {
+			return nil, fmt.Errorf("tenant %q needs its own jwt_secret and access_token_secret", tenant.AppID)
+		}
+		seen[tenant.AppID] = true
+	}
+	if len(tenants) == 0 {
+		return nil, errors.New("no tenants configured")
+	}
+	return tenants, nil
+}
+
+// tenantRouter dispatches requests to the engine of their tenant, resolved
+// from the X-App-Id header, the app_id query parameter or the token's
+// app_id claim
+type tenantRouter struct {
+	tenants map[string]nethttp.Handler
+}
+
+func (t *tenantRouter) ServeHTTP(w nethttp.ResponseWriter, r *nethttp.Request) {
+	handler, ok := t.tenants[resolveAppID(r)]
+	if !ok {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(nethttp.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]string{"code": "unknown_app", "message": "Unknown app"},
+		})
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+func resolveAppID(r *nethttp.Request) string {
+	if appID := r.Header.Get(AppIDHeader); appID != "" {
+		return appID
+	}
+	if appID := r.URL.Query().Get("app_id"); appID != "" {
+		return appID
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return auth.PeekAppID(token)
+	}
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+		return auth.PeekAppID(token)
+	}
+	return ""
+}
+
+// runTenants serves one isolated engine per tenant of TENANTS_FILE on
+// HTTP_ADDR. Each tenant gets its own JWT and access secrets, Laravel
+// upstream, notification channel, limits and Redis key namespace.
+func runTenants(cfg *config.Config) error {
+	out, closeOutput, err := openLogOutput(cfg)
+	if err != nil {
+		return err
+	}
+	defer closeOutput()
+
+	level := provideLogLevel(cfg)
+	logger := provideLogger(cfg, level, out)
+
+	tenants, err := loadTenants(cfg.TenantsFile)
+	if err != nil {
+		return err
+	}
+	if cfg.GRPCAddr != "" {
+		logger.Warn("GRPC_ADDR is ignored in multi-tenant mode")
+	}
+
+	client, err := provideRedisClient(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Tenants have their own Laravel apps, so only Redis is waited for
+	if err := waitForDependencies(ctx, client, nil, cfg, logger); err != nil {
+		return err
+	}
+
+	router := &tenantRouter{tenants: make(map[string]nethttp.Handler, len(tenants))}
+	for _, tenant := range tenants {
+		var pollTimeout time.Duration
+		if tenant.PollTimeout != "" {
+			if pollTimeout, err = time.ParseDuration(tenant.PollTimeout); err != nil {
+				return fmt.Errorf("tenant %q: invalid poll_timeout: %w", tenant.AppID, err)
+			}
+		}
+
+		prefix := "longpoll:" + tenant.AppID + ":"
+		redisChannel := tenant.RedisChannel
+		if redisChannel == "" {
+			redisChannel = prefix + "events"
+		}
+
+		server, err := longpoll.New(longpoll.Options{
+			LoadEnv:              true,
+			LogLevel:             level,
+			AccessLog:            out,
+			Redis:                client,
+			AppID:                tenant.AppID,
+			KeyPrefix:            prefix,
+			LaravelAddr:          tenant.LaravelAddr,
+			AccessTokenSecret:    tenant.AccessTokenSecret,
+			JWTSecret:            tenant.JWTSecret,
+			AdminSecret:          tenant.AdminSecret,
+			RedisChannel:         redisChannel,
+			ControlChannel:       prefix + "control",
+			PollTimeout:          pollTimeout,
+			MaxLimit:             tenant.MaxLimit,
+			MaxPollersPerChannel: tenant.MaxPollersPerChannel,
+			MaxWaitingPolls:      tenant.MaxWaitingPolls,
+			Logger:               logger.With("app_id", tenant.AppID),
+
+			// Tenant secrets are required, so these replace the environment's
+			AccessTokenSecretNext: tenant.AccessTokenSecretNext,
+			JWTSecretNext:         tenant.JWTSecretNext,
+			JWTKeys:               tenant.JWTKeys,
+			JWTActiveKID:          tenant.JWTActiveKID,
+		})
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", tenant.AppID, err)
+		}
+		server.Start(ctx)
+		defer server.Stop()
+
+		router.tenants[tenant.AppID] = server.Handler()
+		logger.Info("tenant started", "app_id", tenant.AppID, "redis_channel", redisChannel)
+	}
+
+	httpServer := &nethttp.Server{
+		Addr:         cfg.HTTPAddr,
+		Handler:      router,
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	build := buildinfo.Get()
+	logger.Info("serving tenants",
+		"addr", cfg.HTTPAddr,
+		"count", len(tenants),
+		"version", build.Version,
+		"commit", build.Commit,
+		"build_date", build.Date,
+	)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, nethttp.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
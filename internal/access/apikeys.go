@@ -0,0 +1,149 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/redis/go-redis/v9"
+)
+
+// APIKeys authenticates machine clients with long-lived keys instead of
+// tokens. Keys are only known by their SHA-256 hex digest, each mapped to
+// the channel patterns (path.Match globs) it may access. A nil *APIKeys
+// knows no key.
+type APIKeys struct {
+	mu   sync.RWMutex
+	keys map[string][]string
+}
+
+// NewAPIKeys creates an empty key set
+func NewAPIKeys() *APIKeys {
+	return &APIKeys{keys: make(map[string][]string)}
+}
+
+// HashAPIKey returns the digest under which a key is configured
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Validate returns claims granting the channels of key, if it is known.
+// The claims' ID identifies the key without revealing it.
+func (k *APIKeys) Validate(key string) (*auth.Claims, bool) {
+	if k == nil || key == "" {
+		return nil, false
+	}
+
+	hash := HashAPIKey(key)
+	k.mu.RLock()
+	patterns, ok := k.keys[hash]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	// Keys are revoked by removing them, so channel revocations, which
+	// cover tokens issued before them, never apply
+	claims := &auth.Claims{}
+	claims.ID = "key:" + hash[:16]
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, `*?[\`) {
+			claims.Patterns = append(claims.Patterns, pattern)
+		} else {
+			claims.Channels = append(claims.Channels, pattern)
+		}
+	}
+	return claims, true
+}
+
+// Replace swaps the keys for a new digest → patterns map. Digests are
+// matched case-insensitively and keys without patterns are dropped.
+func (k *APIKeys) Replace(keys map[string][]string) {
+	copied := make(map[string][]string, len(keys))
+	for hash, patterns := range keys {
+		if len(patterns) > 0 {
+			copied[strings.ToLower(hash)] = patterns
+		}
+	}
+
+	k.mu.Lock()
+	k.keys = copied
+	k.mu.Unlock()
+}
+
+// LoadFile replaces the keys with a JSON object of digest → patterns
+func (k *APIKeys) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read API keys: %w", err)
+	}
+
+	var keys map[string][]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse API keys: %w", err)
+	}
+	k.Replace(keys)
+	return nil
+}
+
+// LoadRedis replaces the keys with the fields of a Redis hash mapping each
+// digest to comma-separated patterns
+func (k *APIKeys) LoadRedis(ctx context.Context, client *redis.Client, key string) error {
+	values, err := client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load API keys: %w", err)
+	}
+
+	keys := make(map[string][]string, len(values))
+	for hash, value := range values {
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				keys[hash] = append(keys[hash], pattern)
+			}
+		}
+	}
+	k.Replace(keys)
+	return nil
+}
+
+// Source returns the function loading keys from the configured file or
+// Redis hash (the file wins when both are set), or nil when API keys are not
+// configured
+func (k *APIKeys) Source(file string, client *redis.Client, redisKey string) func(context.Context) error {
+	switch {
+	case file != "":
+		return func(context.Context) error { return k.LoadFile(file) }
+	case redisKey != "":
+		return func(ctx context.Context) error { return k.LoadRedis(ctx, client, redisKey) }
+	}
+	return nil
+}
+
+// Watch reloads the keys with load every interval until ctx is done.
+// Failed reloads keep the previous keys.
+func (k *APIKeys) Watch(ctx context.Context, interval time.Duration, load func(context.Context) error, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := load(ctx); err != nil {
+				logger.Error("failed to reload API keys", "error", err)
+			}
+		}
+	}
+}
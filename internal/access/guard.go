@@ -0,0 +1,151 @@
+package access
+
+import (
+	"sync"
+	"time"
+)
+
+// guardSweepSize is the number of tracked clients above which idle ones are
+// forgotten
+const guardSweepSize = 10000
+
+type guardState struct {
+	windowStart time.Time
+	attempts    int
+	failures    int
+	lockedUntil time.Time
+	lastSeen    time.Time
+}
+
+// Guard protects token issuance from secret guessing. Each client IP gets a
+// number of attempts per window, and consecutive bad secrets lock it out for
+// a period doubling with every further failure. State is kept per instance.
+// A nil *Guard allows everything.
+type Guard struct {
+	attempts   int
+	window     time.Duration
+	failures   int
+	lockout    time.Duration
+	maxLockout time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*guardState
+}
+
+// NewGuard creates a guard allowing attempts per window (0 disables the
+// limit) and locking a client out for lockout after failures consecutive bad
+// secrets (0 disables lockouts), up to maxLockout. It returns nil when both
+// are disabled.
+func NewGuard(attempts int, window time.Duration, failures int, lockout, maxLockout time.Duration) *Guard {
+	if attempts <= 0 && failures <= 0 {
+		return nil
+	}
+	return &Guard{
+		attempts:   attempts,
+		window:     window,
+		failures:   failures,
+		lockout:    lockout,
+		maxLockout: maxLockout,
+		clients:    make(map[string]*guardState),
+	}
+}
+
+// Allow counts an attempt by ip. When it is refused, the reason and how long
+// the client has to wait are returned.
+func (g *Guard) Allow(ip string) (string, time.Duration, bool) {
+	if g == nil {
+		return "", 0, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	state := g.state(ip, now)
+
+	if now.Before(state.lockedUntil) {
+		return "locked_out", state.lockedUntil.Sub(now), false
+	}
+
+	if g.attempts > 0 {
+		if now.Sub(state.windowStart) >= g.window {
+			state.windowStart = now
+			state.attempts = 0
+		}
+		if state.attempts >= g.attempts {
+			return "rate_limited", state.windowStart.Add(g.window).Sub(now), false
+		}
+		state.attempts++
+	}
+	return "", 0, true
+}
+
+// Failed records a bad secret from ip and returns the lockout it triggered,
+// if any
+func (g *Guard) Failed(ip string) time.Duration {
+	if g == nil || g.failures <= 0 {
+		return 0
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	state := g.state(ip, now)
+	state.failures++
+	if state.failures < g.failures {
+		return 0
+	}
+
+	lockout := g.lockout
+	for i := g.failures; i < state.failures && lockout < g.maxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > g.maxLockout {
+		lockout = g.maxLockout
+	}
+	state.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// Succeeded clears the failure streak of ip
+func (g *Guard) Succeeded(ip string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if state, ok := g.clients[ip]; ok {
+		state.failures = 0
+	}
+}
+
+// state returns the state of ip, creating it if needed. The caller holds mu.
+func (g *Guard) state(ip string, now time.Time) *guardState {
+	state, ok := g.clients[ip]
+	if !ok {
+		if len(g.clients) >= guardSweepSize {
+			g.sweep(now)
+		}
+		state = &guardState{windowStart: now}
+		g.clients[ip] = state
+	}
+	state.lastSeen = now
+	return state
+}
+
+// sweep forgets clients that are neither locked out nor seen for the longest
+// period that could still matter. The caller holds mu.
+func (g *Guard) sweep(now time.Time) {
+	idle := g.window
+	if g.maxLockout > idle {
+		idle = g.maxLockout
+	}
+	for ip, state := range g.clients {
+		if now.After(state.lockedUntil) && now.Sub(state.lastSeen) > idle {
+			delete(g.clients, ip)
+		}
+	}
+}
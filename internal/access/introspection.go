@@ -0,0 +1,185 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/authcache"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var introspections = metrics.NewCounterVec(
+	"longpoll_introspection_requests_total",
+	"Token introspection calls, by result (active, inactive or error).",
+	"result",
+)
+
+// subjectPlaceholder in a channel pattern is replaced with the token's subject
+const subjectPlaceholder = "{sub}"
+
+// introspectionResponse is the part of an RFC 7662 response used here
+type introspectionResponse struct {
+	Active bool   `json:"active"`
+	Scope  string `json:"scope"`
+	Sub    string `json:"sub"`
+	Exp    int64  `json:"exp"`
+}
+
+// Introspector validates opaque access tokens against an OAuth2 token
+// introspection endpoint (RFC 7662), authenticating with client credentials.
+// The scopes of an active token are mapped to the channels it may poll.
+// Results are cached per token, never beyond the token's expiry. A nil
+// *Introspector rejects every token.
+type Introspector struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	scopes       map[string][]string
+	client       *http.Client
+	cache        *authcache.Cache
+}
+
+// NewIntrospector creates an introspector for endpoint. scopes are
+// "scope=pattern" entries granting channels matching the path.Match pattern
+// to tokens with the scope; "{sub}" in a pattern stands for the token's
+// subject. It returns nil when endpoint is empty.
+func NewIntrospector(endpoint, clientID, clientSecret string, scopes []string, timeout time.Duration, cache *authcache.Cache) *Introspector {
+	if endpoint == "" {
+		return nil
+	}
+
+	mapping := make(map[string][]string, len(scopes))
+	for _, entry := range scopes {
+		scope, pattern, ok := strings.Cut(entry, "=")
+		if ok && scope != "" && pattern != "" {
+			mapping[scope] = append(mapping[scope], pattern)
+		}
+	}
+	return &Introspector{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       mapping,
+		client:       &http.Client{Timeout: timeout},
+		cache:        cache,
+	}
+}
+
+// Validate introspects token and returns claims granting the channels its
+// scopes map to. Inactive tokens and tokens granting no channel yield
+// auth.ErrInvalidToken; other errors mean the endpoint could not decide.
+func (i *Introspector) Validate(ctx context.Context, token string) (*auth.Claims, error) {
+	if i == nil {
+		return nil, auth.ErrInvalidToken
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+	decision, err := i.cache.Decide(ctx, "introspect|"+hash, func(ctx context.Context) (authcache.Decision, error) {
+		return i.introspect(ctx, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !decision.Allowed {
+		return nil, fmt.Errorf("%w: %s", auth.ErrInvalidToken, decision.Reason)
+	}
+	if !decision.ExpiresAt.IsZero() && time.Now().After(decision.ExpiresAt) {
+		return nil, auth.ErrExpiredToken
+	}
+
+	// The ID identifies the token, e.g. as the consumer of stored offsets,
+	// without revealing it. The token is revoked by the authorization server,
+	// so it counts as issued now for channel revocations.
+	claims := &auth.Claims{}
+	claims.ID = hash[:32]
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	for _, channel := range decision.Channels {
+		if strings.ContainsAny(channel, `*?[\`) {
+			claims.Patterns = append(claims.Patterns, channel)
+		} else {
+			claims.Channels = append(claims.Channels, channel)
+		}
+	}
+	if !decision.ExpiresAt.IsZero() {
+		claims.ExpiresAt = jwt.NewNumericDate(decision.ExpiresAt)
+	}
+	return claims, nil
+}
+
+// introspect asks the endpoint about token
+func (i *Introspector) introspect(ctx context.Context, token string) (authcache.Decision, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return authcache.Decision{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if i.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		introspections.WithLabelValues("error").Inc()
+		return authcache.Decision{}, fmt.Errorf("failed to call introspection endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+		introspections.WithLabelValues("error").Inc()
+		return authcache.Decision{}, fmt.Errorf("introspection endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result introspectionResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		introspections.WithLabelValues("error").Inc()
+		return authcache.Decision{}, fmt.Errorf("failed to decode introspection response: %w", err)
+	}
+	if !result.Active {
+		introspections.WithLabelValues("inactive").Inc()
+		return authcache.Decision{Reason: "token is not active"}, nil
+	}
+	introspections.WithLabelValues("active").Inc()
+
+	channels := i.channels(result)
+	if len(channels) == 0 {
+		return authcache.Decision{Reason: "token scopes grant no channel"}, nil
+	}
+	decision := authcache.Decision{Allowed: true, Channels: channels}
+	if result.Exp > 0 {
+		decision.ExpiresAt = time.Unix(result.Exp, 0)
+	}
+	return decision, nil
+}
+
+// channels maps the scopes of an active token to channel patterns. Patterns
+// naming the subject are skipped for tokens without one.
+func (i *Introspector) channels(result introspectionResponse) []string {
+	var channels []string
+	for _, scope := range strings.Fields(result.Scope) {
+		for _, pattern := range i.scopes[scope] {
+			if strings.Contains(pattern, subjectPlaceholder) {
+				if result.Sub == "" {
+					continue
+				}
+				pattern = strings.ReplaceAll(pattern, subjectPlaceholder, result.Sub)
+			}
+			channels = append(channels, pattern)
+		}
+	}
+	return channels
+}
@@ -0,0 +1,130 @@
+package access
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/authcache"
+)
+
+// PrivateChannels authorizes channels matching the private patterns against
+// a Laravel endpoint, mirroring Echo's /broadcasting/auth flow: the user's
+// cookies and Authorization header are forwarded with the channel name and
+// any 2xx answer grants access. Decisions are cached per channel and
+// credentials. A nil *PrivateChannels treats every channel as public.
+type PrivateChannels struct {
+	patterns []string
+	endpoint string
+	client   *http.Client
+	cache    *authcache.Cache
+}
+
+// NewPrivateChannels creates an authorizer for channels matching patterns
+// (path.Match globs such as "private-*"). It returns nil when no pattern is
+// configured.
+func NewPrivateChannels(patterns []string, endpoint string, timeout time.Duration, cache *authcache.Cache) *PrivateChannels {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return &PrivateChannels{
+		patterns: patterns,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: timeout},
+		cache:    cache,
+	}
+}
+
+// IsPrivate reports whether channelID requires authorization
+func (p *PrivateChannels) IsPrivate(channelID string) bool {
+	if p == nil {
+		return false
+	}
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, channelID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks every private channel of channelIDs with the credentials
+// of r. It stops at the first denial, whose decision names the channel.
+func (p *PrivateChannels) Authorize(ctx context.Context, r *http.Request, channelIDs []string) (authcache.Decision, error) {
+	allowed := authcache.Decision{Allowed: true}
+	if p == nil {
+		return allowed, nil
+	}
+
+	cookie := r.Header.Get("Cookie")
+	authorization := r.Header.Get("Authorization")
+
+	for _, channelID := range channelIDs {
+		if !p.IsPrivate(channelID) {
+			continue
+		}
+
+		// Without credentials there is nobody to authorize
+		if cookie == "" && authorization == "" {
+			return authcache.Decision{Reason: "no credentials for " + channelID}, nil
+		}
+
+		decision, err := p.cache.Decide(ctx, cacheKey(channelID, cookie, authorization), func(ctx context.Context) (authcache.Decision, error) {
+			return p.check(ctx, channelID, cookie, authorization)
+		})
+		if err != nil {
+			return authcache.Decision{}, err
+		}
+		if !decision.Allowed {
+			return decision, nil
+		}
+	}
+	return allowed, nil
+}
+
+// check asks Laravel whether the forwarded credentials may join channelID
+func (p *PrivateChannels) check(ctx context.Context, channelID, cookie, authorization string) (authcache.Decision, error) {
+	form := url.Values{"channel_name": {channelID}}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return authcache.Decision{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if cookie != "" {
+		req.Header.Set("Cookie", cookie)
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return authcache.Decision{}, fmt.Errorf("failed to call authorization endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return authcache.Decision{Allowed: true}, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return authcache.Decision{Reason: fmt.Sprintf("%s denied with status %d", channelID, resp.StatusCode)}, nil
+	default:
+		return authcache.Decision{}, fmt.Errorf("authorization endpoint returned status %d", resp.StatusCode)
+	}
+}
+
+// cacheKey hashes the credentials so they are never kept in memory as is
+func cacheKey(channelID, cookie, authorization string) string {
+	sum := sha256.Sum256([]byte(cookie + "\x00" + authorization))
+	return channelID + "|" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package access
+
+import (
+	"path"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+)
+
+// PublicChannels lets channels matching its patterns (path.Match globs such
+// as "public.*") be polled without a token. Each client IP may poll them a
+// limited number of times per window. A nil *PublicChannels has no public
+// channel.
+type PublicChannels struct {
+	patterns []string
+	guard    *Guard
+}
+
+// NewPublicChannels creates the public channel set, allowing each client IP
+// limit anonymous polls per window (0 disables the limit). It returns nil
+// when no pattern is configured.
+func NewPublicChannels(patterns []string, limit int, window time.Duration) *PublicChannels {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return &PublicChannels{
+		patterns: patterns,
+		guard:    NewGuard(limit, window, 0, 0, 0),
+	}
+}
+
+// Covers reports whether every one of channelIDs is public
+func (p *PublicChannels) Covers(channelIDs []string) bool {
+	if p == nil || len(channelIDs) == 0 {
+		return false
+	}
+	for _, channelID := range channelIDs {
+		if !p.isPublic(channelID) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *PublicChannels) isPublic(channelID string) bool {
+	for _, pattern := range p.patterns {
+		if ok, _ := path.Match(pattern, channelID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow counts an anonymous poll by ip, returning how long it has to wait
+// when it is over the limit
+func (p *PublicChannels) Allow(ip string) (time.Duration, bool) {
+	_, wait, ok := p.guard.Allow(ip)
+	return wait, ok
+}
+
+// Claims returns the claims of an anonymous poll on channelIDs. They carry
+// no token ID, and channel revocations, which target tokens, never apply.
+func (p *PublicChannels) Claims(channelIDs []string) *auth.Claims {
+	claims := &auth.Claims{Channels: channelIDs}
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	return claims
+}
@@ -0,0 +1,150 @@
+// Package access decides which shared secrets may mint tokens for which
+// channels. The global ACCESS_TOKEN_SECRET covers every channel; scoped
+// secrets only cover channels under their prefix, so internal services can
+// be limited to their own namespaces.
+package access
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Secrets holds the global secret and the prefix-scoped ones
+type Secrets struct {
+	global string
+	next   string
+
+	mu     sync.RWMutex
+	scoped map[string]string
+}
+
+// NewSecrets creates a secret set with only the global secret. A non-empty
+// next is accepted as a global secret too while it is being rotated in.
+func NewSecrets(global, next string) *Secrets {
+	return &Secrets{
+		global: global,
+		next:   next,
+		scoped: make(map[string]string),
+	}
+}
+
+// Allows reports whether secret may access every one of channelIDs. Each
+// channel is governed by the longest prefix configured for it; the global
+// secret is accepted everywhere.
+func (s *Secrets) Allows(secret string, channelIDs ...string) bool {
+	if secret == "" {
+		return false
+	}
+	// Both are compared so timing doesn't tell which one matched
+	if matchesGlobal, matchesNext := equal(secret, s.global), equal(secret, s.next); matchesGlobal || matchesNext {
+		return true
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, channelID := range channelIDs {
+		scoped, ok := s.lookup(channelID)
+		if !ok || !equal(secret, scoped) {
+			return false
+		}
+	}
+	return len(channelIDs) > 0
+}
+
+// lookup returns the secret of the longest prefix matching channelID;
+// callers must hold s.mu
+func (s *Secrets) lookup(channelID string) (string, bool) {
+	var best, secret string
+	found := false
+	for prefix, value := range s.scoped {
+		if strings.HasPrefix(channelID, prefix) && (!found || len(prefix) > len(best)) {
+			best, secret, found = prefix, value, true
+		}
+	}
+	return secret, found
+}
+
+// Replace swaps the scoped secrets for a new prefix → secret map
+func (s *Secrets) Replace(scoped map[string]string) {
+	copied := make(map[string]string, len(scoped))
+	for prefix, secret := range scoped {
+		if secret != "" {
+			copied[prefix] = secret
+		}
+	}
+
+	s.mu.Lock()
+	s.scoped = copied
+	s.mu.Unlock()
+}
+
+// LoadFile replaces the scoped secrets with a JSON object of prefix → secret
+func (s *Secrets) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read access secrets: %w", err)
+	}
+
+	var scoped map[string]string
+	if err := json.Unmarshal(data, &scoped); err != nil {
+		return fmt.Errorf("failed to parse access secrets: %w", err)
+	}
+	s.Replace(scoped)
+	return nil
+}
+
+// LoadRedis replaces the scoped secrets with the prefix → secret fields of a
+// Redis hash
+func (s *Secrets) LoadRedis(ctx context.Context, client *redis.Client, key string) error {
+	scoped, err := client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load access secrets: %w", err)
+	}
+	s.Replace(scoped)
+	return nil
+}
+
+// Source returns the function loading scoped secrets from the configured
+// file or Redis hash (the file wins when both are set), or nil when scoped
+// secrets are not configured
+func (s *Secrets) Source(file string, client *redis.Client, redisKey string) func(context.Context) error {
+	switch {
+	case file != "":
+		return func(context.Context) error { return s.LoadFile(file) }
+	case redisKey != "":
+		return func(ctx context.Context) error { return s.LoadRedis(ctx, client, redisKey) }
+	}
+	return nil
+}
+
+// Watch reloads the secrets with load every interval until ctx is done.
+// Failed reloads keep the previous secrets.
+func (s *Secrets) Watch(ctx context.Context, interval time.Duration, load func(context.Context) error, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := load(ctx); err != nil {
+				logger.Error("failed to reload access secrets", "error", err)
+			}
+		}
+	}
+}
+
+func equal(a, b string) bool {
+	return b != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
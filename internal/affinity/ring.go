@@ -0,0 +1,323 @@
+package affinity
+
+import (
+	"context"
+	"hash/crc32"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis hashes of advertised URL => last heartbeat in unix milliseconds,
+// relative to the key prefix: live instances, and instances draining, which
+// take no channels
+const (
+	instancesKey = "instances"
+	drainingKey  = "draining"
+)
+
+// replicas is the number of points each instance gets on the ring, which
+// evens out the share of channels per instance
+const replicas = 128
+
+// staleHeartbeats is how many missed heartbeats drop an instance from the ring
+const staleHeartbeats = 3
+
+type point struct {
+	hash     uint32
+	instance string
+}
+
+// Ring maps channels to instances with consistent hashing. Every instance
+// advertises its URL in Redis and rebuilds the ring from the live ones, so
+// all instances agree on a channel's owner and adding or removing one only
+// moves that instance's share of channels. A nil *Ring owns every channel.
+type Ring struct {
+	client   *redis.Client
+	key      string
+	drainKey string
+	self     string
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu        sync.RWMutex
+	points    []point
+	instances int
+	peers     []string
+	draining  bool
+	cancel    context.CancelFunc
+}
+
+// NewRing creates a ring advertising this instance as self, heartbeating
+// every interval. It returns nil when self is empty.
+func NewRing(client *redis.Client, keyPrefix, self string, interval time.Duration, logger *slog.Logger) *Ring {
+	if self == "" {
+		return nil
+	}
+	r := &Ring{
+		client:   client,
+		key:      keyPrefix + instancesKey,
+		drainKey: keyPrefix + drainingKey,
+		self:     self,
+		interval: interval,
+		logger:   logger,
+	}
+	r.build([]string{self})
+	return r
+}
+
+// Start advertises the instance and refreshes the ring until Stop is called
+func (r *Ring) Start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.refresh(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Warn("failed to refresh instance ring", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops the heartbeat and withdraws the instance, so the others take
+// over its channels without waiting for it to go stale
+func (r *Ring) Stop() {
+	if r == nil {
+		return
+	}
+
+	r.mu.RLock()
+	cancel := r.cancel
+	r.mu.RUnlock()
+	if cancel != nil {
+		cancel()
+	}
+
+	ctx, cancelDel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelDel()
+	pipe := r.client.Pipeline()
+	pipe.HDel(ctx, r.key, r.self)
+	pipe.HDel(ctx, r.drainKey, r.self)
+	if _, err := pipe.Exec(ctx); err != nil {
+		r.logger.Warn("failed to withdraw instance", "error", err)
+	}
+}
+
+// Drain takes the instance off the ring while it keeps running: every
+// instance, this one included, then assigns its channels to the others,
+// which accept the polls it redirects to them
+func (r *Ring) Drain(ctx context.Context) error {
+	r.mu.Lock()
+	r.draining = true
+	peers := r.peers
+	r.mu.Unlock()
+
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, r.drainKey, r.self, time.Now().UnixMilli())
+	pipe.HDel(ctx, r.key, r.self)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	r.build(peers)
+	return nil
+}
+
+// Undrain puts a draining instance back on the ring
+func (r *Ring) Undrain(ctx context.Context) error {
+	r.mu.Lock()
+	r.draining = false
+	r.mu.Unlock()
+
+	if err := r.client.HDel(ctx, r.drainKey, r.self).Err(); err != nil {
+		return err
+	}
+	return r.refresh(ctx)
+}
+
+// Draining reports whether this instance is draining
+func (r *Ring) Draining() bool {
+	if r == nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.draining
+}
+
+// DrainingInstances returns the URLs of the instances currently draining
+func (r *Ring) DrainingInstances(ctx context.Context) ([]string, error) {
+	values, err := r.client.HGetAll(ctx, r.drainKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := r.cutoff(time.Now())
+	instances := make([]string, 0, len(values))
+	for instance, value := range values {
+		if heartbeat, err := strconv.ParseInt(value, 10, 64); err == nil && heartbeat >= cutoff {
+			instances = append(instances, instance)
+		}
+	}
+	sort.Strings(instances)
+	return instances, nil
+}
+
+// Owner returns the URL of the instance owning a channel and whether it is
+// another instance than this one. A draining instance left alone keeps its
+// channels.
+func (r *Ring) Owner(channelID string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.points) == 0 {
+		return r.self, false
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(channelID))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	owner := r.points[i].instance
+	return owner, owner != r.self
+}
+
+// Instances returns the number of instances currently on the ring
+func (r *Ring) Instances() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.instances
+}
+
+// refresh records a heartbeat, then rebuilds the ring from the instances
+// that sent one recently and are not draining, dropping stale ones from Redis
+func (r *Ring) refresh(ctx context.Context) error {
+	now := time.Now()
+	heartbeatKey := r.key
+	if r.Draining() {
+		heartbeatKey = r.drainKey
+	}
+	if err := r.client.HSet(ctx, heartbeatKey, r.self, now.UnixMilli()).Err(); err != nil {
+		return err
+	}
+
+	pipe := r.client.Pipeline()
+	instancesCmd := pipe.HGetAll(ctx, r.key)
+	drainingCmd := pipe.HGetAll(ctx, r.drainKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	cutoff := r.cutoff(now)
+	draining, staleDraining := split(drainingCmd.Val(), cutoff)
+	instances, staleInstances := split(instancesCmd.Val(), cutoff)
+
+	peers := make([]string, 0, len(instances))
+	for instance := range instances {
+		if instance != r.self && !draining[instance] {
+			peers = append(peers, instance)
+		}
+	}
+
+	pipe = r.client.Pipeline()
+	if len(staleInstances) > 0 {
+		pipe.HDel(ctx, r.key, staleInstances...)
+	}
+	if len(staleDraining) > 0 {
+		pipe.HDel(ctx, r.drainKey, staleDraining...)
+	}
+	if pipe.Len() > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			r.logger.Warn("failed to drop stale instances", "error", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.peers = peers
+	selfDraining := r.draining
+	r.mu.Unlock()
+
+	if selfDraining {
+		r.build(peers)
+	} else {
+		r.build(append([]string{r.self}, peers...))
+	}
+	return nil
+}
+
+// cutoff is the oldest heartbeat of an instance still considered alive
+func (r *Ring) cutoff(now time.Time) int64 {
+	return now.Add(-staleHeartbeats * r.interval).UnixMilli()
+}
+
+// split separates heartbeats at or after cutoff from stale ones
+func split(heartbeats map[string]string, cutoff int64) (map[string]bool, []string) {
+	live := make(map[string]bool, len(heartbeats))
+	var stale []string
+	for instance, value := range heartbeats {
+		heartbeat, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || heartbeat < cutoff {
+			stale = append(stale, instance)
+			continue
+		}
+		live[instance] = true
+	}
+	return live, stale
+}
+
+func (r *Ring) build(instances []string) {
+	points := make([]point, 0, len(instances)*replicas)
+	for _, instance := range instances {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{
+				hash:     crc32.ChecksumIEEE([]byte(instance + "#" + strconv.Itoa(i))),
+				instance: instance,
+			})
+		}
+	}
+	// Ties are broken by instance so every instance builds the same ring
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].instance < points[j].instance
+	})
+
+	r.mu.Lock()
+	if r.instances != len(instances) {
+		r.logger.Info("instance ring changed", "instances", len(instances))
+	}
+	r.points = points
+	r.instances = len(instances)
+	r.mu.Unlock()
+}
@@ -0,0 +1,67 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Webhook posts alert payloads as JSON to a configured URL
+type Webhook struct {
+	url        string
+	httpClient *http.Client
+	logger     *slog.Logger
+}
+
+// NewWebhook creates a new webhook notifier. An empty URL disables delivery.
+func NewWebhook(url string, timeout time.Duration, logger *slog.Logger) *Webhook {
+	return &Webhook{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+		logger:     logger,
+	}
+}
+
+// Notify posts the alert in the background so callers are never blocked
+func (w *Webhook) Notify(kind string, payload interface{}) {
+	if w.url == "" {
+		return
+	}
+
+	go func() {
+		if err := w.send(context.Background(), kind, payload); err != nil {
+			w.logger.Error("failed to deliver alert webhook", "error", err, "kind", kind)
+		}
+	}()
+}
+
+func (w *Webhook) send(ctx context.Context, kind string, payload interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"kind":    kind,
+		"payload": payload,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
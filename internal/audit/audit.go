@@ -0,0 +1,154 @@
+// Package audit records security-relevant actions (token issuance,
+// revocations, bans) to a dedicated stream, separate from the application log.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Recorded actions
+const (
+	ActionTokenIssued     = "token_issued"
+	ActionTokenDenied     = "token_denied"
+	ActionTokenRevoked    = "token_revoked"
+	ActionChannelRevoked  = "channel_tokens_revoked"
+	ActionChannelBanned   = "channel_banned"
+	ActionChannelUnbanned = "channel_unbanned"
+	ActionEventReplayed   = "event_replayed"
+	ActionDeadReplayed    = "dead_letters_replayed"
+	ActionBroadcast       = "broadcast_sent"
+	ActionMaintenance     = "maintenance_started"
+	ActionResumed         = "maintenance_stopped"
+	ActionDrainStarted    = "drain_started"
+	ActionDrainStopped    = "drain_stopped"
+	ActionLogLevel        = "log_level_changed"
+)
+
+// Outcomes of a recorded action
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeError   = "error"
+)
+
+// Entry is one audit record, written as a single JSON line
+type Entry struct {
+	Time      time.Time  `json:"time"`
+	Action    string     `json:"action"`
+	Outcome   string     `json:"outcome"`
+	Channels  []string   `json:"channels,omitempty"`
+	ClientIP  string     `json:"client_ip,omitempty"`
+	TokenID   string     `json:"token_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// Sink stores encoded audit entries
+type Sink interface {
+	Write(ctx context.Context, line []byte) error
+	Close() error
+}
+
+// Log writes entries to a sink. A nil Log discards everything.
+type Log struct {
+	sink   Sink
+	logger *slog.Logger
+}
+
+// NewLog creates an audit log writing to sink
+func NewLog(sink Sink, logger *slog.Logger) *Log {
+	return &Log{sink: sink, logger: logger}
+}
+
+// Record writes an entry. Failures are reported on the application log and
+// never fail the audited request.
+func (l *Log) Record(ctx context.Context, entry Entry) {
+	if l == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		l.logger.Error("failed to encode audit entry", "error", err, "action", entry.Action)
+		return
+	}
+	if err := l.sink.Write(ctx, line); err != nil {
+		l.logger.Error("failed to write audit entry", "error", err, "action", entry.Action, "entry", string(line))
+	}
+}
+
+// Close closes the underlying sink
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
+
+// FileSink appends entries as JSON lines to a file
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it readable by the owner only
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends one line
+func (s *FileSink) Write(_ context.Context, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// RedisSink appends entries to a Redis list capped at maxLen entries
+type RedisSink struct {
+	client *redis.Client
+	key    string
+	maxLen int64
+}
+
+// NewRedisSink creates a sink pushing to the list at key
+func NewRedisSink(client *redis.Client, key string, maxLen int) *RedisSink {
+	return &RedisSink{client: client, key: key, maxLen: int64(maxLen)}
+}
+
+// Write pushes one entry and trims the oldest beyond maxLen
+func (s *RedisSink) Write(ctx context.Context, line []byte) error {
+	pipe := s.client.TxPipeline()
+	pipe.RPush(ctx, s.key, line)
+	if s.maxLen > 0 {
+		pipe.LTrim(ctx, s.key, -s.maxLen, -1)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Close is a no-op; the Redis client is owned by the caller
+func (s *RedisSink) Close() error {
+	return nil
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWK is a public key as published in a JWK Set (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// WithSigningKey returns a copy of the service signing with an asymmetric
+// private key, for the RS*, ES* and EdDSA algorithms. Tokens are validated
+// with its public key or any of publicKeys, e.g. those of retired signing
+// keys; every key is published by JWKS under a kid derived from it.
+func (s *JWTService) WithSigningKey(privatePEM []byte, publicPEMs ...[]byte) (*JWTService, error) {
+	if !s.Asymmetric() {
+		return nil, fmt.Errorf("%s does not use a private key", s.signingAlg.Alg())
+	}
+
+	signer, err := parsePrivateKey(privatePEM)
+	if err != nil {
+		return nil, err
+	}
+	if !s.accepts(signer.Public()) {
+		return nil, fmt.Errorf("private key does not fit %s", s.signingAlg.Alg())
+	}
+	kid, err := keyID(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := *s
+	scoped.signer = signer
+	scoped.signerKID = kid
+	scoped.publicKeys = map[string]crypto.PublicKey{kid: signer.Public()}
+	for _, data := range publicPEMs {
+		public, err := parsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		if !s.accepts(public) {
+			return nil, fmt.Errorf("public key does not fit %s", s.signingAlg.Alg())
+		}
+		kid, err := keyID(public)
+		if err != nil {
+			return nil, err
+		}
+		scoped.publicKeys[kid] = public
+	}
+	return &scoped, nil
+}
+
+// WithSigningKeyFiles is WithSigningKey reading PEM files. It returns the
+// service unchanged when privateFile is empty.
+func (s *JWTService) WithSigningKeyFiles(privateFile string, publicFiles []string) (*JWTService, error) {
+	if privateFile == "" {
+		return s, nil
+	}
+
+	privatePEM, err := os.ReadFile(privateFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT private key: %w", err)
+	}
+	publicPEMs := make([][]byte, 0, len(publicFiles))
+	for _, file := range publicFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+		}
+		publicPEMs = append(publicPEMs, data)
+	}
+	return s.WithSigningKey(privatePEM, publicPEMs...)
+}
+
+// Asymmetric reports whether the algorithm signs with a private key
+func (s *JWTService) Asymmetric() bool {
+	switch s.signingAlg.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+		return true
+	}
+	return false
+}
+
+// accepts reports whether key is of the type the algorithm verifies with
+func (s *JWTService) accepts(key crypto.PublicKey) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		_, ok := s.signingAlg.(*jwt.SigningMethodRSA)
+		return ok
+	case *ecdsa.PublicKey:
+		_, ok := s.signingAlg.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := s.signingAlg.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+	return false
+}
+
+// JWKS returns the public keys validating tokens, the signing key first.
+// It is empty unless an asymmetric signing key is configured.
+func (s *JWTService) JWKS() []JWK {
+	kids := make([]string, 0, len(s.publicKeys))
+	for kid := range s.publicKeys {
+		if kid != s.signerKID {
+			kids = append(kids, kid)
+		}
+	}
+	sort.Strings(kids)
+	if s.signer != nil {
+		kids = append([]string{s.signerKID}, kids...)
+	}
+
+	keys := make([]JWK, 0, len(kids))
+	for _, kid := range kids {
+		key, err := toJWK(s.publicKeys[kid])
+		if err != nil {
+			continue
+		}
+		key.Kid = kid
+		key.Use = "sig"
+		key.Alg = s.signingAlg.Alg()
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return key, nil
+}
+
+// keyID derives a stable kid from the key itself
+func keyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
+
+func toJWK(key crypto.PublicKey) (JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   encode(key.N.Bytes()),
+			E:   encode(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: key.Curve.Params().Name,
+			X:   encode(key.X.FillBytes(make([]byte, size))),
+			Y:   encode(key.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encode(key),
+		}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported public key type %T", key)
+}
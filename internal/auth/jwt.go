@@ -1,8 +1,16 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,15 +21,89 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+func init() {
+	// Issue times carry milliseconds, so a channel revocation tells apart the
+	// tokens issued in the same second before and after it
+	jwt.TimePrecision = time.Millisecond
+}
+
+// ChannelACL holds the rights of a token on one channel
+type ChannelACL struct {
+	CanRead    bool `json:"can_read,omitempty"`
+	CanPublish bool `json:"can_publish,omitempty"`
+}
+
 type Claims struct {
-	ChannelID string `json:"channel_id"`
+	ChannelID string   `json:"channel_id,omitempty"`
+	Channels  []string `json:"channels,omitempty"`
+	AppID     string   `json:"app_id,omitempty"`
+	// ACL, when present, is authoritative over ChannelID and Channels, which
+	// only grant reading
+	ACL map[string]ChannelACL `json:"acl,omitempty"`
 	jwt.RegisteredClaims
+
+	// Patterns are path.Match globs of further channels granted, for
+	// credentials validated by another authority than this service
+	Patterns []string `json:"-"`
+}
+
+// AllowedChannels returns every channel the token grants access to
+func (c *Claims) AllowedChannels() []string {
+	if c.ACL != nil {
+		channels := make([]string, 0, len(c.ACL))
+		for channelID, acl := range c.ACL {
+			if acl.CanRead {
+				channels = append(channels, channelID)
+			}
+		}
+		sort.Strings(channels)
+		return channels
+	}
+	if len(c.Channels) > 0 {
+		return c.Channels
+	}
+	return []string{c.ChannelID}
+}
+
+// IssuedTime returns when the token was issued, or the zero time if unknown
+func (c *Claims) IssuedTime() time.Time {
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
+
+// Allows reports whether the token grants reading the channel
+func (c *Claims) Allows(channelID string) bool {
+	for _, allowed := range c.AllowedChannels() {
+		if allowed == channelID {
+			return true
+		}
+	}
+	for _, pattern := range c.Patterns {
+		if ok, _ := path.Match(pattern, channelID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CanPublish reports whether the token grants publishing to the channel
+func (c *Claims) CanPublish(channelID string) bool {
+	return c.ACL[channelID].CanPublish
 }
 
 type JWTService struct {
 	secret     []byte
+	next       []byte
+	keys       map[string][]byte
+	activeKID  string
+	signer     crypto.Signer
+	signerKID  string
+	publicKeys map[string]crypto.PublicKey
 	expiresIn  int
 	signingAlg jwt.SigningMethod
+	appID      string
 }
 
 // NewJWTService creates a new JWT service
@@ -34,6 +116,20 @@ func NewJWTService(secret string, expiresIn int, algo string) (*JWTService, erro
 		signingAlg = jwt.SigningMethodHS384
 	case "HS512":
 		signingAlg = jwt.SigningMethodHS512
+	case "RS256":
+		signingAlg = jwt.SigningMethodRS256
+	case "RS384":
+		signingAlg = jwt.SigningMethodRS384
+	case "RS512":
+		signingAlg = jwt.SigningMethodRS512
+	case "ES256":
+		signingAlg = jwt.SigningMethodES256
+	case "ES384":
+		signingAlg = jwt.SigningMethodES384
+	case "ES512":
+		signingAlg = jwt.SigningMethodES512
+	case "EdDSA":
+		signingAlg = jwt.SigningMethodEdDSA
 	default:
 		return nil, fmt.Errorf("unsupported algorithm: %s", algo)
 	}
@@ -45,42 +141,192 @@ func NewJWTService(secret string, expiresIn int, algo string) (*JWTService, erro
 	}, nil
 }
 
+// WithNextSecret returns a copy of the service that also accepts tokens
+// signed with next, so the signing secret can be rotated without
+// invalidating live tokens. Tokens are still signed with the current secret.
+func (s *JWTService) WithNextSecret(next string) *JWTService {
+	scoped := *s
+	if next != "" {
+		scoped.next = []byte(next)
+	}
+	return &scoped
+}
+
+// WithKeys returns a copy of the service using keys identified by kid,
+// given as "kid:secret" entries. New tokens are signed with the active key
+// and carry its kid header; tokens with a kid are validated with that key,
+// and tokens without one with the service's secret, so keys can be rotated
+// without invalidating live tokens.
+func (s *JWTService) WithKeys(entries []string, active string) (*JWTService, error) {
+	if len(entries) == 0 {
+		return s, nil
+	}
+
+	keys := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		kid, secret, ok := strings.Cut(entry, ":")
+		if !ok || kid == "" || secret == "" {
+			return nil, fmt.Errorf("invalid JWT key %q, expected kid:secret", kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active JWT key %q is not configured", active)
+	}
+
+	scoped := *s
+	scoped.keys = keys
+	scoped.activeKID = active
+	return &scoped, nil
+}
+
+// WithAppID returns a copy of the service that stamps issued tokens with the
+// tenant's app_id and only accepts tokens carrying it
+func (s *JWTService) WithAppID(appID string) *JWTService {
+	scoped := *s
+	scoped.appID = appID
+	return &scoped
+}
+
 // GenerateToken generates a new JWT token for a channel
 func (s *JWTService) GenerateToken(channelID string) (string, error) {
+	token, _, err := s.sign(Claims{ChannelID: channelID})
+	return token, err
+}
+
+// GenerateMultiChannelToken generates a new JWT token authorizing several channels
+func (s *JWTService) GenerateMultiChannelToken(channelIDs []string) (string, error) {
+	token, _, err := s.sign(Claims{Channels: channelIDs})
+	return token, err
+}
+
+// IssueToken generates a token for one or more channels and also returns its
+// claims, so callers can record the token ID and expiry
+func (s *JWTService) IssueToken(channelIDs []string) (string, *Claims, error) {
+	if len(channelIDs) == 1 {
+		return s.sign(Claims{ChannelID: channelIDs[0]})
+	}
+	return s.sign(Claims{Channels: channelIDs})
+}
+
+// IssueScopedToken generates a token reading the read channels and
+// publishing to the publish ones. Without publish channels it is IssueToken.
+func (s *JWTService) IssueScopedToken(read, publish []string) (string, *Claims, error) {
+	if len(publish) == 0 {
+		return s.IssueToken(read)
+	}
+
+	acl := make(map[string]ChannelACL, len(read)+len(publish))
+	for _, channelID := range read {
+		acl[channelID] = ChannelACL{CanRead: true}
+	}
+	for _, channelID := range publish {
+		rights := acl[channelID]
+		rights.CanPublish = true
+		acl[channelID] = rights
+	}
+	// Channels keeps instances unaware of the ACL to read-only access
+	return s.sign(Claims{Channels: read, ACL: acl})
+}
+
+func (s *JWTService) sign(claims Claims) (string, *Claims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
 	now := time.Now()
-	claims := Claims{
-		ChannelID: channelID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(s.expiresIn) * time.Second)),
-		},
+	claims.AppID = s.appID
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        hex.EncodeToString(id),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Duration(s.expiresIn) * time.Second)),
 	}
 
-	token := jwt.NewWithClaims(s.signingAlg, claims)
-	return token.SignedString(s.secret)
+	unsigned := jwt.NewWithClaims(s.signingAlg, claims)
+	var key interface{} = s.secret
+	switch {
+	case s.signer != nil:
+		unsigned.Header["kid"] = s.signerKID
+		key = s.signer
+	case s.activeKID != "":
+		unsigned.Header["kid"] = s.activeKID
+		key = s.keys[s.activeKID]
+	}
+
+	token, err := unsigned.SignedString(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &claims, nil
 }
 
-// ValidateToken validates a JWT token and returns the channel ID
-func (s *JWTService) ValidateToken(tokenString string) (string, error) {
+// ValidateToken validates a JWT token and returns its claims
+func (s *JWTService) ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Verify the signing method
 		if token.Method != s.signingAlg {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
+		if s.signer != nil {
+			kid, _ := token.Header["kid"].(string)
+			if kid == "" {
+				return s.signer.Public(), nil
+			}
+			key, ok := s.publicKeys[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key id: %q", kid)
+			}
+			return key, nil
+		}
+		if kid, ok := token.Header["kid"].(string); ok {
+			key, ok := s.keys[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key id: %q", kid)
+			}
+			return key, nil
+		}
+		if s.next != nil {
+			return jwt.VerificationKeySet{Keys: []jwt.VerificationKey{s.secret, s.next}}, nil
+		}
 		return s.secret, nil
 	})
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
-			return "", ErrExpiredToken
+			return nil, ErrExpiredToken
 		}
-		return "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
-		return "", ErrInvalidToken
+		return nil, ErrInvalidToken
+	}
+	if claims.AppID != s.appID {
+		return nil, fmt.Errorf("%w: issued for another app", ErrInvalidToken)
 	}
 
-	return claims.ChannelID, nil
+	return claims, nil
+}
+
+// PeekAppID returns the app_id claim of a token without verifying it, so
+// the token can be routed to the tenant whose secret will verify it
+func PeekAppID(tokenString string) string {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		AppID string `json:"app_id"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.AppID
 }
@@ -0,0 +1,158 @@
+package authcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of an authorization lookup
+type Decision struct {
+	Allowed bool
+	Reason  string
+
+	// Grants of lookups that authenticate as well, such as token
+	// introspection: the channels allowed, and when the grant expires
+	// regardless of the cache TTL (zero if it does not)
+	Channels  []string
+	ExpiresAt time.Time
+}
+
+// Store holds cached decisions. Implementations must be safe for concurrent use.
+type Store interface {
+	Get(key string) (Decision, bool)
+	Set(key string, decision Decision, ttl time.Duration)
+}
+
+// Loader performs the actual authorization lookup on a cache miss
+type Loader func(ctx context.Context) (Decision, error)
+
+type call struct {
+	done     chan struct{}
+	decision Decision
+	err      error
+}
+
+// Cache caches authorization decisions with separate TTLs for allowed and
+// denied outcomes. Concurrent misses for the same key share a single lookup,
+// so a burst of pollers doesn't stampede the authorization backend.
+// Lookup errors are never cached.
+type Cache struct {
+	store       Store
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*call
+}
+
+// NewCache creates a new decision cache
+func NewCache(store Store, positiveTTL, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		store:       store,
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		inflight:    make(map[string]*call),
+	}
+}
+
+// Decide returns the cached decision for key, calling load on a miss
+func (c *Cache) Decide(ctx context.Context, key string, load Loader) (Decision, error) {
+	if decision, ok := c.store.Get(key); ok {
+		return decision, nil
+	}
+
+	c.mu.Lock()
+	if pending, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-pending.done:
+			return pending.decision, pending.err
+		case <-ctx.Done():
+			return Decision{}, ctx.Err()
+		}
+	}
+
+	pending := &call{done: make(chan struct{})}
+	c.inflight[key] = pending
+	c.mu.Unlock()
+
+	pending.decision, pending.err = load(ctx)
+	if pending.err == nil {
+		ttl := c.negativeTTL
+		if pending.decision.Allowed {
+			ttl = c.positiveTTL
+		}
+		if ttl > 0 {
+			c.store.Set(key, pending.decision, ttl)
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	c.mu.Unlock()
+	close(pending.done)
+
+	return pending.decision, pending.err
+}
+
+type entry struct {
+	decision  Decision
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-process Store with lazy expiry. Expired entries are
+// swept whenever the store grows past maxEntries.
+type MemoryStore struct {
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[string]entry
+}
+
+// NewMemoryStore creates a new in-memory decision store
+func NewMemoryStore(maxEntries int) *MemoryStore {
+	return &MemoryStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]entry),
+	}
+}
+
+// Get returns a decision that has not expired yet
+func (s *MemoryStore) Get(key string) (Decision, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return Decision{}, false
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(s.entries, key)
+		return Decision{}, false
+	}
+	return e.decision, true
+}
+
+// Set stores a decision for ttl
+func (s *MemoryStore) Set(key string, decision Decision, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) >= s.maxEntries {
+		now := time.Now()
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= s.maxEntries {
+			// Still full of live entries, drop everything rather than grow unbounded
+			s.entries = make(map[string]entry)
+		}
+	}
+
+	s.entries[key] = entry{
+		decision:  decision,
+		expiresAt: time.Now().Add(ttl),
+	}
+}
@@ -0,0 +1,58 @@
+// Package buildinfo reports which build of the service is running. The
+// values are stamped at build time with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/levskiy0/go-laravel-long-polling/internal/buildinfo.Version=v1.4.0" ./cmd/longpoll-server
+//
+// Unstamped builds fall back to the VCS information the Go toolchain embeds.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Set with -ldflags -X at build time
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+var (
+	once sync.Once
+	info Info
+)
+
+// Get returns the build information
+func Get() Info {
+	once.Do(func() {
+		info = Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+
+		build, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		// go install module@version records the module version
+		if info.Version == "dev" && build.Main.Version != "" && build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	})
+	return info
+}
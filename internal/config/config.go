@@ -3,14 +3,23 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
+	// Environment profile, switching the defaults of GinMode, LogFormat and
+	// LogLevel
+	AppEnv  string
+	GinMode string
+
 	// Laravel configuration
 	LaravelAddr string
 
@@ -18,27 +27,240 @@ type Config struct {
 	HTTPAddr         string
 	HTTPReadTimeout  time.Duration
 	HTTPWriteTimeout time.Duration
+	HTTPSocketMode   os.FileMode
+	TrustedProxies   []string
+	HTTPBasePath     string
+	HTTPLegacyPaths  bool
+
+	// gRPC server bind address; empty disables the gRPC API
+	GRPCAddr string
+
+	// Client IPs or CIDRs allowed on the admin and internal endpoints (empty
+	// allows any), and denied on the client-facing ones
+	AdminAllowedIPs []string
+	DeniedIPs       []string
+
+	// JWT configuration; tokens signed with JWTSecretNext are accepted too,
+	// so the secret can be rotated
+	JWTSecret     string
+	JWTSecretNext string
+	JWTExpiresIn  int
+	JWTAlgo       string
 
-	// JWT configuration
-	JWTSecret    string
-	JWTExpiresIn int
-	JWTAlgo      string
+	// Signing keys identified by kid ("kid:secret" entries); new tokens are
+	// signed with JWTActiveKID while every listed key validates
+	JWTKeys      []string
+	JWTActiveKID string
+
+	// Private key signing tokens with an asymmetric JWTAlgo, and public keys
+	// of retired signing keys still accepted; all are published as JWKS
+	JWTPrivateKeyFile string
+	JWTPublicKeyFiles []string
 
 	// Redis configuration
 	RedisAddr     string
 	RedisDB       int
 	RedisPassword string
+	RedisUsername string
 	RedisChannel  string
+	RedisTLS      bool
+	RedisTLSCA    string
+
+	// Notification fan-out (FanoutWorkers 0 dispatches inline)
+	FanoutWorkers   int
+	FanoutQueueSize int
+
+	// Idempotency configuration
+	IdempotencyTTL time.Duration
 
 	// Long-polling configuration
-	PollTimeout time.Duration
+	PollTimeout    time.Duration
+	MaxPollTimeout time.Duration
+	BatchWait      time.Duration
+
+	// Event storage: "laravel" fetches events from Laravel, "redis" keeps
+	// them in Redis and serves polls without calling Laravel
+	StorageMode         string
+	EventStoreMaxLen    int
+	EventStoreRetention time.Duration
+	EventStoreMaxAge    time.Duration
+
+	// Push ingestion configuration (PushBufferSize 0 disables it)
+	PushBufferSize int
+	PushBufferTTL  time.Duration
+
+	// How often expired events are pruned from the push buffer and the
+	// event store
+	RetentionInterval time.Duration
+
+	// Per-channel poller cap (0 disables) and what happens beyond it:
+	// "reject" answers 429, "coalesce" shares one wake-up among the extras
+	MaxPollersPerChannel int
+	ChannelOverflow      string
+
+	// Global cap on waiting polls (0 disables); rejected polls are told to
+	// retry after RetryAfter plus jitter
+	MaxWaitingPolls int
+	RetryAfter      time.Duration
+
+	// On shutdown, waiting polls get a reconnect event asking them to retry
+	// after ReconnectHint plus jitter (0 disables)
+	ReconnectHint time.Duration
+
+	// Brute-force protection of /getAccessToken: attempts per IP and window
+	// (0 disables), and lockouts after consecutive bad secrets (0 disables),
+	// doubling from TokenLockout up to TokenLockoutMax
+	TokenRateLimit       int
+	TokenRateWindow      time.Duration
+	TokenLockoutFailures int
+	TokenLockout         time.Duration
+	TokenLockoutMax      time.Duration
+
+	// While the notification subscription is down, waiting polls refetch
+	// upstream every DegradedPollInterval (poll), wait for their timeout
+	// (wait) or are answered at once (short)
+	DegradedMode         string
+	DegradedPollInterval time.Duration
+
+	// Keep-alive interval for idle polls (0 disables)
+	KeepAliveInterval time.Duration
+
+	// Presence configuration
+	PresenceGrace   time.Duration
+	PresenceChannel string
+	// How often presence is shared with the other instances (0 disables)
+	PresenceSync time.Duration
+
+	// Access token secret, plus optional prefix-scoped secrets loaded from a
+	// JSON file or a Redis hash and reloaded every AccessSecretsRefresh.
+	// AccessTokenSecretNext is accepted as well during a rotation, and sent
+	// to Laravel once it rejects the current secret.
+	AccessTokenSecret     string
+	AccessTokenSecretNext string
+	AccessSecretsFile     string
+	AccessSecretsRedisKey string
+	AccessSecretsRefresh  time.Duration
+
+	// Long-lived API keys of machine clients, as SHA-256 digests mapped to
+	// channel patterns in a JSON file or a Redis hash, reloaded every
+	// AccessSecretsRefresh
+	APIKeysFile     string
+	APIKeysRedisKey string
+
+	// End-to-end payload encryption: only envelopes sealed by Laravel are
+	// relayed. TransportEncryptionKey instead has the relay seal plaintext
+	// payloads itself (empty disables it).
+	EncryptionRequired     bool
+	TransportEncryptionKey string
+
+	// HttpOnly token cookie (empty TokenCookieName disables cookie mode)
+	TokenCookieName     string
+	TokenCookieDomain   string
+	TokenCookiePath     string
+	TokenCookieSecure   bool
+	TokenCookieSameSite string
+
+	// Admin API configuration
+	AdminSecret    string
+	ControlChannel string
+
+	// Audit log: AuditSink is "" (disabled), "file" or "redis"
+	AuditSink        string
+	AuditFile        string
+	AuditRedisKey    string
+	AuditRedisMaxLen int
+
+	// Notification recording for replay: RecordSink is "" (disabled), "file"
+	// or "redis" (a stream capped at about RecordRedisMaxLen entries)
+	RecordSink        string
+	RecordFile        string
+	RecordRedisKey    string
+	RecordRedisMaxLen int
+
+	// Delivery ack watermarks expire AckTTL after a channel's last ack
+	AckTTL time.Duration
 
-	// Access token secret
-	AccessTokenSecret string
+	// Server-side offsets of clients polling without offsets
+	ConsumerOffsets    bool
+	ConsumerOffsetsTTL time.Duration
 
-	// Logging configuration
-	LogLevel  string
-	LogFormat string
+	// Dead letters of dropped notifications (DeadLetterMaxLen 0 disables
+	// them, DeadLetterIdle 0 skips channels without pollers)
+	DeadLetterMaxLen int
+	DeadLetterIdle   time.Duration
+
+	// Limits of /getHistory: widest ID range and most events per response
+	HistoryMaxRange  int
+	HistoryMaxEvents int
+
+	// Request limits in bytes (0 disables each): body, raw query string and
+	// channel ID
+	MaxRequestBody     int
+	MaxQueryLength     int
+	MaxChannelIDLength int
+
+	// Channel affinity: this instance's advertised base URL (empty disables)
+	// and how often it heartbeats into the shared instance ring
+	AffinityURL       string
+	AffinityHeartbeat time.Duration
+
+	// Multi-tenant mode: one isolated engine per app of TenantsFile
+	TenantsFile string
+
+	// Authorization decision cache configuration
+	AuthCachePositiveTTL time.Duration
+	AuthCacheNegativeTTL time.Duration
+	AuthCacheMaxEntries  int
+
+	// Private channels are authorized against Laravel before tokens are
+	// issued or honored
+	PrivateChannels       []string
+	PrivateChannelAuthURL string
+	PrivateAuthTimeout    time.Duration
+
+	// Public channels are polled without a token, each client IP limited to
+	// PublicRateLimit polls per PublicRateWindow (0 disables the limit)
+	PublicChannels   []string
+	PublicRateLimit  int
+	PublicRateWindow time.Duration
+
+	// OAuth2 token introspection (RFC 7662) validating tokens this service
+	// did not issue (IntrospectionURL "" disables it), with scope=pattern
+	// entries mapping token scopes to channels
+	IntrospectionURL      string
+	IntrospectionClientID string
+	IntrospectionSecret   string
+	IntrospectionScopes   []string
+	IntrospectionTimeout  time.Duration
+
+	// Pusher-style credentials signing /broadcasting/auth responses
+	// (EchoAppSecret "" disables the endpoint)
+	EchoAppKey    string
+	EchoAppSecret string
+
+	// Logging configuration (SlowRequestThreshold 0 disables slow request
+	// warnings, LogSampleRate keeps one in every N debug records of each
+	// message)
+	LogLevel             string
+	LogFormat            string
+	LogSampleRate        int
+	SlowRequestThreshold time.Duration
+
+	// Log output: stdout, syslog, journald or file, rotated at
+	// LogFileMaxSize megabytes or after LogFileMaxAge, keeping
+	// LogFileMaxBackups rotated files (0 disables each)
+	LogOutput         string
+	LogSyslogAddr     string
+	LogFilePath       string
+	LogFileMaxSize    int
+	LogFileMaxAge     time.Duration
+	LogFileMaxBackups int
+
+	// Access logs: structured, combined or off, the optional fields of
+	// structured logs and path patterns not logged
+	AccessLogFormat       string
+	AccessLogFields       []string
+	AccessLogExcludePaths []string
 
 	// Laravel upstream pool configuration
 	LaravelUpstreamWorkers int
@@ -49,6 +271,64 @@ type Config struct {
 	HTTPMaxConnsPerHost   int
 	HTTPIdleConnTimeout   time.Duration
 	LaravelRequestTimeout time.Duration
+	UpstreamDecodeRetries int
+	UpstreamQueueTimeout  time.Duration
+	UpstreamAuthMode      string
+
+	// Upstream response cache (UpstreamCacheTTL 0 disables it)
+	UpstreamCacheTTL  time.Duration
+	UpstreamCacheSize int
+
+	// Deep health check probing the event source with a sentinel channel, at
+	// most once per HealthProbeInterval
+	HealthProbeChannel  string
+	HealthProbeInterval time.Duration
+	HealthProbeTimeout  time.Duration
+
+	// Startup waits up to StartupWaitTimeout for Redis and the upstream, and
+	// with StrictStartup fails rather than starting while they are down
+	StartupWaitTimeout time.Duration
+	StrictStartup      bool
+
+	// Upstream error budget configuration
+	ErrorBudget            float64
+	ErrorBudgetBurnRate    float64
+	ErrorBudgetWindow      time.Duration
+	ErrorBudgetMinRequests int
+	ErrorBudgetCooldown    time.Duration
+	AlertWebhookURL        string
+	ErrorReportingDSN      string
+	ErrorReportingEnv      string
+
+	// Fault injection for client resilience testing: latency of up to
+	// ChaosLatency, dropped notifications, 500s and truncated responses, each
+	// at its rate between 0 and 1. Never enable in production.
+	ChaosEnabled      bool
+	ChaosLatency      time.Duration
+	ChaosLatencyRate  float64
+	ChaosDropRate     float64
+	ChaosErrorRate    float64
+	ChaosTruncateRate float64
+
+	// Metrics push over OTLP/HTTP, read from the standard OTEL_ variables.
+	// OTLPMetricsEndpoint is the full /v1/metrics URL, empty when disabled.
+	OTLPMetricsEndpoint string
+	OTLPProtocol        string
+	OTLPHeaders         string
+	OTLPTimeout         time.Duration
+	OTLPExportInterval  time.Duration
+	OTelServiceName     string
+	OTelResourceAttrs   string
+
+	// Response format used when the client's Accept header allows any:
+	// "json", "ndjson" or "msgpack"
+	ResponseFormat string
+
+	// Response compression configuration
+	CompressionEnabled     bool
+	CompressionLevel       int
+	CompressionBrotliLevel int
+	CompressionMinSize     int
 
 	// CORS configuration
 	CORSAllowedOrigins   string
@@ -60,60 +340,612 @@ type Config struct {
 
 // Load loads configuration from environment variables
 func Load() (*Config, error) {
-	// Try to load .env file (ignore error if file doesn't exist)
-	_ = godotenv.Load()
+	if err := loadEnvFiles(); err != nil {
+		return nil, err
+	}
 
-	cfg := &Config{
-		LaravelAddr:            getEnv("LARAVEL_ADDR", "http://localhost:8000"),
-		HTTPAddr:               getEnv("HTTP_ADDR", ":8085"),
-		HTTPReadTimeout:        getDurationEnv("HTTP_READ_TIMEOUT", 30*time.Second),
-		HTTPWriteTimeout:       getDurationEnv("HTTP_WRITE_TIMEOUT", 30*time.Second),
-		JWTSecret:              getEnv("JWT_SECRET", "super_long_random_secret"),
-		JWTExpiresIn:           getIntEnv("JWT_EXPIRES_IN", 3600),
-		JWTAlgo:                getEnv("JWT_ALGO", "HS256"),
-		RedisAddr:              getEnv("REDIS_ADDR", "redis:6379"),
-		RedisDB:                getIntEnv("REDIS_DB", 0),
-		RedisPassword:          getEnv("REDIS_PASSWORD", ""),
-		RedisChannel:           getEnv("REDIS_CHANNEL", "longpoll:events"),
-		PollTimeout:            getDurationEnv("POLL_TIMEOUT", 25*time.Second),
-		AccessTokenSecret:      getEnv("ACCESS_TOKEN_SECRET", "shared_secret_between_laravel_and_go"),
-		LogLevel:               getEnv("LOG_LEVEL", "info"),
-		LogFormat:              getEnv("LOG_FORMAT", "json"),
-		LaravelUpstreamWorkers: getIntEnv("LARAVEL_UPSTREAM_WORKERS", 15),
-		MaxLimit:               getIntEnv("MAX_LIMIT", 100),
-		HTTPMaxIdleConns:       getIntEnv("HTTP_MAX_IDLE_CONNS", 100),
-		HTTPMaxConnsPerHost:    getIntEnv("HTTP_MAX_CONNS_PER_HOST", 50),
-		HTTPIdleConnTimeout:    getDurationEnv("HTTP_IDLE_CONN_TIMEOUT", 90*time.Second),
-		LaravelRequestTimeout:  getDurationEnv("LARAVEL_REQUEST_TIMEOUT", 30*time.Second),
-		CORSAllowedOrigins:     getEnv("CORS_ALLOWED_ORIGINS", "*"),
-		CORSAllowedMethods:     getEnv("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
-		CORSAllowedHeaders:     getEnv("CORS_ALLOWED_HEADERS", "Content-Type,Authorization,X-Requested-With"),
-		CORSAllowCredentials:   getBoolEnv("CORS_ALLOW_CREDENTIALS", true),
-		CORSMaxAge:             getIntEnv("CORS_MAX_AGE", 3600),
-	}
-
-	if err := cfg.validate(); err != nil {
+	cfg := build(prefixedEnv(os.Getenv))
+	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
 
 	return cfg, nil
 }
 
-// validate checks if the configuration is valid
-func (c *Config) validate() error {
+// loadEnvFiles loads ENV_FILE, a comma-separated list of dotenv files where
+// later files override earlier ones, or .env when it exists. Variables set in
+// the environment take precedence over every file.
+func loadEnvFiles() error {
+	files := getListEnv(prefixedEnv(os.Getenv), "ENV_FILE")
+	if len(files) == 0 {
+		// Try to load .env file (ignore error if file doesn't exist)
+		_ = godotenv.Load()
+		return nil
+	}
+
+	merged := make(map[string]string)
+	for _, file := range files {
+		values, err := godotenv.Read(file)
+		if err != nil {
+			return fmt.Errorf("ENV_FILE: %w", err)
+		}
+		for key, value := range values {
+			merged[key] = value
+		}
+	}
+	for key, value := range merged {
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("ENV_FILE: %w", err)
+		}
+	}
+	return nil
+}
+
+// envProfile holds the defaults an APP_ENV switches
+type envProfile struct {
+	ginMode   string
+	logFormat string
+	logLevel  string
+}
+
+// profileFor returns the defaults of an APP_ENV. Laravel's names are
+// accepted, and unknown environments get production defaults, since
+// APP_ENV may be shared with a Laravel app in the same container.
+func profileFor(appEnv string) envProfile {
+	switch appEnv {
+	case "local", "development":
+		return envProfile{ginMode: "debug", logFormat: "text", logLevel: "debug"}
+	case "testing":
+		return envProfile{ginMode: "test", logFormat: "text", logLevel: "info"}
+	}
+	return envProfile{ginMode: "release", logFormat: "json", logLevel: "info"}
+}
+
+// EnvPrefix may precede every setting, e.g. LONGPOLL_REDIS_ADDR, to avoid
+// clashing with generic names other processes in the container use
+const EnvPrefix = "LONGPOLL_"
+
+// prefixedEnv looks up EnvPrefix+key first, falling back to key
+func prefixedEnv(env func(string) string) func(string) string {
+	return func(key string) string {
+		if value := env(EnvPrefix + key); value != "" {
+			return value
+		}
+		return env(key)
+	}
+}
+
+// Defaults returns the configuration used when no environment variable is set
+func Defaults() *Config {
+	return build(func(string) string { return "" })
+}
+
+// build reads the configuration through env, falling back to defaults
+func build(env func(string) string) *Config {
+	appEnv := getEnv(env, "APP_ENV", "production")
+	profile := profileFor(appEnv)
+
+	cfg := &Config{
+		AppEnv:                 appEnv,
+		GinMode:                getEnv(env, "GIN_MODE", profile.ginMode),
+		LaravelAddr:            getEnv(env, "LARAVEL_ADDR", "http://localhost:8000"),
+		HTTPAddr:               getEnv(env, "HTTP_ADDR", ":8085"),
+		HTTPReadTimeout:        getDurationEnv(env, "HTTP_READ_TIMEOUT", 30*time.Second),
+		HTTPWriteTimeout:       getDurationEnv(env, "HTTP_WRITE_TIMEOUT", 30*time.Second),
+		HTTPSocketMode:         getFileModeEnv(env, "HTTP_SOCKET_MODE", 0660),
+		TrustedProxies:         getListEnv(env, "TRUSTED_PROXIES"),
+		AdminAllowedIPs:        getListEnv(env, "ADMIN_ALLOWED_IPS"),
+		DeniedIPs:              getListEnv(env, "DENIED_IPS"),
+		HTTPBasePath:           getEnv(env, "HTTP_BASE_PATH", ""),
+		HTTPLegacyPaths:        getBoolEnv(env, "HTTP_LEGACY_PATHS", true),
+		GRPCAddr:               getEnv(env, "GRPC_ADDR", ""),
+		JWTSecret:              getEnv(env, "JWT_SECRET", "super_long_random_secret"),
+		JWTSecretNext:          getEnv(env, "JWT_SECRET_NEXT", ""),
+		JWTKeys:                getListEnv(env, "JWT_KEYS"),
+		JWTActiveKID:           getEnv(env, "JWT_ACTIVE_KID", ""),
+		JWTPrivateKeyFile:      getEnv(env, "JWT_PRIVATE_KEY_FILE", ""),
+		JWTPublicKeyFiles:      getListEnv(env, "JWT_PUBLIC_KEY_FILES"),
+		JWTExpiresIn:           getIntEnv(env, "JWT_EXPIRES_IN", 3600),
+		JWTAlgo:                getEnv(env, "JWT_ALGO", "HS256"),
+		RedisAddr:              getEnv(env, "REDIS_ADDR", "redis:6379"),
+		RedisDB:                getIntEnv(env, "REDIS_DB", 0),
+		RedisPassword:          getEnv(env, "REDIS_PASSWORD", ""),
+		RedisUsername:          getEnv(env, "REDIS_USERNAME", ""),
+		RedisChannel:           getEnv(env, "REDIS_CHANNEL", "longpoll:events"),
+		RedisTLS:               getBoolEnv(env, "REDIS_TLS", false),
+		RedisTLSCA:             getEnv(env, "REDIS_TLS_CA", ""),
+		FanoutWorkers:          getIntEnv(env, "FANOUT_WORKERS", 8),
+		FanoutQueueSize:        getIntEnv(env, "FANOUT_QUEUE_SIZE", 1024),
+		IdempotencyTTL:         getDurationEnv(env, "IDEMPOTENCY_TTL", 24*time.Hour),
+		PollTimeout:            getDurationEnv(env, "POLL_TIMEOUT", 25*time.Second),
+		BatchWait:              getDurationEnv(env, "BATCH_WAIT", 0),
+		MaxPollTimeout:         getDurationEnv(env, "MAX_POLL_TIMEOUT", 0),
+		KeepAliveInterval:      getDurationEnv(env, "KEEPALIVE_INTERVAL", 0),
+		MaxWaitingPolls:        getIntEnv(env, "MAX_WAITING_POLLS", 0),
+		RetryAfter:             getDurationEnv(env, "RETRY_AFTER", 5*time.Second),
+		ReconnectHint:          getDurationEnv(env, "RECONNECT_HINT", time.Second),
+		TokenRateLimit:         getIntEnv(env, "TOKEN_RATE_LIMIT", 60),
+		TokenRateWindow:        getDurationEnv(env, "TOKEN_RATE_WINDOW", time.Minute),
+		TokenLockoutFailures:   getIntEnv(env, "TOKEN_LOCKOUT_FAILURES", 10),
+		TokenLockout:           getDurationEnv(env, "TOKEN_LOCKOUT", time.Minute),
+		TokenLockoutMax:        getDurationEnv(env, "TOKEN_LOCKOUT_MAX", time.Hour),
+		DegradedMode:           getEnv(env, "DEGRADED_MODE", "poll"),
+		DegradedPollInterval:   getDurationEnv(env, "DEGRADED_POLL_INTERVAL", 5*time.Second),
+		MaxPollersPerChannel:   getIntEnv(env, "MAX_POLLERS_PER_CHANNEL", 0),
+		ChannelOverflow:        getEnv(env, "CHANNEL_OVERFLOW", "reject"),
+		StorageMode:            getEnv(env, "STORAGE_MODE", "laravel"),
+		EventStoreMaxLen:       getIntEnv(env, "EVENT_STORE_MAX_LEN", 1000),
+		EventStoreRetention:    getDurationEnv(env, "EVENT_STORE_RETENTION", 24*time.Hour),
+		EventStoreMaxAge:       getDurationEnv(env, "EVENT_STORE_MAX_AGE", 0),
+		PushBufferSize:         getIntEnv(env, "PUSH_BUFFER_SIZE", 0),
+		PushBufferTTL:          getDurationEnv(env, "PUSH_BUFFER_TTL", 10*time.Minute),
+		RetentionInterval:      getDurationEnv(env, "RETENTION_INTERVAL", time.Minute),
+		PresenceGrace:          getDurationEnv(env, "PRESENCE_GRACE", 10*time.Second),
+		PresenceChannel:        getEnv(env, "PRESENCE_CHANNEL", ""),
+		PresenceSync:           getDurationEnv(env, "PRESENCE_SYNC_INTERVAL", 5*time.Second),
+		AccessTokenSecret:      getEnv(env, "ACCESS_TOKEN_SECRET", "shared_secret_between_laravel_and_go"),
+		AccessTokenSecretNext:  getEnv(env, "ACCESS_TOKEN_SECRET_NEXT", ""),
+		AccessSecretsFile:      getEnv(env, "ACCESS_SECRETS_FILE", ""),
+		AccessSecretsRedisKey:  getEnv(env, "ACCESS_SECRETS_REDIS_KEY", ""),
+		AccessSecretsRefresh:   getDurationEnv(env, "ACCESS_SECRETS_REFRESH", 30*time.Second),
+		APIKeysFile:            getEnv(env, "API_KEYS_FILE", ""),
+		APIKeysRedisKey:        getEnv(env, "API_KEYS_REDIS_KEY", ""),
+		EncryptionRequired:     getBoolEnv(env, "ENCRYPTION_REQUIRED", false),
+		TransportEncryptionKey: getEnv(env, "TRANSPORT_ENCRYPTION_KEY", ""),
+		TokenCookieName:        getEnv(env, "TOKEN_COOKIE_NAME", ""),
+		TokenCookieDomain:      getEnv(env, "TOKEN_COOKIE_DOMAIN", ""),
+		TokenCookiePath:        getEnv(env, "TOKEN_COOKIE_PATH", "/"),
+		TokenCookieSecure:      getBoolEnv(env, "TOKEN_COOKIE_SECURE", true),
+		TokenCookieSameSite:    getEnv(env, "TOKEN_COOKIE_SAMESITE", "lax"),
+		AdminSecret:            getEnv(env, "ADMIN_SECRET", ""),
+		ControlChannel:         getEnv(env, "CONTROL_CHANNEL", "longpoll:control"),
+		AuthCachePositiveTTL:   getDurationEnv(env, "AUTH_CACHE_POSITIVE_TTL", 30*time.Second),
+		AuthCacheNegativeTTL:   getDurationEnv(env, "AUTH_CACHE_NEGATIVE_TTL", 5*time.Second),
+		AuthCacheMaxEntries:    getIntEnv(env, "AUTH_CACHE_MAX_ENTRIES", 10000),
+		PrivateChannels:        getListEnv(env, "PRIVATE_CHANNELS"),
+		PrivateChannelAuthURL:  getEnv(env, "PRIVATE_CHANNEL_AUTH_URL", ""),
+		PrivateAuthTimeout:     getDurationEnv(env, "PRIVATE_CHANNEL_AUTH_TIMEOUT", 5*time.Second),
+		PublicChannels:         getListEnv(env, "PUBLIC_CHANNELS"),
+		PublicRateLimit:        getIntEnv(env, "PUBLIC_RATE_LIMIT", 30),
+		PublicRateWindow:       getDurationEnv(env, "PUBLIC_RATE_WINDOW", time.Minute),
+		IntrospectionURL:       getEnv(env, "INTROSPECTION_URL", ""),
+		IntrospectionClientID:  getEnv(env, "INTROSPECTION_CLIENT_ID", ""),
+		IntrospectionSecret:    getEnv(env, "INTROSPECTION_CLIENT_SECRET", ""),
+		IntrospectionScopes:    getListEnv(env, "INTROSPECTION_SCOPES"),
+		IntrospectionTimeout:   getDurationEnv(env, "INTROSPECTION_TIMEOUT", 5*time.Second),
+		EchoAppKey:             getEnv(env, "ECHO_APP_KEY", "longpoll"),
+		EchoAppSecret:          getEnv(env, "ECHO_APP_SECRET", ""),
+		LogLevel:               getEnv(env, "LOG_LEVEL", profile.logLevel),
+		LogFormat:              getEnv(env, "LOG_FORMAT", profile.logFormat),
+		LogSampleRate:          getIntEnv(env, "LOG_SAMPLE_RATE", 1),
+		LogOutput:              getEnv(env, "LOG_OUTPUT", "stdout"),
+		LogSyslogAddr:          getEnv(env, "LOG_SYSLOG_ADDR", ""),
+		LogFilePath:            getEnv(env, "LOG_FILE_PATH", "/var/log/longpoll/longpoll.log"),
+		LogFileMaxSize:         getIntEnv(env, "LOG_FILE_MAX_SIZE", 100),
+		LogFileMaxAge:          getDurationEnv(env, "LOG_FILE_MAX_AGE", 0),
+		LogFileMaxBackups:      getIntEnv(env, "LOG_FILE_MAX_BACKUPS", 7),
+		AccessLogFormat:        getEnv(env, "ACCESS_LOG_FORMAT", "structured"),
+		AccessLogFields:        getListEnv(env, "ACCESS_LOG_FIELDS"),
+		AccessLogExcludePaths:  getListEnv(env, "ACCESS_LOG_EXCLUDE_PATHS"),
+		SlowRequestThreshold:   getDurationEnv(env, "SLOW_REQUEST_THRESHOLD", 0),
+		LaravelUpstreamWorkers: getIntEnv(env, "LARAVEL_UPSTREAM_WORKERS", 15),
+		MaxLimit:               getIntEnv(env, "MAX_LIMIT", 100),
+		HTTPMaxIdleConns:       getIntEnv(env, "HTTP_MAX_IDLE_CONNS", 100),
+		HTTPMaxConnsPerHost:    getIntEnv(env, "HTTP_MAX_CONNS_PER_HOST", 50),
+		HTTPIdleConnTimeout:    getDurationEnv(env, "HTTP_IDLE_CONN_TIMEOUT", 90*time.Second),
+		LaravelRequestTimeout:  getDurationEnv(env, "LARAVEL_REQUEST_TIMEOUT", 30*time.Second),
+		UpstreamDecodeRetries:  getIntEnv(env, "UPSTREAM_DECODE_RETRIES", 0),
+		UpstreamQueueTimeout:   getDurationEnv(env, "UPSTREAM_QUEUE_TIMEOUT", 0),
+		UpstreamAuthMode:       getEnv(env, "UPSTREAM_AUTH_MODE", "query"),
+		UpstreamCacheTTL:       getDurationEnv(env, "UPSTREAM_CACHE_TTL", 0),
+		UpstreamCacheSize:      getIntEnv(env, "UPSTREAM_CACHE_SIZE", 10000),
+		HealthProbeChannel:     getEnv(env, "HEALTH_PROBE_CHANNEL", "__health"),
+		HealthProbeInterval:    getDurationEnv(env, "HEALTH_PROBE_INTERVAL", 10*time.Second),
+		HealthProbeTimeout:     getDurationEnv(env, "HEALTH_PROBE_TIMEOUT", 5*time.Second),
+		StartupWaitTimeout:     getDurationEnv(env, "STARTUP_WAIT_TIMEOUT", 0),
+		StrictStartup:          getBoolEnv(env, "STRICT_STARTUP", false),
+		ErrorBudget:            getFloatEnv(env, "ERROR_BUDGET", 0.01),
+		ErrorBudgetBurnRate:    getFloatEnv(env, "ERROR_BUDGET_BURN_RATE", 2),
+		ErrorBudgetWindow:      getDurationEnv(env, "ERROR_BUDGET_WINDOW", 5*time.Minute),
+		ErrorBudgetMinRequests: getIntEnv(env, "ERROR_BUDGET_MIN_REQUESTS", 20),
+		ErrorBudgetCooldown:    getDurationEnv(env, "ERROR_BUDGET_COOLDOWN", 5*time.Minute),
+		AlertWebhookURL:        getEnv(env, "ALERT_WEBHOOK_URL", ""),
+		ErrorReportingDSN:      getEnv(env, "ERROR_REPORTING_DSN", ""),
+		ErrorReportingEnv:      getEnv(env, "ERROR_REPORTING_ENVIRONMENT", ""),
+		ChaosEnabled:           getBoolEnv(env, "CHAOS_ENABLED", false),
+		ChaosLatency:           getDurationEnv(env, "CHAOS_LATENCY", 5*time.Second),
+		ChaosLatencyRate:       getFloatEnv(env, "CHAOS_LATENCY_RATE", 0),
+		ChaosDropRate:          getFloatEnv(env, "CHAOS_DROP_RATE", 0),
+		ChaosErrorRate:         getFloatEnv(env, "CHAOS_ERROR_RATE", 0),
+		ChaosTruncateRate:      getFloatEnv(env, "CHAOS_TRUNCATE_RATE", 0),
+		OTLPMetricsEndpoint:    otlpMetricsEndpoint(env),
+		OTLPProtocol:           getEnv(env, "OTEL_EXPORTER_OTLP_METRICS_PROTOCOL", getEnv(env, "OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")),
+		OTLPHeaders:            getEnv(env, "OTEL_EXPORTER_OTLP_METRICS_HEADERS", getEnv(env, "OTEL_EXPORTER_OTLP_HEADERS", "")),
+		OTLPTimeout:            time.Duration(getIntEnv(env, "OTEL_EXPORTER_OTLP_METRICS_TIMEOUT", getIntEnv(env, "OTEL_EXPORTER_OTLP_TIMEOUT", 10000))) * time.Millisecond,
+		OTLPExportInterval:     time.Duration(getIntEnv(env, "OTEL_METRIC_EXPORT_INTERVAL", 60000)) * time.Millisecond,
+		OTelServiceName:        getEnv(env, "OTEL_SERVICE_NAME", "longpoll-server"),
+		OTelResourceAttrs:      getEnv(env, "OTEL_RESOURCE_ATTRIBUTES", ""),
+		AuditSink:              getEnv(env, "AUDIT_SINK", ""),
+		AuditFile:              getEnv(env, "AUDIT_FILE", "audit.log"),
+		AuditRedisKey:          getEnv(env, "AUDIT_REDIS_KEY", "longpoll:audit"),
+		AuditRedisMaxLen:       getIntEnv(env, "AUDIT_REDIS_MAX_LEN", 100000),
+		RecordSink:             getEnv(env, "RECORD_SINK", ""),
+		RecordFile:             getEnv(env, "RECORD_FILE", "notifications.jsonl"),
+		RecordRedisKey:         getEnv(env, "RECORD_REDIS_KEY", "longpoll:recording"),
+		RecordRedisMaxLen:      getIntEnv(env, "RECORD_REDIS_MAX_LEN", 1000000),
+		TenantsFile:            getEnv(env, "TENANTS_FILE", ""),
+		AckTTL:                 getDurationEnv(env, "ACK_TTL", 7*24*time.Hour),
+		ConsumerOffsets:        getBoolEnv(env, "CONSUMER_OFFSETS", false),
+		ConsumerOffsetsTTL:     getDurationEnv(env, "CONSUMER_OFFSETS_TTL", 7*24*time.Hour),
+		DeadLetterMaxLen:       getIntEnv(env, "DEAD_LETTER_MAX_LEN", 0),
+		DeadLetterIdle:         getDurationEnv(env, "DEAD_LETTER_IDLE", 0),
+		HistoryMaxRange:        getIntEnv(env, "HISTORY_MAX_RANGE", 10000),
+		HistoryMaxEvents:       getIntEnv(env, "HISTORY_MAX_EVENTS", 1000),
+		MaxRequestBody:         getIntEnv(env, "MAX_REQUEST_BODY", 1<<20),
+		MaxQueryLength:         getIntEnv(env, "MAX_QUERY_LENGTH", 8192),
+		MaxChannelIDLength:     getIntEnv(env, "MAX_CHANNEL_ID_LENGTH", 255),
+		AffinityURL:            getEnv(env, "AFFINITY_URL", ""),
+		AffinityHeartbeat:      getDurationEnv(env, "AFFINITY_HEARTBEAT", 5*time.Second),
+		ResponseFormat:         getEnv(env, "RESPONSE_FORMAT", "json"),
+		CompressionEnabled:     getBoolEnv(env, "COMPRESSION_ENABLED", false),
+		CompressionLevel:       getIntEnv(env, "COMPRESSION_LEVEL", -1),
+		CompressionBrotliLevel: getIntEnv(env, "COMPRESSION_BROTLI_LEVEL", 4),
+		CompressionMinSize:     getIntEnv(env, "COMPRESSION_MIN_SIZE", 1024),
+		CORSAllowedOrigins:     getEnv(env, "CORS_ALLOWED_ORIGINS", "*"),
+		CORSAllowedMethods:     getEnv(env, "CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
+		CORSAllowedHeaders:     getEnv(env, "CORS_ALLOWED_HEADERS", "Content-Type,Authorization,X-Requested-With,traceparent,tracestate"),
+		CORSAllowCredentials:   getBoolEnv(env, "CORS_ALLOW_CREDENTIALS", true),
+		CORSMaxAge:             getIntEnv(env, "CORS_MAX_AGE", 3600),
+	}
+
+	// Clients may only shorten their wait unless a higher max is configured
+	if cfg.MaxPollTimeout == 0 {
+		cfg.MaxPollTimeout = cfg.PollTimeout
+	}
+	if cfg.PrivateChannelAuthURL == "" {
+		cfg.PrivateChannelAuthURL = strings.TrimRight(cfg.LaravelAddr, "/") + "/broadcasting/auth"
+	}
+	if len(cfg.AccessLogFields) == 0 {
+		cfg.AccessLogFields = []string{"client_ip", "request_id"}
+	}
+
+	return cfg
+}
+
+// otlpMetricsEndpoint resolves the metrics URL the way OpenTelemetry SDKs
+// do: the signal-specific endpoint is used as is, the generic one gets
+// /v1/metrics appended. OTEL_METRICS_EXPORTER=none disables pushing.
+func otlpMetricsEndpoint(env func(string) string) string {
+	if getEnv(env, "OTEL_METRICS_EXPORTER", "otlp") == "none" {
+		return ""
+	}
+	if endpoint := env("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := env("OTEL_EXPORTER_OTLP_ENDPOINT"); endpoint != "" {
+		return strings.TrimRight(endpoint, "/") + "/v1/metrics"
+	}
+	return ""
+}
+
+// ValidationError lists every invalid setting, so they can all be fixed in
+// one pass
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return e.Problems[0]
+	}
+	return fmt.Sprintf("%d invalid settings: %s", len(e.Problems), strings.Join(e.Problems, "; "))
+}
+
+// Validate checks if the configuration is valid. All problems are reported
+// in a *ValidationError rather than only the first.
+func (c *Config) Validate() error {
+	var problems []string
+	invalid := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
 	if c.JWTSecret == "" {
-		return fmt.Errorf("JWT_SECRET is required")
+		invalid("JWT_SECRET is required")
+	}
+	asymmetric := !strings.HasPrefix(c.JWTAlgo, "HS")
+	if asymmetric && c.JWTPrivateKeyFile == "" {
+		invalid("JWT_ALGO=%s requires JWT_PRIVATE_KEY_FILE", c.JWTAlgo)
+	}
+	if !asymmetric && c.JWTPrivateKeyFile != "" {
+		invalid("JWT_PRIVATE_KEY_FILE requires an RS*, ES* or EdDSA JWT_ALGO")
+	}
+	if asymmetric && len(c.JWTKeys) > 0 {
+		invalid("JWT_KEYS only applies to HS* algorithms")
+	}
+	if len(c.JWTKeys) > 0 {
+		active, malformed := false, false
+		for _, entry := range c.JWTKeys {
+			kid, secret, ok := strings.Cut(entry, ":")
+			if !ok || kid == "" || secret == "" {
+				malformed = true
+				continue
+			}
+			active = active || kid == c.JWTActiveKID
+		}
+		if malformed {
+			invalid("JWT_KEYS entries must be kid:secret")
+		}
+		if !active {
+			invalid("JWT_ACTIVE_KID must name one of JWT_KEYS")
+		}
 	}
 	if c.AccessTokenSecret == "" {
-		return fmt.Errorf("ACCESS_TOKEN_SECRET is required")
+		invalid("ACCESS_TOKEN_SECRET is required")
+	}
+	if c.AccessSecretsRefresh < time.Second {
+		invalid("ACCESS_SECRETS_REFRESH must be at least 1s")
+	}
+	if c.TransportEncryptionKey != "" && len(c.TransportEncryptionKey) < 32 {
+		invalid("TRANSPORT_ENCRYPTION_KEY must be at least 32 bytes")
+	}
+	if c.EncryptionRequired && c.TransportEncryptionKey != "" {
+		invalid("ENCRYPTION_REQUIRED and TRANSPORT_ENCRYPTION_KEY are mutually exclusive")
+	}
+	switch c.TokenCookieSameSite {
+	case "lax", "strict", "none":
+	default:
+		invalid("TOKEN_COOKIE_SAMESITE must be lax, strict or none")
+	}
+	if c.TokenCookieSameSite == "none" && !c.TokenCookieSecure {
+		invalid("TOKEN_COOKIE_SAMESITE=none requires TOKEN_COOKIE_SECURE")
 	}
 	if c.LaravelUpstreamWorkers < 1 {
-		return fmt.Errorf("LARAVEL_UPSTREAM_WORKERS must be at least 1")
+		invalid("LARAVEL_UPSTREAM_WORKERS must be at least 1")
 	}
 	if c.MaxLimit < 1 || c.MaxLimit > 1000 {
-		return fmt.Errorf("MAX_LIMIT must be between 1 and 1000")
+		invalid("MAX_LIMIT must be between 1 and 1000")
 	}
-	return nil
+	if c.ErrorBudget <= 0 || c.ErrorBudget >= 1 {
+		invalid("ERROR_BUDGET must be between 0 and 1")
+	}
+	if c.ErrorBudgetWindow < time.Second {
+		invalid("ERROR_BUDGET_WINDOW must be at least 1s")
+	}
+	if c.ResponseFormat != "json" && c.ResponseFormat != "ndjson" && c.ResponseFormat != "msgpack" {
+		invalid("RESPONSE_FORMAT must be json, ndjson or msgpack")
+	}
+	if c.CompressionLevel < -1 || c.CompressionLevel > 9 {
+		invalid("COMPRESSION_LEVEL must be between -1 and 9")
+	}
+	if c.CompressionBrotliLevel < 0 || c.CompressionBrotliLevel > 11 {
+		invalid("COMPRESSION_BROTLI_LEVEL must be between 0 and 11")
+	}
+	if c.AuthCacheMaxEntries < 1 {
+		invalid("AUTH_CACHE_MAX_ENTRIES must be at least 1")
+	}
+	if c.HistoryMaxRange < 1 || c.HistoryMaxEvents < 1 {
+		invalid("HISTORY_MAX_RANGE and HISTORY_MAX_EVENTS must be at least 1")
+	}
+	if c.MaxRequestBody < 0 || c.MaxQueryLength < 0 || c.MaxChannelIDLength < 0 {
+		invalid("MAX_REQUEST_BODY, MAX_QUERY_LENGTH and MAX_CHANNEL_ID_LENGTH must not be negative")
+	}
+	if c.TokenRateLimit > 0 && c.TokenRateWindow <= 0 {
+		invalid("TOKEN_RATE_WINDOW must be positive")
+	}
+	if c.TokenLockoutFailures > 0 && (c.TokenLockout <= 0 || c.TokenLockoutMax < c.TokenLockout) {
+		invalid("TOKEN_LOCKOUT must be positive and at most TOKEN_LOCKOUT_MAX")
+	}
+	if c.AffinityURL != "" {
+		u, err := url.Parse(c.AffinityURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("AFFINITY_URL must be an http(s) URL")
+		}
+		if c.AffinityHeartbeat < 100*time.Millisecond {
+			invalid("AFFINITY_HEARTBEAT must be at least 100ms")
+		}
+	}
+	if c.IntrospectionURL != "" {
+		u, err := url.Parse(c.IntrospectionURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("INTROSPECTION_URL must be an http(s) URL")
+		}
+		if len(c.IntrospectionScopes) == 0 {
+			invalid("INTROSPECTION_SCOPES is required with INTROSPECTION_URL")
+		}
+		for _, entry := range c.IntrospectionScopes {
+			scope, pattern, ok := strings.Cut(entry, "=")
+			if !ok || scope == "" || pattern == "" {
+				invalid("INTROSPECTION_SCOPES entry %q must be scope=pattern", entry)
+				continue
+			}
+			if _, err := path.Match(pattern, ""); err != nil {
+				invalid("invalid INTROSPECTION_SCOPES pattern %q", pattern)
+			}
+		}
+	}
+	for _, pattern := range c.PrivateChannels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			invalid("invalid PRIVATE_CHANNELS pattern %q", pattern)
+		}
+	}
+	for _, pattern := range c.PublicChannels {
+		if _, err := path.Match(pattern, ""); err != nil {
+			invalid("invalid PUBLIC_CHANNELS pattern %q", pattern)
+		}
+	}
+	if c.PublicRateLimit > 0 && c.PublicRateWindow <= 0 {
+		invalid("PUBLIC_RATE_WINDOW must be positive")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			invalid("TRUSTED_PROXIES entry %q is not an IP or CIDR", proxy)
+		}
+	}
+	for _, entry := range append(c.AdminAllowedIPs, c.DeniedIPs...) {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			invalid("ADMIN_ALLOWED_IPS and DENIED_IPS entry %q is not an IP or CIDR", entry)
+		}
+	}
+	if c.UpstreamAuthMode != "query" && c.UpstreamAuthMode != "header" && c.UpstreamAuthMode != "both" {
+		invalid("UPSTREAM_AUTH_MODE must be query, header or both")
+	}
+	if c.UpstreamCacheTTL > 0 && c.UpstreamCacheSize < 1 {
+		invalid("UPSTREAM_CACHE_SIZE must be at least 1")
+	}
+	if c.HealthProbeChannel == "" || c.HealthProbeInterval <= 0 || c.HealthProbeTimeout <= 0 {
+		invalid("HEALTH_PROBE_CHANNEL is required and HEALTH_PROBE_INTERVAL and HEALTH_PROBE_TIMEOUT must be positive")
+	}
+	if c.StartupWaitTimeout < 0 {
+		invalid("STARTUP_WAIT_TIMEOUT must not be negative")
+	}
+	if c.AuditSink != "" && c.AuditSink != "file" && c.AuditSink != "redis" {
+		invalid("AUDIT_SINK must be empty, file or redis")
+	}
+	if c.RecordSink != "" && c.RecordSink != "file" && c.RecordSink != "redis" {
+		invalid("RECORD_SINK must be empty, file or redis")
+	}
+	if c.StorageMode != "laravel" && c.StorageMode != "redis" {
+		invalid("STORAGE_MODE must be laravel or redis")
+	}
+	if c.EventStoreMaxLen < 1 {
+		invalid("EVENT_STORE_MAX_LEN must be at least 1")
+	}
+	if c.FanoutWorkers < 0 || c.FanoutQueueSize < 1 {
+		invalid("FANOUT_WORKERS must be non-negative and FANOUT_QUEUE_SIZE at least 1")
+	}
+	if c.HTTPBasePath != "" && !strings.HasPrefix(c.HTTPBasePath, "/") {
+		invalid("HTTP_BASE_PATH must start with /")
+	}
+	if c.GRPCAddr != "" && c.GRPCAddr == c.HTTPAddr {
+		invalid("GRPC_ADDR must differ from HTTP_ADDR")
+	}
+	if c.MaxPollTimeout < c.PollTimeout {
+		invalid("MAX_POLL_TIMEOUT must not be shorter than POLL_TIMEOUT")
+	}
+	if c.MaxWaitingPolls < 0 {
+		invalid("MAX_WAITING_POLLS must be non-negative")
+	}
+	if c.MaxPollersPerChannel < 0 {
+		invalid("MAX_POLLERS_PER_CHANNEL must be non-negative")
+	}
+	if c.DegradedMode != "poll" && c.DegradedMode != "wait" && c.DegradedMode != "short" {
+		invalid("DEGRADED_MODE must be poll, wait or short")
+	}
+	// Reconnect delays are sent in milliseconds
+	if c.DegradedPollInterval < 0 || (c.DegradedPollInterval > 0 && c.DegradedPollInterval < time.Millisecond) {
+		invalid("DEGRADED_POLL_INTERVAL must be 0 or at least 1ms")
+	}
+	if c.ChannelOverflow != "reject" && c.ChannelOverflow != "coalesce" {
+		invalid("CHANNEL_OVERFLOW must be reject or coalesce")
+	}
+	if c.PushBufferSize < 0 {
+		invalid("PUSH_BUFFER_SIZE must be non-negative")
+	}
+	if c.BatchWait < 0 || c.BatchWait >= c.PollTimeout {
+		invalid("BATCH_WAIT must be non-negative and shorter than POLL_TIMEOUT")
+	}
+	if c.LogSampleRate < 1 {
+		invalid("LOG_SAMPLE_RATE must be at least 1")
+	}
+	switch c.LogOutput {
+	case "stdout", "file", "syslog", "journald":
+	default:
+		invalid("LOG_OUTPUT must be stdout, file, syslog or journald")
+	}
+	if c.LogSyslogAddr != "" {
+		u, err := url.Parse(c.LogSyslogAddr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "unix") {
+			invalid("LOG_SYSLOG_ADDR must be a udp://, tcp:// or unix:// address")
+		}
+	}
+	if c.LogOutput == "file" && c.LogFilePath == "" {
+		invalid("LOG_FILE_PATH is required with LOG_OUTPUT=file")
+	}
+	if c.LogFileMaxSize < 0 || c.LogFileMaxAge < 0 || c.LogFileMaxBackups < 0 {
+		invalid("LOG_FILE_MAX_SIZE, LOG_FILE_MAX_AGE and LOG_FILE_MAX_BACKUPS must not be negative")
+	}
+	switch c.AccessLogFormat {
+	case "structured", "combined", "off":
+	default:
+		invalid("ACCESS_LOG_FORMAT must be structured, combined or off")
+	}
+	for _, field := range c.AccessLogFields {
+		switch field {
+		case "client_ip", "request_id", "channel_id", "user_agent", "referer", "bytes", "poll_outcome":
+		default:
+			invalid("unknown ACCESS_LOG_FIELDS field %q", field)
+		}
+	}
+	for _, pattern := range c.AccessLogExcludePaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			invalid("invalid ACCESS_LOG_EXCLUDE_PATHS pattern %q", pattern)
+		}
+	}
+	if c.GinMode != "debug" && c.GinMode != "release" && c.GinMode != "test" {
+		invalid("GIN_MODE must be debug, release or test")
+	}
+	if c.HTTPReadTimeout < 0 || c.HTTPWriteTimeout < 0 {
+		invalid("HTTP_READ_TIMEOUT and HTTP_WRITE_TIMEOUT must not be negative")
+	}
+	for _, rate := range []float64{c.ChaosLatencyRate, c.ChaosDropRate, c.ChaosErrorRate, c.ChaosTruncateRate} {
+		if rate < 0 || rate > 1 {
+			invalid("CHAOS_LATENCY_RATE, CHAOS_DROP_RATE, CHAOS_ERROR_RATE and CHAOS_TRUNCATE_RATE must be between 0 and 1")
+			break
+		}
+	}
+	if c.ChaosLatency < 0 {
+		invalid("CHAOS_LATENCY must not be negative")
+	}
+	if c.OTLPMetricsEndpoint != "" {
+		if u, err := url.Parse(c.OTLPMetricsEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("OTEL_EXPORTER_OTLP_ENDPOINT must be an http:// or https:// URL")
+		}
+		if c.OTLPProtocol != "http/json" {
+			invalid("OTEL_EXPORTER_OTLP_PROTOCOL %q is not supported, only http/json", c.OTLPProtocol)
+		}
+		if c.OTLPTimeout <= 0 || c.OTLPExportInterval <= 0 {
+			invalid("OTEL_EXPORTER_OTLP_TIMEOUT and OTEL_METRIC_EXPORT_INTERVAL must be positive")
+		}
+	}
+	if c.CORSAllowCredentials && anyOrigin(c.CORSAllowedOrigins) {
+		// Each of these reads the visitor's cookies, so a credentialed
+		// request from any site would act on their behalf
+		for _, mode := range []struct {
+			name    string
+			enabled bool
+		}{
+			{"TOKEN_COOKIE_NAME", c.TokenCookieName != ""},
+			{"PRIVATE_CHANNELS", len(c.PrivateChannels) > 0},
+			{"ECHO_APP_SECRET", c.EchoAppSecret != ""},
+		} {
+			if mode.enabled {
+				invalid("%s with CORS_ALLOW_CREDENTIALS needs explicit CORS_ALLOWED_ORIGINS, not *, or any site could act with the visitor's cookies", mode.name)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// anyOrigin reports whether CORS_ALLOWED_ORIGINS allows every origin
+func anyOrigin(origins string) bool {
+	for _, origin := range strings.Split(origins, ",") {
+		if strings.TrimSpace(origin) == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Standalone reports whether events are stored in Redis instead of Laravel
+func (c *Config) Standalone() bool {
+	return c.StorageMode == "redis"
 }
 
 // GetLogLevel returns the slog.Level based on the configured log level
@@ -134,15 +966,26 @@ func (c *Config) GetLogLevel() slog.Level {
 
 // Helper functions
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
+func getEnv(env func(string) string, key, defaultValue string) string {
+	if value := env(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-func getIntEnv(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
+// getListEnv splits a comma-separated value, dropping empty entries
+func getListEnv(env func(string) string, key string) []string {
+	var list []string
+	for _, item := range strings.Split(env(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getIntEnv(env func(string) string, key string, defaultValue int) int {
+	if value := env(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
 			return intValue
 		}
@@ -150,8 +993,26 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
-func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
+func getFloatEnv(env func(string) string, key string, defaultValue float64) float64 {
+	if value := env(key); value != "" {
+		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatValue
+		}
+	}
+	return defaultValue
+}
+
+func getFileModeEnv(env func(string) string, key string, defaultValue os.FileMode) os.FileMode {
+	if value := env(key); value != "" {
+		if mode, err := strconv.ParseUint(value, 8, 32); err == nil {
+			return os.FileMode(mode)
+		}
+	}
+	return defaultValue
+}
+
+func getDurationEnv(env func(string) string, key string, defaultValue time.Duration) time.Duration {
+	if value := env(key); value != "" {
 		if duration, err := time.ParseDuration(value); err == nil {
 			return duration
 		}
@@ -159,8 +1020,8 @@ func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
 	return defaultValue
 }
 
-func getBoolEnv(key string, defaultValue bool) bool {
-	if value := os.Getenv(key); value != "" {
+func getBoolEnv(env func(string) string, key string, defaultValue bool) bool {
+	if value := env(key); value != "" {
 		if boolValue, err := strconv.ParseBool(value); err == nil {
 			return boolValue
 		}
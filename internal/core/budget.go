@@ -0,0 +1,132 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// budgetBuckets is the number of buckets the rolling window is split into
+const budgetBuckets = 10
+
+// BudgetAlert describes an error budget burning faster than allowed
+type BudgetAlert struct {
+	ErrorRatio float64 `json:"error_ratio"`
+	Budget     float64 `json:"budget"`
+	BurnRate   float64 `json:"burn_rate"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	Window     string  `json:"window"`
+	Timestamp  int64   `json:"timestamp"`
+}
+
+type budgetBucket struct {
+	start    time.Time
+	requests int
+	errors   int
+}
+
+// ErrorBudget tracks the rolling error ratio of upstream requests and calls
+// the alert hook when the budget burns faster than the configured rate
+type ErrorBudget struct {
+	budget      float64
+	burnRate    float64
+	window      time.Duration
+	minRequests int
+	cooldown    time.Duration
+	onAlert     func(BudgetAlert)
+
+	mu        sync.Mutex
+	buckets   [budgetBuckets]budgetBucket
+	lastAlert time.Time
+}
+
+// NewErrorBudget creates a new error budget tracker
+func NewErrorBudget(
+	budget float64,
+	burnRate float64,
+	window time.Duration,
+	minRequests int,
+	cooldown time.Duration,
+	onAlert func(BudgetAlert),
+) *ErrorBudget {
+	return &ErrorBudget{
+		budget:      budget,
+		burnRate:    burnRate,
+		window:      window,
+		minRequests: minRequests,
+		cooldown:    cooldown,
+		onAlert:     onAlert,
+	}
+}
+
+// Record records the outcome of an upstream request
+func (b *ErrorBudget) Record(failed bool) {
+	now := time.Now()
+
+	b.mu.Lock()
+	bucketSize := b.window / budgetBuckets
+	start := now.Truncate(bucketSize)
+	bucket := &b.buckets[(start.UnixNano()/int64(bucketSize))%budgetBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = budgetBucket{start: start}
+	}
+	bucket.requests++
+	if failed {
+		bucket.errors++
+	}
+
+	alert, fire := b.evaluate(now)
+	if fire {
+		b.lastAlert = now
+	}
+	b.mu.Unlock()
+
+	if fire && b.onAlert != nil {
+		b.onAlert(alert)
+	}
+}
+
+// Snapshot returns the current error ratio and request count of the window
+func (b *ErrorBudget) Snapshot() (ratio float64, requests int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, errors := b.totals(time.Now())
+	if requests == 0 {
+		return 0, 0
+	}
+	return float64(errors) / float64(requests), requests
+}
+
+func (b *ErrorBudget) evaluate(now time.Time) (BudgetAlert, bool) {
+	requests, errors := b.totals(now)
+	if requests < b.minRequests || errors == 0 {
+		return BudgetAlert{}, false
+	}
+
+	ratio := float64(errors) / float64(requests)
+	burnRate := ratio / b.budget
+	if burnRate < b.burnRate || now.Sub(b.lastAlert) < b.cooldown {
+		return BudgetAlert{}, false
+	}
+
+	return BudgetAlert{
+		ErrorRatio: ratio,
+		Budget:     b.budget,
+		BurnRate:   burnRate,
+		Requests:   requests,
+		Errors:     errors,
+		Window:     b.window.String(),
+		Timestamp:  now.Unix(),
+	}, true
+}
+
+func (b *ErrorBudget) totals(now time.Time) (requests, errors int) {
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < b.window {
+			requests += bucket.requests
+			errors += bucket.errors
+		}
+	}
+	return requests, errors
+}
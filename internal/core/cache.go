@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var (
+	cacheHits = metrics.NewCounter(
+		"longpoll_upstream_cache_hits_total",
+		"Fetches answered from the upstream response cache or a shared in-flight request.",
+	)
+	cacheMisses = metrics.NewCounter(
+		"longpoll_upstream_cache_misses_total",
+		"Fetches that went to the upstream.",
+	)
+)
+
+type cachedResponse struct {
+	events    []Event
+	expiresAt time.Time
+}
+
+type fetchCall struct {
+	done   chan struct{}
+	events []Event
+	err    error
+}
+
+// CachedSource caches successful responses of another EventSource for a
+// short TTL, keyed by channel, offset and limit. Concurrent identical fetches
+// share a single upstream request, so the burst of polls that follows a
+// notification costs one request.
+//
+// Errors and empty responses are never cached: an empty answer goes stale as
+// soon as the next notification arrives, while a non-empty one only grows.
+//
+// Cached event slices are shared between callers and must not be modified.
+type CachedSource struct {
+	source     EventSource
+	ttl        time.Duration
+	maxEntries int
+
+	mu       sync.Mutex
+	entries  map[string]cachedResponse
+	inflight map[string]*fetchCall
+}
+
+// NewCachedSource wraps source with a cache of up to maxEntries responses
+func NewCachedSource(source EventSource, ttl time.Duration, maxEntries int) *CachedSource {
+	return &CachedSource{
+		source:     source,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]cachedResponse),
+		inflight:   make(map[string]*fetchCall),
+	}
+}
+
+// GetEvents implements EventSource
+func (s *CachedSource) GetEvents(ctx context.Context, channelID string, offset int64, limit int) ([]Event, error) {
+	key := channelID + "\x00" + strconv.FormatInt(offset, 10) + "\x00" + strconv.Itoa(limit)
+
+	s.mu.Lock()
+	if cached, ok := s.entries[key]; ok {
+		if time.Now().Before(cached.expiresAt) {
+			s.mu.Unlock()
+			cacheHits.Inc()
+			return cached.events, nil
+		}
+		delete(s.entries, key)
+	}
+
+	if pending, ok := s.inflight[key]; ok {
+		s.mu.Unlock()
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// The shared request may have been cut short by its own caller
+		// going away; fetch again on our own behalf in that case
+		if !isContextError(pending.err) {
+			cacheHits.Inc()
+			return pending.events, pending.err
+		}
+		cacheMisses.Inc()
+		return s.source.GetEvents(ctx, channelID, offset, limit)
+	}
+
+	pending := &fetchCall{done: make(chan struct{})}
+	s.inflight[key] = pending
+	s.mu.Unlock()
+
+	cacheMisses.Inc()
+	pending.events, pending.err = s.source.GetEvents(ctx, channelID, offset, limit)
+
+	s.mu.Lock()
+	delete(s.inflight, key)
+	if pending.err == nil && len(pending.events) > 0 {
+		s.store(key, pending.events)
+	}
+	s.mu.Unlock()
+	close(pending.done)
+
+	return pending.events, pending.err
+}
+
+// GetEventsSince implements SinceSource when the wrapped source does.
+// Time-based fetches are rare reconnects and bypass the cache.
+func (s *CachedSource) GetEventsSince(ctx context.Context, channelID string, since time.Time, limit int) ([]Event, error) {
+	source, ok := s.source.(SinceSource)
+	if !ok {
+		return nil, ErrSinceUnsupported
+	}
+	return source.GetEventsSince(ctx, channelID, since, limit)
+}
+
+// store caches a response; callers must hold s.mu
+func (s *CachedSource) store(key string, events []Event) {
+	if len(s.entries) >= s.maxEntries {
+		now := time.Now()
+		for k, cached := range s.entries {
+			if now.After(cached.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= s.maxEntries {
+			// Still full of live entries, drop everything rather than grow unbounded
+			s.entries = make(map[string]cachedResponse)
+		}
+	}
+
+	s.entries[key] = cachedResponse{
+		events:    events,
+		expiresAt: time.Now().Add(s.ttl),
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
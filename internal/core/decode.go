@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+// Classes of invalid upstream responses
+const (
+	DecodeFailureHTML      = "html"
+	DecodeFailureTruncated = "truncated"
+	DecodeFailureEncoding  = "encoding"
+	DecodeFailureSchema    = "schema"
+	DecodeFailureSyntax    = "syntax"
+)
+
+// maxExcerptBytes bounds the body excerpt kept for diagnostics
+const maxExcerptBytes = 256
+
+var decodeFailures = metrics.NewCounterVec(
+	"longpoll_upstream_decode_failures_total",
+	"Laravel responses that could not be decoded, by failure class.",
+	"class",
+)
+
+// DecodeError is returned when Laravel answers 200 with a body that is not a
+// valid events response
+type DecodeError struct {
+	Class       string
+	ContentType string
+	Excerpt     string
+	Err         error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode response (%s): %v", e.Class, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// IsDecodeError reports whether err is caused by an undecodable upstream response
+func IsDecodeError(err error) bool {
+	var decodeErr *DecodeError
+	return errors.As(err, &decodeErr)
+}
+
+// decodeResponse decodes a Laravel response body, classifying failures
+func decodeResponse(body []byte, contentType string) (*LaravelResponse, error) {
+	var laravelResp LaravelResponse
+	err := json.Unmarshal(body, &laravelResp)
+	if err == nil {
+		return &laravelResp, nil
+	}
+
+	decodeErr := &DecodeError{
+		Class:       classifyDecodeFailure(body, contentType, err),
+		ContentType: contentType,
+		Excerpt:     excerpt(body),
+		Err:         err,
+	}
+	decodeFailures.WithLabelValues(decodeErr.Class).Inc()
+
+	return nil, decodeErr
+}
+
+func classifyDecodeFailure(body []byte, contentType string, err error) string {
+	trimmed := bytes.TrimSpace(body)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case strings.Contains(contentType, "text/html") || bytes.HasPrefix(trimmed, []byte("<")):
+		return DecodeFailureHTML
+	case !utf8.Valid(body):
+		return DecodeFailureEncoding
+	case len(trimmed) == 0 || (errors.As(err, &syntaxErr) && syntaxErr.Offset >= int64(len(body))):
+		return DecodeFailureTruncated
+	case errors.As(err, &typeErr):
+		return DecodeFailureSchema
+	default:
+		return DecodeFailureSyntax
+	}
+}
+
+func excerpt(body []byte) string {
+	if len(body) > maxExcerptBytes {
+		body = body[:maxExcerptBytes]
+	}
+	return strings.ToValidUTF8(string(body), "�")
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Pruner drops the events a store no longer retains and reports how many
+type Pruner interface {
+	Prune(ctx context.Context) (int, error)
+}
+
+// Janitor periodically enforces the retention limits of the stores holding
+// events locally, so expired events go away even on channels that never
+// see another write
+type Janitor struct {
+	interval time.Duration
+	pruners  map[string]Pruner
+	logger   *slog.Logger
+}
+
+// NewJanitor creates a janitor running every pruner every interval. It
+// returns nil when there is nothing to prune; a nil *Janitor does nothing.
+func NewJanitor(interval time.Duration, pruners map[string]Pruner, logger *slog.Logger) *Janitor {
+	if interval <= 0 || len(pruners) == 0 {
+		return nil
+	}
+	return &Janitor{
+		interval: interval,
+		pruners:  pruners,
+		logger:   logger,
+	}
+}
+
+// Run prunes every interval until ctx is done
+func (j *Janitor) Run(ctx context.Context) {
+	if j == nil {
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.prune(ctx)
+		}
+	}
+}
+
+func (j *Janitor) prune(ctx context.Context) {
+	for name, pruner := range j.pruners {
+		pruned, err := pruner.Prune(ctx)
+		if err != nil {
+			j.logger.Warn("retention pruning failed", "store", name, "error", err)
+			continue
+		}
+		if pruned > 0 {
+			j.logger.Debug("pruned expired events", "store", name, "count", pruned)
+		}
+	}
+}
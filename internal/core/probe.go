@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ProbeResult is the outcome of an upstream probe. Reason classifies a
+// failure without exposing the upstream's answer: timeout, status,
+// saturated, invalid_response or unreachable.
+type ProbeResult struct {
+	OK         bool
+	Reason     string
+	StatusCode int
+	Latency    time.Duration
+	CheckedAt  time.Time
+}
+
+// UpstreamProbe checks that the event source answers by fetching a sentinel
+// channel. A result is reused for interval, so the source is probed at most
+// once per interval however often health is checked, and concurrent checks
+// wait for the same probe.
+type UpstreamProbe struct {
+	source   EventSource
+	channel  string
+	interval time.Duration
+	timeout  time.Duration
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	last ProbeResult
+}
+
+// NewUpstreamProbe creates a probe fetching channel from source, which
+// should bypass any response cache
+func NewUpstreamProbe(source EventSource, channel string, interval, timeout time.Duration, logger *slog.Logger) *UpstreamProbe {
+	return &UpstreamProbe{
+		source:   source,
+		channel:  channel,
+		interval: interval,
+		timeout:  timeout,
+		logger:   logger,
+	}
+}
+
+// Check returns the latest result, probing first when it is older than the
+// interval. The boolean reports whether the result was reused.
+func (p *UpstreamProbe) Check(ctx context.Context) (ProbeResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.last.CheckedAt.IsZero() && time.Since(p.last.CheckedAt) < p.interval {
+		return p.last, true
+	}
+	return p.probe(ctx), false
+}
+
+// Refresh probes regardless of the interval, e.g. while waiting for the
+// upstream at startup
+func (p *UpstreamProbe) Refresh(ctx context.Context) ProbeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.probe(ctx)
+}
+
+// probe fetches the sentinel channel. The caller holds mu.
+func (p *UpstreamProbe) probe(ctx context.Context) ProbeResult {
+	// A health checker hanging up must not cut the probe short for the
+	// checks waiting on it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), p.timeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := p.source.GetEvents(ctx, p.channel, 0, 1)
+	result := ProbeResult{OK: err == nil, Latency: time.Since(start), CheckedAt: time.Now()}
+	if err != nil {
+		result.Reason, result.StatusCode = probeFailure(err)
+		p.logger.Warn("upstream probe failed", "error", err, "channel_id", p.channel, "latency", result.Latency)
+	}
+	p.last = result
+	return result
+}
+
+// probeFailure classifies a failed probe
+func probeFailure(err error) (string, int) {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return "status", statusErr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout", 0
+	case errors.Is(err, ErrPoolSaturated):
+		return "saturated", 0
+	case IsDecodeError(err):
+		return "invalid_response", 0
+	}
+	return "unreachable", 0
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var pushBufferPruned = metrics.NewCounterVec(
+	"longpoll_push_buffer_pruned_total",
+	"Events dropped from the push buffer, by reason (max_len or max_age).",
+	"reason",
+)
+
+// PushBuffer holds the latest events pushed by Laravel per channel so polls
+// can be answered without fetching them back from Laravel.
+//
+// A channel's buffer only answers offsets it fully covers: the event at the
+// offset must be buffered, and only the run of consecutive IDs from it is
+// returned, since a missing ID may have reached Laravel without being pushed.
+// Other offsets, channels that were never pushed and channels whose events
+// expired fall back to Laravel.
+type PushBuffer struct {
+	size      int
+	ttl       time.Duration
+	channels  map[string]*channelBuffer
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type channelBuffer struct {
+	events   []Event
+	received []time.Time
+}
+
+// NewPushBuffer creates a buffer keeping up to size events per channel for ttl
+func NewPushBuffer(size int, ttl time.Duration) *PushBuffer {
+	return &PushBuffer{
+		size:     size,
+		ttl:      ttl,
+		channels: make(map[string]*channelBuffer),
+	}
+}
+
+// Append stores pushed events. Events already buffered are ignored.
+func (b *PushBuffer) Append(channelID string, events []Event) {
+	if b.size <= 0 || len(events) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if b.ttl > 0 && now.Sub(b.lastSweep) > b.ttl {
+		for id, buf := range b.channels {
+			b.expire(id, buf)
+		}
+		b.lastSweep = now
+	}
+
+	buf, ok := b.channels[channelID]
+	if !ok {
+		buf = &channelBuffer{}
+		b.channels[channelID] = buf
+	}
+
+	for _, event := range events {
+		i := sort.Search(len(buf.events), func(i int) bool { return buf.events[i].ID >= event.ID })
+		if i < len(buf.events) && buf.events[i].ID == event.ID {
+			continue
+		}
+		event.ChannelID = ""
+		buf.events = append(buf.events, Event{})
+		copy(buf.events[i+1:], buf.events[i:])
+		buf.events[i] = event
+		buf.received = append(buf.received, time.Time{})
+		copy(buf.received[i+1:], buf.received[i:])
+		buf.received[i] = now
+	}
+
+	if overflow := len(buf.events) - b.size; overflow > 0 {
+		pushBufferPruned.WithLabelValues("max_len").Add(uint64(overflow))
+		buf.events = append([]Event(nil), buf.events[overflow:]...)
+		buf.received = append([]time.Time(nil), buf.received[overflow:]...)
+	}
+}
+
+// Events returns up to limit events with consecutive IDs starting at offset.
+// ok is false when the buffer doesn't hold the event at offset and the
+// caller must ask Laravel instead.
+func (b *PushBuffer) Events(channelID string, offset int64, limit int) (events []Event, ok bool) {
+	if b.size <= 0 {
+		return nil, false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	buf, exists := b.channels[channelID]
+	if !exists {
+		return nil, false
+	}
+	b.expire(channelID, buf)
+
+	i := sort.Search(len(buf.events), func(i int) bool { return buf.events[i].ID >= offset })
+	if i == len(buf.events) || buf.events[i].ID != offset {
+		return nil, false
+	}
+
+	// Stop before a gap; the next poll asks Laravel for the missing ID
+	end := i + 1
+	for end < len(buf.events) && end-i < limit && buf.events[end].ID == buf.events[end-1].ID+1 {
+		end++
+	}
+	return append([]Event{}, buf.events[i:end]...), true
+}
+
+// Prune drops expired events of every channel, including channels nobody
+// pushes to or polls anymore, and returns how many were dropped
+func (b *PushBuffer) Prune(ctx context.Context) (int, error) {
+	if b.size <= 0 || b.ttl <= 0 {
+		return 0, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	pruned := 0
+	for id, buf := range b.channels {
+		pruned += b.expire(id, buf)
+	}
+	b.lastSweep = time.Now()
+	return pruned, nil
+}
+
+// expire drops events older than the TTL and returns how many were dropped.
+// Events are kept in ID order, and a late push of a low ID is younger than
+// the events after it, so every event is checked. Must be called with the
+// lock held.
+func (b *PushBuffer) expire(channelID string, buf *channelBuffer) int {
+	if b.ttl <= 0 {
+		return 0
+	}
+	cutoff := time.Now().Add(-b.ttl)
+	kept := 0
+	for i := range buf.events {
+		if buf.received[i].Before(cutoff) {
+			continue
+		}
+		buf.events[kept] = buf.events[i]
+		buf.received[kept] = buf.received[i]
+		kept++
+	}
+	n := len(buf.events) - kept
+	if n == 0 {
+		return 0
+	}
+	pushBufferPruned.WithLabelValues("max_age").Add(uint64(n))
+	clear(buf.events[kept:])
+	buf.events = buf.events[:kept]
+	buf.received = buf.received[:kept]
+	if len(buf.events) == 0 {
+		delete(b.channels, channelID)
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPushBufferExpiresEventsBehindALatePush(t *testing.T) {
+	b := NewPushBuffer(16, time.Minute)
+	b.Append("orders.1", []Event{{ID: 2}, {ID: 3}})
+	b.Append("orders.1", []Event{{ID: 1}})
+
+	// Events 2 and 3 are past the TTL, while event 1, pushed late, is not
+	buf := b.channels["orders.1"]
+	old := time.Now().Add(-2 * time.Minute)
+	buf.received[1] = old
+	buf.received[2] = old
+
+	pruned, err := b.Prune(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Fatalf("expected 2 events pruned, got %d", pruned)
+	}
+	if events, ok := b.Events("orders.1", 1, 10); !ok || len(events) != 1 || events[0].ID != 1 {
+		t.Fatalf("expected only event 1 to remain, got %v (ok=%v)", events, ok)
+	}
+	if _, ok := b.Events("orders.1", 2, 10); ok {
+		t.Fatal("expired event 2 should not be served")
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"strings"
+)
+
+// maxTraceStateLength bounds the tracestate forwarded to Laravel; longer
+// values are dropped rather than truncated mid-entry
+const maxTraceStateLength = 512
+
+// TraceContext is the W3C trace context (traceparent and tracestate) of the
+// client request an upstream fetch is made for
+type TraceContext struct {
+	Parent string
+	State  string
+}
+
+type traceContextKey struct{}
+
+// ParseTraceContext validates the traceparent and tracestate headers. ok is
+// false when traceparent is missing or malformed, in which case neither is
+// forwarded.
+func ParseTraceContext(parent, state string) (TraceContext, bool) {
+	parent = strings.TrimSpace(parent)
+	if traceIDOf(parent) == "" {
+		return TraceContext{}, false
+	}
+	state = strings.TrimSpace(state)
+	if len(state) > maxTraceStateLength || strings.ContainsAny(state, "\r\n") {
+		state = ""
+	}
+	return TraceContext{Parent: parent, State: state}, true
+}
+
+// TraceID returns the 32 hex digit trace ID
+func (t TraceContext) TraceID() string {
+	return traceIDOf(t.Parent)
+}
+
+// WithTraceContext returns a copy of ctx carrying trace
+func WithTraceContext(ctx context.Context, trace TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// TraceContextFrom returns the trace context stored in ctx, if any
+func TraceContextFrom(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace, ok
+}
+
+// traceIDOf returns the trace ID of a version-parent-flags traceparent, or
+// "" when it is malformed. Later versions may append fields after the flags.
+func traceIDOf(parent string) string {
+	if len(parent) < 55 || (len(parent) > 55 && parent[55] != '-') {
+		return ""
+	}
+	version, traceID, parentID, flags := parent[0:2], parent[3:35], parent[36:52], parent[53:55]
+	if parent[2] != '-' || parent[35] != '-' || parent[52] != '-' {
+		return ""
+	}
+	if !lowerHex(version) || version == "ff" || (version == "00" && len(parent) != 55) {
+		return ""
+	}
+	if !lowerHex(traceID) || !lowerHex(parentID) || !lowerHex(flags) {
+		return ""
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return ""
+	}
+	return traceID
+}
+
+func lowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if (s[i] < '0' || s[i] > '9') && (s[i] < 'a' || s[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -2,48 +2,123 @@ package core
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var (
+	upstreamInFlight = metrics.NewGauge(
+		"longpoll_upstream_in_flight",
+		"Laravel requests currently being executed.",
+	)
+	upstreamQueued = metrics.NewGauge(
+		"longpoll_upstream_queued",
+		"Fetches waiting for a free Laravel upstream worker.",
+	)
+	upstreamQueueTimeouts = metrics.NewCounter(
+		"longpoll_upstream_queue_timeouts_total",
+		"Fetches rejected after waiting UPSTREAM_QUEUE_TIMEOUT for a worker.",
+	)
+)
+
+// Ways of passing the access secret to Laravel
+const (
+	// UpstreamAuthQuery sends secret as a query parameter (legacy)
+	UpstreamAuthQuery = "query"
+	// UpstreamAuthHeader sends it as a bearer token, keeping it out of logs
+	UpstreamAuthHeader = "header"
+	// UpstreamAuthBoth sends both while Laravel is being migrated
+	UpstreamAuthBoth = "both"
 )
 
+// ErrPoolSaturated is returned when no upstream worker freed up within the
+// queue timeout
+var ErrPoolSaturated = errors.New("upstream pool saturated")
+
+// ErrSinceUnsupported is returned when an event source can't fetch by time
+var ErrSinceUnsupported = errors.New("event source does not support since")
+
 // Event represents a long-polling event from Laravel
 type Event struct {
 	ID        int64                  `json:"id"`
+	ChannelID string                 `json:"channel_id,omitempty"`
 	Event     map[string]interface{} `json:"event"`
 	CreatedAt int64                  `json:"created_at"`
 }
 
+// EventSource serves a channel's events with ID >= offset
+type EventSource interface {
+	GetEvents(ctx context.Context, channelID string, offset int64, limit int) ([]Event, error)
+}
+
+// SinceSource is implemented by event sources that can also serve a
+// channel's events created at or after a point in time
+type SinceSource interface {
+	GetEventsSince(ctx context.Context, channelID string, since time.Time, limit int) ([]Event, error)
+}
+
 // LaravelResponse represents the response from Laravel's /getEvents endpoint
 type LaravelResponse struct {
 	Events []Event `json:"events"`
 	Count  int     `json:"count"`
 }
 
+// StatusError is returned when Laravel answers with a status other than 200
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Laravel returned status %d: %s", e.Code, e.Body)
+}
+
+// isAuthRejection reports whether Laravel refused the access secret
+func isAuthRejection(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && (statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden)
+}
+
 // LaravelUpstreamPool manages concurrent requests to Laravel
 type LaravelUpstreamPool struct {
 	laravelAddr string
 	secret      string
+	nextSecret  string
+	useNext     atomic.Bool
 	maxLimit    int
 	logger      *slog.Logger
 	semaphore   chan struct{}
 	httpClient  *http.Client
+	budget      *ErrorBudget
+	retries     int
+	queueWait   time.Duration
+	queued      atomic.Int64
+	authMode    string
 }
 
 // NewLaravelUpstreamPool creates a new Laravel upstream pool
 func NewLaravelUpstreamPool(
 	laravelAddr string,
 	secret string,
+	nextSecret string,
 	maxLimit int,
 	workers int,
 	requestTimeout time.Duration,
 	maxIdleConns int,
 	maxConnsPerHost int,
 	idleConnTimeout time.Duration,
+	budget *ErrorBudget,
+	decodeRetries int,
+	queueTimeout time.Duration,
+	authMode string,
 	logger *slog.Logger,
 ) *LaravelUpstreamPool {
 	transport := &http.Transport{
@@ -58,6 +133,7 @@ func NewLaravelUpstreamPool(
 	return &LaravelUpstreamPool{
 		laravelAddr: laravelAddr,
 		secret:      secret,
+		nextSecret:  nextSecret,
 		maxLimit:    maxLimit,
 		logger:      logger,
 		semaphore:   make(chan struct{}, workers),
@@ -65,42 +141,199 @@ func NewLaravelUpstreamPool(
 			Timeout:   requestTimeout,
 			Transport: transport,
 		},
+		budget:    budget,
+		retries:   decodeRetries,
+		queueWait: queueTimeout,
+		authMode:  authMode,
 	}
 }
 
-// GetEvents fetches events from Laravel for a specific channel
-func (p *LaravelUpstreamPool) GetEvents(ctx context.Context, channelID string, offset int64, limit int) ([]Event, error) {
+// InFlight returns the number of Laravel requests being executed
+func (p *LaravelUpstreamPool) InFlight() int {
+	return len(p.semaphore)
+}
+
+// Queued returns the number of fetches waiting for a free worker
+func (p *LaravelUpstreamPool) Queued() int {
+	return int(p.queued.Load())
+}
+
+// acquire takes a worker slot, waiting at most queueWait when it is set
+func (p *LaravelUpstreamPool) acquire(ctx context.Context) error {
+	select {
+	case p.semaphore <- struct{}{}:
+		upstreamInFlight.Inc()
+		return nil
+	default:
+	}
+
+	p.queued.Add(1)
+	upstreamQueued.Inc()
+	defer func() {
+		p.queued.Add(-1)
+		upstreamQueued.Dec()
+	}()
+
+	var deadline <-chan time.Time
+	if p.queueWait > 0 {
+		timer := time.NewTimer(p.queueWait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
 	select {
 	case p.semaphore <- struct{}{}:
-		defer func() { <-p.semaphore }()
+		upstreamInFlight.Inc()
+		return nil
+	case <-deadline:
+		upstreamQueueTimeouts.Inc()
+		return ErrPoolSaturated
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return ctx.Err()
+	}
+}
+
+func (p *LaravelUpstreamPool) release() {
+	upstreamInFlight.Dec()
+	<-p.semaphore
+}
+
+// GetEvents fetches events from Laravel for a specific channel
+func (p *LaravelUpstreamPool) GetEvents(ctx context.Context, channelID string, offset int64, limit int) ([]Event, error) {
+	return p.getEvents(ctx, channelID, "offset", offset, limit)
+}
+
+// GetEventsSince fetches the events Laravel created at or after since,
+// passed as the since query parameter in Unix milliseconds
+func (p *LaravelUpstreamPool) GetEventsSince(ctx context.Context, channelID string, since time.Time, limit int) ([]Event, error) {
+	return p.getEvents(ctx, channelID, "since", since.UnixMilli(), limit)
+}
+
+// getEvents fetches a page of events starting at the given position, named
+// by param ("offset" or "since")
+func (p *LaravelUpstreamPool) getEvents(ctx context.Context, channelID, param string, position int64, limit int) ([]Event, error) {
+	if err := p.acquire(ctx); err != nil {
+		if errors.Is(err, ErrPoolSaturated) {
+			p.logger.Warn("upstream pool saturated",
+				"channel_id", channelID,
+				"in_flight", p.InFlight(),
+				"queued", p.Queued(),
+				"queue_timeout", p.queueWait,
+			)
+		}
+		return nil, err
 	}
+	defer p.release()
 
 	if limit > p.maxLimit {
 		limit = p.maxLimit
 	}
 
-	reqURL := fmt.Sprintf("%s/api/long-polling/getEvents?channel_id=%s&secret=%s&offset=%d&limit=%d",
+	baseURL := fmt.Sprintf("%s/api/long-polling/getEvents?channel_id=%s&%s=%d&limit=%d",
 		p.laravelAddr,
 		url.QueryEscape(channelID),
-		url.QueryEscape(p.secret),
-		offset,
+		param,
+		position,
 		limit,
 	)
 
+	secret, other := p.secrets()
+	reqURL, header := p.authorize(baseURL, channelID, secret)
+
+	trace, _ := TraceContextFrom(ctx)
 	p.logger.Debug("fetching events from Laravel",
-		"url", reqURL,
+		"url", baseURL,
 		"channel_id", channelID,
-		"offset", offset,
+		param, position,
 		"limit", limit,
+		"trace_id", trace.TraceID(),
+	)
+
+	laravelResp, err := p.fetch(ctx, reqURL, header)
+	// During a secret rotation Laravel may already accept only the other one
+	if other != "" && isAuthRejection(err) {
+		reqURL, header = p.authorize(baseURL, channelID, other)
+		if laravelResp, err = p.fetch(ctx, reqURL, header); err == nil || !isAuthRejection(err) {
+			p.useNext.Store(other == p.nextSecret)
+			p.logger.Info("Laravel rejected the access secret, switched to the other one",
+				"next", other == p.nextSecret,
+			)
+		}
+	}
+	for attempt := 0; attempt < p.retries && IsDecodeError(err) && ctx.Err() == nil; attempt++ {
+		p.logDecodeError(err, channelID)
+		laravelResp, err = p.fetch(ctx, reqURL, header)
+	}
+	if p.budget != nil && ctx.Err() == nil {
+		p.budget.Record(err != nil)
+	}
+	if err != nil {
+		p.logDecodeError(err, channelID)
+		return nil, err
+	}
+
+	p.logger.Debug("received events from Laravel",
+		"channel_id", channelID,
+		"count", laravelResp.Count,
+	)
+
+	return laravelResp.Events, nil
+}
+
+// secrets returns the access secret to send and the one to try when Laravel
+// rejects it
+func (p *LaravelUpstreamPool) secrets() (string, string) {
+	if p.useNext.Load() {
+		return p.nextSecret, p.secret
+	}
+	return p.secret, p.nextSecret
+}
+
+// authorize adds secret to a request the way UPSTREAM_AUTH_MODE asks
+func (p *LaravelUpstreamPool) authorize(reqURL, channelID, secret string) (string, http.Header) {
+	header := make(http.Header)
+	if p.authMode == UpstreamAuthHeader || p.authMode == UpstreamAuthBoth {
+		header.Set("Authorization", "Bearer "+secret)
+		header.Set("X-Longpoll-Channel-Id", channelID)
+	}
+	if p.authMode != UpstreamAuthHeader {
+		reqURL += "&secret=" + url.QueryEscape(secret)
+	}
+	return reqURL, header
+}
+
+// logDecodeError logs the classification and excerpt of an undecodable response
+func (p *LaravelUpstreamPool) logDecodeError(err error, channelID string) {
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		return
+	}
+	p.logger.Warn("invalid response from Laravel",
+		"channel_id", channelID,
+		"class", decodeErr.Class,
+		"content_type", decodeErr.ContentType,
+		"excerpt", decodeErr.Excerpt,
+		"error", decodeErr.Err,
 	)
+}
 
+// fetch performs a single request to Laravel and decodes the response
+func (p *LaravelUpstreamPool) fetch(ctx context.Context, reqURL string, header http.Header) (*LaravelResponse, error) {
 	// Create the request
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	// Let Laravel's APM join the polling client's trace
+	if trace, ok := TraceContextFrom(ctx); ok {
+		req.Header.Set("traceparent", trace.Parent)
+		if trace.State != "" {
+			req.Header.Set("tracestate", trace.State)
+		}
+	}
 
 	// Execute the request
 	resp, err := p.httpClient.Do(req)
@@ -112,19 +345,14 @@ func (p *LaravelUpstreamPool) GetEvents(ctx context.Context, channelID string, o
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("Laravel returned status %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	// Parse the response
-	var laravelResp LaravelResponse
-	if err := json.NewDecoder(resp.Body).Decode(&laravelResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	p.logger.Debug("received events from Laravel",
-		"channel_id", channelID,
-		"count", laravelResp.Count,
-	)
-
-	return laravelResp.Events, nil
+	return decodeResponse(body, resp.Header.Get("Content-Type"))
 }
@@ -0,0 +1,167 @@
+// Package e2e handles encrypted event payloads, so the relay, proxies, CDNs
+// and anything else between Laravel and the client only ever see ciphertext.
+//
+// End-to-end encryption happens in Laravel: it stores each payload as an
+// AES-256-GCM envelope sealed with the channel's key and hands that key to
+// clients authorized for the channel. The relay never holds a key; it passes
+// envelopes through and refuses plaintext payloads.
+//
+// Transport-only sealing is the fallback for Laravel apps that can't encrypt
+// themselves: the relay holds the master key and seals plaintext payloads as
+// they leave it. That hides them from everything after the relay, but not
+// from the relay, Redis or anything before it.
+//
+// Channel keys are HMAC-SHA256(master key, channel ID) in both modes.
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Algorithm identifies the envelope format
+const Algorithm = "A256GCM"
+
+// MinKeyLength is the minimum master key length in bytes
+const MinKeyLength = 32
+
+// ErrNotSealed is returned for plaintext payloads when end-to-end encryption
+// is required
+var ErrNotSealed = errors.New("payload is not encrypted")
+
+// Sealer enforces the encryption of payloads leaving the relay. A nil Sealer
+// leaves payloads untouched.
+type Sealer struct {
+	masterKey []byte
+}
+
+// NewSealer creates a sealer for end-to-end encryption: envelopes sealed by
+// Laravel pass through and plaintext payloads are refused
+func NewSealer() *Sealer {
+	return &Sealer{}
+}
+
+// NewTransportSealer creates a sealer that encrypts plaintext payloads
+// itself, deriving channel keys from masterKey. The relay sees the
+// plaintext, so this only protects payloads after they leave it.
+func NewTransportSealer(masterKey string) (*Sealer, error) {
+	if len(masterKey) < MinKeyLength {
+		return nil, fmt.Errorf("encryption key must be at least %d bytes", MinKeyLength)
+	}
+	return &Sealer{masterKey: []byte(masterKey)}, nil
+}
+
+// Transport reports whether the sealer encrypts plaintext payloads itself
+func (s *Sealer) Transport() bool {
+	return s != nil && s.masterKey != nil
+}
+
+// Accepts reports whether payload may enter the relay: with end-to-end
+// encryption only envelopes are accepted
+func (s *Sealer) Accepts(payload map[string]interface{}) bool {
+	return s == nil || s.Transport() || IsSealed(payload)
+}
+
+// ChannelKey returns the AES-256 key of a channel
+func (s *Sealer) ChannelKey(channelID string) []byte {
+	mac := hmac.New(sha256.New, s.masterKey)
+	mac.Write([]byte(channelID))
+	return mac.Sum(nil)
+}
+
+// Seal returns payload as an envelope of the form
+// {"enc": "A256GCM", "nonce": "...", "ciphertext": "..."} with base64 fields.
+// Payloads that already are envelopes are returned as they are. Plaintext
+// payloads are encrypted for channelID by a transport sealer, and refused
+// with ErrNotSealed otherwise. The channel ID is authenticated as additional
+// data, so a payload can't be replayed into another channel.
+func (s *Sealer) Seal(channelID string, payload map[string]interface{}) (map[string]interface{}, error) {
+	if s == nil || IsSealed(payload) {
+		return payload, nil
+	}
+	if !s.Transport() {
+		return nil, ErrNotSealed
+	}
+
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	block, err := aes.NewCipher(s.ChannelKey(channelID))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, plaintext, []byte(channelID))
+	return map[string]interface{}{
+		"enc":        Algorithm,
+		"nonce":      base64.StdEncoding.EncodeToString(nonce),
+		"ciphertext": base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+// Open decrypts an envelope produced by Seal
+func Open(key []byte, channelID string, envelope map[string]interface{}) (map[string]interface{}, error) {
+	if !IsSealed(envelope) {
+		return nil, ErrNotSealed
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(envelope["nonce"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %w", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(envelope["ciphertext"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce length")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(channelID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payload: %w", err)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(plaintext, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+	return payload, nil
+}
+
+// IsSealed reports whether payload is an encrypted envelope
+func IsSealed(payload map[string]interface{}) bool {
+	if payload["enc"] != Algorithm {
+		return false
+	}
+	_, hasNonce := payload["nonce"].(string)
+	_, hasCiphertext := payload["ciphertext"].(string)
+	return hasNonce && hasCiphertext
+}
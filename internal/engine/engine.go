@@ -0,0 +1,561 @@
+// Package engine wires the long-polling components from a configuration.
+// The longpoll-server binary, the embeddable pkg/longpoll and the
+// pkg/longpolltest harness all build on it, so they serve the same features.
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/access"
+	"github.com/levskiy0/go-laravel-long-polling/internal/affinity"
+	"github.com/levskiy0/go-laravel-long-polling/internal/alert"
+	"github.com/levskiy0/go-laravel-long-polling/internal/audit"
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/authcache"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/e2e"
+	"github.com/levskiy0/go-laravel-long-polling/internal/errreport"
+	"github.com/levskiy0/go-laravel-long-polling/internal/http"
+	"github.com/levskiy0/go-laravel-long-polling/internal/presence"
+	"github.com/levskiy0/go-laravel-long-polling/internal/recording"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+	"github.com/levskiy0/go-laravel-long-polling/internal/revocation"
+	"github.com/levskiy0/go-laravel-long-polling/internal/stats"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// DefaultKeyPrefix namespaces the Redis keys of a single-tenant engine
+const DefaultKeyPrefix = "longpoll:"
+
+// Options holds what the configuration doesn't
+type Options struct {
+	// Redis is used for notifications, presence, bans, revocations and the
+	// other shared state. Required.
+	Redis *goredis.Client
+
+	// KeyPrefix namespaces the Redis keys. Defaults to DefaultKeyPrefix.
+	KeyPrefix string
+
+	// AppID scopes the engine to one tenant: issued tokens carry it as the
+	// app_id claim and tokens of other apps are rejected
+	AppID string
+
+	// Source, when set, replaces the Laravel upstream and the event store
+	Source core.EventSource
+
+	// Logger receives the engine's logs. Required.
+	Logger *slog.Logger
+
+	// LogLevel, when set, is the level Logger filters by, changed at
+	// runtime through /admin/loglevel
+	LogLevel *slog.LevelVar
+
+	// AccessLog receives access log lines with ACCESS_LOG_FORMAT=combined.
+	// Defaults to stdout.
+	AccessLog io.Writer
+
+	// Reporter receives errors and panics. May be nil.
+	Reporter *errreport.Reporter
+}
+
+// Engine is a fully wired long-polling service without a listener
+type Engine struct {
+	Config      *config.Config
+	Handlers    *http.Handlers
+	Server      *http.Server
+	Subscriber  *redis.Subscriber
+	Probe       *core.UpstreamProbe
+	Presence    *presence.Tracker
+	Cluster     *presence.Cluster
+	Revocations *revocation.Registry
+	Ring        *affinity.Ring
+	JWTService  *auth.JWTService
+
+	janitor       *core.Janitor
+	recorder      *recording.Recorder
+	auditLog      *audit.Log
+	secrets       *access.Secrets
+	reloadSecrets func(context.Context) error
+	apiKeys       *access.APIKeys
+	reloadKeys    func(context.Context) error
+	reporter      *errreport.Reporter
+	logger        *slog.Logger
+	cancel        context.CancelFunc
+	started       bool
+}
+
+// New wires an engine from a validated configuration. Call Load and Start
+// to begin serving.
+func New(cfg *config.Config, opts Options) (*Engine, error) {
+	if opts.Redis == nil {
+		return nil, errors.New("engine: Redis client is required")
+	}
+	logger := opts.Logger
+	keyPrefix := opts.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = DefaultKeyPrefix
+	}
+	accessLog := opts.AccessLog
+	if accessLog == nil {
+		accessLog = os.Stdout
+	}
+	reporter := opts.Reporter
+
+	jwtService, err := NewJWTService(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if opts.AppID != "" {
+		jwtService = jwtService.WithAppID(opts.AppID)
+	}
+	logger.Info("JWT service created", "rotating", cfg.JWTSecretNext != "", "active_kid", cfg.JWTActiveKID)
+
+	webhook := alert.NewWebhook(cfg.AlertWebhookURL, 5*time.Second, logger)
+	pool := newUpstreamPool(cfg, newErrorBudget(cfg, webhook, reporter, logger), logger)
+	store := newEventStore(cfg, opts.Redis, keyPrefix, logger)
+
+	var source core.EventSource = pool
+	var probed core.EventSource = pool
+	switch {
+	case opts.Source != nil:
+		source, probed = opts.Source, opts.Source
+	case store != nil:
+		source, probed = store, store
+	case cfg.UpstreamCacheTTL > 0:
+		source = core.NewCachedSource(pool, cfg.UpstreamCacheTTL, cfg.UpstreamCacheSize)
+	}
+	probe := core.NewUpstreamProbe(probed, cfg.HealthProbeChannel, cfg.HealthProbeInterval, cfg.HealthProbeTimeout, logger)
+
+	channelStats := stats.NewRecorder()
+	pushBuffer := core.NewPushBuffer(cfg.PushBufferSize, cfg.PushBufferTTL)
+	pruners := make(map[string]core.Pruner)
+	if cfg.PushBufferSize > 0 {
+		pruners["push_buffer"] = pushBuffer
+	}
+	if store != nil {
+		pruners["event_store"] = store
+	}
+
+	var deadLetters *redis.DeadLetters
+	if cfg.DeadLetterMaxLen > 0 {
+		logger.Info("dead-letter tracking enabled", "max_len", cfg.DeadLetterMaxLen, "idle", cfg.DeadLetterIdle)
+		deadLetters = redis.NewDeadLetters(opts.Redis, keyPrefix+"dead-letters", cfg.DeadLetterMaxLen, cfg.DeadLetterIdle, logger)
+	}
+
+	recorder, err := newRecorder(cfg, opts.Redis, logger)
+	if err != nil {
+		return nil, err
+	}
+	auditLog, err := newAuditLog(cfg, opts.Redis, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriber := newSubscriber(cfg, opts.Redis, pushBuffer, channelStats, deadLetters, webhook, reporter, logger)
+	presenceTracker := newPresenceTracker(cfg, opts.Redis, logger)
+	presenceCluster := presence.NewCluster(opts.Redis, keyPrefix, presenceTracker, cfg.PresenceSync, logger)
+	revocations := revocation.NewRegistry(opts.Redis, keyPrefix, cfg.ControlChannel, logger)
+	logger.Info("revocation registry created", "channel", cfg.ControlChannel)
+
+	var ring *affinity.Ring
+	if cfg.AffinityURL != "" {
+		logger.Info("channel affinity enabled", "url", cfg.AffinityURL, "heartbeat", cfg.AffinityHeartbeat)
+		ring = affinity.NewRing(opts.Redis, keyPrefix, cfg.AffinityURL, cfg.AffinityHeartbeat, logger)
+	}
+
+	sealer, err := NewSealer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case sealer.Transport():
+		logger.Warn("transport payload encryption enabled, payloads are visible to this service", "algorithm", e2e.Algorithm)
+	case sealer != nil:
+		logger.Info("end-to-end payload encryption required", "algorithm", e2e.Algorithm)
+	}
+
+	secrets := access.NewSecrets(cfg.AccessTokenSecret, cfg.AccessTokenSecretNext)
+	apiKeys := access.NewAPIKeys()
+	reloadKeys := apiKeys.Source(cfg.APIKeysFile, opts.Redis, cfg.APIKeysRedisKey)
+	if reloadKeys == nil {
+		apiKeys = nil
+	}
+
+	authCache := authcache.NewCache(
+		authcache.NewMemoryStore(cfg.AuthCacheMaxEntries),
+		cfg.AuthCachePositiveTTL,
+		cfg.AuthCacheNegativeTTL,
+	)
+
+	var offsets *redis.OffsetStore
+	if cfg.ConsumerOffsets {
+		offsets = redis.NewOffsetStore(opts.Redis, keyPrefix, cfg.ConsumerOffsetsTTL)
+	}
+
+	handlers := http.NewHandlers(http.Deps{
+		JWTService:      jwtService,
+		Source:          source,
+		PushBuffer:      pushBuffer,
+		Store:           store,
+		Acks:            redis.NewAckStore(opts.Redis, keyPrefix, cfg.AckTTL),
+		Offsets:         offsets,
+		DeadLetters:     deadLetters,
+		Subscriber:      subscriber,
+		Presence:        presenceTracker,
+		Cluster:         presenceCluster,
+		Revocations:     revocations,
+		Stats:           channelStats,
+		Audit:           auditLog,
+		Recorder:        recorder,
+		Sealer:          sealer,
+		Secrets:         secrets,
+		Guard:           access.NewGuard(cfg.TokenRateLimit, cfg.TokenRateWindow, cfg.TokenLockoutFailures, cfg.TokenLockout, cfg.TokenLockoutMax),
+		PrivateChannels: access.NewPrivateChannels(cfg.PrivateChannels, cfg.PrivateChannelAuthURL, cfg.PrivateAuthTimeout, authCache),
+		Introspector:    access.NewIntrospector(cfg.IntrospectionURL, cfg.IntrospectionClientID, cfg.IntrospectionSecret, cfg.IntrospectionScopes, cfg.IntrospectionTimeout, authCache),
+		APIKeys:         apiKeys,
+		PublicChannels:  access.NewPublicChannels(cfg.PublicChannels, cfg.PublicRateLimit, cfg.PublicRateWindow),
+		Ring:            ring,
+		Probe:           probe,
+		LogLevel:        opts.LogLevel,
+	}, cfg, logger)
+
+	idempotency := redis.NewIdempotencyStore(opts.Redis, keyPrefix+"idempotency:", cfg.IdempotencyTTL)
+	server := http.NewServer(
+		cfg.HTTPAddr,
+		cfg.HTTPReadTimeout,
+		cfg.HTTPWriteTimeout,
+		handlers,
+		idempotency,
+		reporter,
+		webhook,
+		accessLog,
+		cfg,
+		logger,
+	)
+
+	return &Engine{
+		Config:        cfg,
+		Handlers:      handlers,
+		Server:        server,
+		Subscriber:    subscriber,
+		Probe:         probe,
+		Presence:      presenceTracker,
+		Cluster:       presenceCluster,
+		Revocations:   revocations,
+		Ring:          ring,
+		JWTService:    jwtService,
+		janitor:       core.NewJanitor(cfg.RetentionInterval, pruners, logger),
+		recorder:      recorder,
+		auditLog:      auditLog,
+		secrets:       secrets,
+		reloadSecrets: secrets.Source(cfg.AccessSecretsFile, opts.Redis, cfg.AccessSecretsRedisKey),
+		apiKeys:       apiKeys,
+		reloadKeys:    reloadKeys,
+		reporter:      reporter,
+		logger:        logger,
+	}, nil
+}
+
+// Load reads the scoped access secrets and API keys, if configured, so
+// they are in place before the first request
+func (e *Engine) Load(ctx context.Context) error {
+	if e.reloadSecrets != nil {
+		if err := e.reloadSecrets(ctx); err != nil {
+			return err
+		}
+		e.logger.Info("scoped access secrets loaded", "file", e.Config.AccessSecretsFile, "redis_key", e.Config.AccessSecretsRedisKey)
+	}
+	if e.reloadKeys != nil {
+		if err := e.reloadKeys(ctx); err != nil {
+			return err
+		}
+		e.logger.Info("API keys loaded", "file", e.Config.APIKeysFile, "redis_key", e.Config.APIKeysRedisKey)
+	}
+	return nil
+}
+
+// Start runs the notification subscriber, presence sweeper and sync,
+// revocation sync, retention janitor, affinity heartbeat, recording and
+// secret reloads in the background until Stop is called or ctx is done.
+// The HTTP server is not started.
+func (e *Engine) Start(ctx context.Context) {
+	ctx, e.cancel = context.WithCancel(ctx)
+	e.started = true
+
+	go func() {
+		defer e.reporter.Recover(map[string]string{"component": "redis_subscriber"})
+		e.Subscriber.Run(ctx)
+	}()
+	go e.Presence.Start(ctx)
+	go e.Cluster.Run(ctx)
+	go func() {
+		for {
+			err := e.Revocations.Start(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			e.logger.Error("revocation registry stopped, reconnecting", "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+	go e.janitor.Run(ctx)
+	go e.Ring.Start(ctx)
+	go e.recorder.Run()
+	if e.reloadSecrets != nil {
+		go e.secrets.Watch(ctx, e.Config.AccessSecretsRefresh, e.reloadSecrets, e.logger)
+	}
+	if e.reloadKeys != nil {
+		go e.apiKeys.Watch(ctx, e.Config.AccessSecretsRefresh, e.reloadKeys, e.logger)
+	}
+}
+
+// Stop hands channels over to the other instances, sends waiting polls a
+// reconnect event and stops the background loops
+func (e *Engine) Stop() {
+	e.Ring.Stop()
+	e.Handlers.Drain(e.Config.ReconnectHint)
+	if e.cancel != nil {
+		e.cancel()
+	}
+	e.Subscriber.Stop()
+	e.Presence.Stop()
+	e.Revocations.Stop()
+}
+
+// Close writes out and closes the recording and the audit log. Call it
+// after the HTTP server stopped, so the last requests are recorded.
+func (e *Engine) Close() error {
+	var err error
+	if e.started {
+		err = e.recorder.Close()
+	}
+	return errors.Join(err, e.auditLog.Close())
+}
+
+// NewJWTService builds the JWT service with every configured key
+func NewJWTService(cfg *config.Config) (*auth.JWTService, error) {
+	service, err := auth.NewJWTService(cfg.JWTSecret, cfg.JWTExpiresIn, cfg.JWTAlgo)
+	if err != nil {
+		return nil, err
+	}
+	service, err = service.WithNextSecret(cfg.JWTSecretNext).WithKeys(cfg.JWTKeys, cfg.JWTActiveKID)
+	if err != nil {
+		return nil, err
+	}
+	return service.WithSigningKeyFiles(cfg.JWTPrivateKeyFile, cfg.JWTPublicKeyFiles)
+}
+
+// NewSealer returns nil unless ENCRYPTION_REQUIRED or
+// TRANSPORT_ENCRYPTION_KEY is set
+func NewSealer(cfg *config.Config) (*e2e.Sealer, error) {
+	switch {
+	case cfg.EncryptionRequired:
+		return e2e.NewSealer(), nil
+	case cfg.TransportEncryptionKey != "":
+		return e2e.NewTransportSealer(cfg.TransportEncryptionKey)
+	}
+	return nil, nil
+}
+
+// NewProbe probes Laravel, or the event store when standalone, without
+// starting an engine
+func NewProbe(cfg *config.Config, client *goredis.Client, logger *slog.Logger) *core.UpstreamProbe {
+	var source core.EventSource = newUpstreamPool(cfg, nil, logger)
+	if store := newEventStore(cfg, client, DefaultKeyPrefix, logger); store != nil {
+		source = store
+	}
+	return core.NewUpstreamProbe(source, cfg.HealthProbeChannel, cfg.HealthProbeInterval, cfg.HealthProbeTimeout, logger)
+}
+
+func newErrorBudget(cfg *config.Config, webhook *alert.Webhook, reporter *errreport.Reporter, logger *slog.Logger) *core.ErrorBudget {
+	return core.NewErrorBudget(
+		cfg.ErrorBudget,
+		cfg.ErrorBudgetBurnRate,
+		cfg.ErrorBudgetWindow,
+		cfg.ErrorBudgetMinRequests,
+		cfg.ErrorBudgetCooldown,
+		func(a core.BudgetAlert) {
+			logger.Warn("upstream error budget burning",
+				"error_ratio", a.ErrorRatio,
+				"budget", a.Budget,
+				"burn_rate", a.BurnRate,
+				"requests", a.Requests,
+				"errors", a.Errors,
+				"window", a.Window,
+			)
+			webhook.Notify("upstream_error_budget", a)
+			reporter.Capture(
+				fmt.Errorf("upstream error ratio %.3f exceeds budget %.3f", a.ErrorRatio, a.Budget),
+				errreport.Event{
+					Tags: map[string]string{"component": "upstream"},
+					Extra: map[string]interface{}{
+						"burn_rate": a.BurnRate,
+						"requests":  a.Requests,
+						"errors":    a.Errors,
+						"window":    a.Window,
+						"laravel":   cfg.LaravelAddr,
+					},
+				},
+			)
+		},
+	)
+}
+
+func newUpstreamPool(cfg *config.Config, budget *core.ErrorBudget, logger *slog.Logger) *core.LaravelUpstreamPool {
+	pool := core.NewLaravelUpstreamPool(
+		cfg.LaravelAddr,
+		cfg.AccessTokenSecret,
+		cfg.AccessTokenSecretNext,
+		cfg.MaxLimit,
+		cfg.LaravelUpstreamWorkers,
+		cfg.LaravelRequestTimeout,
+		cfg.HTTPMaxIdleConns,
+		cfg.HTTPMaxConnsPerHost,
+		cfg.HTTPIdleConnTimeout,
+		budget,
+		cfg.UpstreamDecodeRetries,
+		cfg.UpstreamQueueTimeout,
+		cfg.UpstreamAuthMode,
+		logger,
+	)
+	logger.Info("Laravel upstream pool created",
+		"addr", cfg.LaravelAddr,
+		"workers", cfg.LaravelUpstreamWorkers,
+		"request_timeout", cfg.LaravelRequestTimeout,
+		"max_idle_conns", cfg.HTTPMaxIdleConns,
+		"max_conns_per_host", cfg.HTTPMaxConnsPerHost,
+	)
+	return pool
+}
+
+// newEventStore returns nil unless STORAGE_MODE=redis
+func newEventStore(cfg *config.Config, client *goredis.Client, keyPrefix string, logger *slog.Logger) *redis.EventStore {
+	if !cfg.Standalone() {
+		return nil
+	}
+	logger.Info("standalone mode, events are stored in Redis",
+		"max_len", cfg.EventStoreMaxLen,
+		"retention", cfg.EventStoreRetention,
+		"max_age", cfg.EventStoreMaxAge,
+	)
+	return redis.NewEventStore(client, keyPrefix, cfg.EventStoreMaxLen, cfg.EventStoreRetention, cfg.EventStoreMaxAge)
+}
+
+func newSubscriber(
+	cfg *config.Config,
+	client *goredis.Client,
+	pushBuffer *core.PushBuffer,
+	channelStats *stats.Recorder,
+	deadLetters *redis.DeadLetters,
+	webhook *alert.Webhook,
+	reporter *errreport.Reporter,
+	logger *slog.Logger,
+) *redis.Subscriber {
+	onNotify := func(notification redis.EventNotification) {
+		pushBuffer.Append(notification.ChannelID, notification.Events)
+		channelStats.Notified(notification.ChannelID)
+	}
+	// Only outages and the recoveries from them are alerted, not the first
+	// successful subscription at startup
+	var down bool
+	onStateChange := func(healthy bool, err error) {
+		if healthy && !down {
+			return
+		}
+		down = !healthy
+
+		payload := map[string]interface{}{
+			"channel": cfg.RedisChannel,
+			"healthy": healthy,
+		}
+		if err != nil {
+			payload["error"] = err.Error()
+			reporter.Capture(err, errreport.Event{
+				Tags:  map[string]string{"component": "redis_subscriber"},
+				Extra: map[string]interface{}{"channel": cfg.RedisChannel},
+			})
+		}
+		webhook.Notify("redis_subscription", payload)
+	}
+
+	subscriber := redis.NewSubscriber(client, cfg.RedisChannel, cfg.FanoutWorkers, cfg.FanoutQueueSize, onNotify, onStateChange, deadLetters, logger)
+	logger.Info("Redis subscriber created", "channel", cfg.RedisChannel)
+	return subscriber
+}
+
+func newPresenceTracker(cfg *config.Config, client *goredis.Client, logger *slog.Logger) *presence.Tracker {
+	var onChange func(presence.Change)
+	if cfg.PresenceChannel != "" {
+		onChange = func(change presence.Change) {
+			payload, err := json.Marshal(change)
+			if err != nil {
+				logger.Error("failed to encode presence change", "error", err)
+				return
+			}
+			// Changes are published from the poll that joins, so a slow Redis
+			// must not hold it up for long
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := client.Publish(ctx, cfg.PresenceChannel, payload).Err(); err != nil {
+				logger.Error("failed to publish presence change", "error", err, "channel_id", change.ChannelID)
+			}
+		}
+	}
+
+	tracker := presence.NewTracker(cfg.PresenceGrace, onChange, logger)
+	logger.Info("presence tracker created", "grace", cfg.PresenceGrace, "channel", cfg.PresenceChannel)
+	return tracker
+}
+
+// newAuditLog returns nil unless AUDIT_SINK is set
+func newAuditLog(cfg *config.Config, client *goredis.Client, logger *slog.Logger) (*audit.Log, error) {
+	var sink audit.Sink
+	switch cfg.AuditSink {
+	case "file":
+		fileSink, err := audit.NewFileSink(cfg.AuditFile)
+		if err != nil {
+			return nil, err
+		}
+		sink = fileSink
+		logger.Info("audit log enabled", "sink", "file", "path", cfg.AuditFile)
+	case "redis":
+		sink = audit.NewRedisSink(client, cfg.AuditRedisKey, cfg.AuditRedisMaxLen)
+		logger.Info("audit log enabled", "sink", "redis", "key", cfg.AuditRedisKey)
+	default:
+		return nil, nil
+	}
+	return audit.NewLog(sink, logger), nil
+}
+
+// newRecorder records the event batches delivered to pollers for the replay
+// command; it is nil unless RECORD_SINK is set
+func newRecorder(cfg *config.Config, client *goredis.Client, logger *slog.Logger) (*recording.Recorder, error) {
+	var sink recording.Sink
+	switch cfg.RecordSink {
+	case "file":
+		fileSink, err := recording.NewFileSink(cfg.RecordFile)
+		if err != nil {
+			return nil, err
+		}
+		sink = fileSink
+		logger.Info("notification recording enabled", "sink", "file", "path", cfg.RecordFile)
+	case "redis":
+		sink = recording.NewStreamSink(client, cfg.RecordRedisKey, cfg.RecordRedisMaxLen)
+		logger.Info("notification recording enabled", "sink", "redis", "key", cfg.RecordRedisKey)
+	default:
+		return nil, nil
+	}
+	return recording.NewRecorder(sink, logger), nil
+}
@@ -0,0 +1,199 @@
+// Package errreport sends panics and operational failures to a
+// Sentry-compatible error tracker (Sentry, GlitchTip, ...) over its HTTP
+// store API.
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Event carries the context attached to a captured error
+type Event struct {
+	Tags    map[string]string
+	Extra   map[string]interface{}
+	Request *http.Request
+}
+
+// Reporter delivers errors to the configured DSN. A nil Reporter, or one
+// created with an empty DSN, discards everything.
+type Reporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	serverName  string
+	httpClient  *http.Client
+	logger      *slog.Logger
+}
+
+// NewReporter parses a DSN of the form scheme://key@host[/path]/project
+func NewReporter(dsn, environment string, timeout time.Duration, logger *slog.Logger) (*Reporter, error) {
+	r := &Reporter{
+		environment: environment,
+		httpClient:  &http.Client{Timeout: timeout},
+		logger:      logger,
+	}
+	if dsn == "" {
+		return r, nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error reporting DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid error reporting DSN: missing public key")
+	}
+	path := strings.Trim(u.Path, "/")
+	idx := strings.LastIndex(path, "/")
+	project := path[idx+1:]
+	if project == "" {
+		return nil, fmt.Errorf("invalid error reporting DSN: missing project id")
+	}
+	prefix := ""
+	if idx >= 0 {
+		prefix = "/" + path[:idx]
+	}
+
+	r.endpoint = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project)
+	r.auth = fmt.Sprintf("Sentry sentry_version=7, sentry_client=longpoll-server/1.0, sentry_key=%s", u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		r.auth += ", sentry_secret=" + secret
+	}
+	r.serverName, _ = os.Hostname()
+	return r, nil
+}
+
+// Enabled reports whether errors are actually delivered
+func (r *Reporter) Enabled() bool {
+	return r != nil && r.endpoint != ""
+}
+
+// Capture reports err in the background
+func (r *Reporter) Capture(err error, event Event) {
+	if !r.Enabled() || err == nil {
+		return
+	}
+	r.deliver(r.payload("error", fmt.Sprintf("%T", err), err.Error(), nil, event))
+}
+
+// CapturePanic reports a recovered panic value together with the stack of
+// the goroutine that panicked. It must be called from the deferred recover.
+func (r *Reporter) CapturePanic(recovered interface{}, event Event) {
+	if !r.Enabled() {
+		return
+	}
+	r.deliver(r.payload("fatal", "panic", fmt.Sprint(recovered), debug.Stack(), event))
+}
+
+// Recover is deferred at the top of a goroutine to report and re-raise a
+// panic, so crashes still terminate the process as before
+func (r *Reporter) Recover(tags map[string]string) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	if r.Enabled() {
+		// Deliver synchronously; the process is about to die
+		body := r.payload("fatal", "panic", fmt.Sprint(recovered), debug.Stack(), Event{Tags: tags})
+		ctx, cancel := context.WithTimeout(context.Background(), r.httpClient.Timeout)
+		if err := r.send(ctx, body); err != nil {
+			r.logger.Error("failed to report panic", "error", err)
+		}
+		cancel()
+	}
+	panic(recovered)
+}
+
+func (r *Reporter) payload(level, kind, message string, stack []byte, event Event) map[string]interface{} {
+	exception := map[string]interface{}{
+		"type":  kind,
+		"value": message,
+	}
+	extra := map[string]interface{}{}
+	for k, v := range event.Extra {
+		extra[k] = v
+	}
+	if stack != nil {
+		extra["stack"] = string(stack)
+	}
+
+	body := map[string]interface{}{
+		"event_id":    newEventID(),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      "longpoll-server",
+		"server_name": r.serverName,
+		"exception":   map[string]interface{}{"values": []interface{}{exception}},
+		"tags":        event.Tags,
+		"extra":       extra,
+	}
+	if r.environment != "" {
+		body["environment"] = r.environment
+	}
+	if req := event.Request; req != nil {
+		// Authorization headers and query tokens are left out on purpose
+		body["request"] = map[string]interface{}{
+			"url":    req.URL.Path,
+			"method": req.Method,
+			"headers": map[string]string{
+				"User-Agent": req.UserAgent(),
+				"Referer":    req.Referer(),
+			},
+		}
+	}
+	return body
+}
+
+func (r *Reporter) deliver(body map[string]interface{}) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), r.httpClient.Timeout)
+		defer cancel()
+		if err := r.send(ctx, body); err != nil {
+			r.logger.Error("failed to report error", "error", err)
+		}
+	}()
+}
+
+func (r *Reporter) send(ctx context.Context, body map[string]interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error tracker returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func newEventID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
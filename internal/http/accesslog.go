@@ -0,0 +1,175 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+)
+
+// Access log formats
+const (
+	// AccessLogStructured logs a "request completed" record with the logger
+	AccessLogStructured = "structured"
+	// AccessLogCombined writes Apache combined format lines
+	AccessLogCombined = "combined"
+	// AccessLogOff logs nothing, slow requests aside
+	AccessLogOff = "off"
+)
+
+// AccessLogFields lists the optional fields of structured access logs, in the
+// order they are logged
+var AccessLogFields = []string{"client_ip", "request_id", "channel_id", "user_agent", "referer", "bytes", "poll_outcome"}
+
+// combinedTimeFormat is the timestamp layout of Apache logs
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogMiddleware logs every request not matching
+// ACCESS_LOG_EXCLUDE_PATHS in ACCESS_LOG_FORMAT, combined lines going to out.
+// Requests slower than SLOW_REQUEST_THRESHOLD are logged either way.
+func AccessLogMiddleware(cfg *config.Config, out io.Writer, logger *slog.Logger) gin.HandlerFunc {
+	fields := make(map[string]bool, len(cfg.AccessLogFields))
+	for _, field := range cfg.AccessLogFields {
+		fields[field] = true
+	}
+	var mu sync.Mutex
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		raw := c.Request.URL.RawQuery
+
+		timing := &requestTiming{started: start}
+		c.Request = c.Request.WithContext(withTiming(c.Request.Context(), timing))
+
+		c.Next()
+
+		latency := time.Since(start)
+		statusCode := c.Writer.Status()
+
+		switch {
+		case cfg.AccessLogFormat == AccessLogOff || excludedPath(cfg.AccessLogExcludePaths, path):
+		case cfg.AccessLogFormat == AccessLogCombined:
+			line := combinedLine(c, start)
+			mu.Lock()
+			_, _ = io.WriteString(out, line)
+			mu.Unlock()
+		default:
+			if raw != "" {
+				path = path + "?" + raw
+			}
+			attrs := []any{
+				"method", c.Request.Method,
+				"path", path,
+				"status", statusCode,
+				"latency", latency.String(),
+			}
+			attrs = append(attrs, accessLogAttrs(c, timing, fields)...)
+			if id := traceID(c); id != "" {
+				attrs = append(attrs, "trace_id", id)
+			}
+			logger.Info("request completed", attrs...)
+		}
+
+		// Time a poll spent waiting for events is expected, only the rest
+		// counts towards the slow request threshold
+		if cfg.SlowRequestThreshold > 0 && timing.active(latency) >= cfg.SlowRequestThreshold {
+			attrs := []any{
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"status", statusCode,
+				"latency", latency.String(),
+				"client_ip", c.ClientIP(),
+				"request_id", requestID(c),
+				"trace_id", traceID(c),
+			}
+			logger.Warn("slow request", append(attrs, timing.logAttrs()...)...)
+		}
+	}
+}
+
+// excludedPath reports whether path matches one of patterns
+func excludedPath(patterns []string, urlPath string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// accessLogAttrs returns the selected optional fields. channel_id and
+// poll_outcome are only logged for polls.
+func accessLogAttrs(c *gin.Context, timing *requestTiming, fields map[string]bool) []any {
+	var attrs []any
+	for _, field := range AccessLogFields {
+		if !fields[field] {
+			continue
+		}
+		switch field {
+		case "client_ip":
+			attrs = append(attrs, field, c.ClientIP())
+		case "request_id":
+			attrs = append(attrs, field, requestID(c))
+		case "channel_id":
+			if channels := timing.pollChannels(); len(channels) > 0 {
+				attrs = append(attrs, field, strings.Join(channels, ","))
+			}
+		case "user_agent":
+			attrs = append(attrs, field, c.Request.UserAgent())
+		case "referer":
+			attrs = append(attrs, field, c.Request.Referer())
+		case "bytes":
+			attrs = append(attrs, field, max(c.Writer.Size(), 0))
+		case "poll_outcome":
+			if outcome := timing.pollOutcome(); outcome != "" {
+				attrs = append(attrs, field, outcome)
+			}
+		}
+	}
+	return attrs
+}
+
+// combinedLine formats a request in Apache combined format
+func combinedLine(c *gin.Context, start time.Time) string {
+	size := "-"
+	if n := c.Writer.Size(); n > 0 {
+		size = strconv.Itoa(n)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		c.ClientIP(),
+		start.Format(combinedTimeFormat),
+		c.Request.Method,
+		escapeLogValue(c.Request.RequestURI),
+		c.Request.Proto,
+		c.Writer.Status(),
+		size,
+		escapeLogValue(c.Request.Referer()),
+		escapeLogValue(c.Request.UserAgent()),
+	)
+}
+
+// escapeLogValue escapes quotes, backslashes and control characters like
+// Apache does, so client-supplied values can't forge log lines
+func escapeLogValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case ch == '"' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(ch)
+		case ch < 0x20 || ch == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", ch)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ackRequest is the body of POST /ack
+type ackRequest struct {
+	Token     string `json:"token"`
+	ChannelID string `json:"channel_id" binding:"required"`
+	ClientID  string `json:"client_id"`
+	EventID   int64  `json:"event_id" binding:"required,min=1"`
+}
+
+// acksQuery holds the query parameters of GET /internal/acks
+type acksQuery struct {
+	ChannelID string `form:"channel_id" binding:"required"`
+}
+
+// Ack handles POST /ack
+// A client confirms it has received a channel's events up to event_id. The
+// watermark is kept per client_id, or for the whole channel without one.
+func (h *Handlers) Ack(c *gin.Context) {
+	var req ackRequest
+	if !bindRequest(c, c.ShouldBindJSON, &req, "token, channel_id and event_id are required") {
+		return
+	}
+	if req.Token == "" {
+		req.Token = h.storedToken(c)
+	}
+	if req.Token == "" {
+		respondError(c, http.StatusBadRequest, codeTokenRequired, "token is required")
+		return
+	}
+
+	claims, ok := h.authenticate(c, req.Token)
+	if !ok {
+		return
+	}
+	if h.revocations.IsRevoked(claims.ID, req.ChannelID, claims.IssuedTime()) {
+		respondError(c, http.StatusUnauthorized, codeInvalidToken, "Invalid or expired token")
+		return
+	}
+	if !claims.Allows(req.ChannelID) {
+		respondError(c, http.StatusForbidden, codeChannelNotAuthorized, "Channel not authorized by token")
+		return
+	}
+
+	watermark, err := h.acks.Ack(c.Request.Context(), req.ChannelID, req.ClientID, req.EventID)
+	if err != nil {
+		h.logger.Error("failed to store ack", "error", err, "channel_id", req.ChannelID)
+		respondError(c, http.StatusServiceUnavailable, codeInternalError, "Failed to store ack")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"channel_id": req.ChannelID,
+		"acked":      watermark,
+	})
+}
+
+// GetAcks handles GET /internal/acks?channel_id=...
+// Laravel reads the channel's watermarks here; every event up to
+// low_watermark has been confirmed by all clients and can be pruned.
+func (h *Handlers) GetAcks(c *gin.Context) {
+	var query acksQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "channel_id is required") {
+		return
+	}
+	channelID := query.ChannelID
+
+	watermarks, err := h.acks.Watermarks(c.Request.Context(), channelID)
+	if err != nil {
+		h.logger.Error("failed to load acks", "error", err, "channel_id", channelID)
+		respondError(c, http.StatusServiceUnavailable, codeInternalError, "Failed to load acks")
+		return
+	}
+
+	var low int64
+	for _, eventID := range watermarks {
+		if low == 0 || eventID < low {
+			low = eventID
+		}
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"channel_id":    channelID,
+		"clients":       watermarks,
+		"low_watermark": low,
+	})
+}
@@ -0,0 +1,441 @@
+package http
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/audit"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+	"github.com/levskiy0/go-laravel-long-polling/internal/revocation"
+)
+
+// AdminAuthMiddleware protects admin routes with a bearer secret.
+// Admin routes are disabled when no secret is configured.
+func AdminAuthMiddleware(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if secret == "" {
+			abortError(c, http.StatusForbidden, codeFeatureDisabled, "Admin API is disabled")
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+			abortError(c, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// BanChannel handles POST /admin/channels/:id/ban
+func (h *Handlers) BanChannel(c *gin.Context) {
+	channelID := c.Param("id")
+
+	if err := h.revocations.Ban(c.Request.Context(), channelID); err != nil {
+		h.logger.Error("failed to ban channel", "error", err, "channel_id", channelID)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to ban channel")
+		return
+	}
+
+	h.logger.Info("channel banned", "channel_id", channelID)
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionChannelBanned, Channels: []string{channelID}})
+	respond(c, http.StatusOK, gin.H{
+		"channel_id": channelID,
+		"banned":     true,
+	})
+}
+
+// UnbanChannel handles DELETE /admin/channels/:id/ban
+func (h *Handlers) UnbanChannel(c *gin.Context) {
+	channelID := c.Param("id")
+
+	if err := h.revocations.Unban(c.Request.Context(), channelID); err != nil {
+		h.logger.Error("failed to unban channel", "error", err, "channel_id", channelID)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to unban channel")
+		return
+	}
+
+	h.logger.Info("channel unbanned", "channel_id", channelID)
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionChannelUnbanned, Channels: []string{channelID}})
+	respond(c, http.StatusOK, gin.H{
+		"channel_id": channelID,
+		"banned":     false,
+	})
+}
+
+// RevokeChannelTokens handles POST /admin/channels/:id/revoke
+// Every token for the channel issued up to now stops being accepted.
+func (h *Handlers) RevokeChannelTokens(c *gin.Context) {
+	channelID := c.Param("id")
+
+	if err := h.revocations.RevokeChannel(c.Request.Context(), channelID); err != nil {
+		h.logger.Error("failed to revoke channel tokens", "error", err, "channel_id", channelID)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to revoke tokens")
+		return
+	}
+
+	h.logger.Info("channel tokens revoked", "channel_id", channelID)
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionChannelRevoked, Channels: []string{channelID}})
+	respond(c, http.StatusOK, gin.H{
+		"channel_id": channelID,
+		"revoked":    true,
+	})
+}
+
+// revokeTokenQuery holds the query parameters of POST /admin/tokens/revoke
+type revokeTokenQuery struct {
+	Token string `form:"token" binding:"required"`
+}
+
+// RevokeToken handles POST /admin/tokens/revoke?token=...
+func (h *Handlers) RevokeToken(c *gin.Context) {
+	var query revokeTokenQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "token is required") {
+		return
+	}
+
+	claims, err := h.jwtService.ValidateToken(query.Token)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidToken, "Invalid or expired token")
+		return
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Token has no ID, revoke its channel instead")
+		return
+	}
+
+	if err := h.revocations.RevokeToken(c.Request.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		h.logger.Error("failed to revoke token", "error", err, "token_id", claims.ID)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to revoke token")
+		return
+	}
+
+	h.logger.Info("token revoked", "token_id", claims.ID)
+	expiresAt := claims.ExpiresAt.Time
+	h.auditAdmin(c, audit.Entry{
+		Action:    audit.ActionTokenRevoked,
+		Channels:  claims.AllowedChannels(),
+		TokenID:   claims.ID,
+		ExpiresAt: &expiresAt,
+	})
+	respond(c, http.StatusOK, gin.H{
+		"token_id": claims.ID,
+		"revoked":  true,
+	})
+}
+
+// replayQuery holds the query parameters of POST /admin/channels/:id/replay
+type replayQuery struct {
+	EventID  int64  `form:"event_id" binding:"required,min=1"`
+	ClientID string `form:"client_id"`
+}
+
+// ReplayEvent handles POST /admin/channels/:id/replay?event_id=...&client_id=...
+// The stored event is fetched from Laravel and re-delivered to the channel's
+// waiting pollers on every instance, or only to those polling with client_id.
+func (h *Handlers) ReplayEvent(c *gin.Context) {
+	var query replayQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "event_id is required") {
+		return
+	}
+	channelID := c.Param("id")
+	clientID := query.ClientID
+	eventID := query.EventID
+
+	ctx := c.Request.Context()
+
+	events, err := h.source.GetEvents(ctx, channelID, eventID, 1)
+	if err != nil {
+		h.logger.Error("failed to fetch event for replay", "error", err, "channel_id", channelID, "event_id", eventID)
+		respondError(c, http.StatusBadGateway, codeUpstreamError, "Failed to fetch event")
+		return
+	}
+
+	var found bool
+	notification := redis.EventNotification{
+		ChannelID:      channelID,
+		EventID:        eventID,
+		Timestamp:      time.Now().Unix(),
+		TargetClientID: clientID,
+	}
+	for i := range events {
+		if events[i].ID == eventID {
+			notification.Replay = &events[i]
+			found = true
+			break
+		}
+	}
+	if !found {
+		respondError(c, http.StatusNotFound, codeNotFound, "Event not found")
+		return
+	}
+
+	if err := h.subscriber.Publish(ctx, notification); err != nil {
+		h.logger.Error("failed to publish replay", "error", err, "channel_id", channelID, "event_id", eventID)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to replay event")
+		return
+	}
+
+	h.auditAdmin(c, audit.Entry{
+		Action:   audit.ActionEventReplayed,
+		Channels: []string{channelID},
+		Reason:   fmt.Sprintf("event %d replayed to %q", eventID, clientID),
+	})
+
+	respond(c, http.StatusOK, gin.H{
+		"channel_id": channelID,
+		"event_id":   eventID,
+		"client_id":  clientID,
+		"replayed":   true,
+	})
+}
+
+// maintenanceRequest is the body of POST /admin/maintenance
+type maintenanceRequest struct {
+	// RetryAfter is the base delay suggested to clients, in seconds
+	RetryAfter int    `json:"retry_after"`
+	Message    string `json:"message"`
+}
+
+// StartMaintenance handles POST /admin/maintenance
+// Until DELETE /admin/maintenance, polls on every instance are answered at
+// once with a maintenance response instead of being held.
+func (h *Handlers) StartMaintenance(c *gin.Context) {
+	var req maintenanceRequest
+	if c.Request.ContentLength != 0 {
+		if !bindRequest(c, c.ShouldBindJSON, &req, "Invalid request body") {
+			return
+		}
+	}
+	if req.RetryAfter < 1 {
+		req.RetryAfter = 30
+	}
+
+	if err := h.revocations.StartMaintenance(c.Request.Context(), time.Duration(req.RetryAfter)*time.Second, req.Message); err != nil {
+		h.logger.Error("failed to start maintenance", "error", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to start maintenance")
+		return
+	}
+
+	h.logger.Info("maintenance started", "retry_after", req.RetryAfter)
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionMaintenance, Reason: req.Message})
+	respond(c, http.StatusOK, gin.H{
+		"maintenance": true,
+		"retry_after": req.RetryAfter,
+	})
+}
+
+// StopMaintenance handles DELETE /admin/maintenance
+func (h *Handlers) StopMaintenance(c *gin.Context) {
+	if err := h.revocations.Resume(c.Request.Context()); err != nil {
+		h.logger.Error("failed to stop maintenance", "error", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to stop maintenance")
+		return
+	}
+
+	h.logger.Info("maintenance stopped")
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionResumed})
+	respond(c, http.StatusOK, gin.H{
+		"maintenance": false,
+	})
+}
+
+// respondMaintenance tells a poller to come back later. The suggested delay
+// is jittered up to twice the configured one so clients don't all return at
+// the same moment.
+func (h *Handlers) respondMaintenance(c *gin.Context, maintenance revocation.Maintenance) {
+	base := maintenance.RetryAfter
+	if base < 1 {
+		base = 1
+	}
+	retryAfter := base + rand.Intn(base)
+
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	message := "Service is under maintenance"
+	if maintenance.Message != "" {
+		message = maintenance.Message
+	}
+	respondError(c, http.StatusServiceUnavailable, codeMaintenance, message, gin.H{
+		"retry_after": retryAfter,
+		"since":       maintenance.Since,
+	})
+}
+
+// broadcastRequest is the body of POST /admin/broadcast
+type broadcastRequest struct {
+	Event map[string]interface{} `json:"event" binding:"required,min=1"`
+}
+
+// Broadcast handles POST /admin/broadcast
+// The event is delivered to every poll waiting on any instance, whatever its
+// channel, e.g. to announce maintenance. It has ID 0 and doesn't move offsets.
+func (h *Handlers) Broadcast(c *gin.Context) {
+	var req broadcastRequest
+	if !bindRequest(c, c.ShouldBindJSON, &req, "event is required") {
+		return
+	}
+
+	now := time.Now().Unix()
+	notification := redis.EventNotification{
+		Timestamp: now,
+		Broadcast: true,
+		Replay: &core.Event{
+			Event:     req.Event,
+			CreatedAt: now,
+		},
+	}
+	if err := h.subscriber.Publish(c.Request.Context(), notification); err != nil {
+		h.logger.Error("failed to publish broadcast", "error", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to broadcast event")
+		return
+	}
+
+	eventType, _ := req.Event["type"].(string)
+	h.auditAdmin(c, audit.Entry{
+		Action: audit.ActionBroadcast,
+		Reason: "event type " + strconv.Quote(eventType),
+	})
+
+	respond(c, http.StatusOK, gin.H{
+		"broadcast": true,
+	})
+}
+
+// deadLettersQuery holds the query parameters of the dead-letter endpoints
+type deadLettersQuery struct {
+	Limit int `form:"limit,default=100"`
+}
+
+// deadLetterLimit returns the limit query parameter, capped at 1000
+func deadLetterLimit(c *gin.Context) int {
+	var query deadLettersQuery
+	if err := c.ShouldBindQuery(&query); err != nil || query.Limit < 1 {
+		return 100
+	}
+	return min(query.Limit, 1000)
+}
+
+// ListDeadLetters handles GET /admin/dead-letters?limit=...
+// Returns the most recent undeliverable notifications, newest first.
+func (h *Handlers) ListDeadLetters(c *gin.Context) {
+	if h.deadLetters == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "Dead-letter tracking is disabled")
+		return
+	}
+
+	letters, err := h.deadLetters.List(c.Request.Context(), deadLetterLimit(c))
+	if err != nil {
+		h.logger.Error("failed to list dead letters", "error", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to list dead letters")
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"dead_letters": letters,
+	})
+}
+
+// ReplayDeadLetters handles POST /admin/dead-letters/replay?limit=...
+// The oldest dead letters are removed and published again to every instance.
+func (h *Handlers) ReplayDeadLetters(c *gin.Context) {
+	if h.deadLetters == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "Dead-letter tracking is disabled")
+		return
+	}
+
+	ctx := c.Request.Context()
+	letters, err := h.deadLetters.Take(ctx, deadLetterLimit(c))
+	if err != nil {
+		h.logger.Error("failed to take dead letters", "error", err)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to replay dead letters")
+		return
+	}
+
+	var replayed int
+	channels := make([]string, 0, len(letters))
+	for _, letter := range letters {
+		if err := h.subscriber.Publish(ctx, letter.Notification); err != nil {
+			h.logger.Error("failed to replay dead letter", "error", err, "channel_id", letter.Notification.ChannelID)
+			h.deadLetters.Record(letter.Notification, letter.Reason)
+			continue
+		}
+		replayed++
+		channels = append(channels, letter.Notification.ChannelID)
+	}
+
+	h.auditAdmin(c, audit.Entry{
+		Action:   audit.ActionDeadReplayed,
+		Channels: channels,
+		Reason:   fmt.Sprintf("%d of %d dead letters replayed", replayed, len(letters)),
+	})
+
+	respond(c, http.StatusOK, gin.H{
+		"replayed": replayed,
+		"failed":   len(letters) - replayed,
+	})
+}
+
+// auditAdmin records a successful admin action
+func (h *Handlers) auditAdmin(c *gin.Context, entry audit.Entry) {
+	entry.Outcome = audit.OutcomeSuccess
+	entry.ClientIP = c.ClientIP()
+	h.audit.Record(c.Request.Context(), entry)
+}
+
+// ChannelStats handles GET /admin/channels/:id/stats
+// Statistics are kept in memory and cover this instance only.
+func (h *Handlers) ChannelStats(c *gin.Context) {
+	respond(c, http.StatusOK, h.stats.Snapshot(c.Param("id")))
+}
+
+// logLevelRequest is the body of PUT /admin/loglevel
+type logLevelRequest struct {
+	Level string `json:"level" binding:"required,oneof=debug info warn error"`
+}
+
+// LogLevel handles GET /admin/loglevel
+func (h *Handlers) LogLevel(c *gin.Context) {
+	if h.logLevel == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "Log level is not adjustable")
+		return
+	}
+	respond(c, http.StatusOK, gin.H{
+		"level": strings.ToLower(h.logLevel.Level().String()),
+	})
+}
+
+// SetLogLevel handles PUT /admin/loglevel
+// The level applies to this instance until it restarts or is changed again,
+// so debug logs can be captured without dropping the waiting polls.
+func (h *Handlers) SetLogLevel(c *gin.Context) {
+	if h.logLevel == nil {
+		respondError(c, http.StatusNotFound, codeFeatureDisabled, "Log level is not adjustable")
+		return
+	}
+	var req logLevelRequest
+	if !bindRequest(c, c.ShouldBindJSON, &req, "Invalid request body") {
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "Invalid log level")
+		return
+	}
+	previous := h.logLevel.Level()
+	h.logLevel.Set(level)
+
+	// Logged at WARN so the change shows at any level
+	h.logger.Warn("log level changed", "from", previous, "to", level)
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionLogLevel, Reason: req.Level})
+	h.LogLevel(c)
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/audit"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+// routedParam marks a redirected poll, which is then served wherever it
+// lands so instances with diverging rings can't bounce it around
+const routedParam = "routed"
+
+var affinityRedirects = metrics.NewCounter(
+	"longpoll_affinity_redirects_total",
+	"Polls redirected to the instance owning their channels.",
+)
+
+// redirectToOwner sends a poll to the instance owning its channels with a
+// 307, which keeps the method and body. Polls whose channels belong to
+// different instances, or that were already redirected, are served here
+// unless this instance is draining.
+func (h *Handlers) redirectToOwner(c *gin.Context, channels []string) bool {
+	if h.ring == nil || len(channels) == 0 {
+		return false
+	}
+	if c.Query(routedParam) != "" && !h.ring.Draining() {
+		return false
+	}
+
+	owner, remote := h.ring.Owner(channels[0])
+	if !remote {
+		return false
+	}
+	for _, channelID := range channels[1:] {
+		if other, _ := h.ring.Owner(channelID); other != owner {
+			return false
+		}
+	}
+
+	query := c.Request.URL.Query()
+	query.Set(routedParam, "1")
+	location := strings.TrimSuffix(owner, "/") + c.Request.URL.Path + "?" + query.Encode()
+
+	affinityRedirects.Inc()
+	c.Redirect(http.StatusTemporaryRedirect, location)
+	return true
+}
+
+// StartDrain handles POST /admin/drain
+// The instance leaves the affinity ring, so the others take over its
+// channels and accept the polls it redirects to them, and its waiting polls
+// are told to reconnect. It keeps serving until the load balancer stops
+// sending it traffic; DELETE /admin/drain undoes it.
+func (h *Handlers) StartDrain(c *gin.Context) {
+	if h.ring != nil {
+		if err := h.ring.Drain(c.Request.Context()); err != nil {
+			h.logger.Error("failed to announce drain", "error", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to start draining")
+			return
+		}
+	}
+	h.Drain(h.reconnectHint)
+
+	h.logger.Info("instance draining")
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionDrainStarted})
+	h.DrainStatus(c)
+}
+
+// StopDrain handles DELETE /admin/drain
+func (h *Handlers) StopDrain(c *gin.Context) {
+	if h.ring != nil {
+		if err := h.ring.Undrain(c.Request.Context()); err != nil {
+			h.logger.Error("failed to stop draining", "error", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to stop draining")
+			return
+		}
+	}
+	h.Undrain()
+
+	h.logger.Info("instance no longer draining")
+	h.auditAdmin(c, audit.Entry{Action: audit.ActionDrainStopped})
+	h.DrainStatus(c)
+}
+
+// DrainStatus handles GET /admin/drain
+// It reports whether this instance drains and which instances of the ring
+// currently do.
+func (h *Handlers) DrainStatus(c *gin.Context) {
+	response := gin.H{
+		"draining": h.draining.Load() > 0,
+	}
+	if h.ring != nil {
+		instances, err := h.ring.DrainingInstances(c.Request.Context())
+		if err != nil {
+			h.logger.Error("failed to load draining instances", "error", err)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to load draining instances")
+			return
+		}
+		response["draining_instances"] = instances
+		response["ring_instances"] = h.ring.Instances()
+	}
+	respond(c, http.StatusOK, response)
+}
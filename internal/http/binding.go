@@ -0,0 +1,66 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// fieldNamesOnce registers fieldName with gin's validator
+var fieldNamesOnce sync.Once
+
+// registerFieldNames makes validation errors name fields the way clients
+// send them
+func registerFieldNames() {
+	fieldNamesOnce.Do(func() {
+		if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+			validate.RegisterTagNameFunc(fieldName)
+		}
+	})
+}
+
+// fieldName returns the name a field is sent under: its json tag, or its
+// form tag for query parameters
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "form"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(key), ","); name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// bindRequest decodes the request into req with bind, e.g. c.ShouldBindJSON,
+// which also checks req's binding tags. On failure it responds 400 with
+// message and, for fields breaking a rule, the rule each one broke.
+func bindRequest(c *gin.Context, bind func(interface{}) error, req interface{}, message string) bool {
+	err := bind(req)
+	if err == nil {
+		return true
+	}
+
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, message)
+		return false
+	}
+
+	fields := make(gin.H, len(invalid))
+	for _, fieldErr := range invalid {
+		rule := fieldErr.Tag()
+		if fieldErr.Param() != "" {
+			rule += "=" + fieldErr.Param()
+		}
+		fields[fieldErr.Field()] = rule
+	}
+	respondError(c, http.StatusBadRequest, codeInvalidRequest, message, gin.H{
+		"fields": fields,
+	})
+	return false
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var chaosFaults = metrics.NewCounterVec(
+	"longpoll_chaos_faults_total",
+	"Faults injected by CHAOS_ENABLED, by fault.",
+	"fault",
+)
+
+// Injected faults, named in the X-Chaos-Fault response header
+const (
+	faultLatency  = "latency"
+	faultDrop     = "drop"
+	faultError    = "error"
+	faultTruncate = "truncate"
+)
+
+// chaosFaultHeader tells testers which faults a response was given
+const chaosFaultHeader = "X-Chaos-Fault"
+
+type chaosKey struct{}
+
+// chaos injects faults into client-facing requests at configured rates, so
+// clients can exercise their retry and backoff logic
+type chaos struct {
+	latency      time.Duration
+	latencyRate  float64
+	dropRate     float64
+	errorRate    float64
+	truncateRate float64
+}
+
+// chaosFrom returns the request's fault injector, or nil when chaos mode is
+// off. All methods accept a nil receiver.
+func chaosFrom(ctx context.Context) *chaos {
+	ch, _ := ctx.Value(chaosKey{}).(*chaos)
+	return ch
+}
+
+// dropNotification reports whether a poll should ignore a notification, as
+// if it had been lost
+func (ch *chaos) dropNotification() bool {
+	if ch == nil || !hit(ch.dropRate) {
+		return false
+	}
+	chaosFaults.WithLabelValues(faultDrop).Inc()
+	return true
+}
+
+func hit(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// ChaosMiddleware injects latency of up to CHAOS_LATENCY, 500 responses and
+// truncated bodies at their configured rates. It does nothing unless
+// CHAOS_ENABLED is set.
+func ChaosMiddleware(cfg *config.Config, logger *slog.Logger) gin.HandlerFunc {
+	if !cfg.ChaosEnabled {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	ch := &chaos{
+		latency:      cfg.ChaosLatency,
+		latencyRate:  cfg.ChaosLatencyRate,
+		dropRate:     cfg.ChaosDropRate,
+		errorRate:    cfg.ChaosErrorRate,
+		truncateRate: cfg.ChaosTruncateRate,
+	}
+	logger.Warn("fault injection enabled, do not use in production",
+		"latency", ch.latency,
+		"latency_rate", ch.latencyRate,
+		"drop_rate", ch.dropRate,
+		"error_rate", ch.errorRate,
+		"truncate_rate", ch.truncateRate,
+	)
+
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), chaosKey{}, ch))
+
+		if ch.latency > 0 && hit(ch.latencyRate) {
+			chaosFaults.WithLabelValues(faultLatency).Inc()
+			c.Writer.Header().Add(chaosFaultHeader, faultLatency)
+			delay := time.Duration(rand.Int63n(int64(ch.latency)) + 1)
+			select {
+			case <-time.After(delay):
+			case <-c.Request.Context().Done():
+				c.Abort()
+				return
+			}
+		}
+
+		if hit(ch.errorRate) {
+			chaosFaults.WithLabelValues(faultError).Inc()
+			c.Writer.Header().Add(chaosFaultHeader, faultError)
+			abortError(c, http.StatusInternalServerError, codeInternalError, "Injected fault")
+			return
+		}
+
+		if !hit(ch.truncateRate) {
+			c.Next()
+			return
+		}
+
+		chaosFaults.WithLabelValues(faultTruncate).Inc()
+		c.Writer.Header().Add(chaosFaultHeader, faultTruncate)
+		writer := &truncatingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+		writer.finish()
+	}
+}
+
+// truncatingWriter holds the body back and sends only its first half, so
+// the client receives a response that can't be decoded. Keep-alive bytes
+// are held back too.
+type truncatingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *truncatingWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *truncatingWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *truncatingWriter) Flush() {}
+
+func (w *truncatingWriter) finish() {
+	w.Header().Del("Content-Length")
+	_, _ = w.ResponseWriter.Write(w.body.Bytes()[:w.body.Len()/2])
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// encoder is the part of gzip.Writer and brotli.Writer the middleware uses
+type encoder interface {
+	io.Writer
+	Reset(w io.Writer)
+	Flush() error
+	Close() error
+}
+
+// compressResponseWriter compresses the response once the first write shows
+// it is large enough to be worth it
+type compressResponseWriter struct {
+	gin.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	minSize  int
+	enc      encoder
+	decided  bool
+}
+
+func (w *compressResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decide(len(data))
+	}
+	if w.enc != nil {
+		return w.enc.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressResponseWriter) Flush() {
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide enables compression based on the size of the first write. Responses
+// that start with a small write, such as keep-alive bytes, stay uncompressed.
+func (w *compressResponseWriter) decide(size int) {
+	w.decided = true
+
+	header := w.Header()
+	if size < w.minSize || header.Get("Content-Encoding") != "" {
+		return
+	}
+	status := w.Status()
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		return
+	}
+
+	header.Set("Content-Encoding", w.encoding)
+	header.Del("Content-Length")
+
+	w.enc = w.pool.Get().(encoder)
+	w.enc.Reset(w.ResponseWriter)
+}
+
+func (w *compressResponseWriter) close() {
+	if w.enc == nil {
+		return
+	}
+	_ = w.enc.Close()
+	w.pool.Put(w.enc)
+	w.enc = nil
+}
+
+// CompressionMiddleware compresses responses of at least minSize bytes with
+// Brotli or gzip, whichever the client's Accept-Encoding prefers. Brotli wins
+// a tie, its output being smaller for JSON.
+func CompressionMiddleware(gzipLevel, brotliLevel, minSize int) gin.HandlerFunc {
+	pools := map[string]*sync.Pool{
+		"br": {
+			New: func() interface{} {
+				return brotli.NewWriterLevel(nil, brotliLevel)
+			},
+		},
+		"gzip": {
+			New: func() interface{} {
+				gz, err := gzip.NewWriterLevel(nil, gzipLevel)
+				if err != nil {
+					gz = gzip.NewWriter(nil)
+				}
+				return gz
+			},
+		},
+	}
+
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		writer := &compressResponseWriter{
+			ResponseWriter: c.Writer,
+			encoding:       encoding,
+			pool:           pools[encoding],
+			minSize:        minSize,
+		}
+		c.Writer = writer
+		defer writer.close()
+
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks "br" or "gzip" from an Accept-Encoding header by
+// weight, or returns an empty string when the client accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				parsed = 0
+			}
+			weight = parsed
+		}
+		weights[coding] = weight
+	}
+
+	best, bestWeight := "", 0.0
+	for _, coding := range []string{"br", "gzip"} {
+		weight, ok := weights[coding]
+		if !ok {
+			weight = weights["*"]
+		}
+		if weight > bestWeight {
+			best, bestWeight = coding, weight
+		}
+	}
+	return best
+}
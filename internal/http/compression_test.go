@@ -0,0 +1,72 @@
+package http_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	longpollhttp "github.com/levskiy0/go-laravel-long-polling/internal/http"
+)
+
+func compressed(t *testing.T, acceptEncoding string) *http.Response {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(longpollhttp.CompressionMiddleware(-1, 4, 16))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat("event ", 100))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func TestCompressionNegotiatesEncoding(t *testing.T) {
+	cases := map[string]string{
+		"gzip, deflate, br":  "br",
+		"gzip":               "gzip",
+		"br;q=0.5, gzip":     "gzip",
+		"br;q=0, *":          "gzip",
+		"*":                  "br",
+		"deflate":            "",
+		"gzip;q=0, br;q=0.0": "",
+	}
+	for acceptEncoding, want := range cases {
+		if got := compressed(t, acceptEncoding).Header.Get("Content-Encoding"); got != want {
+			t.Errorf("Accept-Encoding %q: expected %q, got %q", acceptEncoding, want, got)
+		}
+	}
+}
+
+func TestCompressionRoundTrips(t *testing.T) {
+	for _, encoding := range []string{"br", "gzip"} {
+		resp := compressed(t, encoding)
+
+		var body io.Reader = resp.Body
+		if encoding == "br" {
+			body = brotli.NewReader(resp.Body)
+		} else {
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = gz
+		}
+		decoded, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if string(decoded) != strings.Repeat("event ", 100) {
+			t.Fatalf("%s: unexpected body %q", encoding, decoded)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+)
+
+// TokenCookie configures the HttpOnly cookie carrying access tokens for
+// browser apps. An empty Name disables cookie mode.
+type TokenCookie struct {
+	Name     string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// Enabled reports whether tokens may be handed out and read as cookies
+func (t TokenCookie) Enabled() bool {
+	return t.Name != ""
+}
+
+// set stores the token in an HttpOnly cookie expiring with the token
+func (t TokenCookie) set(c *gin.Context, token string, expiresAt time.Time) {
+	maxAge := int(time.Until(expiresAt).Seconds())
+	c.SetSameSite(t.SameSite)
+	c.SetCookie(t.Name, token, maxAge, t.Path, t.Domain, t.Secure, true)
+}
+
+// get returns the token from the cookie, or "" when there is none
+func (t TokenCookie) get(c *gin.Context) string {
+	if !t.Enabled() {
+		return ""
+	}
+	token, err := c.Cookie(t.Name)
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// NewTokenCookie reads the cookie settings from the configuration
+func NewTokenCookie(cfg *config.Config) TokenCookie {
+	sameSite := http.SameSiteLaxMode
+	switch cfg.TokenCookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return TokenCookie{
+		Name:     cfg.TokenCookieName,
+		Domain:   cfg.TokenCookieDomain,
+		Path:     cfg.TokenCookiePath,
+		Secure:   cfg.TokenCookieSecure,
+		SameSite: sameSite,
+	}
+}
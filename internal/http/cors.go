@@ -1,28 +1,99 @@
 package http
 
 import (
+	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/levskiy0/go-laravel-long-polling/internal/config"
 )
 
-// CORSMiddleware creates CORS middleware based on config
+// originMatcher matches request origins against the CORS_ALLOWED_ORIGINS list
+type originMatcher struct {
+	any      bool
+	exact    map[string]struct{}
+	suffixes []originSuffix
+}
+
+// originSuffix is a "scheme://*.domain" entry
+type originSuffix struct {
+	scheme string
+	domain string
+}
+
+func newOriginMatcher(list string) *originMatcher {
+	m := &originMatcher{exact: make(map[string]struct{})}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimRight(strings.ToLower(strings.TrimSpace(entry)), "/")
+		switch {
+		case entry == "":
+		case entry == "*":
+			m.any = true
+		case strings.Contains(entry, "://*."):
+			parts := strings.SplitN(entry, "://*.", 2)
+			m.suffixes = append(m.suffixes, originSuffix{scheme: parts[0], domain: parts[1]})
+		default:
+			m.exact[entry] = struct{}{}
+		}
+	}
+	return m
+}
+
+// allows reports whether origin is on the list. Wildcard entries match
+// subdomains at any depth but not the bare domain itself.
+func (m *originMatcher) allows(origin string) bool {
+	if m.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if _, ok := m.exact[origin]; ok {
+		return true
+	}
+	for _, s := range m.suffixes {
+		host, ok := strings.CutPrefix(origin, s.scheme+"://")
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(host, "."+s.domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware creates CORS middleware based on config. A "*" list answers
+// with a literal "*" and never allows credentials, so no site can make
+// requests carrying a visitor's cookies; otherwise only the request's own
+// Origin is reflected, and only when it is on the allowlist.
 func CORSMiddleware(cfg *config.Config) gin.HandlerFunc {
+	matcher := newOriginMatcher(cfg.CORSAllowedOrigins)
+	maxAge := strconv.Itoa(cfg.CORSMaxAge)
+
 	return func(c *gin.Context) {
-		// Set CORS headers
-		c.Writer.Header().Set("Access-Control-Allow-Origin", cfg.CORSAllowedOrigins)
-		c.Writer.Header().Set("Access-Control-Allow-Methods", cfg.CORSAllowedMethods)
-		c.Writer.Header().Set("Access-Control-Allow-Headers", cfg.CORSAllowedHeaders)
-		c.Writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(cfg.CORSMaxAge))
-
-		if cfg.CORSAllowCredentials {
-			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		origin := c.GetHeader("Origin")
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+
+		if origin != "" && matcher.allows(origin) {
+			if matcher.any {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			header.Set("Access-Control-Allow-Methods", cfg.CORSAllowedMethods)
+			header.Set("Access-Control-Allow-Headers", cfg.CORSAllowedHeaders)
+			header.Set("Access-Control-Max-Age", maxAge)
+
+			// Credentials only for origins listed explicitly
+			if cfg.CORSAllowCredentials && !matcher.any {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
 		}
 
 		// Handle preflight requests
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
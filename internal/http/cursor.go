@@ -0,0 +1,35 @@
+package http
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursor is the decoded form of the opaque next_cursor value. It records the
+// offset to resume from for every channel of the poll.
+type cursor struct {
+	Offsets map[string]int64 `json:"o"`
+}
+
+// encodeCursor encodes per-channel offsets into an opaque cursor string
+func encodeCursor(offsets map[string]int64) string {
+	payload, _ := json.Marshal(cursor{Offsets: offsets})
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// decodeCursor decodes a cursor string produced by encodeCursor
+func decodeCursor(value string) (map[string]int64, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var decoded cursor
+	if err := json.Unmarshal(payload, &decoded); err != nil || len(decoded.Offsets) == 0 {
+		return nil, errInvalidCursor
+	}
+	return decoded.Offsets, nil
+}
@@ -0,0 +1,35 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pollWriteMargin is granted on top of a poll's wait to collect its events
+// and write the response
+const pollWriteMargin = 10 * time.Second
+
+type rawWriterKey struct{}
+
+// withRawWriter keeps the server's own ResponseWriter in the request context:
+// per-request write deadlines can only be set on it, not on gin's or the
+// compression middleware's wrappers
+func withRawWriter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), rawWriterKey{}, w)))
+	})
+}
+
+// extendWriteDeadline lets the response be written until d from now,
+// replacing HTTP_WRITE_TIMEOUT for this request only
+func extendWriteDeadline(c *gin.Context, d time.Duration) error {
+	w, ok := c.Request.Context().Value(rawWriterKey{}).(http.ResponseWriter)
+	if !ok {
+		return errors.New("response writer not available")
+	}
+	return http.NewResponseController(w).SetWriteDeadline(time.Now().Add(d))
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net"
+
+	"github.com/gin-gonic/gin"
+)
+
+// debugHeader set to 1 asks for a timing breakdown in the poll response
+const debugHeader = "X-Debug"
+
+// debugTokenHeader carries the admin secret. Polls may send Laravel
+// credentials in Authorization, which is forwarded for private channels.
+const debugTokenHeader = "X-Debug-Token"
+
+// debugTimingKey marks requests allowed to see the breakdown
+const debugTimingKey = "debug_timing"
+
+// DebugTimingMiddleware grants the X-Debug timing breakdown to polls that
+// also carry the admin secret in X-Debug-Token, from an IP allowed to use
+// the admin API. Anyone else's X-Debug header is ignored.
+func DebugTimingMiddleware(secret string, allowed []string) gin.HandlerFunc {
+	allow := parseNetworks(allowed)
+
+	return func(c *gin.Context) {
+		if secret != "" && c.GetHeader(debugHeader) == "1" {
+			provided := c.GetHeader(debugTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) == 1 &&
+				(len(allow) == 0 || containsIP(allow, net.ParseIP(c.ClientIP()))) {
+				c.Set(debugTimingKey, true)
+			}
+		}
+		c.Next()
+	}
+}
+
+// debugTiming reports whether the response should include the breakdown
+func debugTiming(c *gin.Context) bool {
+	return c.GetBool(debugTimingKey)
+}
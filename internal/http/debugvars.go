@@ -0,0 +1,63 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+// processStarted dates the process for the uptime in /debug/vars
+var processStarted = time.Now()
+
+// DebugVars handles GET /debug/vars
+// It serves the standard expvar variables (cmdline, memstats) with runtime
+// and service statistics of this instance under "runtime" and "longpoll",
+// for a quick look without a metrics stack.
+func (h *Handlers) DebugVars(c *gin.Context) {
+	channels, subscriptions := h.subscriber.Subscriptions()
+	service := gin.H{
+		"waiting_polls":         h.waiting.Load(),
+		"subscribed_channels":   channels,
+		"subscription_handlers": subscriptions,
+		"subscriber_healthy":    h.subscriber.Healthy(),
+		"draining":              h.draining.Load() > 0,
+		"metrics":               metrics.Default.Snapshot(),
+	}
+	runtimeStats := gin.H{
+		"version":        runtime.Version(),
+		"goroutines":     runtime.NumGoroutine(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"cpus":           runtime.NumCPU(),
+		"uptime_seconds": int64(time.Since(processStarted).Seconds()),
+	}
+
+	// Written like expvar's own handler, whose variables are already JSON
+	var body bytes.Buffer
+	body.WriteString("{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(&body, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	for _, section := range []struct {
+		name  string
+		value gin.H
+	}{{"runtime", runtimeStats}, {"longpoll", service}} {
+		encoded, err := json.Marshal(section.value)
+		if err != nil {
+			h.logger.Error("failed to encode debug vars", "error", err, "section", section.name)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to encode debug vars")
+			return
+		}
+		fmt.Fprintf(&body, "%q: %s,\n", section.name, encoded)
+	}
+	body.Truncate(body.Len() - 2)
+	body.WriteString("\n}\n")
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body.Bytes())
+}
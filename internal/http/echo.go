@@ -0,0 +1,91 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/audit"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+)
+
+// EchoApp holds the Pusher-style app credentials used to sign
+// /broadcasting/auth responses. An empty Secret disables the endpoint.
+type EchoApp struct {
+	Key    string
+	Secret string
+}
+
+// Enabled reports whether the Echo-compatible auth endpoint is served
+func (e EchoApp) Enabled() bool {
+	return e.Secret != ""
+}
+
+// sign returns the Pusher auth string "<key>:<hmac>" for a socket and channel
+func (e EchoApp) sign(socketID, channelName string) string {
+	mac := hmac.New(sha256.New, []byte(e.Secret))
+	mac.Write([]byte(socketID + ":" + channelName))
+	return e.Key + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewEchoApp reads the Echo app credentials from the configuration
+func NewEchoApp(cfg *config.Config) EchoApp {
+	return EchoApp{
+		Key:    cfg.EchoAppKey,
+		Secret: cfg.EchoAppSecret,
+	}
+}
+
+type broadcastingAuthRequest struct {
+	SocketID    string `form:"socket_id" json:"socket_id" binding:"required"`
+	ChannelName string `form:"channel_name" json:"channel_name" binding:"required"`
+}
+
+// BroadcastingAuth handles the Laravel Echo authorizer contract
+// POST /broadcasting/auth (channel_name, socket_id)
+//
+// Only private channels are accepted. Access is decided by Laravel with the
+// caller's forwarded credentials; the response carries the signed Pusher
+// auth string along with an access token for polling the channel.
+func (h *Handlers) BroadcastingAuth(app EchoApp) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req broadcastingAuthRequest
+		if !bindRequest(c, c.ShouldBind, &req, "socket_id and channel_name are required") {
+			return
+		}
+		channelIDs := []string{req.ChannelName}
+
+		if !h.private.IsPrivate(req.ChannelName) {
+			h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, "not a private channel")
+			respondError(c, http.StatusForbidden, codeChannelNotPrivate, "Channel is not private")
+			return
+		}
+
+		if h.revocations.IsBanned(req.ChannelName) {
+			h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, "channel banned: "+req.ChannelName)
+			respondError(c, http.StatusForbidden, codeChannelBanned, "Channel is banned")
+			return
+		}
+
+		if reason, ok := h.authorizePrivate(c, channelIDs); !ok {
+			h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, reason)
+			return
+		}
+
+		token, claims, err := h.jwtService.IssueToken(channelIDs)
+		if err != nil {
+			h.logger.Error("failed to generate token", "error", err, "channel_id", req.ChannelName)
+			h.auditToken(c, channelIDs, nil, audit.OutcomeError, err.Error())
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to generate token")
+			return
+		}
+		h.auditToken(c, channelIDs, claims, audit.OutcomeSuccess, "")
+
+		respond(c, http.StatusOK, gin.H{
+			"auth":  app.sign(req.SocketID, req.ChannelName),
+			"token": token,
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes are part of the API: clients branch on them, so they never
+// change once released, while messages may
+const (
+	codeInvalidRequest          = "invalid_request"
+	codeTokenRequired           = "token_required"
+	codeInvalidToken            = "invalid_token"
+	codeUnauthorized            = "unauthorized"
+	codeForbidden               = "forbidden"
+	codeChannelNotAuthorized    = "channel_not_authorized"
+	codePublishNotAuthorized    = "publish_not_authorized"
+	codeChannelBanned           = "channel_banned"
+	codeChannelNotPrivate       = "channel_not_private"
+	codeChannelIDTooLong        = "channel_id_too_long"
+	codeInvalidCursor           = "invalid_cursor"
+	codeRangeTooLarge           = "range_too_large"
+	codeBodyTooLarge            = "body_too_large"
+	codeQueryTooLong            = "query_too_long"
+	codeNotFound                = "not_found"
+	codeNotSupported            = "not_supported"
+	codeFeatureDisabled         = "feature_disabled"
+	codeRateLimited             = "rate_limited"
+	codeChannelFull             = "channel_full"
+	codeServerSaturated         = "server_saturated"
+	codeUpstreamSaturated       = "upstream_saturated"
+	codeUpstreamInvalidResponse = "upstream_invalid_response"
+	codeUpstreamError           = "upstream_error"
+	codeAuthUnavailable         = "auth_unavailable"
+	codeIdempotencyConflict     = "idempotency_conflict"
+	codeMaintenance             = "maintenance"
+	codeInternalError           = "internal_error"
+)
+
+// requestIDHeader carries the ID correlating a request with its logs
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs taken from clients or proxies
+const maxRequestIDLength = 128
+
+// requestIDKey stores the request ID in the gin context
+const requestIDKey = "request_id"
+
+// RequestIDMiddleware reuses the X-Request-ID a proxy or client sent, or
+// generates one, and echoes it in the response
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength || !printable(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// requestID returns the ID of the request, or "" outside the middleware
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func printable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// errorBody builds {"error": {"code", "message", "request_id"}}. details,
+// such as retry_after, are added to the error object.
+func errorBody(c *gin.Context, code, message string, details ...gin.H) gin.H {
+	body := gin.H{
+		"code":    code,
+		"message": message,
+	}
+	if id := requestID(c); id != "" {
+		body["request_id"] = id
+	}
+	for _, extra := range details {
+		for key, value := range extra {
+			body[key] = value
+		}
+	}
+	return gin.H{"error": body}
+}
+
+// respondError writes an error in the negotiated format
+func respondError(c *gin.Context, status int, code, message string, details ...gin.H) {
+	respond(c, status, errorBody(c, code, message, details...))
+}
+
+// abortError stops the handler chain and writes an error like respondError
+func abortError(c *gin.Context, status int, code, message string, details ...gin.H) {
+	c.Abort()
+	respondError(c, status, code, message, details...)
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+// Values accepted in the format_opts parameter
+const (
+	formatOptRFC3339   = "rfc3339"
+	formatOptStringIDs = "string_ids"
+)
+
+// Media types that select a MessagePack response
+const (
+	mimeMsgPack  = "application/msgpack"
+	mimeXMsgPack = "application/x-msgpack"
+)
+
+// Values accepted in the comma-separated format parameter
+const (
+	formatMsgPack = "msgpack"
+	formatV2      = "v2"
+)
+
+// hasFormat reports whether the comma-separated format value contains want
+func hasFormat(format, want string) bool {
+	for _, value := range strings.Split(format, ",") {
+		if strings.TrimSpace(value) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// wantsV2 reports whether the client asked for the v2 response envelope,
+// either with format=v2 or with an Accept-Version: 2 header
+func wantsV2(c *gin.Context, format string) bool {
+	if hasFormat(format, formatV2) {
+		return true
+	}
+	version := strings.TrimPrefix(strings.ToLower(c.GetHeader("Accept-Version")), "v")
+	return version == "2"
+}
+
+// formatOptions controls how event IDs and timestamps are encoded in responses
+type formatOptions struct {
+	rfc3339   bool
+	stringIDs bool
+}
+
+// parseFormatOptions parses a comma-separated format_opts value
+func parseFormatOptions(value string) (formatOptions, error) {
+	var opts formatOptions
+	for _, opt := range strings.Split(value, ",") {
+		switch strings.TrimSpace(opt) {
+		case "":
+		case formatOptRFC3339:
+			opts.rfc3339 = true
+		case formatOptStringIDs:
+			opts.stringIDs = true
+		default:
+			return opts, fmt.Errorf("unknown format option: %s", opt)
+		}
+	}
+	return opts, nil
+}
+
+// formattedEvent is an event with its ID and timestamp re-encoded
+type formattedEvent struct {
+	ID        interface{}            `json:"id"`
+	ChannelID string                 `json:"channel_id,omitempty"`
+	Event     map[string]interface{} `json:"event"`
+	CreatedAt interface{}            `json:"created_at"`
+}
+
+// id encodes an event ID or offset
+func (o formatOptions) id(id int64) interface{} {
+	if o.stringIDs {
+		return strconv.FormatInt(id, 10)
+	}
+	return id
+}
+
+// timestamp encodes a Unix timestamp as a number or an RFC 3339 string
+func (o formatOptions) timestamp(unix int64) interface{} {
+	if o.rfc3339 {
+		return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+	}
+	return unix
+}
+
+// events re-encodes events, returning them untouched when no option is set
+func (o formatOptions) events(events []core.Event) interface{} {
+	if !o.rfc3339 && !o.stringIDs {
+		return events
+	}
+
+	formatted := make([]interface{}, len(events))
+	for i, event := range events {
+		formatted[i] = o.event(event)
+	}
+	return formatted
+}
+
+// event re-encodes a single event
+func (o formatOptions) event(event core.Event) interface{} {
+	if !o.rfc3339 && !o.stringIDs {
+		return event
+	}
+	return formattedEvent{
+		ID:        o.id(event.ID),
+		ChannelID: event.ChannelID,
+		Event:     event.Event,
+		CreatedAt: o.timestamp(event.CreatedAt),
+	}
+}
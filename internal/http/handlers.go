@@ -2,192 +2,1467 @@ package http
 
 import (
 	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/access"
+	"github.com/levskiy0/go-laravel-long-polling/internal/affinity"
+	"github.com/levskiy0/go-laravel-long-polling/internal/audit"
 	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/buildinfo"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
 	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/e2e"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+	"github.com/levskiy0/go-laravel-long-polling/internal/presence"
+	"github.com/levskiy0/go-laravel-long-polling/internal/recording"
 	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+	"github.com/levskiy0/go-laravel-long-polling/internal/revocation"
+	"github.com/levskiy0/go-laravel-long-polling/internal/stats"
 )
 
+var tokenAttemptsBlocked = metrics.NewCounterVec(
+	"longpoll_token_attempts_blocked_total",
+	"Access token requests refused by brute-force protection, by reason.",
+	"reason",
+)
+
+var waitingPolls = metrics.NewGauge(
+	"longpoll_waiting_polls",
+	"Polls currently waiting for events.",
+)
+
+var unsealedEventsDropped = metrics.NewCounter(
+	"longpoll_unsealed_events_dropped_total",
+	"Plaintext events withheld from clients because ENCRYPTION_REQUIRED is set.",
+)
+
+// errChannelFull is returned when a channel has reached its poller cap
+var errChannelFull = errors.New("channel poller limit reached")
+
 type Handlers struct {
-	jwtService   *auth.JWTService
-	upstreamPool *core.LaravelUpstreamPool
-	subscriber   *redis.Subscriber
-	accessSecret string
-	pollTimeout  time.Duration
-	maxLimit     int
-	logger       *slog.Logger
-}
-
-func NewHandlers(
-	jwtService *auth.JWTService,
-	upstreamPool *core.LaravelUpstreamPool,
-	subscriber *redis.Subscriber,
-	accessSecret string,
-	pollTimeout time.Duration,
-	maxLimit int,
-	logger *slog.Logger,
-) *Handlers {
+	jwtService     *auth.JWTService
+	source         core.EventSource
+	pushBuffer     *core.PushBuffer
+	store          *redis.EventStore
+	acks           *redis.AckStore
+	offsets        *redis.OffsetStore
+	deadLetters    *redis.DeadLetters
+	subscriber     *redis.Subscriber
+	presence       *presence.Tracker
+	cluster        *presence.Cluster
+	revocations    *revocation.Registry
+	stats          *stats.Recorder
+	audit          *audit.Log
+	recorder       *recording.Recorder
+	sealer         *e2e.Sealer
+	tokenCookie    TokenCookie
+	secrets        *access.Secrets
+	guard          *access.Guard
+	private        *access.PrivateChannels
+	introspector   *access.Introspector
+	apiKeys        *access.APIKeys
+	public         *access.PublicChannels
+	ring           *affinity.Ring
+	probe          *core.UpstreamProbe
+	pollTimeout    time.Duration
+	maxPollTimeout time.Duration
+	batchWait      time.Duration
+	maxLimit       int
+	keepAlive      time.Duration
+	maxPollers     int
+	coalesce       bool
+	maxWaiting     int64
+	retryAfter     time.Duration
+	reconnectHint  time.Duration
+	degradedMode   string
+	fallbackPoll   time.Duration
+	historyRange   int
+	historyEvents  int
+	maxChannelID   int
+	waiting        atomic.Int64
+	draining       atomic.Int64
+	logLevel       *slog.LevelVar
+	logger         *slog.Logger
+}
+
+// Deps are the components the handlers serve from. Optional components
+// may be nil; the nil value of each disables its feature.
+type Deps struct {
+	JWTService      *auth.JWTService
+	Source          core.EventSource
+	PushBuffer      *core.PushBuffer
+	Store           *redis.EventStore
+	Acks            *redis.AckStore
+	Offsets         *redis.OffsetStore
+	DeadLetters     *redis.DeadLetters
+	Subscriber      *redis.Subscriber
+	Presence        *presence.Tracker
+	Cluster         *presence.Cluster
+	Revocations     *revocation.Registry
+	Stats           *stats.Recorder
+	Audit           *audit.Log
+	Recorder        *recording.Recorder
+	Sealer          *e2e.Sealer
+	Secrets         *access.Secrets
+	Guard           *access.Guard
+	PrivateChannels *access.PrivateChannels
+	Introspector    *access.Introspector
+	APIKeys         *access.APIKeys
+	PublicChannels  *access.PublicChannels
+	Ring            *affinity.Ring
+	Probe           *core.UpstreamProbe
+	LogLevel        *slog.LevelVar
+}
+
+// NewHandlers creates the handlers from their components and the poll,
+// history and degraded-mode settings of cfg
+func NewHandlers(deps Deps, cfg *config.Config, logger *slog.Logger) *Handlers {
 	return &Handlers{
-		jwtService:   jwtService,
-		upstreamPool: upstreamPool,
-		subscriber:   subscriber,
-		accessSecret: accessSecret,
-		pollTimeout:  pollTimeout,
-		maxLimit:     maxLimit,
-		logger:       logger,
+		jwtService:     deps.JWTService,
+		source:         deps.Source,
+		pushBuffer:     deps.PushBuffer,
+		store:          deps.Store,
+		acks:           deps.Acks,
+		offsets:        deps.Offsets,
+		deadLetters:    deps.DeadLetters,
+		subscriber:     deps.Subscriber,
+		presence:       deps.Presence,
+		cluster:        deps.Cluster,
+		revocations:    deps.Revocations,
+		stats:          deps.Stats,
+		audit:          deps.Audit,
+		recorder:       deps.Recorder,
+		sealer:         deps.Sealer,
+		tokenCookie:    NewTokenCookie(cfg),
+		secrets:        deps.Secrets,
+		guard:          deps.Guard,
+		private:        deps.PrivateChannels,
+		introspector:   deps.Introspector,
+		apiKeys:        deps.APIKeys,
+		public:         deps.PublicChannels,
+		ring:           deps.Ring,
+		probe:          deps.Probe,
+		pollTimeout:    cfg.PollTimeout,
+		maxPollTimeout: cfg.MaxPollTimeout,
+		batchWait:      cfg.BatchWait,
+		maxLimit:       cfg.MaxLimit,
+		keepAlive:      cfg.KeepAliveInterval,
+		maxPollers:     cfg.MaxPollersPerChannel,
+		coalesce:       cfg.ChannelOverflow == "coalesce",
+		maxWaiting:     int64(cfg.MaxWaitingPolls),
+		retryAfter:     cfg.RetryAfter,
+		reconnectHint:  cfg.ReconnectHint,
+		degradedMode:   cfg.DegradedMode,
+		fallbackPoll:   cfg.DegradedPollInterval,
+		historyRange:   cfg.HistoryMaxRange,
+		historyEvents:  cfg.HistoryMaxEvents,
+		maxChannelID:   cfg.MaxChannelIDLength,
+		logLevel:       deps.LogLevel,
+		logger:         logger,
 	}
 }
 
+// accessTokenQuery holds the query parameters of POST /getAccessToken
+type accessTokenQuery struct {
+	ChannelIDs        []string `form:"channel_id" binding:"dive,required"`
+	PublishChannelIDs []string `form:"publish_channel_id" binding:"dive,required"`
+	Secret            string   `form:"secret"`
+	// Cookie set to 1 sends the token in a cookie rather than the body
+	Cookie string `form:"cookie"`
+}
+
 // GetAccessToken handles the /getAccessToken endpoint
 // POST /getAccessToken?channel_id=...&secret=...
+//
+// channel_id may be repeated to issue a token authorizing several channels.
+// publish_channel_id, also repeatable, grants publishing to a channel through
+// /publish; tokens only read their channel_id channels otherwise. The secret
+// has to cover both.
 func (h *Handlers) GetAccessToken(c *gin.Context) {
-	channelID := c.Query("channel_id")
-	secret := c.Query("secret")
+	var query accessTokenQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "channel_id is required") {
+		return
+	}
+	readIDs := query.ChannelIDs
+	publishIDs := query.PublishChannelIDs
+	channelIDs := slices.Clone(readIDs)
+	for _, id := range publishIDs {
+		if !slices.Contains(channelIDs, id) {
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	secret := query.Secret
+	channelID := strings.Join(channelIDs, ",")
 
-	if channelID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "channel_id is required",
+	if len(channelIDs) == 0 {
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, "channel_id is required")
+		return
+	}
+
+	clientIP := c.ClientIP()
+	if reason, wait, ok := h.guard.Allow(clientIP); !ok {
+		retryAfter := int(wait.Round(time.Second) / time.Second)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		tokenAttemptsBlocked.WithLabelValues(reason).Inc()
+		h.logger.Warn("security event",
+			"event", "token_"+reason,
+			"client_ip", clientIP,
+			"channel_id", channelID,
+			"retry_after", retryAfter,
+		)
+		h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, strings.ReplaceAll(reason, "_", " "))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		respondError(c, http.StatusTooManyRequests, codeRateLimited, "Too many attempts", gin.H{
+			"retry_after": retryAfter,
 		})
 		return
 	}
 
-	if secret != h.accessSecret {
+	if !h.secrets.Allows(secret, channelIDs...) {
 		h.logger.Warn("invalid access secret", "channel_id", channelID)
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Unauthorized",
-		})
+		if lockout := h.guard.Failed(clientIP); lockout > 0 {
+			h.logger.Warn("security event",
+				"event", "token_lockout",
+				"client_ip", clientIP,
+				"channel_id", channelID,
+				"lockout", lockout,
+			)
+		}
+		h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, "invalid secret")
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return
+	}
+	h.guard.Succeeded(clientIP)
+
+	for _, id := range channelIDs {
+		if h.revocations.IsBanned(id) {
+			h.logger.Warn("token requested for banned channel", "channel_id", id)
+			h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, "channel banned: "+id)
+			respondError(c, http.StatusForbidden, codeChannelBanned, "Channel is banned")
+			return
+		}
+	}
+
+	if reason, ok := h.authorizePrivate(c, channelIDs); !ok {
+		h.auditToken(c, channelIDs, nil, audit.OutcomeDenied, reason)
 		return
 	}
 
-	token, err := h.jwtService.GenerateToken(channelID)
+	token, claims, err := h.jwtService.IssueScopedToken(readIDs, publishIDs)
 	if err != nil {
 		h.logger.Error("failed to generate token", "error", err, "channel_id", channelID)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to generate token",
-		})
+		h.auditToken(c, channelIDs, nil, audit.OutcomeError, err.Error())
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to generate token")
 		return
 	}
 
 	h.logger.Info("token generated", "channel_id", channelID)
+	h.auditToken(c, channelIDs, claims, audit.OutcomeSuccess, "")
 
-	c.JSON(http.StatusOK, gin.H{
+	// In cookie mode the token is kept away from JavaScript entirely
+	if h.tokenCookie.Enabled() && query.Cookie == "1" {
+		h.tokenCookie.set(c, token, claims.ExpiresAt.Time)
+		respond(c, http.StatusOK, gin.H{
+			"cookie":     true,
+			"expires_at": claims.ExpiresAt.Unix(),
+		})
+		return
+	}
+
+	respond(c, http.StatusOK, gin.H{
 		"token": token,
 	})
 }
 
+// auditToken records a /getAccessToken call; claims is nil unless a token
+// was issued
+func (h *Handlers) auditToken(c *gin.Context, channelIDs []string, claims *auth.Claims, outcome, reason string) {
+	entry := audit.Entry{
+		Action:   audit.ActionTokenIssued,
+		Outcome:  outcome,
+		Channels: channelIDs,
+		ClientIP: c.ClientIP(),
+		Reason:   reason,
+	}
+	if outcome != audit.OutcomeSuccess {
+		entry.Action = audit.ActionTokenDenied
+	}
+	if claims != nil {
+		entry.TokenID = claims.ID
+		expiresAt := claims.ExpiresAt.Time
+		entry.ExpiresAt = &expiresAt
+	}
+	h.audit.Record(c.Request.Context(), entry)
+}
+
+// updatesRequest holds the parameters of a single poll
+type updatesRequest struct {
+	Token    string           `json:"token"`
+	Channels []string         `json:"channels"`
+	Offset   *int64           `json:"offset"`
+	Offsets  map[string]int64 `json:"offsets"`
+	Limit    int              `json:"limit" binding:"min=0"`
+	Cursor   string           `json:"cursor"`
+	ClientID string           `json:"client_id"`
+	Format   string           `json:"format_opts"`
+	Encoding string           `json:"format"`
+	// Wait overrides POLL_TIMEOUT in seconds, 0 meaning short polling
+	Wait *int `json:"wait" binding:"omitempty,min=0"`
+	// Types restricts delivery to events whose payload "type" is listed
+	Types []string `json:"types"`
+	// Since starts channels without an offset at the events created at or
+	// after this Unix time in milliseconds
+	Since int64 `json:"since"`
+
+	format formatOptions
+	pollID string
+	// outcome and delivered describe how the poll ended
+	outcome   string
+	delivered int
+	// tracked is set when the client passed no offsets; consumer then names
+	// the server-side offsets the poll reads and advances
+	tracked  bool
+	consumer string
+}
+
+// matches reports whether an event passes the types filter
+func (r *updatesRequest) matches(event core.Event) bool {
+	if len(r.Types) == 0 {
+		return true
+	}
+	eventType, _ := event.Event["type"].(string)
+	return slices.Contains(r.Types, eventType)
+}
+
+// offset returns the requested offset, 0 without one
+func (r *updatesRequest) offset() int64 {
+	if r.Offset == nil {
+		return 0
+	}
+	return *r.Offset
+}
+
+// offsetFor returns the offset to fetch a channel from
+func (r *updatesRequest) offsetFor(channelID string) int64 {
+	if offset, ok := r.Offsets[channelID]; ok {
+		return offset
+	}
+	return r.offset()
+}
+
+// updatesQuery holds the query parameters of GET /getUpdates. channel and
+// types may be repeated or comma-separated.
+type updatesQuery struct {
+	Token    string   `form:"token"`
+	Channels []string `form:"channel"`
+	Offset   *int64   `form:"offset"`
+	Limit    int      `form:"limit,default=100" binding:"min=0"`
+	Cursor   string   `form:"cursor"`
+	ClientID string   `form:"client_id"`
+	Format   string   `form:"format_opts"`
+	Encoding string   `form:"format"`
+	Wait     *int     `form:"wait" binding:"omitempty,min=0"`
+	Types    []string `form:"types"`
+	Since    int64    `form:"since"`
+}
+
 // GetUpdates handles the /getUpdates endpoint
-// GET /getUpdates?token=...&offset=...&limit=...
+// GET /getUpdates?token=...&offset=...&limit=...&channel=...&client_id=...
 func (h *Handlers) GetUpdates(c *gin.Context) {
-	tokenString := c.Query("token")
-	offsetStr := c.DefaultQuery("offset", "0")
-	limitStr := c.DefaultQuery("limit", "100")
-
-	// Validate token
-	if tokenString == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "token is required",
-		})
+	var query updatesQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "Invalid query parameters") {
+		return
+	}
+
+	// Without an offset parameter, an EventSource reconnecting sends the
+	// last ID it received; offsets are inclusive, so it resumes after it
+	tracked := query.Offset == nil && query.Cursor == "" && query.Since == 0
+	var offset int64
+	if query.Offset != nil {
+		offset = *query.Offset
+	} else if lastEventID := c.GetHeader("Last-Event-ID"); lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "Last-Event-ID must be an event ID")
+			return
+		}
+		tracked = false
+		offset = id + 1
+	}
+
+	h.poll(c, &updatesRequest{
+		tracked:  tracked,
+		Since:    query.Since,
+		Wait:     query.Wait,
+		Types:    splitValues(query.Types),
+		Token:    query.Token,
+		Channels: splitValues(query.Channels),
+		Offset:   &offset,
+		Limit:    query.Limit,
+		Cursor:   query.Cursor,
+		ClientID: query.ClientID,
+		Format:   query.Format,
+		Encoding: query.Encoding,
+	})
+}
+
+// splitValues splits comma-separated values, dropping empty ones
+func splitValues(values []string) []string {
+	var split []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				split = append(split, item)
+			}
+		}
+	}
+	return split
+}
+
+// PostUpdates handles the POST variant of the /getUpdates endpoint
+// POST /getUpdates {"token": "...", "channels": [...], "offsets": {"channel": 42}, "limit": 100}
+func (h *Handlers) PostUpdates(c *gin.Context) {
+	var req updatesRequest
+	if !bindRequest(c, c.ShouldBindJSON, &req, "Invalid request body") {
 		return
 	}
+	req.tracked = req.Offset == nil && len(req.Offsets) == 0 && req.Cursor == "" && req.Since == 0
+
+	h.poll(c, &req)
+}
 
-	channelID, err := h.jwtService.ValidateToken(tokenString)
+// authorizePrivate checks the private channels among channelIDs with
+// Laravel and responds when access is not granted
+func (h *Handlers) authorizePrivate(c *gin.Context, channelIDs []string) (string, bool) {
+	decision, err := h.private.Authorize(c.Request.Context(), c.Request, channelIDs)
 	if err != nil {
+		h.logger.Error("private channel authorization failed", "error", err, "channels", channelIDs)
+		respondError(c, http.StatusBadGateway, codeAuthUnavailable, "Channel authorization unavailable")
+		return err.Error(), false
+	}
+	if !decision.Allowed {
+		h.logger.Warn("private channel access denied", "reason", decision.Reason)
+		respondError(c, http.StatusForbidden, codeChannelNotAuthorized, "Channel access denied")
+		return decision.Reason, false
+	}
+	return "", true
+}
+
+// storedToken returns the credential of a request without a token: the
+// token cookie, or the X-API-Key header of machine clients
+func (h *Handlers) storedToken(c *gin.Context) string {
+	if token := h.tokenCookie.get(c); token != "" {
+		return token
+	}
+	if h.apiKeys != nil {
+		return c.GetHeader("X-API-Key")
+	}
+	return ""
+}
+
+// validateToken validates an API key, a token issued by this service or,
+// with token introspection configured, one issued by the OAuth2
+// authorization server. Errors other than auth.ErrInvalidToken and
+// auth.ErrExpiredToken mean the token could not be checked.
+func (h *Handlers) validateToken(ctx context.Context, token string) (*auth.Claims, error) {
+	if claims, ok := h.apiKeys.Validate(token); ok {
+		return claims, nil
+	}
+	claims, err := h.jwtService.ValidateToken(token)
+	if err == nil || h.introspector == nil || errors.Is(err, auth.ErrExpiredToken) {
+		return claims, err
+	}
+	return h.introspector.Validate(ctx, token)
+}
+
+// authenticate validates token, responding 401 when it is invalid and 503
+// when the introspection endpoint cannot tell
+func (h *Handlers) authenticate(c *gin.Context, token string) (*auth.Claims, bool) {
+	claims, err := h.validateToken(c.Request.Context(), token)
+	switch {
+	case err == nil:
+		return claims, true
+	case errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrExpiredToken):
 		h.logger.Warn("invalid token", "error", err)
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid or expired token",
-		})
+		respondError(c, http.StatusUnauthorized, codeInvalidToken, "Invalid or expired token")
+	default:
+		h.logger.Error("token introspection failed", "error", err)
+		respondError(c, http.StatusServiceUnavailable, codeAuthUnavailable, "Token validation unavailable")
+	}
+	return nil, false
+}
+
+// loadOffsets resumes a poll without offsets from the consumer's stored
+// ones. The consumer is the client_id, or the token ID without one.
+func (h *Handlers) loadOffsets(ctx context.Context, req *updatesRequest, claims *auth.Claims, channels []string) error {
+	req.consumer = req.ClientID
+	if req.consumer == "" {
+		req.consumer = "token:" + claims.ID
+	}
+
+	offsets, err := h.offsets.Load(ctx, req.consumer, channels)
+	if err != nil {
+		return err
+	}
+	req.Offsets = offsets
+	if len(channels) == 1 {
+		offset := offsets[channels[0]]
+		req.Offset = &offset
+	}
+	return nil
+}
+
+// poll authorizes the request and holds it until events are available or the poll times out
+func (h *Handlers) poll(c *gin.Context, req *updatesRequest) {
+	authStart := time.Now()
+	overrideFormat(c, req.Encoding)
+
+	if maintenance, ok := h.revocations.Maintenance(); ok {
+		h.respondMaintenance(c, maintenance)
+		return
+	}
+	if req.Token == "" {
+		req.Token = h.storedToken(c)
+	}
+
+	// Validate token, which public channels do without
+	var claims *auth.Claims
+	var ok bool
+	if req.Token == "" {
+		claims, ok = h.anonymous(c, req.Channels)
+	} else {
+		claims, ok = h.authenticate(c, req.Token)
+	}
+	if !ok {
 		return
 	}
 
-	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	var err error
+	req.format, err = parseFormatOptions(req.Format)
 	if err != nil {
-		offset = 0
+		respondError(c, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	channels := req.Channels
+	if req.Cursor != "" {
+		offsets, err := decodeCursor(req.Cursor)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, codeInvalidCursor, "Invalid cursor")
+			return
+		}
+		req.Offsets = offsets
+		if len(channels) == 0 {
+			for channelID := range offsets {
+				channels = append(channels, channelID)
+			}
+			sort.Strings(channels)
+		}
+	}
+	if len(channels) == 0 {
+		channels = claims.AllowedChannels()
+	}
+	for _, channelID := range channels {
+		if h.maxChannelID > 0 && len(channelID) > h.maxChannelID {
+			respondError(c, http.StatusBadRequest, codeChannelIDTooLong, "Channel ID too long", gin.H{
+				"max_bytes": h.maxChannelID,
+			})
+			return
+		}
+		if !claims.Allows(channelID) {
+			h.logger.Warn("channel not authorized by token", "channel_id", channelID)
+			respondError(c, http.StatusForbidden, codeChannelNotAuthorized, "Channel not authorized by token")
+			return
+		}
+		if h.revocations.IsBanned(channelID) {
+			h.logger.Warn("poll on banned channel", "channel_id", channelID)
+			respondError(c, http.StatusForbidden, codeChannelBanned, "Channel is banned")
+			return
+		}
+		if h.revocations.IsRevoked(claims.ID, channelID, claims.IssuedTime()) {
+			h.logger.Warn("revoked token used", "channel_id", channelID, "token_id", claims.ID)
+			respondError(c, http.StatusUnauthorized, codeInvalidToken, "Invalid or expired token")
+			return
+		}
+	}
+	if h.redirectToOwner(c, channels) {
+		return
 	}
+	if _, ok := h.authorizePrivate(c, channels); !ok {
+		return
+	}
+	timingFrom(c.Request.Context()).addPhase(phaseAuth, time.Since(authStart))
 
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 {
-		limit = 100
+	// Anonymous polls are only tracked under a client_id
+	if req.tracked && h.offsets != nil && (claims.ID != "" || req.ClientID != "") {
+		if err := h.loadOffsets(c.Request.Context(), req, claims, channels); err != nil {
+			h.logger.Error("failed to load consumer offsets", "error", err)
+			respondError(c, http.StatusServiceUnavailable, codeInternalError, "Failed to load offsets")
+			return
+		}
 	}
-	if limit > h.maxLimit {
-		limit = h.maxLimit
+
+	if req.Since > 0 {
+		if _, ok := h.source.(core.SinceSource); !ok {
+			respondError(c, http.StatusBadRequest, codeNotSupported, "since is not supported by the event source")
+			return
+		}
 	}
 
-	h.logger.Debug("getUpdates request",
-		"channel_id", channelID,
-		"offset", offset,
-		"limit", limit,
+	if req.Limit < 1 {
+		req.Limit = 100
+	}
+	if req.Limit > h.maxLimit {
+		req.Limit = h.maxLimit
+	}
+
+	req.pollID = newPollID()
+
+	h.logger.Debug("poll started",
+		"poll_id", req.pollID,
+		"trace_id", traceID(c),
+		"channels", channels,
+		"client_id", req.ClientID,
+		"offset", req.offset(),
+		"limit", req.Limit,
 	)
 
+	started := time.Now()
 	ctx := c.Request.Context()
-	events, err := h.upstreamPool.GetEvents(ctx, channelID, offset, limit)
+	timing := timingFrom(ctx)
+	defer h.finishPoll(req, timing, started)
+
+	for _, channelID := range channels {
+		h.presence.Join(channelID, req.ClientID)
+		defer h.presence.Leave(channelID, req.ClientID)
+
+		h.stats.PollStarted(channelID)
+		defer func(channelID string) {
+			h.stats.PollFinished(channelID, time.Since(started))
+		}(channelID)
+	}
+
+	timing.setPoll(channels, req.offset())
+
+	fetchStart := time.Now()
+	events, hasMore, err := h.fetchEvents(ctx, channels, req)
+	timing.addPhase(phaseFetch, time.Since(fetchStart))
 	if err != nil {
-		h.logger.Error("failed to fetch events from Laravel", "error", err, "channel_id", channelID)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch events",
-		})
+		h.logger.Error("failed to fetch events from Laravel", "error", err, "channels", channels)
+		h.respondFetchError(c, err)
 		return
 	}
 
 	if len(events) > 0 {
 		h.logger.Debug("returning immediate events",
-			"channel_id", channelID,
+			"channels", channels,
 			"count", len(events),
 		)
-		c.JSON(http.StatusOK, gin.H{
-			"events": events,
-		})
+		h.respondEvents(c, req, channels, events, hasMore)
+		return
+	}
+
+	// A draining instance sends waiting polls elsewhere
+	if reconnectAfter := h.draining.Load(); reconnectAfter > 0 {
+		h.respondReconnect(c, req, channels, reconnectAfter)
 		return
 	}
 
-	notifyCh := h.subscriber.Subscribe(channelID)
-	defer h.subscriber.Unsubscribe(channelID, notifyCh)
+	timeout := h.waitTimeout(req)
+	if timeout == 0 {
+		h.respondEvents(c, req, channels, []core.Event{}, false)
+		return
+	}
 
-	pollCtx, cancel := context.WithTimeout(ctx, h.pollTimeout)
+	// Without notifications, short mode hands polling back to the client
+	// instead of holding the poll
+	if h.degradedMode == DegradedModeShort && !h.subscriber.Healthy() {
+		if h.fallbackPoll > 0 {
+			h.respondReconnect(c, req, channels, h.fallbackPoll.Milliseconds())
+		} else {
+			h.respondEvents(c, req, channels, []core.Event{}, false)
+		}
+		return
+	}
+
+	// The wait must not be cut short by HTTP_WRITE_TIMEOUT
+	if err := extendWriteDeadline(c, timeout+h.batchWait+pollWriteMargin); err != nil {
+		h.logger.Debug("failed to extend write deadline", "error", err, "poll_id", req.pollID)
+	}
+
+	if !h.acquireWaitSlot() {
+		h.logger.Warn("waiting poll limit reached", "limit", h.maxWaiting, "channels", channels)
+		c.Header("Retry-After", strconv.Itoa(h.retryAfterSeconds()))
+		respondError(c, http.StatusServiceUnavailable, codeServerSaturated, "Server is at capacity, retry later")
+		return
+	}
+	defer h.releaseWaitSlot()
+
+	notifyCh, unsubscribe, err := h.subscribe(channels)
+	if errors.Is(err, errChannelFull) {
+		h.logger.Warn("too many pollers on channel", "channels", channels)
+		respondError(c, http.StatusTooManyRequests, codeChannelFull, "Too many pollers on channel")
+		return
+	}
+	defer unsubscribe()
+
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	select {
-	case <-pollCtx.Done():
-		// Timeout - return empty response
-		h.logger.Debug("poll timeout", "channel_id", channelID)
-		c.JSON(http.StatusOK, gin.H{
-			"events": []interface{}{},
+	waitStart := time.Now()
+
+	var keepAlive <-chan time.Time
+	if h.keepAlive > 0 {
+		ticker := time.NewTicker(h.keepAlive)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	var fallback <-chan time.Time
+	if h.degradedMode == DegradedModePoll && h.fallbackPoll > 0 {
+		ticker := time.NewTicker(h.fallbackPoll)
+		defer ticker.Stop()
+		fallback = ticker.C
+	}
+
+	for {
+		select {
+		case <-keepAlive:
+			h.writeKeepAlive(c)
+
+		case <-fallback:
+			// Without the notification subscription, events are only noticed
+			// by fetching again
+			if h.subscriber.Healthy() {
+				continue
+			}
+			fetchStart := time.Now()
+			events, hasMore, err := h.fetchEvents(ctx, channels, req)
+			timing.addPhase(phaseRefetch, time.Since(fetchStart))
+			if err != nil {
+				h.logger.Warn("degraded poll fetch failed", "error", err, "channels", channels)
+				continue
+			}
+			if len(events) == 0 {
+				continue
+			}
+			timing.addWait(time.Since(waitStart))
+			h.respondEvents(c, req, channels, events, hasMore)
+			return
+
+		case <-pollCtx.Done():
+			timing.addWait(time.Since(waitStart))
+			// The request's context ends first when the client hangs up
+			if ctx.Err() != nil {
+				req.setOutcome(pollCanceled)
+			} else {
+				req.setOutcome(pollTimeout)
+			}
+			// Timeout - return empty response
+			h.logger.Debug("poll timeout", "channels", channels)
+			h.respondEvents(c, req, channels, []core.Event{}, false)
+			return
+
+		case notification := <-notifyCh:
+			if notification.TargetClientID != "" && notification.TargetClientID != req.ClientID {
+				continue
+			}
+			if chaosFrom(ctx).dropNotification() {
+				c.Writer.Header().Add(chaosFaultHeader, faultDrop)
+				continue
+			}
+
+			if notification.ReconnectAfter > 0 {
+				timing.addWait(time.Since(waitStart))
+				h.respondReconnect(c, req, channels, notification.ReconnectAfter)
+				return
+			}
+
+			if notification.Replay != nil {
+				timing.addWait(time.Since(waitStart))
+				h.logger.Info("delivering replayed event",
+					"channel_id", notification.ChannelID,
+					"event_id", notification.Replay.ID,
+					"client_id", req.ClientID,
+				)
+				replayed := *notification.Replay
+				if len(channels) > 1 && !notification.Broadcast {
+					replayed.ChannelID = notification.ChannelID
+				}
+				h.respondEvents(c, req, channels, []core.Event{replayed}, false)
+				return
+			}
+
+			// New event notification received, fetch events again
+			h.logWakeup(req, notification.ChannelID, notification.EventID, time.Since(waitStart))
+
+			h.waitForBatch(pollCtx, notifyCh)
+			timing.addWait(time.Since(waitStart))
+
+			fetchStart := time.Now()
+			events, hasMore, err := h.fetchEvents(ctx, channels, req)
+			timing.addPhase(phaseRefetch, time.Since(fetchStart))
+			if err != nil {
+				h.logger.Error("failed to fetch events after notification",
+					"error", err,
+					"channels", channels,
+				)
+				h.respondFetchError(c, err)
+				return
+			}
+
+			h.respondEvents(c, req, channels, events, hasMore)
+			return
+		}
+	}
+}
+
+// Drain answers every waiting poll, and every poll arriving until Undrain,
+// with a reconnect event so clients move to another instance before this
+// one shuts down. reconnectAfter < 1ms does nothing.
+func (h *Handlers) Drain(reconnectAfter time.Duration) {
+	ms := reconnectAfter.Milliseconds()
+	if ms < 1 {
+		return
+	}
+	h.draining.Store(ms)
+	h.subscriber.Dispatch(redis.EventNotification{
+		Broadcast:      true,
+		ReconnectAfter: ms,
+		Timestamp:      time.Now().Unix(),
+	})
+}
+
+// Undrain lets polls wait on this instance again
+func (h *Handlers) Undrain() {
+	h.draining.Store(0)
+}
+
+// respondReconnect delivers a synthetic reconnect event. The delay is
+// jittered up to twice reconnectAfter so clients don't all retry at once.
+func (h *Handlers) respondReconnect(c *gin.Context, req *updatesRequest, channels []string, reconnectAfter int64) {
+	// rand.Int63n panics on 0
+	if reconnectAfter < 1 {
+		reconnectAfter = 1
+	}
+	event := core.Event{
+		Event: map[string]interface{}{
+			"type":           "reconnect",
+			"retry_after_ms": reconnectAfter + rand.Int63n(reconnectAfter),
+		},
+		CreatedAt: time.Now().Unix(),
+	}
+	req.setOutcome(pollReconnect)
+	h.respondEvents(c, req, channels, []core.Event{event}, false)
+}
+
+// respondFetchError reports a failed upstream fetch. Invalid responses from
+// Laravel are surfaced as 502 with the failure class so clients and operators
+// can tell them apart from other errors; a saturated pool answers 503.
+func (h *Handlers) respondFetchError(c *gin.Context, err error) {
+	if errors.Is(err, core.ErrPoolSaturated) {
+		c.Header("Retry-After", strconv.Itoa(h.retryAfterSeconds()))
+		respondError(c, http.StatusServiceUnavailable, codeUpstreamSaturated, "Upstream is at capacity, retry later")
+		return
+	}
+
+	var decodeErr *core.DecodeError
+	if errors.As(err, &decodeErr) {
+		respondError(c, http.StatusBadGateway, codeUpstreamInvalidResponse, "Invalid response from upstream", gin.H{
+			"reason": decodeErr.Class,
 		})
 		return
+	}
 
-	case notification := <-notifyCh:
-		// New event notification received, fetch events again
-		h.logger.Debug("notification received",
-			"channel_id", channelID,
-			"event_id", notification.EventID,
-		)
+	respondError(c, http.StatusInternalServerError, codeUpstreamError, "Failed to fetch events")
+}
+
+// recordEvents records the events fetched for a response, before the
+// poller's filters, per channel
+func (h *Handlers) recordEvents(channels []string, events []core.Event) {
+	batches := make(map[string][]core.Event, len(channels))
+	for _, event := range events {
+		if event.ID == 0 {
+			continue
+		}
+		channelID := event.ChannelID
+		if channelID == "" {
+			channelID = channels[0]
+		}
+		batches[channelID] = append(batches[channelID], event)
+	}
+	for channelID, batch := range batches {
+		h.recorder.Delivered(channelID, batch)
+	}
+}
+
+// respondEvents writes the events together with the offset to resume from.
+// next_offset is the highest delivered event ID + 1, or the requested offset
+// when nothing was delivered. Multi-channel polls also get per-channel offsets.
+// When more events are pending, next_cursor lets the client continue draining.
+func (h *Handlers) respondEvents(c *gin.Context, req *updatesRequest, channels []string, events []core.Event, hasMore bool) {
+	serializeStart := time.Now()
+	nextOffsets := make(map[string]int64, len(channels))
+	for _, channelID := range channels {
+		nextOffsets[channelID] = req.offsetFor(channelID)
+	}
+
+	if h.recorder != nil {
+		h.recordEvents(channels, events)
+	}
+
+	// Offsets advance past filtered-out events too, so they aren't fetched again
+	nextOffset := req.offset()
+	delivered := make([]core.Event, 0, len(events))
+	for _, event := range events {
+		// Broadcast events have no ID and belong to no channel's sequence
+		if event.ID == 0 {
+			delivered = append(delivered, event)
+			continue
+		}
+		if event.ID+1 > nextOffset {
+			nextOffset = event.ID + 1
+		}
+		channelID := event.ChannelID
+		if channelID == "" {
+			channelID = channels[0]
+		}
+		if event.ID+1 > nextOffsets[channelID] {
+			nextOffsets[channelID] = event.ID + 1
+		}
+		if !req.matches(event) {
+			continue
+		}
+		delivered = append(delivered, event)
+		h.stats.Delivered(channelID, 1)
+	}
+	events = delivered
 
-		events, err := h.upstreamPool.GetEvents(c.Request.Context(), channelID, offset, limit)
+	req.delivered = len(events)
+	if len(events) > 0 {
+		req.setOutcome(pollEvents)
+	} else {
+		req.setOutcome(pollEmpty)
+	}
+
+	if req.consumer != "" {
+		if err := h.offsets.Save(c.Request.Context(), req.consumer, nextOffsets); err != nil {
+			h.logger.Warn("failed to save consumer offsets", "error", err, "consumer", req.consumer)
+		}
+	}
+
+	if h.sealer != nil {
+		sealed, err := h.sealEvents(events, channels[0])
 		if err != nil {
-			h.logger.Error("failed to fetch events after notification",
-				"error", err,
-				"channel_id", channelID,
-			)
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to fetch events",
-			})
+			h.logger.Error("failed to encrypt events", "error", err, "channels", channels)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to encrypt events")
 			return
 		}
+		events = sealed
+	}
 
-		c.JSON(http.StatusOK, gin.H{
-			"events": events,
-		})
+	c.Header("X-Next-Offset", strconv.FormatInt(nextOffset, 10))
+
+	response := gin.H{
+		"events":      req.format.events(events),
+		"next_offset": req.format.id(nextOffset),
+	}
+	if len(channels) > 1 {
+		if req.format.stringIDs {
+			formatted := make(map[string]interface{}, len(nextOffsets))
+			for channelID, offset := range nextOffsets {
+				formatted[channelID] = req.format.id(offset)
+			}
+			response["next_offsets"] = formatted
+		} else {
+			response["next_offsets"] = nextOffsets
+		}
+	}
+	if hasMore {
+		response["next_cursor"] = encodeCursor(nextOffsets)
+	}
+	if wantsV2(c, req.Encoding) {
+		response["has_more"] = hasMore
+		response["server_time"] = req.format.timestamp(time.Now().Unix())
+		response["poll_id"] = req.pollID
+	}
+
+	timing := timingFrom(c.Request.Context())
+	timing.addPhase(phaseSerialize, time.Since(serializeStart))
+	if debugTiming(c) {
+		response["debug"] = timing.breakdown()
+	}
+
+	// NDJSON puts one event per line, followed by the envelope without them
+	if responseFormat(c) == formatNDJSON {
+		lines := make([]interface{}, 0, len(events)+1)
+		for _, event := range events {
+			lines = append(lines, req.format.event(event))
+		}
+		delete(response, "events")
+		c.Render(http.StatusOK, ndjsonRender{lines: append(lines, response)})
+		return
+	}
+	respond(c, http.StatusOK, response)
+}
+
+// sealEvents makes sure every event payload leaves as an envelope, sealing
+// plaintext with the channel's key in transport mode. With end-to-end
+// encryption, plaintext events are withheld; their offsets still advance.
+// Events may be shared with the upstream cache, so copies are returned.
+func (h *Handlers) sealEvents(events []core.Event, defaultChannel string) ([]core.Event, error) {
+	sealed := make([]core.Event, 0, len(events))
+	for _, event := range events {
+		channelID := event.ChannelID
+		if channelID == "" {
+			channelID = defaultChannel
+		}
+		payload, err := h.sealer.Seal(channelID, event.Event)
+		switch {
+		case errors.Is(err, e2e.ErrNotSealed) && event.ID == 0:
+			// System broadcasts are written through the admin API, not by
+			// Laravel, and belong to no channel key
+			payload = event.Event
+		case errors.Is(err, e2e.ErrNotSealed):
+			unsealedEventsDropped.Inc()
+			h.logger.Error("withholding unencrypted event", "channel_id", channelID, "event_id", event.ID)
+			continue
+		case err != nil:
+			return nil, err
+		}
+		event.Event = payload
+		sealed = append(sealed, event)
+	}
+	return sealed, nil
+}
+
+// writeKeepAlive sends a whitespace byte so intermediaries see traffic on an
+// idle poll. Leading whitespace is ignored by JSON parsers. Once the first
+// byte is sent the status code is committed as 200, so later errors are
+// reported in the body only.
+func (h *Handlers) writeKeepAlive(c *gin.Context) {
+	// A whitespace byte would corrupt a binary body
+	if responseFormat(c) == formatMsgPack {
+		return
+	}
+	if !c.Writer.Written() {
+		if responseFormat(c) == formatNDJSON {
+			c.Header("Content-Type", mimeNDJSON)
+		} else {
+			c.Header("Content-Type", "application/json; charset=utf-8")
+		}
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+	}
+
+	if _, err := c.Writer.WriteString(" "); err != nil {
+		h.logger.Debug("failed to write keep-alive", "error", err)
+		return
+	}
+	c.Writer.Flush()
+}
+
+// waitForBatch holds the poll for the batch window after the first notification
+// so that a burst of events is returned in one response. Notifications arriving
+// during the window are drained so they don't overflow the channel.
+func (h *Handlers) waitForBatch(ctx context.Context, notifyCh <-chan redis.EventNotification) {
+	if h.batchWait <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(h.batchWait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-notifyCh:
+		}
+	}
+}
+
+// newPollID returns a random identifier for one /getUpdates request
+func newPollID() string {
+	id := make([]byte, 8)
+	_, _ = crand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// waitTimeout returns how long a poll may wait: POLL_TIMEOUT unless the
+// client asked for its own wait, which is clamped to MAX_POLL_TIMEOUT
+func (h *Handlers) waitTimeout(req *updatesRequest) time.Duration {
+	if req.Wait == nil {
+		return h.pollTimeout
+	}
+	wait := time.Duration(*req.Wait) * time.Second
+	if wait < 0 {
+		return 0
+	}
+	if wait > h.maxPollTimeout {
+		return h.maxPollTimeout
+	}
+	return wait
+}
+
+// acquireWaitSlot reserves one of the global waiting-poll slots. It always
+// succeeds when no limit is configured.
+func (h *Handlers) acquireWaitSlot() bool {
+	if h.waiting.Add(1) > h.maxWaiting && h.maxWaiting > 0 {
+		h.waiting.Add(-1)
+		return false
+	}
+	waitingPolls.Inc()
+	return true
+}
+
+func (h *Handlers) releaseWaitSlot() {
+	h.waiting.Add(-1)
+	waitingPolls.Dec()
+}
+
+// retryAfterSeconds spreads retries of rejected polls over [retryAfter, 2*retryAfter)
+// so they don't come back as a single wave
+func (h *Handlers) retryAfterSeconds() int {
+	base := h.retryAfter
+	if base < time.Second {
+		base = time.Second
+	}
+	return int((base + time.Duration(rand.Int63n(int64(base)))).Seconds())
+}
+
+// subscribe registers for notifications on every channel and merges them into
+// one channel. The returned function releases the subscriptions.
+func (h *Handlers) subscribe(channels []string) (<-chan redis.EventNotification, func(), error) {
+	if len(channels) == 1 {
+		return h.subscribeChannel(channels[0])
+	}
+
+	notifyChs := make([]<-chan redis.EventNotification, 0, len(channels))
+	unsubscribes := make([]func(), 0, len(channels))
+	for _, channelID := range channels {
+		notifyCh, unsubscribe, err := h.subscribeChannel(channelID)
+		if err != nil {
+			for _, unsubscribe := range unsubscribes {
+				unsubscribe()
+			}
+			return nil, nil, err
+		}
+		notifyChs = append(notifyChs, notifyCh)
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	merged := make(chan redis.EventNotification, len(channels))
+	for i := range notifyChs {
+		go func(notifyCh <-chan redis.EventNotification, unsubscribe func()) {
+			defer unsubscribe()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case notification := <-notifyCh:
+					select {
+					case merged <- notification:
+					default:
+					}
+				}
+			}
+		}(notifyChs[i], unsubscribes[i])
+	}
+	return merged, cancel, nil
+}
+
+// subscribeChannel subscribes to one channel, applying the per-channel
+// poller cap. Over the cap the poll is either rejected with errChannelFull
+// or coalesced onto the channel's shared wake-up, which hands it the
+// notification, broadcasts included, like a subscription of its own.
+func (h *Handlers) subscribeChannel(channelID string) (<-chan redis.EventNotification, func(), error) {
+	if notifyCh, ok := h.subscriber.TrySubscribe(channelID, h.maxPollers); ok {
+		return notifyCh, func() { h.subscriber.Unsubscribe(channelID, notifyCh) }, nil
+	}
+	if !h.coalesce {
+		return nil, nil, errChannelFull
+	}
+
+	shared := h.subscriber.WaitShared(channelID)
+	ctx, cancel := context.WithCancel(context.Background())
+	notifyCh := make(chan redis.EventNotification, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-shared.Done():
+			notifyCh <- shared.Notification()
+		}
+	}()
+	return notifyCh, cancel, nil
+}
+
+// fetchEvents fetches events for every requested channel. Events from several
+// channels are tagged with their channel ID and merged in ID order.
+// hasMore reports whether a full page was returned, meaning more events may be pending.
+func (h *Handlers) fetchEvents(ctx context.Context, channels []string, req *updatesRequest) ([]core.Event, bool, error) {
+	if len(channels) == 1 {
+		events, err := h.fetchChannel(ctx, channels[0], req)
+		return events, len(events) >= req.Limit, err
+	}
+
+	results := make([][]core.Event, len(channels))
+	errs := make([]error, len(channels))
+
+	var wg sync.WaitGroup
+	for i, channelID := range channels {
+		wg.Add(1)
+		go func(i int, channelID string) {
+			defer wg.Done()
+			results[i], errs[i] = h.fetchChannel(ctx, channelID, req)
+		}(i, channelID)
+	}
+	wg.Wait()
+
+	events := make([]core.Event, 0)
+	hasMore := false
+	for i, channelID := range channels {
+		if errs[i] != nil {
+			return nil, false, fmt.Errorf("channel %s: %w", channelID, errs[i])
+		}
+		if len(results[i]) >= req.Limit {
+			hasMore = true
+		}
+		for _, event := range results[i] {
+			event.ChannelID = channelID
+			events = append(events, event)
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	if len(events) > req.Limit {
+		events = events[:req.Limit]
+		hasMore = true
+	}
+
+	return events, hasMore, nil
+}
+
+// fetchChannel reads a channel from its offset, or from req.Since when the
+// client tracks time instead of an offset for it
+func (h *Handlers) fetchChannel(ctx context.Context, channelID string, req *updatesRequest) ([]core.Event, error) {
+	offset := req.offsetFor(channelID)
+	if req.Since <= 0 || offset > 0 {
+		return h.getEvents(ctx, channelID, offset, req.Limit)
+	}
+
+	source, ok := h.source.(core.SinceSource)
+	if !ok {
+		return nil, core.ErrSinceUnsupported
+	}
+	start := time.Now()
+	events, err := source.GetEventsSince(ctx, channelID, time.UnixMilli(req.Since), req.Limit)
+	timingFrom(ctx).addUpstream(time.Since(start))
+	h.stats.Fetched(channelID, err != nil)
+	return events, err
+}
+
+// getEvents reads a channel's events from the push buffer when it covers
+// the offset, and from the event source otherwise
+func (h *Handlers) getEvents(ctx context.Context, channelID string, offset int64, limit int) ([]core.Event, error) {
+	if events, ok := h.pushBuffer.Events(channelID, offset, limit); ok {
+		return events, nil
+	}
+
+	start := time.Now()
+	events, err := h.source.GetEvents(ctx, channelID, offset, limit)
+	timingFrom(ctx).addUpstream(time.Since(start))
+	h.stats.Fetched(channelID, err != nil)
+	return events, err
+}
+
+// presenceQuery holds the query parameters of GET /presence
+type presenceQuery struct {
+	ChannelID string `form:"channel_id" binding:"required"`
+	Secret    string `form:"secret"`
+}
+
+// GetPresence handles the /presence endpoint
+// GET /presence?channel_id=...&secret=...
+func (h *Handlers) GetPresence(c *gin.Context) {
+	var query presenceQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "channel_id is required") {
+		return
+	}
+	channelID := query.ChannelID
+	secret := query.Secret
+
+	if !h.secrets.Allows(secret, channelID) {
+		h.logger.Warn("invalid access secret", "channel_id", channelID)
+		respondError(c, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
 		return
 	}
+
+	members := h.presence.Members(channelID)
+	if h.cluster != nil {
+		clusterMembers, err := h.cluster.Members(c.Request.Context(), channelID)
+		if err != nil {
+			h.logger.Warn("falling back to local presence", "error", err, "channel_id", channelID)
+		} else {
+			members = clusterMembers
+		}
+	}
+
+	connections := 0
+	for _, m := range members {
+		connections += m.Connections
+	}
+
+	respond(c, http.StatusOK, gin.H{
+		"channel_id":  channelID,
+		"occupied":    len(members) > 0,
+		"connections": connections,
+		"members":     members,
+	})
+}
+
+// Ways of serving waiting polls while the notification subscription is down
+const (
+	// DegradedModePoll refetches upstream every DEGRADED_POLL_INTERVAL
+	DegradedModePoll = "poll"
+	// DegradedModeWait leaves polls waiting for their timeout
+	DegradedModeWait = "wait"
+	// DegradedModeShort answers at once, telling clients to poll again after
+	// DEGRADED_POLL_INTERVAL
+	DegradedModeShort = "short"
+)
+
+// Readiness states reported by /ready
+const (
+	stateServing     = "serving"
+	stateDegraded    = "degraded"
+	stateDraining    = "draining"
+	stateUnavailable = "unavailable"
+)
+
+// Ready handles the /ready endpoint
+// A draining instance fails so load balancers remove it. While the Redis
+// notification subscription is down, the instance is degraded if polls still
+// learn about events, by refetching upstream or by clients polling again,
+// and fails otherwise, since waiting pollers would only be released by their
+// timeout.
+func (h *Handlers) Ready(c *gin.Context) {
+	healthy := h.subscriber.Healthy()
+
+	state := stateServing
+	switch {
+	case h.draining.Load() > 0:
+		state = stateDraining
+	case !healthy && h.degradedFallback():
+		state = stateDegraded
+	case !healthy:
+		state = stateUnavailable
+	}
+
+	response := gin.H{
+		"status":             "ok",
+		"state":              state,
+		"redis_subscription": healthy,
+	}
+	if last := h.subscriber.LastMessageAt(); !last.IsZero() {
+		response["last_message_at"] = last.Unix()
+	}
+	c.Header("X-Longpoll-State", state)
+
+	if state == stateDraining || state == stateUnavailable {
+		response["status"] = "unavailable"
+		respond(c, http.StatusServiceUnavailable, response)
+		return
+	}
+	respond(c, http.StatusOK, response)
+}
+
+// degradedFallback reports whether polls still see new events while the
+// notification subscription is down
+func (h *Handlers) degradedFallback() bool {
+	switch h.degradedMode {
+	case DegradedModePoll:
+		return h.fallbackPoll > 0
+	case DegradedModeShort:
+		return true
+	}
+	return false
 }
 
 // Health check endpoint
 func (h *Handlers) Health(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{
-		"status": "ok",
+	if deep := c.Query("deep"); h.probe == nil || (deep != "1" && deep != "true") {
+		respond(c, http.StatusOK, gin.H{
+			"status":  "ok",
+			"version": buildinfo.Get().Version,
+		})
+		return
+	}
+
+	// A deep check also probes the event source, so deployments can verify
+	// the wiring to Laravel end to end
+	result, cached := h.probe.Check(c.Request.Context())
+	upstream := gin.H{
+		"status":     "ok",
+		"latency_ms": result.Latency.Milliseconds(),
+		"checked_at": result.CheckedAt.Unix(),
+		"cached":     cached,
+	}
+	if !result.OK {
+		upstream["status"] = "error"
+		upstream["reason"] = result.Reason
+		if result.StatusCode != 0 {
+			upstream["status_code"] = result.StatusCode
+		}
+		respond(c, http.StatusServiceUnavailable, gin.H{
+			"status":   "unavailable",
+			"version":  buildinfo.Get().Version,
+			"upstream": upstream,
+		})
+		return
+	}
+	respond(c, http.StatusOK, gin.H{
+		"status":   "ok",
+		"version":  buildinfo.Get().Version,
+		"upstream": upstream,
 	})
 }
+
+// Version handles GET /version, reporting which build is running
+func (h *Handlers) Version(c *gin.Context) {
+	respond(c, http.StatusOK, buildinfo.Get())
+}
+
+// JWKS handles /.well-known/jwks.json, publishing the public keys tokens are
+// validated with. It is always JSON, as verifiers expect, and 404 while
+// tokens are signed with a shared secret.
+func (h *Handlers) JWKS(c *gin.Context) {
+	keys := h.jwtService.JWKS()
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, errorBody(c, codeNotFound, "no public keys configured"))
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
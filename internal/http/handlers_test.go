@@ -0,0 +1,297 @@
+package http_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/levskiy0/go-laravel-long-polling/pkg/longpolltest"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// get performs a GET /getUpdates with the given query and headers
+func get(t *testing.T, srv *longpolltest.Server, query url.Values, header http.Header) (*http.Response, *longpolltest.Response) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/getUpdates?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return do(t, req)
+}
+
+func do(t *testing.T, req *http.Request) (*http.Response, *longpolltest.Response) {
+	t.Helper()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	decoded := &longpolltest.Response{Status: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(decoded); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return resp, decoded
+}
+
+func newRedis(t *testing.T) *goredis.Client {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestOffsetIsInclusive(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{})
+	token := srv.Token(t, "orders.1")
+	for i := 0; i < 3; i++ {
+		srv.Upstream.Push("orders.1", map[string]interface{}{"n": i})
+	}
+
+	resp := srv.Poll(t, token, 2)
+	longpolltest.RequireEventIDs(t, resp, 2, 3)
+	if resp.NextOffset != 4 {
+		t.Fatalf("expected next_offset 4, got %d", resp.NextOffset)
+	}
+
+	pending := srv.PollAsync(token, resp.NextOffset)
+	event := srv.Upstream.Push("orders.1", map[string]interface{}{"n": 3})
+	srv.Broker.Notify("orders.1", event.ID)
+	longpolltest.RequireEventIDs(t, longpolltest.RequireDelivery(t, pending, time.Second), 4)
+}
+
+func TestPollWaitsForNewEvents(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{})
+	token := srv.Token(t, "orders.1")
+	srv.Upstream.Push("orders.1", nil)
+
+	pending := srv.PollAsync(token, 2)
+	longpolltest.RequireNoDelivery(t, pending, 100*time.Millisecond)
+
+	event := srv.Upstream.Push("orders.1", nil)
+	srv.Broker.Notify("orders.1", event.ID)
+	longpolltest.RequireEventIDs(t, longpolltest.RequireDelivery(t, pending, time.Second), 2)
+}
+
+func TestLastEventIDResumesAfterIt(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{})
+	token := srv.Token(t, "orders.1")
+	for i := 0; i < 3; i++ {
+		srv.Upstream.Push("orders.1", nil)
+	}
+
+	_, resp := get(t, srv, url.Values{"token": {token}}, http.Header{"Last-Event-ID": {"2"}})
+	longpolltest.RequireEventIDs(t, resp, 3)
+	if resp.NextOffset != 4 {
+		t.Fatalf("expected next_offset 4, got %d", resp.NextOffset)
+	}
+}
+
+func TestOffsetTakesPrecedenceOverLastEventID(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{})
+	token := srv.Token(t, "orders.1")
+	for i := 0; i < 3; i++ {
+		srv.Upstream.Push("orders.1", nil)
+	}
+
+	_, resp := get(t, srv, url.Values{"token": {token}, "offset": {"1"}}, http.Header{"Last-Event-ID": {"2"}})
+	longpolltest.RequireEventIDs(t, resp, 1, 2, 3)
+}
+
+func TestInvalidLastEventIDIsRejected(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{})
+	token := srv.Token(t, "orders.1")
+
+	for _, lastEventID := range []string{"abc", "-1", "1.5"} {
+		_, resp := get(t, srv, url.Values{"token": {token}}, http.Header{"Last-Event-ID": {lastEventID}})
+		if resp.Status != http.StatusBadRequest || resp.Error == nil || resp.Error.Code != "invalid_request" {
+			t.Fatalf("Last-Event-ID %q: expected 400 invalid_request, got %d (%s)", lastEventID, resp.Status, resp.Error)
+		}
+	}
+}
+
+func TestPushBufferServesContiguousRuns(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{PushBufferSize: 16})
+	token := srv.Token(t, "orders.1")
+
+	// Laravel holds every event, the buffer misses event 4
+	for i := 0; i < 5; i++ {
+		srv.Upstream.Push("orders.1", nil)
+	}
+	srv.Broker.Push("orders.1",
+		longpolltest.Event{ID: 1},
+		longpolltest.Event{ID: 2},
+		longpolltest.Event{ID: 3},
+		longpolltest.Event{ID: 5},
+	)
+
+	resp := srv.Poll(t, token, 2)
+	longpolltest.RequireEventIDs(t, resp, 2, 3)
+	if requests := srv.Upstream.Requests(); requests != 0 {
+		t.Fatalf("expected the buffer to answer, got %d upstream requests", requests)
+	}
+
+	// Event 4 isn't buffered, so the poll falls back to Laravel
+	resp = srv.Poll(t, token, 4)
+	longpolltest.RequireEventIDs(t, resp, 4, 5)
+	if requests := srv.Upstream.Requests(); requests != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", requests)
+	}
+}
+
+func TestPushBufferDoesNotServeOlderOffsets(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{PushBufferSize: 16})
+	token := srv.Token(t, "orders.1")
+
+	for i := 0; i < 3; i++ {
+		srv.Upstream.Push("orders.1", nil)
+	}
+	srv.Broker.Push("orders.1", longpolltest.Event{ID: 2}, longpolltest.Event{ID: 3})
+
+	// Event 1 predates the buffer, so the buffered run would skip it
+	resp := srv.Poll(t, token, 1)
+	longpolltest.RequireEventIDs(t, resp, 1, 2, 3)
+	if requests := srv.Upstream.Requests(); requests != 1 {
+		t.Fatalf("expected 1 upstream request, got %d", requests)
+	}
+}
+
+func TestPushedEventsWakePollers(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{PushBufferSize: 16})
+	token := srv.Token(t, "orders.1")
+
+	pending := srv.PollAsync(token, 1)
+	srv.Broker.Push("orders.1", longpolltest.Event{ID: 1, Event: map[string]interface{}{"type": "created"}})
+
+	resp := longpolltest.RequireDelivery(t, pending, time.Second)
+	longpolltest.RequireEventIDs(t, resp, 1)
+	if resp.Events[0].Event["type"] != "created" {
+		t.Fatalf("expected the pushed payload, got %v", resp.Events[0].Event)
+	}
+}
+
+// post performs a POST /getUpdates with the given JSON body
+func post(t *testing.T, srv *longpolltest.Server, body string) *longpolltest.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/getUpdates", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, resp := do(t, req)
+	return resp
+}
+
+func TestExplicitZeroOffsetIgnoresStoredOffsets(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{ConsumerOffsets: true, Redis: newRedis(t)})
+	token := srv.Token(t, "orders.1")
+	for i := 0; i < 2; i++ {
+		srv.Upstream.Push("orders.1", nil)
+	}
+
+	// The first poll stores next_offset 3 for the consumer
+	resp := post(t, srv, `{"token":"`+token+`","client_id":"tab-1"}`)
+	longpolltest.RequireEventIDs(t, resp, 1, 2)
+
+	resp = post(t, srv, `{"token":"`+token+`","client_id":"tab-1","offset":0}`)
+	longpolltest.RequireEventIDs(t, resp, 1, 2)
+
+	_, resp = get(t, srv, url.Values{"token": {token}, "client_id": {"tab-1"}, "offset": {"0"}}, nil)
+	longpolltest.RequireEventIDs(t, resp, 1, 2)
+}
+
+// ingest posts events to /internal/events with an Idempotency-Key
+func ingest(t *testing.T, srv *longpolltest.Server, key, body string) (*http.Response, *longpolltest.Response) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/internal/events", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+srv.AccessSecret)
+	req.Header.Set("Idempotency-Key", key)
+	return do(t, req)
+}
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{PushBufferSize: 16, Redis: newRedis(t)})
+
+	first, firstBody := ingest(t, srv, "push-1", `{"channel_id":"orders.1","events":[{"id":1,"event":{}}]}`)
+	if first.StatusCode != http.StatusAccepted || first.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected a fresh 202, got %d (%s)", first.StatusCode, firstBody.Error)
+	}
+
+	// The retry carries other events but is answered with the stored response
+	second, _ := ingest(t, srv, "push-1", `{"channel_id":"orders.1","events":[{"id":2,"event":{}}]}`)
+	if second.StatusCode != http.StatusAccepted || second.Header.Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected a replayed 202, got %d replayed=%q", second.StatusCode, second.Header.Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyKeysAreIndependent(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{PushBufferSize: 16, Redis: newRedis(t)})
+
+	ingest(t, srv, "push-1", `{"channel_id":"orders.1","events":[{"id":1,"event":{}}]}`)
+	resp, _ := ingest(t, srv, "push-2", `{"channel_id":"orders.1","events":[{"id":2,"event":{}}]}`)
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Idempotent-Replayed") != "" {
+		t.Fatalf("expected a fresh 202, got %d replayed=%q", resp.StatusCode, resp.Header.Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotencyFailsClosedWithoutRedis(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{PushBufferSize: 16})
+
+	resp, body := ingest(t, srv, "push-1", `{"channel_id":"orders.1","events":[{"id":1,"event":{}}]}`)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d (%s)", resp.StatusCode, body.Error)
+	}
+}
+
+func TestCORSWildcardNeverAllowsCredentials(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{CORSAllowCredentials: true})
+	token := srv.Token(t, "orders.1")
+	srv.Upstream.Push("orders.1", nil)
+
+	resp, _ := get(t, srv, url.Values{"token": {token}, "offset": {"1"}}, http.Header{"Origin": {"https://app.example"}})
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("expected Access-Control-Allow-Origin *, got %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Fatalf("expected no Access-Control-Allow-Credentials, got %q", got)
+	}
+}
+
+func TestCORSEchoesAllowedOrigin(t *testing.T) {
+	srv := longpolltest.NewServer(t, longpolltest.Options{
+		CORSAllowedOrigins:   "https://app.example",
+		CORSAllowCredentials: true,
+	})
+	token := srv.Token(t, "orders.1")
+	srv.Upstream.Push("orders.1", nil)
+
+	resp, _ := get(t, srv, url.Values{"token": {token}, "offset": {"1"}}, http.Header{"Origin": {"https://app.example"}})
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example" {
+		t.Fatalf("expected the origin to be echoed, got %q", got)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Fatalf("expected Access-Control-Allow-Credentials true, got %q", got)
+	}
+
+	resp, _ = get(t, srv, url.Values{"token": {token}, "offset": {"1"}}, http.Header{"Origin": {"https://evil.example"}})
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected no Access-Control-Allow-Origin for another origin, got %q", got)
+	}
+}
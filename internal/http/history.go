@@ -0,0 +1,121 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+// historyQuery holds the query parameters of GET /getHistory
+type historyQuery struct {
+	Token     string `form:"token"`
+	ChannelID string `form:"channel_id" binding:"required"`
+	FromID    int64  `form:"from_id" binding:"required,min=1"`
+	ToID      int64  `form:"to_id" binding:"required,gtefield=FromID"`
+}
+
+// GetHistory handles the /getHistory endpoint
+// GET /getHistory?token=...&channel_id=...&from_id=...&to_id=...
+//
+// It serves a closed range of a channel's past events so a client that was
+// offline for long can backfill beyond the polling window. The range is
+// capped at historyRange IDs and a response at historyEvents events;
+// next_from_id is returned when the range was not exhausted.
+func (h *Handlers) GetHistory(c *gin.Context) {
+	var query historyQuery
+	if !bindRequest(c, c.ShouldBindQuery, &query, "token, channel_id, from_id and to_id are required") {
+		return
+	}
+	token := query.Token
+	if token == "" {
+		token = h.storedToken(c)
+	}
+	if token == "" {
+		respondError(c, http.StatusBadRequest, codeTokenRequired, "token is required")
+		return
+	}
+	channelID, fromID, toID := query.ChannelID, query.FromID, query.ToID
+
+	if toID-fromID >= int64(h.historyRange) {
+		respondError(c, http.StatusBadRequest, codeRangeTooLarge, "Range too large", gin.H{
+			"max_range": h.historyRange,
+		})
+		return
+	}
+
+	claims, ok := h.authenticate(c, token)
+	if !ok {
+		return
+	}
+	if h.revocations.IsRevoked(claims.ID, channelID, claims.IssuedTime()) {
+		respondError(c, http.StatusUnauthorized, codeInvalidToken, "Invalid or expired token")
+		return
+	}
+	if !claims.Allows(channelID) {
+		respondError(c, http.StatusForbidden, codeChannelNotAuthorized, "Channel not authorized by token")
+		return
+	}
+	if h.revocations.IsBanned(channelID) {
+		respondError(c, http.StatusForbidden, codeChannelBanned, "Channel is banned")
+		return
+	}
+	if _, ok := h.authorizePrivate(c, []string{channelID}); !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	events := make([]core.Event, 0)
+	offset := fromID
+	for offset <= toID && len(events) < h.historyEvents {
+		limit := h.maxLimit
+		if remaining := h.historyEvents - len(events); remaining < limit {
+			limit = remaining
+		}
+
+		page, err := h.source.GetEvents(ctx, channelID, offset, limit)
+		h.stats.Fetched(channelID, err != nil)
+		if err != nil {
+			h.respondFetchError(c, err)
+			return
+		}
+
+		next := offset
+		for _, event := range page {
+			if event.ID >= offset && event.ID <= toID && len(events) < h.historyEvents {
+				events = append(events, event)
+			}
+			if event.ID+1 > next {
+				next = event.ID + 1
+			}
+		}
+		// A short or stalled page means the source has nothing further
+		if len(page) < limit || next == offset {
+			offset = toID + 1
+			break
+		}
+		offset = next
+	}
+	if len(events) > 0 {
+		offset = events[len(events)-1].ID + 1
+	}
+
+	if h.sealer != nil {
+		sealed, err := h.sealEvents(events, channelID)
+		if err != nil {
+			h.logger.Error("failed to encrypt events", "error", err, "channel_id", channelID)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to encrypt events")
+			return
+		}
+		events = sealed
+	}
+
+	response := gin.H{
+		"channel_id": channelID,
+		"events":     events,
+	}
+	if len(events) >= h.historyEvents && offset <= toID {
+		response["next_from_id"] = offset
+	}
+	respond(c, http.StatusOK, response)
+}
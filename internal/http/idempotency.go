@@ -0,0 +1,97 @@
+package http
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+)
+
+// IdempotencyKeyHeader is the request header carrying the idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// capturingWriter records the response body while writing it through
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyScopeKey holds the caller identity keys are scoped to
+const idempotencyScopeKey = "idempotency_scope"
+
+// setIdempotencyScope scopes the request's Idempotency-Key to a caller, so
+// the same key sent by another caller is never answered with this response
+func setIdempotencyScope(c *gin.Context, scope string) {
+	c.Set(idempotencyScopeKey, scope)
+}
+
+// IdempotencyMiddleware replays the stored response for requests carrying an
+// Idempotency-Key that was already processed. Requests without the header
+// pass through untouched. Server errors are not stored so they can be retried.
+func IdempotencyMiddleware(store *redis.IdempotencyStore, logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		scopedKey := c.Request.Method + ":" + c.FullPath() + ":" + key
+		if scope := c.GetString(idempotencyScopeKey); scope != "" {
+			scopedKey += ":" + scope
+		}
+
+		stored, acquired, err := store.Reserve(ctx, scopedKey)
+		if err != nil {
+			logger.Error("failed to reserve idempotency key", "error", err)
+			abortError(c, http.StatusServiceUnavailable, codeInternalError, "Idempotency store unavailable")
+			return
+		}
+
+		if stored != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		if !acquired {
+			abortError(c, http.StatusConflict, codeIdempotencyConflict, "A request with this Idempotency-Key is in progress")
+			return
+		}
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := store.Release(ctx, scopedKey); err != nil {
+				logger.Error("failed to release idempotency key", "error", err)
+			}
+			return
+		}
+
+		err = store.Complete(ctx, scopedKey, redis.StoredResponse{
+			Status:      writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		})
+		if err != nil {
+			logger.Error("failed to store idempotent response", "error", err)
+		}
+	}
+}
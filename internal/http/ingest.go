@@ -0,0 +1,130 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+)
+
+// ingestRequest is the body of POST /internal/events
+type ingestRequest struct {
+	ChannelID string       `json:"channel_id" binding:"required"`
+	Events    []core.Event `json:"events" binding:"required,min=1"`
+}
+
+// IngestAuthMiddleware protects the ingestion endpoint with the secret shared
+// with Laravel, or its next secret during a rotation. The endpoint is
+// disabled unless push buffering or standalone storage is enabled.
+func IngestAuthMiddleware(secret, nextSecret string, enabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !enabled {
+			abortError(c, http.StatusForbidden, codeFeatureDisabled, "Push ingestion is disabled")
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		matches := subtle.ConstantTimeCompare([]byte(provided), []byte(secret))
+		if nextSecret != "" {
+			matches |= subtle.ConstantTimeCompare([]byte(provided), []byte(nextSecret))
+		}
+		if matches != 1 {
+			abortError(c, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IngestEvents handles POST /internal/events
+// Laravel pushes new events here instead of only notifying through Redis.
+// The events are fanned out to every instance, which buffers them and wakes
+// its pollers without fetching anything back from Laravel. In standalone mode
+// the events are stored in Redis first and may omit their IDs.
+func (h *Handlers) IngestEvents(c *gin.Context) {
+	var req ingestRequest
+	if !bindRequest(c, c.ShouldBindJSON, &req, "channel_id and events are required") {
+		return
+	}
+
+	for _, event := range req.Events {
+		if event.ID < 0 || (event.ID == 0 && h.store == nil) {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "every event needs a positive id")
+			return
+		}
+	}
+	if !h.acceptsPayloads(c, req.Events) {
+		return
+	}
+
+	h.ingest(c, req.ChannelID, req.Events)
+}
+
+// acceptsPayloads refuses plaintext payloads when end-to-end encryption is
+// required, so they are never stored or fanned out
+func (h *Handlers) acceptsPayloads(c *gin.Context, events []core.Event) bool {
+	for _, event := range events {
+		if !h.sealer.Accepts(event.Event) {
+			respondError(c, http.StatusBadRequest, codeInvalidRequest, "event payloads must be encrypted envelopes")
+			return false
+		}
+	}
+	return true
+}
+
+// ingest stores events when standalone, fans them out to every instance and
+// responds with their IDs
+func (h *Handlers) ingest(c *gin.Context, channelID string, events []core.Event) {
+	now := time.Now().Unix()
+	for i := range events {
+		if events[i].CreatedAt == 0 {
+			events[i].CreatedAt = now
+		}
+		events[i].ChannelID = ""
+	}
+
+	ctx := c.Request.Context()
+	if h.store != nil {
+		stored, err := h.store.Append(ctx, channelID, events)
+		if err != nil {
+			h.logger.Error("failed to store pushed events", "error", err, "channel_id", channelID)
+			respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to store events")
+			return
+		}
+		events = stored
+	}
+
+	var maxID int64
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+		if event.ID > maxID {
+			maxID = event.ID
+		}
+	}
+
+	notification := redis.EventNotification{
+		ChannelID: channelID,
+		EventID:   maxID,
+		Timestamp: now,
+		Events:    events,
+	}
+	if err := h.subscriber.Publish(ctx, notification); err != nil {
+		h.logger.Error("failed to publish pushed events", "error", err, "channel_id", channelID)
+		respondError(c, http.StatusInternalServerError, codeInternalError, "Failed to publish events")
+		return
+	}
+
+	h.logger.Debug("events pushed", "channel_id", channelID, "count", len(events), "event_id", maxID)
+	respond(c, http.StatusAccepted, gin.H{
+		"channel_id": channelID,
+		"accepted":   len(events),
+		"event_id":   maxID,
+		"event_ids":  ids,
+	})
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var ipFilterRejections = metrics.NewCounterVec(
+	"longpoll_ip_filter_rejections_total",
+	"Requests refused by the IP allow and deny lists, by list.",
+	"list",
+)
+
+// IPFilterMiddleware refuses requests whose client IP, as derived through the
+// trusted proxies, is outside allowed (when it is not empty) or inside denied.
+// Entries are IPs or CIDRs.
+func IPFilterMiddleware(allowed, denied []string, logger *slog.Logger) gin.HandlerFunc {
+	allow := parseNetworks(allowed)
+	deny := parseNetworks(denied)
+
+	return func(c *gin.Context) {
+		if len(allow) == 0 && len(deny) == 0 {
+			c.Next()
+			return
+		}
+
+		ip := net.ParseIP(c.ClientIP())
+		list := ""
+		switch {
+		case len(allow) > 0 && !containsIP(allow, ip):
+			list = "allow"
+		case containsIP(deny, ip):
+			list = "deny"
+		}
+		if list != "" {
+			ipFilterRejections.WithLabelValues(list).Inc()
+			logger.Warn("security event",
+				"event", "ip_refused",
+				"list", list,
+				"client_ip", c.ClientIP(),
+				"path", c.Request.URL.Path,
+			)
+			abortError(c, http.StatusForbidden, codeForbidden, "Forbidden")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// parseNetworks parses IP and CIDR entries, skipping invalid ones, which
+// the configuration rejects up front
+func parseNetworks(entries []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return networks
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
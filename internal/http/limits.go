@@ -0,0 +1,55 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// channelParams are the query parameters carrying channel IDs
+var channelParams = []string{"channel", "channel_id", "publish_channel_id"}
+
+// RequestLimitsMiddleware refuses oversized requests before they reach the
+// handlers: bodies over maxBody bytes get 413, query strings over maxQuery
+// bytes get 414 and channel IDs over maxChannelID bytes in the query get 400.
+// Bodies without a declared length are cut off at maxBody while being read.
+// A limit of 0 disables it.
+func RequestLimitsMiddleware(maxBody, maxQuery, maxChannelID int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxQuery > 0 && len(c.Request.URL.RawQuery) > maxQuery {
+			abortError(c, http.StatusRequestURITooLong, codeQueryTooLong, "Query string too long", gin.H{
+				"max_bytes": maxQuery,
+			})
+			return
+		}
+
+		if maxBody > 0 {
+			if c.Request.ContentLength > int64(maxBody) {
+				abortError(c, http.StatusRequestEntityTooLarge, codeBodyTooLarge, "Request body too large", gin.H{
+					"max_bytes": maxBody,
+				})
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(maxBody))
+		}
+
+		if maxChannelID > 0 {
+			query := c.Request.URL.Query()
+			for _, param := range channelParams {
+				for _, value := range query[param] {
+					for _, channelID := range strings.Split(value, ",") {
+						if len(channelID) > maxChannelID {
+							abortError(c, http.StatusBadRequest, codeChannelIDTooLong, "Channel ID too long", gin.H{
+								"max_bytes": maxChannelID,
+							})
+							return
+						}
+					}
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
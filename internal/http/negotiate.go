@@ -0,0 +1,130 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// Response formats, also accepted in the format parameter
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// Media types that select an NDJSON response
+const (
+	mimeNDJSON  = "application/x-ndjson"
+	mimeNDJSON2 = "application/ndjson"
+)
+
+// responseFormatKey is the gin context key holding the negotiated format
+const responseFormatKey = "longpoll.response_format"
+
+// NegotiationMiddleware picks the response format from the Accept header,
+// falling back to defaultFormat when the client accepts anything
+func NegotiationMiddleware(defaultFormat string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(responseFormatKey, negotiateAccept(c.GetHeader("Accept"), defaultFormat))
+		c.Next()
+	}
+}
+
+// negotiateAccept returns the supported format with the highest quality in
+// an Accept header, preferring earlier entries on ties
+func negotiateAccept(accept, fallback string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		format := formatForMediaType(strings.ToLower(strings.TrimSpace(params[0])))
+		if format == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		return fallback
+	}
+	return best
+}
+
+func formatForMediaType(mediaType string) string {
+	switch mediaType {
+	case "application/json":
+		return formatJSON
+	case mimeNDJSON, mimeNDJSON2:
+		return formatNDJSON
+	case mimeMsgPack, mimeXMsgPack:
+		return formatMsgPack
+	}
+	return ""
+}
+
+// overrideFormat applies an explicit format parameter, which takes
+// precedence over the Accept header
+func overrideFormat(c *gin.Context, format string) {
+	for _, want := range []string{formatMsgPack, formatNDJSON, formatJSON} {
+		if hasFormat(format, want) {
+			c.Set(responseFormatKey, want)
+			return
+		}
+	}
+}
+
+// responseFormat returns the negotiated format, JSON by default
+func responseFormat(c *gin.Context) string {
+	if format := c.GetString(responseFormatKey); format != "" {
+		return format
+	}
+	return formatJSON
+}
+
+// respond writes body in the negotiated format
+func respond(c *gin.Context, status int, body interface{}) {
+	switch responseFormat(c) {
+	case formatMsgPack:
+		c.Render(status, render.MsgPack{Data: body})
+	case formatNDJSON:
+		c.Render(status, ndjsonRender{lines: []interface{}{body}})
+	default:
+		c.JSON(status, body)
+	}
+}
+
+// ndjsonRender writes each value as one JSON document per line
+type ndjsonRender struct {
+	lines []interface{}
+}
+
+func (r ndjsonRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	encoder := json.NewEncoder(w)
+	for _, line := range r.lines {
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r ndjsonRender) WriteContentType(w http.ResponseWriter) {
+	header := w.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", mimeNDJSON)
+	}
+}
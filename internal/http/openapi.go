@@ -0,0 +1,374 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+// apiOperation documents a route in the OpenAPI specification. Parameters
+// and bodies are described by the structs the handler binds, so the
+// specification follows them.
+type apiOperation struct {
+	summary  string
+	tag      string
+	query    interface{}
+	body     interface{}
+	response interface{}
+	// security names the scheme authenticating the route, if any
+	security string
+}
+
+// accessTokenResponse documents the body of a token issued by
+// /getAccessToken
+type accessTokenResponse struct {
+	Token     string `json:"token"`
+	Cookie    bool   `json:"cookie,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+}
+
+// updatesResponse documents the body of a poll
+type updatesResponse struct {
+	Events      []core.Event     `json:"events"`
+	NextOffset  int64            `json:"next_offset"`
+	NextOffsets map[string]int64 `json:"next_offsets,omitempty"`
+	NextCursor  string           `json:"next_cursor,omitempty"`
+}
+
+// apiOperations documents the routes by method and path, relative to the
+// base path. Registered routes missing here are still listed.
+var apiOperations = map[string]apiOperation{
+	"GET /health":                     {summary: "Liveness probe", tag: "health"},
+	"GET /ready":                      {summary: "Readiness probe", tag: "health"},
+	"GET /version":                    {summary: "Version, commit and build date of the running build", tag: "health"},
+	"GET /metrics":                    {summary: "Prometheus metrics", tag: "health"},
+	"GET /openapi.json":               {summary: "This specification", tag: "health"},
+	"GET /.well-known/jwks.json":      {summary: "Public keys validating tokens", tag: "tokens"},
+	"POST /getAccessToken":            {summary: "Issue an access token for channels", tag: "tokens", query: accessTokenQuery{}, response: accessTokenResponse{}},
+	"POST /broadcasting/auth":         {summary: "Authorize a private channel for Laravel Echo", tag: "tokens", body: broadcastingAuthRequest{}},
+	"GET /getUpdates":                 {summary: "Long poll channels for events", tag: "polling", query: updatesQuery{}, response: updatesResponse{}},
+	"POST /getUpdates":                {summary: "Long poll channels for events", tag: "polling", body: updatesRequest{}, response: updatesResponse{}},
+	"GET /getHistory":                 {summary: "Read a range of a channel's past events", tag: "polling", query: historyQuery{}},
+	"GET /presence":                   {summary: "List a channel's present members", tag: "channels", query: presenceQuery{}},
+	"POST /ack":                       {summary: "Acknowledge events up to an ID", tag: "channels", body: ackRequest{}},
+	"POST /publish":                   {summary: "Publish events to a channel", tag: "channels", body: publishRequest{}},
+	"POST /internal/events":           {summary: "Push events from Laravel", tag: "internal", body: ingestRequest{}, security: "sharedSecret"},
+	"GET /internal/acks":              {summary: "Read a channel's acknowledgement watermarks", tag: "internal", query: acksQuery{}, security: "sharedSecret"},
+	"POST /admin/channels/:id/ban":    {summary: "Ban a channel", tag: "admin", security: "adminSecret"},
+	"DELETE /admin/channels/:id/ban":  {summary: "Lift a channel ban", tag: "admin", security: "adminSecret"},
+	"POST /admin/channels/:id/revoke": {summary: "Revoke the tokens issued for a channel", tag: "admin", security: "adminSecret"},
+	"POST /admin/channels/:id/replay": {summary: "Deliver a stored event again", tag: "admin", query: replayQuery{}, security: "adminSecret"},
+	"GET /admin/channels/:id/stats":   {summary: "Read a channel's statistics", tag: "admin", security: "adminSecret"},
+	"POST /admin/tokens/revoke":       {summary: "Revoke a token", tag: "admin", query: revokeTokenQuery{}, security: "adminSecret"},
+	"POST /admin/broadcast":           {summary: "Broadcast an event to every waiting poll", tag: "admin", body: broadcastRequest{}, security: "adminSecret"},
+	"POST /admin/maintenance":         {summary: "Start maintenance mode", tag: "admin", body: maintenanceRequest{}, security: "adminSecret"},
+	"DELETE /admin/maintenance":       {summary: "Stop maintenance mode", tag: "admin", security: "adminSecret"},
+	"GET /admin/drain":                {summary: "List draining instances", tag: "admin", security: "adminSecret"},
+	"POST /admin/drain":               {summary: "Drain this instance", tag: "admin", security: "adminSecret"},
+	"DELETE /admin/drain":             {summary: "Stop draining this instance", tag: "admin", security: "adminSecret"},
+	"GET /admin/dead-letters":         {summary: "List undeliverable notifications", tag: "admin", query: deadLettersQuery{}, security: "adminSecret"},
+	"POST /admin/dead-letters/replay": {summary: "Publish dead letters again", tag: "admin", query: deadLettersQuery{}, security: "adminSecret"},
+	"GET /debug/vars":                 {summary: "Runtime and service statistics in expvar format", tag: "admin", security: "adminSecret"},
+	"GET /admin/loglevel":             {summary: "Show the log level", tag: "admin", security: "adminSecret"},
+	"PUT /admin/loglevel":             {summary: "Change the log level at runtime", tag: "admin", body: logLevelRequest{}, security: "adminSecret"},
+}
+
+// OpenAPIHandler serves the OpenAPI 3.0 specification of the routes router
+// registered under basePath. It is built on the first request, once every
+// route is registered.
+func OpenAPIHandler(router *gin.Engine, basePath string) gin.HandlerFunc {
+	var once sync.Once
+	var spec []byte
+
+	return func(c *gin.Context) {
+		once.Do(func() {
+			spec, _ = json.Marshal(openAPISpec(router.Routes(), basePath))
+		})
+		c.Data(http.StatusOK, "application/json; charset=utf-8", spec)
+	}
+}
+
+// openAPISpec builds the specification of routes under basePath
+func openAPISpec(routes gin.RoutesInfo, basePath string) gin.H {
+	paths := gin.H{}
+	for _, route := range routes {
+		path := route.Path
+		if basePath != "" {
+			if !strings.HasPrefix(path, basePath+"/") {
+				continue
+			}
+			path = strings.TrimPrefix(path, basePath)
+		}
+
+		doc, ok := apiOperations[route.Method+" "+path]
+		if !ok {
+			doc = apiOperation{summary: route.Method + " " + path}
+		}
+
+		template, params := pathTemplate(path)
+		item, _ := paths[template].(gin.H)
+		if item == nil {
+			item = gin.H{}
+			paths[template] = item
+		}
+		item[strings.ToLower(route.Method)] = doc.operation(params)
+	}
+
+	server := basePath
+	if server == "" {
+		server = "/"
+	}
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "Laravel long polling server",
+			"version": "1",
+		},
+		"servers": []gin.H{{"url": server}},
+		"paths":   paths,
+		"components": gin.H{
+			"schemas": gin.H{
+				"Error": errorSchema(),
+			},
+			"securitySchemes": gin.H{
+				"adminSecret":  gin.H{"type": "http", "scheme": "bearer", "description": "ADMIN_SECRET"},
+				"sharedSecret": gin.H{"type": "http", "scheme": "bearer", "description": "ACCESS_TOKEN_SECRET"},
+			},
+		},
+	}
+}
+
+// operation builds the OpenAPI operation, with params the path parameters
+func (o apiOperation) operation(params []interface{}) gin.H {
+	if o.query != nil {
+		params = append(params, queryParameters(reflect.TypeOf(o.query))...)
+	}
+
+	success := gin.H{"description": "OK"}
+	if o.response != nil {
+		success["content"] = gin.H{
+			"application/json": gin.H{"schema": schemaOf(reflect.TypeOf(o.response), "json", "")},
+		}
+	}
+	operation := gin.H{
+		"summary": o.summary,
+		"responses": gin.H{
+			"200": success,
+			"default": gin.H{
+				"description": "Error",
+				"content": gin.H{
+					"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/Error"}},
+				},
+			},
+		},
+	}
+	if o.tag != "" {
+		operation["tags"] = []string{o.tag}
+	}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+	if o.body != nil {
+		operation["requestBody"] = gin.H{
+			"required": true,
+			"content": gin.H{
+				"application/json": gin.H{"schema": schemaOf(reflect.TypeOf(o.body), "json", "")},
+			},
+		}
+	}
+	if o.security != "" {
+		operation["security"] = []gin.H{{o.security: []string{}}}
+	}
+	return operation
+}
+
+// pathTemplate turns gin's :name segments into OpenAPI {name} templates
+func pathTemplate(path string) (string, []interface{}) {
+	var params []interface{}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+			params = append(params, gin.H{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   gin.H{"type": "string"},
+			})
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// queryParameters describes the fields of a query struct by their form tags
+func queryParameters(t reflect.Type) []interface{} {
+	var params []interface{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("form"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+
+		defaultValue, _ := strings.CutPrefix(options, "default=")
+		schema := schemaOf(field.Type, "form", field.Tag.Get("binding"))
+		if defaultValue != "" {
+			schema["default"] = typedValue(field.Type, defaultValue)
+		}
+		params = append(params, gin.H{
+			"name":     name,
+			"in":       "query",
+			"required": required(field),
+			"schema":   schema,
+		})
+	}
+	return params
+}
+
+// schemaOf describes t as a JSON schema. Struct fields are named by their
+// tagKey tag and rules are the binding tag of the value being described.
+func schemaOf(t reflect.Type, tagKey, rules string) gin.H {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Rules after dive apply to the elements of a slice or map
+	rules, itemRules, _ := strings.Cut(","+rules, ",dive")
+	rules = strings.TrimPrefix(rules, ",")
+	itemRules = strings.TrimPrefix(itemRules, ",")
+
+	var schema gin.H
+	switch t.Kind() {
+	case reflect.String:
+		schema = gin.H{"type": "string"}
+	case reflect.Bool:
+		schema = gin.H{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		schema = gin.H{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		schema = gin.H{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		schema = gin.H{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		schema = gin.H{"type": "array", "items": schemaOf(t.Elem(), tagKey, itemRules)}
+	case reflect.Map:
+		schema = gin.H{"type": "object", "additionalProperties": schemaOf(t.Elem(), tagKey, itemRules)}
+	case reflect.Struct:
+		schema = structSchema(t, tagKey)
+	default:
+		schema = gin.H{}
+	}
+	applyRules(schema, t, rules)
+	return schema
+}
+
+// structSchema describes a struct's exported fields
+func structSchema(t reflect.Type, tagKey string) gin.H {
+	properties := gin.H{}
+	var requiredFields []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get(tagKey), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, tagKey, field.Tag.Get("binding"))
+		if required(field) {
+			requiredFields = append(requiredFields, name)
+		}
+	}
+
+	schema := gin.H{"type": "object", "properties": properties}
+	if len(requiredFields) > 0 {
+		sort.Strings(requiredFields)
+		schema["required"] = requiredFields
+	}
+	return schema
+}
+
+// applyRules maps the validator rules the schema can express
+func applyRules(schema gin.H, t reflect.Type, rules string) {
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if name != "min" && name != "max" {
+			continue
+		}
+		value, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			continue
+		}
+
+		switch t.Kind() {
+		case reflect.String:
+			name += "Length"
+		case reflect.Slice, reflect.Array:
+			name += "Items"
+		case reflect.Map:
+			name += "Properties"
+		default:
+			name += "imum"
+		}
+		if name == "minimum" || name == "maximum" {
+			schema[name] = value
+		} else {
+			schema[name] = int(value)
+		}
+	}
+}
+
+// required reports whether a field's binding tag requires it
+func required(field reflect.StructField) bool {
+	rules, _, _ := strings.Cut(","+field.Tag.Get("binding"), ",dive")
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// typedValue converts a default from a form tag to the field's type
+func typedValue(t reflect.Type, value string) interface{} {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// errorSchema describes the body of every error response
+func errorSchema() gin.H {
+	return gin.H{
+		"type":     "object",
+		"required": []string{"error"},
+		"properties": gin.H{
+			"error": gin.H{
+				"type":     "object",
+				"required": []string{"code", "message"},
+				"properties": gin.H{
+					"code":       gin.H{"type": "string"},
+					"message":    gin.H{"type": "string"},
+					"request_id": gin.H{"type": "string"},
+				},
+				"additionalProperties": true,
+			},
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package http
+
+import (
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var (
+	pollsFinished = metrics.NewCounterVec(
+		"longpoll_polls_total",
+		"Polls finished, by outcome.",
+		"outcome",
+	)
+	pollDuration = metrics.NewCounterVec(
+		"longpoll_poll_duration_milliseconds_total",
+		"Time polls were held, by outcome. Divided by longpoll_polls_total it gives the mean latency.",
+		"outcome",
+	)
+	pollWakeups = metrics.NewCounter(
+		"longpoll_poll_wakeups_total",
+		"Waiting polls woken by a notification.",
+	)
+)
+
+// How a poll ended, logged with its poll_id and counted in
+// longpoll_polls_total
+const (
+	// pollEvents delivered events
+	pollEvents = "events"
+	// pollEmpty answered without events, e.g. a short poll
+	pollEmpty = "empty"
+	// pollTimeout waited its whole timeout without events
+	pollTimeout = "timeout"
+	// pollCanceled was abandoned by the client before its timeout
+	pollCanceled = "canceled"
+	// pollReconnect was told to reconnect elsewhere
+	pollReconnect = "reconnect"
+	// pollError ended with an error response
+	pollError = "error"
+)
+
+// setOutcome records how the poll ended. The first outcome sticks, so a
+// timeout isn't reported as an empty response.
+func (r *updatesRequest) setOutcome(outcome string) {
+	if r.outcome == "" {
+		r.outcome = outcome
+	}
+}
+
+// finishPoll logs and counts the end of a poll, and hands its outcome to the
+// access log
+func (h *Handlers) finishPoll(req *updatesRequest, timing *requestTiming, started time.Time) {
+	req.setOutcome(pollError)
+	latency := time.Since(started)
+
+	pollsFinished.WithLabelValues(req.outcome).Inc()
+	pollDuration.WithLabelValues(req.outcome).Add(uint64(latency.Milliseconds()))
+	timing.setOutcome(req.outcome)
+
+	h.logger.Debug("poll finished",
+		"poll_id", req.pollID,
+		"outcome", req.outcome,
+		"count", req.delivered,
+		"latency", latency,
+	)
+}
+
+// logWakeup records a waiting poll woken by a notification
+func (h *Handlers) logWakeup(req *updatesRequest, channelID string, eventID int64, waited time.Duration) {
+	pollWakeups.Inc()
+	h.logger.Debug("poll woken",
+		"poll_id", req.pollID,
+		"channel_id", channelID,
+		"event_id", eventID,
+		"waited", waited,
+	)
+}
@@ -0,0 +1,40 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var anonymousPollsLimited = metrics.NewCounter(
+	"longpoll_anonymous_polls_limited_total",
+	"Polls without a token on public channels refused by the per-IP rate limit.",
+)
+
+// anonymous authorizes a poll without a token, which is only allowed when
+// every requested channel is public and the client IP is within its rate
+// limit. It responds itself when the poll is refused.
+func (h *Handlers) anonymous(c *gin.Context, channels []string) (*auth.Claims, bool) {
+	if !h.public.Covers(channels) {
+		respondError(c, http.StatusBadRequest, codeTokenRequired, "token is required")
+		return nil, false
+	}
+
+	if wait, ok := h.public.Allow(c.ClientIP()); !ok {
+		retryAfter := int(wait.Round(time.Second) / time.Second)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		anonymousPollsLimited.Inc()
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		respondError(c, http.StatusTooManyRequests, codeRateLimited, "Too many requests", gin.H{
+			"retry_after": retryAfter,
+		})
+		return nil, false
+	}
+	return h.public.Claims(channels), true
+}
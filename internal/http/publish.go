@@ -0,0 +1,98 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+// publishRequest is the body of POST /publish
+type publishRequest struct {
+	Token     string       `json:"token"`
+	ChannelID string       `json:"channel_id" binding:"required"`
+	Events    []core.Event `json:"events" binding:"required,min=1"`
+
+	tokenID string
+}
+
+// publishRequestKey holds the authorized request between AuthorizePublish
+// and Publish
+const publishRequestKey = "publish_request"
+
+// AuthorizePublish checks that the token of POST /publish grants
+// can_publish on the channel. It runs before the idempotency middleware, so
+// a stored response is only replayed to the token that caused it.
+func (h *Handlers) AuthorizePublish(c *gin.Context) {
+	req, ok := h.authorizePublish(c)
+	if !ok {
+		c.Abort()
+		return
+	}
+	c.Set(publishRequestKey, req)
+	setIdempotencyScope(c, "token:"+req.tokenID)
+	c.Next()
+}
+
+func (h *Handlers) authorizePublish(c *gin.Context) (*publishRequest, bool) {
+	if h.store == nil {
+		respondError(c, http.StatusForbidden, codeFeatureDisabled, "Publishing is disabled")
+		return nil, false
+	}
+
+	var req publishRequest
+	if !bindRequest(c, c.ShouldBindJSON, &req, "token, channel_id and events are required") {
+		return nil, false
+	}
+	if req.Token == "" {
+		req.Token = h.storedToken(c)
+	}
+	if req.Token == "" {
+		respondError(c, http.StatusBadRequest, codeTokenRequired, "token is required")
+		return nil, false
+	}
+
+	claims, ok := h.authenticate(c, req.Token)
+	if !ok {
+		return nil, false
+	}
+	if h.revocations.IsRevoked(claims.ID, req.ChannelID, claims.IssuedTime()) {
+		respondError(c, http.StatusUnauthorized, codeInvalidToken, "Invalid or expired token")
+		return nil, false
+	}
+	if !claims.CanPublish(req.ChannelID) {
+		h.logger.Warn("publish not authorized by token", "channel_id", req.ChannelID, "token_id", claims.ID)
+		respondError(c, http.StatusForbidden, codePublishNotAuthorized, "Publishing not authorized by token")
+		return nil, false
+	}
+	if h.revocations.IsBanned(req.ChannelID) {
+		respondError(c, http.StatusForbidden, codeChannelBanned, "Channel is banned")
+		return nil, false
+	}
+	if !h.acceptsPayloads(c, req.Events) {
+		return nil, false
+	}
+
+	req.tokenID = claims.ID
+	if req.tokenID == "" {
+		// Tokens from introspection may carry no ID
+		sum := sha256.Sum256([]byte(req.Token))
+		req.tokenID = hex.EncodeToString(sum[:])
+	}
+	return &req, true
+}
+
+// Publish handles POST /publish after AuthorizePublish
+// The events are stored and fanned out like pushed ones, so publishing needs
+// standalone mode, where this service assigns event IDs.
+func (h *Handlers) Publish(c *gin.Context) {
+	req := c.MustGet(publishRequestKey).(*publishRequest)
+
+	// IDs are always assigned by the store, never by clients
+	for i := range req.Events {
+		req.Events[i].ID = 0
+	}
+	h.ingest(c, req.ChannelID, req.Events)
+}
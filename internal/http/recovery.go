@@ -0,0 +1,115 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/alert"
+	"github.com/levskiy0/go-laravel-long-polling/internal/errreport"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+)
+
+var panicsRecovered = metrics.NewCounterVec(
+	"longpoll_http_panics_total",
+	"Panics recovered in HTTP handlers, by route.",
+	"route",
+)
+
+// panicAlertCooldown is the least time between two panic alerts, so a panic
+// on every request doesn't flood the webhook
+const panicAlertCooldown = time.Minute
+
+// RecoveryMiddleware turns handler panics into 500 responses in the
+// negotiated format. Each panic is logged with its stack, counted, reported
+// with the request attached and, at most once per cooldown, posted to the
+// alert webhook as an http_panic alert. Connections broken by the client are
+// only logged.
+func RecoveryMiddleware(reporter *errreport.Reporter, webhook *alert.Webhook, logger *slog.Logger) gin.HandlerFunc {
+	var lastAlert atomic.Int64
+
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// Deliberate aborts are left to net/http
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			route := c.FullPath()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			if brokenConnection(recovered) {
+				logger.Warn("client connection broken", "route", route, "error", fmt.Sprint(recovered))
+				c.Abort()
+				return
+			}
+
+			stack := debug.Stack()
+			panicsRecovered.WithLabelValues(route).Inc()
+			logger.Error("panic recovered",
+				"error", fmt.Sprint(recovered),
+				"route", route,
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"client_ip", c.ClientIP(),
+				"stack", strings.Split(strings.TrimSpace(string(stack)), "\n"),
+			)
+			reporter.CapturePanic(recovered, errreport.Event{
+				Tags:    map[string]string{"route": route},
+				Extra:   map[string]interface{}{"client_ip": c.ClientIP()},
+				Request: c.Request,
+			})
+
+			now := time.Now().UnixNano()
+			last := lastAlert.Load()
+			if webhook != nil && now-last >= int64(panicAlertCooldown) && lastAlert.CompareAndSwap(last, now) {
+				webhook.Notify("http_panic", map[string]interface{}{
+					"route":  route,
+					"method": c.Request.Method,
+					"error":  fmt.Sprint(recovered),
+				})
+			}
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			abortError(c, http.StatusInternalServerError, codeInternalError, "Internal server error")
+		}()
+
+		c.Next()
+	}
+}
+
+// brokenConnection reports whether a panic comes from writing to a client
+// that went away, which is no server fault
+func brokenConnection(recovered any) bool {
+	err, ok := recovered.(error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var syscallErr *os.SyscallError
+	if !errors.As(opErr, &syscallErr) {
+		return false
+	}
+	message := strings.ToLower(syscallErr.Error())
+	return strings.Contains(message, "broken pipe") || strings.Contains(message, "connection reset by peer")
+}
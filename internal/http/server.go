@@ -2,17 +2,33 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/alert"
 	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/errreport"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
 )
 
+// unixScheme prefixes HTTP_ADDR values that name a Unix domain socket
+const unixScheme = "unix://"
+
 type Server struct {
 	httpServer *http.Server
+	listening  chan struct{}
+	socketPath string
+	socketMode os.FileMode
 	logger     *slog.Logger
 }
 
@@ -21,66 +37,209 @@ func NewServer(
 	readTimeout time.Duration,
 	writeTimeout time.Duration,
 	handlers *Handlers,
+	idempotency *redis.IdempotencyStore,
+	reporter *errreport.Reporter,
+	webhook *alert.Webhook,
+	accessLog io.Writer,
 	cfg *config.Config,
 	logger *slog.Logger,
 ) *Server {
-	// Set Gin mode based on log level
-	gin.SetMode(gin.ReleaseMode)
+	gin.SetMode(cfg.GinMode)
+	registerFieldNames()
 
 	router := gin.New()
-	router.Use(gin.Recovery())
-	router.Use(CORSMiddleware(cfg))
 
-	// Add custom logger middleware
-	router.Use(func(c *gin.Context) {
-		start := time.Now()
-		path := c.Request.URL.Path
-		raw := c.Request.URL.RawQuery
-
-		c.Next()
+	// Forwarding headers are honoured only when the direct peer is a trusted
+	// proxy; otherwise c.ClientIP() is the socket's remote address
+	router.ForwardedByClientIP = true
+	router.RemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		logger.Error("invalid trusted proxies, trusting none", "error", err)
+		_ = router.SetTrustedProxies(nil)
+	}
 
-		latency := time.Since(start)
-		statusCode := c.Writer.Status()
+	router.Use(RequestIDMiddleware())
+	router.Use(TraceContextMiddleware())
+	router.Use(RecoveryMiddleware(reporter, webhook, logger))
+	router.Use(NegotiationMiddleware(cfg.ResponseFormat))
+	router.Use(CORSMiddleware(cfg))
+	router.Use(RequestLimitsMiddleware(cfg.MaxRequestBody, cfg.MaxQueryLength, cfg.MaxChannelIDLength))
+	if cfg.CompressionEnabled {
+		router.Use(CompressionMiddleware(cfg.CompressionLevel, cfg.CompressionBrotliLevel, cfg.CompressionMinSize))
+	}
 
-		if raw != "" {
-			path = path + "?" + raw
-		}
+	router.Use(AccessLogMiddleware(cfg, accessLog, logger))
 
-		logger.Info("request completed",
-			"method", c.Request.Method,
-			"path", path,
-			"status", statusCode,
-			"latency", latency.String(),
-		)
+	router.NoRoute(func(c *gin.Context) {
+		respondError(c, http.StatusNotFound, codeNotFound, "Not found")
 	})
 
-	// Register routes
-	router.GET("/health", handlers.Health)
-	router.POST("/getAccessToken", handlers.GetAccessToken)
-	router.GET("/getUpdates", handlers.GetUpdates)
+	// Register routes under the base path, and at the root as well while
+	// legacy paths are kept
+	basePath := strings.TrimRight(cfg.HTTPBasePath, "/")
+	registerRoutes(router.Group(basePath), handlers, idempotency, cfg, logger)
+	if basePath != "" && cfg.HTTPLegacyPaths {
+		registerRoutes(&router.RouterGroup, handlers, idempotency, cfg, logger)
+	}
+	router.GET(basePath+"/openapi.json", OpenAPIHandler(router, basePath))
+
+	// Polls set their own write deadline, so a write timeout below the poll
+	// timeout only applies to the other routes
+	if writeTimeout > 0 && writeTimeout < cfg.MaxPollTimeout+pollWriteMargin {
+		logger.Info("polls extend HTTP_WRITE_TIMEOUT to fit their wait",
+			"write_timeout", writeTimeout,
+			"max_poll_timeout", cfg.MaxPollTimeout,
+		)
+	}
 
 	httpServer := &http.Server{
 		Addr:         addr,
-		Handler:      router,
+		Handler:      withRawWriter(router),
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 	}
 
 	return &Server{
 		httpServer: httpServer,
+		listening:  make(chan struct{}),
+		socketPath: strings.TrimPrefix(addr, unixScheme),
+		socketMode: cfg.HTTPSocketMode,
 		logger:     logger,
 	}
 }
 
+// Handler returns the HTTP handler serving all routes
+func (s *Server) Handler() http.Handler {
+	return s.httpServer.Handler
+}
+
+// registerRoutes registers every endpoint on the given group
+func registerRoutes(
+	router *gin.RouterGroup,
+	handlers *Handlers,
+	idempotency *redis.IdempotencyStore,
+	cfg *config.Config,
+	logger *slog.Logger,
+) {
+	router.GET("/health", handlers.Health)
+	router.GET("/ready", handlers.Ready)
+	router.GET("/version", handlers.Version)
+	router.GET("/metrics", gin.WrapH(metrics.Default.Handler()))
+
+	// Client-facing endpoints refuse denied IPs, while admin and internal
+	// ones only accept allowed IPs
+	public := router.Group("", IPFilterMiddleware(nil, cfg.DeniedIPs, logger), ChaosMiddleware(cfg, logger))
+	restricted := IPFilterMiddleware(cfg.AdminAllowedIPs, nil, logger)
+
+	public.GET("/.well-known/jwks.json", handlers.JWKS)
+	public.POST("/getAccessToken", handlers.GetAccessToken)
+	debugTiming := DebugTimingMiddleware(cfg.AdminSecret, cfg.AdminAllowedIPs)
+	public.GET("/getUpdates", debugTiming, handlers.GetUpdates)
+	public.POST("/getUpdates", debugTiming, handlers.PostUpdates)
+	public.GET("/getHistory", handlers.GetHistory)
+	public.GET("/presence", handlers.GetPresence)
+	public.POST("/ack", handlers.Ack)
+	public.POST("/publish", handlers.AuthorizePublish, IdempotencyMiddleware(idempotency, logger), handlers.Publish)
+
+	if echo := NewEchoApp(cfg); echo.Enabled() {
+		public.POST("/broadcasting/auth", handlers.BroadcastingAuth(echo))
+	}
+
+	router.POST("/internal/events",
+		restricted,
+		IngestAuthMiddleware(cfg.AccessTokenSecret, cfg.AccessTokenSecretNext, cfg.PushBufferSize > 0 || cfg.Standalone()),
+		IdempotencyMiddleware(idempotency, logger),
+		handlers.IngestEvents,
+	)
+
+	// Read by Laravel with the shared secret, regardless of ingestion
+	router.GET("/internal/acks", restricted, IngestAuthMiddleware(cfg.AccessTokenSecret, cfg.AccessTokenSecretNext, true), handlers.GetAcks)
+
+	router.GET("/debug/vars", restricted, AdminAuthMiddleware(cfg.AdminSecret), handlers.DebugVars)
+
+	admin := router.Group("/admin", restricted, AdminAuthMiddleware(cfg.AdminSecret))
+	admin.POST("/channels/:id/ban", handlers.BanChannel)
+	admin.DELETE("/channels/:id/ban", handlers.UnbanChannel)
+	admin.POST("/channels/:id/revoke", handlers.RevokeChannelTokens)
+	admin.POST("/channels/:id/replay", handlers.ReplayEvent)
+	admin.GET("/channels/:id/stats", handlers.ChannelStats)
+	admin.POST("/tokens/revoke", handlers.RevokeToken)
+	admin.POST("/broadcast", handlers.Broadcast)
+	admin.POST("/maintenance", handlers.StartMaintenance)
+	admin.DELETE("/maintenance", handlers.StopMaintenance)
+	admin.GET("/drain", handlers.DrainStatus)
+	admin.POST("/drain", handlers.StartDrain)
+	admin.DELETE("/drain", handlers.StopDrain)
+	admin.GET("/dead-letters", handlers.ListDeadLetters)
+	admin.POST("/dead-letters/replay", handlers.ReplayDeadLetters)
+	admin.GET("/loglevel", handlers.LogLevel)
+	admin.PUT("/loglevel", handlers.SetLogLevel)
+}
+
 func (s *Server) Start() error {
 	s.logger.Info("starting HTTP server", "addr", s.httpServer.Addr)
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+
+	var listener net.Listener
+	var err error
+	if s.isUnixSocket() {
+		listener, err = s.listenUnix()
+	} else {
+		listener, err = net.Listen("tcp", s.httpServer.Addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	close(s.listening)
+
+	if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 	return nil
 }
 
+// Listening is closed once the server accepts connections
+func (s *Server) Listening() <-chan struct{} {
+	return s.listening
+}
+
 func (s *Server) Stop(ctx context.Context) error {
 	s.logger.Info("stopping HTTP server")
-	return s.httpServer.Shutdown(ctx)
+	err := s.httpServer.Shutdown(ctx)
+
+	if s.isUnixSocket() {
+		if removeErr := os.Remove(s.socketPath); removeErr != nil && !errors.Is(removeErr, fs.ErrNotExist) {
+			s.logger.Warn("failed to remove unix socket", "path", s.socketPath, "error", removeErr)
+		}
+	}
+
+	return err
+}
+
+func (s *Server) isUnixSocket() bool {
+	return strings.HasPrefix(s.httpServer.Addr, unixScheme)
+}
+
+// listenUnix listens on a Unix domain socket, replacing a stale socket file
+// left behind by an unclean shutdown
+func (s *Server) listenUnix() (net.Listener, error) {
+	if info, err := os.Stat(s.socketPath); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", s.socketPath)
+		}
+		if err := os.Remove(s.socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(s.socketPath, s.socketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+
+	return listener, nil
 }
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type timingKey struct{}
+
+// Phases of a poll reported by the debug timing breakdown
+const (
+	phaseAuth      = "auth"
+	phaseFetch     = "fetch"
+	phaseRefetch   = "refetch"
+	phaseSerialize = "serialize"
+)
+
+// requestTiming breaks a request's latency down so slow requests can be told
+// apart from polls that were merely waiting for events
+type requestTiming struct {
+	mu            sync.Mutex
+	started       time.Time
+	phases        map[string]time.Duration
+	channels      []string
+	offset        int64
+	waited        time.Duration
+	upstream      time.Duration
+	upstreamMax   time.Duration
+	upstreamCalls int
+	outcome       string
+}
+
+func withTiming(ctx context.Context, t *requestTiming) context.Context {
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// timingFrom returns the request's timing, or nil outside the HTTP server.
+// All methods accept a nil receiver.
+func timingFrom(ctx context.Context) *requestTiming {
+	t, _ := ctx.Value(timingKey{}).(*requestTiming)
+	return t
+}
+
+func (t *requestTiming) setPoll(channels []string, offset int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.channels = channels
+	t.offset = offset
+	t.mu.Unlock()
+}
+
+// setOutcome records how a poll ended for the access log. The first outcome
+// recorded sticks.
+func (t *requestTiming) setOutcome(outcome string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.outcome == "" {
+		t.outcome = outcome
+	}
+	t.mu.Unlock()
+}
+
+func (t *requestTiming) pollOutcome() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.outcome
+}
+
+func (t *requestTiming) pollChannels() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.channels
+}
+
+func (t *requestTiming) addWait(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.waited += d
+	t.mu.Unlock()
+}
+
+// addUpstream records one upstream fetch. Fetches for several channels run
+// concurrently, so upstream can exceed the request's wall time.
+func (t *requestTiming) addUpstream(d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.upstream += d
+	t.upstreamCalls++
+	if d > t.upstreamMax {
+		t.upstreamMax = d
+	}
+	t.mu.Unlock()
+}
+
+// addPhase adds d to one of the poll phases
+func (t *requestTiming) addPhase(phase string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if t.phases == nil {
+		t.phases = make(map[string]time.Duration)
+	}
+	t.phases[phase] += d
+	t.mu.Unlock()
+}
+
+// breakdown reports the phases in milliseconds for X-Debug responses. The
+// total stops before the response is encoded.
+func (t *requestTiming) breakdown() gin.H {
+	if t == nil {
+		return gin.H{}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return gin.H{
+		"auth_ms":        milliseconds(t.phases[phaseAuth]),
+		"fetch_ms":       milliseconds(t.phases[phaseFetch]),
+		"wait_ms":        milliseconds(t.waited),
+		"refetch_ms":     milliseconds(t.phases[phaseRefetch]),
+		"serialize_ms":   milliseconds(t.phases[phaseSerialize]),
+		"upstream_ms":    milliseconds(t.upstream),
+		"upstream_calls": t.upstreamCalls,
+		"total_ms":       milliseconds(time.Since(t.started)),
+	}
+}
+
+// milliseconds keeps microsecond precision, as auth and serialization are
+// often well under a millisecond
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// active is the part of latency not spent waiting for notifications
+func (t *requestTiming) active(latency time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return latency - t.waited
+}
+
+func (t *requestTiming) logAttrs() []any {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	attrs := []any{
+		"waited", t.waited.String(),
+		"upstream_time", t.upstream.String(),
+		"upstream_max", t.upstreamMax.String(),
+		"upstream_calls", t.upstreamCalls,
+	}
+	if len(t.channels) > 0 {
+		attrs = append(attrs, "channels", t.channels, "offset", t.offset)
+	}
+	return attrs
+}
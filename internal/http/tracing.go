@@ -0,0 +1,27 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+// traceIDKey stores the W3C trace ID of the request in the gin context
+const traceIDKey = "trace_id"
+
+// TraceContextMiddleware keeps a valid traceparent/tracestate pair from the
+// client on the request context, so upstream fetches made for it carry the
+// same trace
+func TraceContextMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if trace, ok := core.ParseTraceContext(c.GetHeader("traceparent"), c.GetHeader("tracestate")); ok {
+			c.Set(traceIDKey, trace.TraceID())
+			c.Request = c.Request.WithContext(core.WithTraceContext(c.Request.Context(), trace))
+		}
+		c.Next()
+	}
+}
+
+// traceID returns the trace ID the client sent, or ""
+func traceID(c *gin.Context) string {
+	return c.GetString(traceIDKey)
+}
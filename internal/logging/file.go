@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat suffixes rotated files, sorting them by age
+const backupTimeFormat = "20060102-150405.000000000"
+
+// RotatingFile is a log file rotated once it reaches maxSize bytes or has
+// been written to for maxAge since it was opened. Rotated files are renamed
+// with a timestamp suffix and only the newest maxBackups are kept. Zero
+// limits disable the rotation or pruning they control.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// OpenRotatingFile opens path for appending, creating it and its directory
+// when missing
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.due(len(p)) {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing records
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen reopens the file, e.g. on SIGHUP after an external tool such as
+// logrotate moved it. When the file can't be opened, logging continues to
+// the current handle.
+func (f *RotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open()
+}
+
+// Close closes the file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// due reports whether writing n more bytes needs a rotation first
+func (f *RotatingFile) due(n int) bool {
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && time.Since(f.openedAt) >= f.maxAge
+}
+
+// open opens the file, taking its size from disk so the size limit carries
+// over restarts, and closes the previous handle once the new one is in
+// place. On failure the previous handle is kept. The caller holds mu.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+
+	previous := f.file
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	if previous != nil {
+		if err := previous.Close(); err != nil {
+			return fmt.Errorf("close previous log file: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotate renames the file aside, opens a new one and prunes old backups.
+// The open handle follows the rename, so logging continues to the backup if
+// the new file can't be opened. The caller holds mu.
+func (f *RotatingFile) rotate() error {
+	backup := f.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("rename log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes the oldest backups beyond maxBackups. Files rotated by other
+// tools are left alone. The caller holds mu.
+func (f *RotatingFile) prune() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, match := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(match, f.path+".")); err == nil {
+			backups = append(backups, match)
+		}
+	}
+	if len(backups) <= f.maxBackups {
+		return
+	}
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		_ = os.Remove(backup)
+	}
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// PriorityWriter receives every formatted record together with its level,
+// for outputs such as syslog and the journal that store a priority per
+// entry. Plain writes, e.g. combined access log lines, are informational.
+type PriorityWriter interface {
+	io.Writer
+	WriteLevel(level slog.Level, p []byte) (int, error)
+}
+
+// Severity maps a slog level to a syslog severity, which journald uses as
+// its priority too
+func Severity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	}
+	return 7 // debug
+}
+
+// NewHandler returns a JSON handler for format "json" and a text handler
+// otherwise, writing to out. When out is a PriorityWriter each record is
+// written with its level.
+func NewHandler(out io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	priority, ok := out.(PriorityWriter)
+	if !ok {
+		return newFormatHandler(out, format, opts)
+	}
+	w := &levelWriter{out: priority}
+	return &priorityHandler{next: newFormatHandler(w, format, opts), w: w}
+}
+
+func newFormatHandler(out io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(out, opts)
+	}
+	return slog.NewTextHandler(out, opts)
+}
+
+// levelWriter passes the level of the record being handled to a
+// PriorityWriter. slog's handlers write each record in a single call.
+type levelWriter struct {
+	mu    sync.Mutex
+	level slog.Level
+	out   PriorityWriter
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	return w.out.WriteLevel(w.level, p)
+}
+
+// priorityHandler sets the level of each record on its levelWriter, which is
+// shared by the handlers derived with WithAttrs and WithGroup
+type priorityHandler struct {
+	next slog.Handler
+	w    *levelWriter
+}
+
+func (h *priorityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *priorityHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.level = record.Level
+	return h.next.Handle(ctx, record)
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{next: h.next.WithAttrs(attrs), w: h.w}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{next: h.next.WithGroup(name), w: h.w}
+}
@@ -0,0 +1,51 @@
+// Package logging holds slog handlers shared by the binaries.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// SamplingHandler passes one in every rate debug records of each message to
+// the handler it wraps, so per-poll debug logs stay affordable under load.
+// The first record of a message always passes, as do records at INFO and
+// above.
+type SamplingHandler struct {
+	next slog.Handler
+	rate uint64
+	// counts maps messages to their *atomic.Uint64 record count and is
+	// shared by the handlers derived with WithAttrs and WithGroup
+	counts *sync.Map
+}
+
+// NewSamplingHandler wraps next, or returns it as is when rate is 1 or less
+func NewSamplingHandler(next slog.Handler, rate int) slog.Handler {
+	if rate <= 1 {
+		return next
+	}
+	return &SamplingHandler{next: next, rate: uint64(rate), counts: new(sync.Map)}
+}
+
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *SamplingHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < slog.LevelInfo {
+		count, _ := h.counts.LoadOrStore(record.Message, new(atomic.Uint64))
+		if count.(*atomic.Uint64).Add(1)%h.rate != 1 {
+			return nil
+		}
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SamplingHandler{next: h.next.WithAttrs(attrs), rate: h.rate, counts: h.counts}
+}
+
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{next: h.next.WithGroup(name), rate: h.rate, counts: h.counts}
+}
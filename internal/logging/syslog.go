@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// syslogDaemon is the facility logs are sent with
+const syslogDaemon = 3
+
+// localSyslogSockets are tried in order when no address is given
+var localSyslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter sends records to a syslog daemon in the format of Go's
+// log/syslog: the traditional BSD format to the local socket, with an
+// RFC 3339 timestamp and the hostname over the network. It redials once
+// when a write fails, e.g. after the daemon restarted.
+type SyslogWriter struct {
+	network  string
+	addr     string
+	tag      string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// DialSyslog connects to addr, given as udp://host:port, tcp://host:port or
+// unix:///path, or to the local daemon when addr is empty
+func DialSyslog(addr, tag string) (*SyslogWriter, error) {
+	w := &SyslogWriter{tag: tag}
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "unix") {
+			return nil, fmt.Errorf("invalid syslog address %q", addr)
+		}
+		w.network, w.addr = u.Scheme, u.Host
+		if u.Scheme == "unix" {
+			w.addr = u.Path
+		}
+		w.hostname, _ = os.Hostname()
+	}
+
+	if err := w.dial(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *SyslogWriter) dial() error {
+	if w.network != "" {
+		network := w.network
+		if network == "unix" {
+			network = "unixgram"
+		}
+		conn, err := net.Dial(network, w.addr)
+		if err != nil {
+			return fmt.Errorf("dial syslog: %w", err)
+		}
+		w.conn = conn
+		return nil
+	}
+
+	for _, socket := range localSyslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, socket); err == nil {
+				w.conn = conn
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no local syslog socket found")
+}
+
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(slog.LevelInfo, p)
+}
+
+func (w *SyslogWriter) WriteLevel(level slog.Level, p []byte) (int, error) {
+	msg := bytes.TrimRight(p, "\n")
+	priority := syslogDaemon*8 + Severity(level)
+
+	var line string
+	if w.hostname == "" {
+		line = fmt.Sprintf("<%d>%s %s[%d]: %s\n", priority, time.Now().Format(time.Stamp), w.tag, os.Getpid(), msg)
+	} else {
+		line = fmt.Sprintf("<%d>%s %s %s[%d]: %s\n", priority, time.Now().Format(time.RFC3339), w.hostname, w.tag, os.Getpid(), msg)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		if _, err := w.conn.Write([]byte(line)); err == nil {
+			return len(p), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.dial(); err != nil {
+		return 0, err
+	}
+	if _, err := w.conn.Write([]byte(line)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection
+func (w *SyslogWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
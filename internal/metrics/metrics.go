@@ -0,0 +1,304 @@
+// Package metrics provides a minimal, dependency-free metrics registry
+// exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomic.Uint64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Value returns the current value
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	labels   []string
+	mu       sync.RWMutex
+	children map[string]*Counter
+}
+
+// WithLabelValues returns the counter for the given label values
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	counter, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if counter, ok = v.children[key]; !ok {
+		counter = &Counter{}
+		v.children[key] = counter
+	}
+	return counter
+}
+
+// Sample is one value of a metric with its label set
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Family is a metric with the samples read at collection time
+type Family struct {
+	Name    string
+	Help    string
+	Kind    string
+	Samples []Sample
+}
+
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	write   func(w io.Writer, name string)
+	value   func() interface{}
+	samples func() []Sample
+}
+
+// Registry holds named metrics
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+// Default is the registry used by the package-level constructors
+var Default = NewRegistry()
+
+func (r *Registry) register(m *metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.metrics[m.name]; exists {
+		panic("metrics: duplicate metric " + m.name)
+	}
+	r.metrics[m.name] = m
+}
+
+// NewCounter registers a counter
+func (r *Registry) NewCounter(name, help string) *Counter {
+	counter := &Counter{}
+	r.register(&metric{name: name, help: help, kind: "counter", write: func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %d\n", name, counter.Value())
+	}, value: func() interface{} {
+		return counter.Value()
+	}, samples: func() []Sample {
+		return []Sample{{Value: float64(counter.Value())}}
+	}})
+	return counter
+}
+
+// NewCounterVec registers a counter partitioned by labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	vec := &CounterVec{labels: labels, children: make(map[string]*Counter)}
+	r.register(&metric{name: name, help: help, kind: "counter", write: func(w io.Writer, name string) {
+		vec.mu.RLock()
+		keys := make([]string, 0, len(vec.children))
+		for key := range vec.children {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s{%s} %d\n", name, formatLabels(vec.labels, strings.Split(key, "\xff")), vec.children[key].Value())
+		}
+		vec.mu.RUnlock()
+	}, value: func() interface{} {
+		vec.mu.RLock()
+		defer vec.mu.RUnlock()
+		values := make(map[string]uint64, len(vec.children))
+		for key, counter := range vec.children {
+			values[formatLabels(vec.labels, strings.Split(key, "\xff"))] = counter.Value()
+		}
+		return values
+	}, samples: func() []Sample {
+		vec.mu.RLock()
+		defer vec.mu.RUnlock()
+		samples := make([]Sample, 0, len(vec.children))
+		for key, counter := range vec.children {
+			labels := make(map[string]string, len(vec.labels))
+			for i, value := range strings.Split(key, "\xff") {
+				if i < len(vec.labels) {
+					labels[vec.labels[i]] = value
+				}
+			}
+			samples = append(samples, Sample{Labels: labels, Value: float64(counter.Value())})
+		}
+		return samples
+	}})
+	return vec
+}
+
+// NewGauge registers a gauge
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	gauge := &Gauge{}
+	r.register(&metric{name: name, help: help, kind: "gauge", write: func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %d\n", name, gauge.Value())
+	}, value: func() interface{} {
+		return gauge.Value()
+	}, samples: func() []Sample {
+		return []Sample{{Value: float64(gauge.Value())}}
+	}})
+	return gauge
+}
+
+// NewGaugeFunc registers a gauge whose value is computed on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&metric{name: name, help: help, kind: "gauge", write: func(w io.Writer, name string) {
+		fmt.Fprintf(w, "%s %g\n", name, fn())
+	}, value: func() interface{} {
+		return fn()
+	}, samples: func() []Sample {
+		return []Sample{{Value: fn()}}
+	}})
+}
+
+// WritePrometheus writes every metric in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		m.write(w, m.name)
+	}
+}
+
+// Snapshot returns the current value of every metric by name. Labeled
+// counters map their label sets, e.g. `reason="max_len"`, to values.
+func (r *Registry) Snapshot() map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := make(map[string]interface{}, len(r.metrics))
+	for name, m := range r.metrics {
+		values[name] = m.value()
+	}
+	return values
+}
+
+// Collect reads every metric, sorted by name, for exporters that push
+// rather than being scraped
+func (r *Registry) Collect() []Family {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name < metrics[j].name
+	})
+
+	families := make([]Family, len(metrics))
+	for i, m := range metrics {
+		families[i] = Family{Name: m.name, Help: m.help, Kind: m.kind, Samples: m.samples()}
+	}
+	return families
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+// NewCounter registers a counter in the default registry
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounter(name, help)
+}
+
+// NewCounterVec registers a labeled counter in the default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGauge registers a gauge in the default registry
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGauge(name, help)
+}
+
+// NewGaugeFunc registers a computed gauge in the default registry
+func NewGaugeFunc(name, help string, fn func() float64) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, value)
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLPExporter periodically pushes a registry to an OpenTelemetry collector
+// over OTLP/HTTP with the JSON encoding. Counters are sent as cumulative
+// monotonic sums starting when the exporter was created.
+type OTLPExporter struct {
+	registry   *Registry
+	endpoint   string
+	headers    map[string]string
+	resource   map[string]string
+	interval   time.Duration
+	started    time.Time
+	httpClient *http.Client
+	logger     *slog.Logger
+}
+
+// NewOTLPExporter creates an exporter posting to endpoint, the full
+// /v1/metrics URL. It returns nil when endpoint is empty; a nil exporter
+// does nothing.
+func NewOTLPExporter(registry *Registry, endpoint string, headers, resource map[string]string, interval, timeout time.Duration, logger *slog.Logger) *OTLPExporter {
+	if endpoint == "" {
+		return nil
+	}
+	return &OTLPExporter{
+		registry:   registry,
+		endpoint:   endpoint,
+		headers:    headers,
+		resource:   resource,
+		interval:   interval,
+		started:    time.Now(),
+		httpClient: &http.Client{Timeout: timeout},
+		logger:     logger,
+	}
+}
+
+// Run exports every interval until ctx is done
+func (e *OTLPExporter) Run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil && ctx.Err() == nil {
+				e.logger.Error("failed to export metrics", "endpoint", e.endpoint, "error", err)
+			}
+		}
+	}
+}
+
+// Export pushes the current values once. It is also called on shutdown so
+// the collector sees the final counts.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(e.payload(time.Now()))
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) payload(now time.Time) map[string]interface{} {
+	start := strconv.FormatInt(e.started.UnixNano(), 10)
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+
+	var metrics []interface{}
+	for _, family := range e.registry.Collect() {
+		points := make([]interface{}, len(family.Samples))
+		for i, sample := range family.Samples {
+			points[i] = map[string]interface{}{
+				"attributes":        otlpAttributes(sample.Labels),
+				"startTimeUnixNano": start,
+				"timeUnixNano":      timestamp,
+				"asDouble":          sample.Value,
+			}
+		}
+
+		metric := map[string]interface{}{
+			"name":        family.Name,
+			"description": family.Help,
+		}
+		if family.Kind == "counter" {
+			metric["sum"] = map[string]interface{}{
+				"dataPoints":             points,
+				"aggregationTemporality": 2, // cumulative
+				"isMonotonic":            true,
+			}
+		} else {
+			metric["gauge"] = map[string]interface{}{"dataPoints": points}
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": otlpAttributes(e.resource)},
+			"scopeMetrics": []interface{}{map[string]interface{}{
+				"scope":   map[string]interface{}{"name": "longpoll-server"},
+				"metrics": metrics,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(values map[string]string) []interface{} {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]interface{}, len(keys))
+	for i, key := range keys {
+		attributes[i] = map[string]interface{}{
+			"key":   key,
+			"value": map[string]interface{}{"stringValue": values[key]},
+		}
+	}
+	return attributes
+}
+
+// ParseOTelPairs parses the key1=value1,key2=value2 lists used by
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_RESOURCE_ATTRIBUTES. Values are
+// percent-decoded.
+func ParseOTelPairs(list string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid pair %q", item)
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %w", key, err)
+		}
+		pairs[key] = decoded
+	}
+	return pairs, nil
+}
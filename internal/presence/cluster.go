@@ -0,0 +1,182 @@
+package presence
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// staleSyncs is how many missed syncs make an instance's entry stale
+const staleSyncs = 3
+
+// instanceMembers is what an instance publishes for one channel
+type instanceMembers struct {
+	Members   []Member `json:"members"`
+	UpdatedAt int64    `json:"updated_at"`
+}
+
+// Cluster shares presence between instances. Every instance periodically
+// publishes the members of its channels to a Redis hash per channel, keyed
+// by instance, and merges the other instances' entries with its own live
+// members, so every instance gives the same answer.
+type Cluster struct {
+	client   *redis.Client
+	prefix   string
+	instance string
+	interval time.Duration
+	tracker  *Tracker
+	logger   *slog.Logger
+
+	published map[string]bool
+}
+
+// NewCluster creates a cluster registry syncing tracker every interval. It
+// returns nil when interval is not positive; Run on a nil *Cluster does
+// nothing.
+func NewCluster(client *redis.Client, keyPrefix string, tracker *Tracker, interval time.Duration, logger *slog.Logger) *Cluster {
+	if interval <= 0 {
+		return nil
+	}
+
+	id := make([]byte, 8)
+	_, _ = crand.Read(id)
+
+	return &Cluster{
+		client:    client,
+		prefix:    keyPrefix + "presence:",
+		instance:  hex.EncodeToString(id),
+		interval:  interval,
+		tracker:   tracker,
+		logger:    logger,
+		published: make(map[string]bool),
+	}
+}
+
+// Run publishes the local members every interval until ctx is done, then
+// withdraws them
+func (c *Cluster) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.withdraw()
+			return
+		case <-ticker.C:
+			if err := c.sync(ctx); err != nil && ctx.Err() == nil {
+				c.logger.Warn("failed to sync cluster presence", "error", err)
+			}
+		}
+	}
+}
+
+// Members returns the members of a channel across all instances. A client
+// polling through several instances is listed once, with its connections
+// summed and its earliest since.
+func (c *Cluster) Members(ctx context.Context, channelID string) ([]Member, error) {
+	values, err := c.client.HGetAll(ctx, c.prefix+channelID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cluster presence: %w", err)
+	}
+
+	local := c.tracker.Members(channelID)
+	merged := make(map[string]Member, len(local))
+	add := func(m Member) {
+		if existing, ok := merged[m.ClientID]; ok {
+			m.Connections += existing.Connections
+			if existing.Since < m.Since {
+				m.Since = existing.Since
+			}
+		}
+		merged[m.ClientID] = m
+	}
+	for _, m := range local {
+		add(m)
+	}
+
+	cutoff := time.Now().Add(-staleSyncs * c.interval).Unix()
+	for instance, value := range values {
+		// The local tracker is fresher than what this instance published
+		if instance == c.instance {
+			continue
+		}
+		var entry instanceMembers
+		if err := json.Unmarshal([]byte(value), &entry); err != nil || entry.UpdatedAt < cutoff {
+			continue
+		}
+		for _, m := range entry.Members {
+			add(m)
+		}
+	}
+
+	members := make([]Member, 0, len(merged))
+	for _, m := range merged {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ClientID < members[j].ClientID
+	})
+	return members, nil
+}
+
+// sync publishes the current members of every local channel and removes
+// this instance from channels it no longer has members on
+func (c *Cluster) sync(ctx context.Context) error {
+	snapshot := c.tracker.Snapshot()
+	now := time.Now().Unix()
+	ttl := staleSyncs * c.interval
+
+	pipe := c.client.Pipeline()
+	for channelID, members := range snapshot {
+		payload, err := json.Marshal(instanceMembers{Members: members, UpdatedAt: now})
+		if err != nil {
+			return err
+		}
+		pipe.HSet(ctx, c.prefix+channelID, c.instance, payload)
+		pipe.PExpire(ctx, c.prefix+channelID, ttl)
+	}
+	for channelID := range c.published {
+		if _, ok := snapshot[channelID]; !ok {
+			pipe.HDel(ctx, c.prefix+channelID, c.instance)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	c.published = make(map[string]bool, len(snapshot))
+	for channelID := range snapshot {
+		c.published[channelID] = true
+	}
+	return nil
+}
+
+// withdraw removes this instance's entries so its members disappear at once
+func (c *Cluster) withdraw() {
+	if len(c.published) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	pipe := c.client.Pipeline()
+	for channelID := range c.published {
+		pipe.HDel(ctx, c.prefix+channelID, c.instance)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.logger.Warn("failed to withdraw cluster presence", "error", err)
+	}
+}
@@ -0,0 +1,230 @@
+package presence
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Presence change types
+const (
+	MemberAdded   = "member_added"
+	MemberRemoved = "member_removed"
+)
+
+// Change describes a member joining or leaving a channel
+type Change struct {
+	Type      string `json:"type"`
+	ChannelID string `json:"channel_id"`
+	ClientID  string `json:"client_id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// Member represents a client currently polling a channel
+type Member struct {
+	ClientID    string `json:"client_id"`
+	Connections int    `json:"connections"`
+	Since       int64  `json:"since"`
+}
+
+type member struct {
+	connections int
+	since       time.Time
+	leftAt      time.Time
+}
+
+// Tracker keeps track of which clients are polling which channels.
+// A member stays present for a grace period after its last poll ends so that
+// clients re-polling between cycles don't flap in and out of presence.
+type Tracker struct {
+	grace    time.Duration
+	onChange func(Change)
+	logger   *slog.Logger
+	channels map[string]map[string]*member
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	stopped  bool
+}
+
+// NewTracker creates a new presence tracker
+func NewTracker(grace time.Duration, onChange func(Change), logger *slog.Logger) *Tracker {
+	return &Tracker{
+		grace:    grace,
+		onChange: onChange,
+		logger:   logger,
+		channels: make(map[string]map[string]*member),
+	}
+}
+
+// Join marks a client as polling a channel
+func (t *Tracker) Join(channelID, clientID string) {
+	t.mu.Lock()
+	members, ok := t.channels[channelID]
+	if !ok {
+		members = make(map[string]*member)
+		t.channels[channelID] = members
+	}
+
+	m, ok := members[clientID]
+	if !ok {
+		m = &member{since: time.Now()}
+		members[clientID] = m
+	}
+	m.connections++
+	m.leftAt = time.Time{}
+	t.mu.Unlock()
+
+	if !ok {
+		t.notify(MemberAdded, channelID, clientID)
+	}
+}
+
+// Leave marks the end of a client's poll on a channel
+func (t *Tracker) Leave(channelID, clientID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	m, ok := t.channels[channelID][clientID]
+	if !ok {
+		return
+	}
+	m.connections--
+	if m.connections <= 0 {
+		m.connections = 0
+		m.leftAt = time.Now()
+	}
+}
+
+// Members returns the clients currently present on a channel
+func (t *Tracker) Members(channelID string) []Member {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	members := make([]Member, 0, len(t.channels[channelID]))
+	for clientID, m := range t.channels[channelID] {
+		members = append(members, Member{
+			ClientID:    clientID,
+			Connections: m.connections,
+			Since:       m.since.Unix(),
+		})
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ClientID < members[j].ClientID
+	})
+
+	return members
+}
+
+// Snapshot returns the members of every channel with at least one
+func (t *Tracker) Snapshot() map[string][]Member {
+	t.mu.Lock()
+	channelIDs := make([]string, 0, len(t.channels))
+	for channelID := range t.channels {
+		channelIDs = append(channelIDs, channelID)
+	}
+	t.mu.Unlock()
+
+	snapshot := make(map[string][]Member, len(channelIDs))
+	for _, channelID := range channelIDs {
+		if members := t.Members(channelID); len(members) > 0 {
+			snapshot[channelID] = members
+		}
+	}
+	return snapshot
+}
+
+// Start runs the sweeper that expires members after the grace period
+func (t *Tracker) Start(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Stop may run before the sweeper goroutine gets here
+	t.mu.Lock()
+	t.cancel = cancel
+	if t.stopped {
+		cancel()
+	}
+	t.mu.Unlock()
+
+	interval := t.grace / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.sweep()
+		}
+	}
+}
+
+// Stop stops the sweeper
+func (t *Tracker) Stop() {
+	t.mu.Lock()
+	t.stopped = true
+	cancel := t.cancel
+	t.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// sweep removes members whose grace period has elapsed
+func (t *Tracker) sweep() {
+	var removed []Change
+
+	t.mu.Lock()
+	now := time.Now()
+	for channelID, members := range t.channels {
+		for clientID, m := range members {
+			if m.connections > 0 || m.leftAt.IsZero() || now.Sub(m.leftAt) < t.grace {
+				continue
+			}
+			delete(members, clientID)
+			removed = append(removed, Change{
+				Type:      MemberRemoved,
+				ChannelID: channelID,
+				ClientID:  clientID,
+				Timestamp: now.Unix(),
+			})
+		}
+		if len(members) == 0 {
+			delete(t.channels, channelID)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, change := range removed {
+		t.emit(change)
+	}
+}
+
+func (t *Tracker) notify(changeType, channelID, clientID string) {
+	t.emit(Change{
+		Type:      changeType,
+		ChannelID: channelID,
+		ClientID:  clientID,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+func (t *Tracker) emit(change Change) {
+	t.logger.Debug("presence changed",
+		"type", change.Type,
+		"channel_id", change.ChannelID,
+		"client_id", change.ClientID,
+	)
+	if t.onChange != nil {
+		t.onChange(change)
+	}
+}
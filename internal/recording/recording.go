@@ -0,0 +1,270 @@
+// Package recording captures the event batches delivered to pollers, as
+// notifications carrying the events, so incidents can be replayed against
+// another instance with their original timing.
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+var recordsDropped = metrics.NewCounter(
+	"longpoll_recording_dropped_total",
+	"Notifications not recorded because the recording queue was full.",
+)
+
+// queueSize bounds the notifications waiting to be written
+const queueSize = 4096
+
+// streamField holds the encoded record in Redis stream entries
+const streamField = "record"
+
+// Record is one delivered batch, written as a single JSON line
+type Record struct {
+	Time         time.Time               `json:"time"`
+	Notification redis.EventNotification `json:"notification"`
+}
+
+// Sink stores encoded records
+type Sink interface {
+	Write(ctx context.Context, line []byte) error
+	Close() error
+}
+
+// Recorder writes notifications to a sink in the background, so recording
+// never delays delivery. A nil Recorder discards everything.
+type Recorder struct {
+	sink   Sink
+	mu     sync.RWMutex
+	closed bool
+	queue  chan Record
+	done   chan struct{}
+	logger *slog.Logger
+
+	// last holds the highest event ID recorded per channel, so a batch
+	// returned to several pollers is recorded once
+	lastMu sync.Mutex
+	last   map[string]int64
+}
+
+// NewRecorder creates a recorder writing to sink; Run must be started
+func NewRecorder(sink Sink, logger *slog.Logger) *Recorder {
+	return &Recorder{
+		sink:   sink,
+		queue:  make(chan Record, queueSize),
+		done:   make(chan struct{}),
+		logger: logger,
+		last:   make(map[string]int64),
+	}
+}
+
+// Delivered records the events of a channel returned to a poller that were
+// not recorded yet, as one notification carrying them. Broadcasts are not
+// recorded.
+func (r *Recorder) Delivered(channelID string, events []core.Event) {
+	if r == nil {
+		return
+	}
+
+	r.lastMu.Lock()
+	last := r.last[channelID]
+	fresh := make([]core.Event, 0, len(events))
+	for _, event := range events {
+		if event.ID > last {
+			fresh = append(fresh, event)
+		}
+	}
+	if len(fresh) == 0 {
+		r.lastMu.Unlock()
+		return
+	}
+	maxID := last
+	for _, event := range fresh {
+		if event.ID > maxID {
+			maxID = event.ID
+		}
+	}
+	r.last[channelID] = maxID
+	r.lastMu.Unlock()
+
+	r.Record(redis.EventNotification{
+		ChannelID: channelID,
+		EventID:   maxID,
+		Timestamp: time.Now().Unix(),
+		Events:    fresh,
+	})
+}
+
+// Record queues a notification, dropping it when the sink falls behind or
+// the recorder is closed
+func (r *Recorder) Record(notification redis.EventNotification) {
+	if r == nil {
+		return
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- Record{Time: time.Now().UTC(), Notification: notification}:
+	default:
+		recordsDropped.Inc()
+	}
+}
+
+// Run writes queued records until Close
+func (r *Recorder) Run() {
+	if r == nil {
+		return
+	}
+	defer close(r.done)
+
+	for record := range r.queue {
+		line, err := json.Marshal(record)
+		if err != nil {
+			r.logger.Error("failed to encode recorded notification", "error", err, "channel_id", record.Notification.ChannelID)
+			continue
+		}
+		if err := r.sink.Write(context.Background(), line); err != nil {
+			r.logger.Error("failed to record notification", "error", err, "channel_id", record.Notification.ChannelID)
+		}
+	}
+}
+
+// Close writes the records still queued, waiting for Run to finish, and
+// closes the sink
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	r.closed = true
+	close(r.queue)
+	r.mu.Unlock()
+	<-r.done
+	return r.sink.Close()
+}
+
+// FileSink appends records as JSON lines to a file
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it readable by the owner
+// only, as events may hold personal data
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends one line
+func (s *FileSink) Write(_ context.Context, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// StreamSink appends records to a Redis stream capped at about maxLen
+// entries
+type StreamSink struct {
+	client *goredis.Client
+	key    string
+	maxLen int64
+}
+
+// NewStreamSink creates a sink adding to the stream at key
+func NewStreamSink(client *goredis.Client, key string, maxLen int) *StreamSink {
+	return &StreamSink{client: client, key: key, maxLen: int64(maxLen)}
+}
+
+// Write adds one entry, trimming the oldest beyond maxLen
+func (s *StreamSink) Write(ctx context.Context, line []byte) error {
+	return s.client.XAdd(ctx, &goredis.XAddArgs{
+		Stream: s.key,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{streamField: line},
+	}).Err()
+}
+
+// Close is a no-op; the Redis client is owned by the caller
+func (s *StreamSink) Close() error {
+	return nil
+}
+
+// ReadFile calls fn with every record of a file written by FileSink, in
+// order
+func ReadFile(path string, fn func(Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("invalid record on line %d: %w", line, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// ReadStream calls fn with every record of a stream written by StreamSink,
+// in order
+func ReadStream(ctx context.Context, client *goredis.Client, key string, fn func(Record) error) error {
+	start := "-"
+	for {
+		entries, err := client.XRangeN(ctx, key, start, "+", 1000).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read recording: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		for _, entry := range entries {
+			raw, _ := entry.Values[streamField].(string)
+			var record Record
+			if err := json.Unmarshal([]byte(raw), &record); err != nil {
+				return fmt.Errorf("invalid record %s: %w", entry.ID, err)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		// Exclusive range after the last entry read
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
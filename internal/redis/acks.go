@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ChannelAckClient is the client ID recorded for acks sent without one
+const ChannelAckClient = "*"
+
+// raiseAck stores ARGV[2] for field ARGV[1] unless it is below the current
+// watermark, refreshes the key's TTL (ARGV[3] milliseconds, 0 keeps it) and
+// returns the resulting watermark
+var raiseAck = redis.NewScript(`
+local current = tonumber(redis.call('HGET', KEYS[1], ARGV[1]) or '0')
+local acked = tonumber(ARGV[2])
+if acked > current then
+	redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+	current = acked
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return current
+`)
+
+// AckStore keeps per-client delivery watermarks of each channel in a Redis
+// hash, so Laravel can prune events every client has confirmed
+type AckStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewAckStore creates a store whose channel watermarks expire ttl after the
+// last ack (0 keeps them)
+func NewAckStore(client *redis.Client, prefix string, ttl time.Duration) *AckStore {
+	return &AckStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+func (s *AckStore) key(channelID string) string {
+	return s.prefix + "acks:" + channelID
+}
+
+// Ack records that clientID received the channel's events up to eventID.
+// Watermarks never move backwards; the current one is returned.
+func (s *AckStore) Ack(ctx context.Context, channelID, clientID string, eventID int64) (int64, error) {
+	if clientID == "" {
+		clientID = ChannelAckClient
+	}
+
+	watermark, err := raiseAck.Run(ctx, s.client, []string{s.key(channelID)}, clientID, eventID, s.ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to store ack: %w", err)
+	}
+	return watermark, nil
+}
+
+// Watermarks returns the acked event ID of every client of a channel
+func (s *AckStore) Watermarks(ctx context.Context, channelID string) (map[string]int64, error) {
+	values, err := s.client.HGetAll(ctx, s.key(channelID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load acks: %w", err)
+	}
+
+	watermarks := make(map[string]int64, len(values))
+	for clientID, value := range values {
+		eventID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		watermarks[clientID] = eventID
+	}
+	return watermarks, nil
+}
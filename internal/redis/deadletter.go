@@ -0,0 +1,165 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// Reasons a notification ends up in the dead-letter list
+const (
+	DeadLetterQueueFull  = "fanout_queue_full"
+	DeadLetterPollerFull = "poller_full"
+	DeadLetterNoPoller   = "no_poller"
+)
+
+// deadLetterWriteTimeout bounds a dead-letter write, which runs off the
+// dispatch path
+const deadLetterWriteTimeout = 2 * time.Second
+
+var deadLettersRecorded = metrics.NewCounterVec(
+	"longpoll_dead_letters_total",
+	"Notifications recorded as undeliverable, by reason.",
+	"reason",
+)
+
+// DeadLetter is an undeliverable notification
+type DeadLetter struct {
+	Notification EventNotification `json:"notification"`
+	Reason       string            `json:"reason"`
+	RecordedAt   int64             `json:"recorded_at"`
+}
+
+// DeadLetters records dropped notifications in a capped Redis list, newest
+// first, for inspection and replay. With idle > 0, notifications for
+// channels that had no local poller for that long are recorded too. A nil
+// *DeadLetters records nothing.
+type DeadLetters struct {
+	client *redis.Client
+	key    string
+	maxLen int64
+	idle   time.Duration
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	lastPoll map[string]time.Time
+}
+
+// NewDeadLetters creates a dead-letter list keeping the last maxLen entries
+func NewDeadLetters(client *redis.Client, key string, maxLen int, idle time.Duration, logger *slog.Logger) *DeadLetters {
+	return &DeadLetters{
+		client:   client,
+		key:      key,
+		maxLen:   int64(maxLen),
+		idle:     idle,
+		logger:   logger,
+		lastPoll: make(map[string]time.Time),
+	}
+}
+
+// Record stores a dropped notification in the background
+func (d *DeadLetters) Record(notification EventNotification, reason string) {
+	if d == nil {
+		return
+	}
+	deadLettersRecorded.WithLabelValues(reason).Inc()
+
+	payload, err := json.Marshal(DeadLetter{
+		Notification: notification,
+		Reason:       reason,
+		RecordedAt:   time.Now().Unix(),
+	})
+	if err != nil {
+		d.logger.Error("failed to encode dead letter", "error", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), deadLetterWriteTimeout)
+		defer cancel()
+
+		pipe := d.client.TxPipeline()
+		pipe.LPush(ctx, d.key, payload)
+		pipe.LTrim(ctx, d.key, 0, d.maxLen-1)
+		if _, err := pipe.Exec(ctx); err != nil {
+			d.logger.Warn("failed to record dead letter", "error", err, "channel_id", notification.ChannelID)
+		}
+	}()
+}
+
+// Polled notes that a channel has a poller right now
+func (d *DeadLetters) Polled(channelID string) {
+	if d == nil || d.idle <= 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.lastPoll[channelID] = now
+
+	// Forget channels idle for longer than the window; they count as idle
+	// either way
+	if len(d.lastPoll) > 10000 {
+		for id, at := range d.lastPoll {
+			if now.Sub(at) > d.idle {
+				delete(d.lastPoll, id)
+			}
+		}
+	}
+}
+
+// Idle reports whether a channel had no poller for the idle window
+func (d *DeadLetters) Idle(channelID string) bool {
+	if d == nil || d.idle <= 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	at, ok := d.lastPoll[channelID]
+	return !ok || time.Since(at) > d.idle
+}
+
+// List returns up to limit dead letters, newest first
+func (d *DeadLetters) List(ctx context.Context, limit int) ([]DeadLetter, error) {
+	values, err := d.client.LRange(ctx, d.key, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	return decodeDeadLetters(values, d.logger), nil
+}
+
+// Take removes and returns up to limit dead letters, oldest first
+func (d *DeadLetters) Take(ctx context.Context, limit int) ([]DeadLetter, error) {
+	values, err := d.client.RPopCount(ctx, d.key, limit).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take dead letters: %w", err)
+	}
+	return decodeDeadLetters(values, d.logger), nil
+}
+
+func decodeDeadLetters(values []string, logger *slog.Logger) []DeadLetter {
+	letters := make([]DeadLetter, 0, len(values))
+	for _, value := range values {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(value), &letter); err != nil {
+			logger.Warn("skipping malformed dead letter", "error", err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
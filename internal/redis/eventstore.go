@@ -0,0 +1,230 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// bumpSequence raises a channel's ID sequence to at least ARGV[1] so that
+// assigned IDs never collide with explicit ones
+var bumpSequence = redis.NewScript(`
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if current < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// pruneBatch is how many of a channel's oldest events Prune inspects at once
+const pruneBatch = 100
+
+var eventStorePruned = metrics.NewCounterVec(
+	"longpoll_event_store_pruned_total",
+	"Events dropped from the Redis event store, by reason (max_len or max_age).",
+	"reason",
+)
+
+// EventStore keeps each channel's events in a Redis sorted set scored by
+// event ID, making this service the source of truth for the polling window
+type EventStore struct {
+	client    *redis.Client
+	prefix    string
+	maxLen    int
+	retention time.Duration
+	maxAge    time.Duration
+}
+
+// NewEventStore creates a store keeping up to maxLen events per channel.
+// Channels without new events for retention are dropped, and events older
+// than maxAge are removed by Prune (0 disables either).
+func NewEventStore(client *redis.Client, prefix string, maxLen int, retention, maxAge time.Duration) *EventStore {
+	return &EventStore{
+		client:    client,
+		prefix:    prefix,
+		maxLen:    maxLen,
+		retention: retention,
+		maxAge:    maxAge,
+	}
+}
+
+func (s *EventStore) eventsKey(channelID string) string {
+	return s.prefix + "events:" + channelID
+}
+
+func (s *EventStore) sequenceKey(channelID string) string {
+	return s.prefix + "seq:" + channelID
+}
+
+// Append stores events for a channel. Events without an ID get the next IDs
+// of the channel's sequence. The stored events are returned with their IDs.
+func (s *EventStore) Append(ctx context.Context, channelID string, events []core.Event) ([]core.Event, error) {
+	stored := make([]core.Event, len(events))
+	copy(stored, events)
+
+	var missing, maxID int64
+	for _, event := range stored {
+		if event.ID == 0 {
+			missing++
+		} else if event.ID > maxID {
+			maxID = event.ID
+		}
+	}
+
+	if maxID > 0 {
+		if err := bumpSequence.Run(ctx, s.client, []string{s.sequenceKey(channelID)}, maxID).Err(); err != nil {
+			return nil, fmt.Errorf("failed to advance sequence: %w", err)
+		}
+	}
+	if missing > 0 {
+		last, err := s.client.IncrBy(ctx, s.sequenceKey(channelID), missing).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate event IDs: %w", err)
+		}
+		next := last - missing + 1
+		for i := range stored {
+			if stored[i].ID == 0 {
+				stored[i].ID = next
+				next++
+			}
+		}
+	}
+
+	key := s.eventsKey(channelID)
+	pipe := s.client.TxPipeline()
+	for _, event := range stored {
+		event.ChannelID = ""
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		score := strconv.FormatInt(event.ID, 10)
+		pipe.ZRemRangeByScore(ctx, key, score, score)
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(event.ID), Member: payload})
+	}
+	var trimmed *redis.IntCmd
+	if s.maxLen > 0 {
+		trimmed = pipe.ZRemRangeByRank(ctx, key, 0, int64(-s.maxLen-1))
+	}
+	if s.retention > 0 {
+		pipe.Expire(ctx, key, s.retention)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to store events: %w", err)
+	}
+	if trimmed != nil && trimmed.Val() > 0 {
+		eventStorePruned.WithLabelValues("max_len").Add(uint64(trimmed.Val()))
+	}
+
+	return stored, nil
+}
+
+// GetEvents returns up to limit events of a channel with ID >= offset
+func (s *EventStore) GetEvents(ctx context.Context, channelID string, offset int64, limit int) ([]core.Event, error) {
+	members, err := s.client.ZRangeByScore(ctx, s.eventsKey(channelID), &redis.ZRangeBy{
+		Min:   strconv.FormatInt(offset, 10),
+		Max:   "+inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]core.Event, 0, len(members))
+	for _, member := range members {
+		var event core.Event
+		if err := json.Unmarshal([]byte(member), &event); err != nil {
+			return nil, fmt.Errorf("corrupt event in %s: %w", s.eventsKey(channelID), err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// GetEventsSince implements core.SinceSource. Events are ordered by ID, not
+// time, so the channel is scanned from its oldest retained event.
+func (s *EventStore) GetEventsSince(ctx context.Context, channelID string, since time.Time, limit int) ([]core.Event, error) {
+	members, err := s.client.ZRange(ctx, s.eventsKey(channelID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := since.Unix()
+	events := make([]core.Event, 0, limit)
+	for _, member := range members {
+		var event core.Event
+		if err := json.Unmarshal([]byte(member), &event); err != nil {
+			return nil, fmt.Errorf("corrupt event in %s: %w", s.eventsKey(channelID), err)
+		}
+		if event.CreatedAt < cutoff {
+			continue
+		}
+		events = append(events, event)
+		if len(events) >= limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// Prune implements core.Pruner by removing events older than maxAge from
+// every channel. IDs grow with time, so each channel is read from its oldest
+// event until a young enough one is found.
+func (s *EventStore) Prune(ctx context.Context) (int, error) {
+	if s.maxAge <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-s.maxAge).Unix()
+
+	pruned := 0
+	iter := s.client.Scan(ctx, 0, s.prefix+"events:*", pruneBatch).Iterator()
+	for iter.Next(ctx) {
+		n, err := s.pruneKey(ctx, iter.Val(), cutoff)
+		pruned += n
+		if err != nil {
+			return pruned, err
+		}
+	}
+	if pruned > 0 {
+		eventStorePruned.WithLabelValues("max_age").Add(uint64(pruned))
+	}
+	return pruned, iter.Err()
+}
+
+// pruneKey removes a channel's events created before cutoff
+func (s *EventStore) pruneKey(ctx context.Context, key string, cutoff int64) (int, error) {
+	pruned := 0
+	for {
+		members, err := s.client.ZRange(ctx, key, 0, pruneBatch-1).Result()
+		if err != nil {
+			return pruned, err
+		}
+
+		expired := make([]interface{}, 0, len(members))
+		// Corrupt events are dropped along with expired ones
+		for _, member := range members {
+			var event core.Event
+			if err := json.Unmarshal([]byte(member), &event); err == nil && event.CreatedAt >= cutoff {
+				break
+			}
+			expired = append(expired, member)
+		}
+		if len(expired) == 0 {
+			return pruned, nil
+		}
+
+		if err := s.client.ZRem(ctx, key, expired...).Err(); err != nil {
+			return pruned, err
+		}
+		pruned += len(expired)
+		if len(expired) < len(members) || len(members) < pruneBatch {
+			return pruned, nil
+		}
+	}
+}
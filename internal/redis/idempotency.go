@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// pendingMarker is stored under a key while the first request is still running
+const pendingMarker = "pending"
+
+// StoredResponse is a response cached for an idempotency key
+type StoredResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// IdempotencyStore caches responses by idempotency key so retried requests
+// get the original response instead of being executed twice
+type IdempotencyStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewIdempotencyStore creates a new Redis-backed idempotency store
+func NewIdempotencyStore(client *redis.Client, prefix string, ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// Reserve claims a key for a new request. It returns the stored response if
+// the key was already completed, and acquired=false if the key is claimed by
+// a request that is still running.
+func (s *IdempotencyStore) Reserve(ctx context.Context, key string) (stored *StoredResponse, acquired bool, err error) {
+	acquired, err = s.client.SetNX(ctx, s.prefix+key, pendingMarker, s.ttl).Result()
+	if err != nil || acquired {
+		return nil, acquired, err
+	}
+
+	value, err := s.client.Get(ctx, s.prefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		// Expired between SETNX and GET, try again
+		return s.Reserve(ctx, key)
+	}
+	if err != nil || value == pendingMarker {
+		return nil, false, err
+	}
+
+	var response StoredResponse
+	if err := json.Unmarshal([]byte(value), &response); err != nil {
+		return nil, false, err
+	}
+	return &response, false, nil
+}
+
+// Complete stores the response for a reserved key
+func (s *IdempotencyStore) Complete(ctx context.Context, key string, response StoredResponse) error {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+key, payload, s.ttl).Err()
+}
+
+// Release frees a reserved key so the request can be retried
+func (s *IdempotencyStore) Release(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// raiseOffsets raises each channel field of KEYS[1] to the offset following
+// it in ARGV[2:], so concurrent polls never move a consumer backwards, and
+// refreshes the key's TTL (ARGV[1] milliseconds, 0 keeps it)
+var raiseOffsets = redis.NewScript(`
+for i = 2, #ARGV, 2 do
+	local current = tonumber(redis.call('HGET', KEYS[1], ARGV[i]) or '0')
+	if tonumber(ARGV[i + 1]) > current then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[i + 1])
+	end
+end
+if tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// OffsetStore remembers the next offset of every channel per consumer, so
+// clients may poll without tracking offsets themselves
+type OffsetStore struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// NewOffsetStore creates a store whose consumers are forgotten ttl after
+// their last delivery (0 keeps them)
+func NewOffsetStore(client *redis.Client, prefix string, ttl time.Duration) *OffsetStore {
+	return &OffsetStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+func (s *OffsetStore) key(consumer string) string {
+	return s.prefix + "offsets:" + consumer
+}
+
+// Load returns the stored offsets of a consumer for the given channels.
+// Channels the consumer never received are left out.
+func (s *OffsetStore) Load(ctx context.Context, consumer string, channels []string) (map[string]int64, error) {
+	values, err := s.client.HMGet(ctx, s.key(consumer), channels...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load offsets: %w", err)
+	}
+
+	offsets := make(map[string]int64, len(channels))
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		offset, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			continue
+		}
+		offsets[channels[i]] = offset
+	}
+	return offsets, nil
+}
+
+// Save records the next offsets delivered to a consumer
+func (s *OffsetStore) Save(ctx context.Context, consumer string, offsets map[string]int64) error {
+	args := make([]interface{}, 0, 1+2*len(offsets))
+	args = append(args, s.ttl.Milliseconds())
+	for channelID, offset := range offsets {
+		args = append(args, channelID, offset)
+	}
+
+	if err := raiseOffsets.Run(ctx, s.client, []string{s.key(consumer)}, args...).Err(); err != nil {
+		return fmt.Errorf("failed to save offsets: %w", err)
+	}
+	return nil
+}
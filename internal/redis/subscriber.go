@@ -3,9 +3,17 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"hash/fnv"
 	"log/slog"
+	"math/rand"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+	"github.com/levskiy0/go-laravel-long-polling/internal/metrics"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -14,71 +22,357 @@ type EventNotification struct {
 	ChannelID string `json:"channel_id"`
 	EventID   int64  `json:"event_id"`
 	Timestamp int64  `json:"timestamp"`
+
+	// Replay carries an event re-delivered by an admin. Pollers return it
+	// as-is instead of fetching from Laravel.
+	Replay *core.Event `json:"replay,omitempty"`
+	// Events carries events pushed by Laravel to /internal/events. Every
+	// instance buffers them so polls don't fetch them back from Laravel.
+	Events []core.Event `json:"events,omitempty"`
+	// TargetClientID restricts a replay to the pollers with this client ID
+	TargetClientID string `json:"target_client_id,omitempty"`
+	// Broadcast delivers the Replay event to every waiting poller,
+	// whatever its channel
+	Broadcast bool `json:"broadcast,omitempty"`
+	// ReconnectAfter asks local pollers to reconnect after about this many
+	// milliseconds; it is dispatched on shutdown and never published
+	ReconnectAfter int64 `json:"-"`
 }
 
+// Reconnect backoff bounds
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+// heartbeatInterval is how often the subscriber loop proves it isn't stuck
+const heartbeatInterval = 5 * time.Second
+
+var (
+	subscriberHealthy = metrics.NewGauge(
+		"longpoll_redis_subscriber_healthy",
+		"Whether the Redis notification subscription is established (1) or not (0).",
+	)
+	subscriberReconnects = metrics.NewCounter(
+		"longpoll_redis_subscriber_reconnects_total",
+		"Times the Redis notification subscription was re-established after a failure.",
+	)
+	fanoutDropped = metrics.NewCounter(
+		"longpoll_fanout_dropped_total",
+		"Notifications not delivered to pollers because their fan-out worker queue was full.",
+	)
+)
+
 // Subscriber manages Redis pub/sub subscriptions
 type Subscriber struct {
-	client   *redis.Client
-	channel  string
-	logger   *slog.Logger
-	handlers map[string][]chan EventNotification
-	mu       sync.RWMutex
-	cancel   context.CancelFunc
+	client        *redis.Client
+	channel       string
+	workers       int
+	queueSize     int
+	queues        []chan EventNotification
+	onNotify      func(EventNotification)
+	onStateChange func(healthy bool, err error)
+	deadLetters   *DeadLetters
+	logger        *slog.Logger
+	handlers      map[string][]chan EventNotification
+	shared        map[string]*SharedWake
+	sharedMu      sync.Mutex
+	mu            sync.RWMutex
+	cancel        context.CancelFunc
+	healthy       atomic.Bool
+	lastMessage   atomic.Int64
+	heartbeat     atomic.Int64
 }
 
-// NewSubscriber creates a new Redis subscriber
-func NewSubscriber(client *redis.Client, channel string, logger *slog.Logger) *Subscriber {
+// NewSubscriber creates a new Redis subscriber. Notifications are fanned out
+// by workers goroutines, each owning a queue of queueSize, so a hot channel
+// can't hold up the others; workers < 1 dispatches on the pub/sub goroutine.
+// onNotify, if set, observes every dispatched notification whether or not
+// anyone is polling. onStateChange, if set, is called when the subscription
+// goes up or down. Dropped notifications are recorded in deadLetters, which
+// may be nil.
+func NewSubscriber(
+	client *redis.Client,
+	channel string,
+	workers int,
+	queueSize int,
+	onNotify func(EventNotification),
+	onStateChange func(healthy bool, err error),
+	deadLetters *DeadLetters,
+	logger *slog.Logger,
+) *Subscriber {
 	return &Subscriber{
-		client:   client,
-		channel:  channel,
-		logger:   logger,
-		handlers: make(map[string][]chan EventNotification),
+		client:        client,
+		channel:       channel,
+		workers:       workers,
+		queueSize:     queueSize,
+		onNotify:      onNotify,
+		onStateChange: onStateChange,
+		deadLetters:   deadLetters,
+		logger:        logger,
+		handlers:      make(map[string][]chan EventNotification),
+		shared:        make(map[string]*SharedWake),
 	}
 }
 
-// Start begins listening for Redis pub/sub messages
-func (s *Subscriber) Start(ctx context.Context) error {
+// Run listens for Redis pub/sub messages and reconnects with jittered
+// exponential backoff until ctx is cancelled or Stop is called
+func (s *Subscriber) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
+	s.mu.Lock()
 	s.cancel = cancel
+	s.mu.Unlock()
+	defer cancel()
 
-	pubsub := s.client.Subscribe(ctx, s.channel)
-	defer pubsub.Close()
+	s.startWorkers(ctx)
+
+	backoff := minBackoff
+	for {
+		s.beat()
+		connected, err := s.listen(ctx)
+		s.setHealthy(false, err)
+		if ctx.Err() != nil {
+			s.logger.Info("Redis subscriber stopped")
+			return ctx.Err()
+		}
+
+		// A subscription that was established resets the backoff
+		if connected {
+			backoff = minBackoff
+		}
+		wait := jitter(backoff)
+		s.logger.Error("Redis subscriber disconnected, reconnecting",
+			"error", err,
+			"retry_in", wait,
+		)
 
-	s.logger.Info("Redis subscriber started", "channel", s.channel)
+		if err := s.sleep(ctx, wait); err != nil {
+			s.logger.Info("Redis subscriber stopped")
+			return err
+		}
+
+		subscriberReconnects.Inc()
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// listen runs a single subscription until it fails or ctx is done.
+// connected reports whether the subscription was confirmed.
+func (s *Subscriber) listen(ctx context.Context) (connected bool, err error) {
+	var pubsub *redis.PubSub
+	if s.isPattern() {
+		pubsub = s.client.PSubscribe(ctx, s.channel)
+	} else {
+		pubsub = s.client.Subscribe(ctx, s.channel)
+	}
+	defer pubsub.Close()
 
 	// Wait for subscription confirmation
 	if _, err := pubsub.Receive(ctx); err != nil {
-		return err
+		return false, err
 	}
 
+	s.logger.Info("Redis subscriber started", "channel", s.channel, "pattern", s.isPattern())
+	s.setHealthy(true, nil)
+
 	ch := pubsub.Channel()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 
 	for {
 		select {
+		case <-heartbeat.C:
+			s.beat()
 		case <-ctx.Done():
-			s.logger.Info("Redis subscriber stopped")
-			return ctx.Err()
+			return true, ctx.Err()
 		case msg, ok := <-ch:
 			if !ok {
 				// Channel closed - Redis connection lost
-				s.logger.Warn("Redis subscription channel closed, reconnection needed")
-				return nil
+				return true, errors.New("subscription channel closed")
 			}
 			if msg == nil {
 				continue
 			}
-			s.handleMessage(msg.Payload)
+			s.lastMessage.Store(time.Now().UnixNano())
+			s.handleMessage(msg.Channel, msg.Payload)
+		}
+	}
+}
+
+// startWorkers starts the fan-out workers, which exit when ctx is done
+func (s *Subscriber) startWorkers(ctx context.Context) {
+	if s.workers < 1 {
+		return
+	}
+
+	queues := make([]chan EventNotification, s.workers)
+	for i := range queues {
+		queues[i] = make(chan EventNotification, s.queueSize)
+		go func(queue <-chan EventNotification) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case notification := <-queue:
+					s.Dispatch(notification)
+				}
+			}
+		}(queues[i])
+	}
+
+	s.mu.Lock()
+	s.queues = queues
+	s.mu.Unlock()
+}
+
+// enqueue hands a notification to the worker owning its channel, keeping
+// the notifications of a channel in order. The notification is dropped if
+// that worker is backed up, rather than stalling every other channel, but
+// onNotify still observes it so pushed events reach the push buffer.
+func (s *Subscriber) enqueue(notification EventNotification) {
+	s.mu.RLock()
+	queues := s.queues
+	s.mu.RUnlock()
+
+	if len(queues) == 0 {
+		s.Dispatch(notification)
+		return
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(notification.ChannelID))
+	select {
+	case queues[h.Sum32()%uint32(len(queues))] <- notification:
+	default:
+		if s.onNotify != nil && !notification.Broadcast {
+			s.onNotify(notification)
+		}
+		fanoutDropped.Inc()
+		s.logger.Warn("fan-out queue is full, dropping notification",
+			"channel_id", notification.ChannelID,
+			"event_id", notification.EventID,
+		)
+		s.deadLetters.Record(notification, DeadLetterQueueFull)
+	}
+}
+
+// sleep waits for d while keeping the heartbeat going
+func (s *Subscriber) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return nil
+		case <-heartbeat.C:
+			s.beat()
 		}
 	}
 }
 
+func (s *Subscriber) beat() {
+	s.heartbeat.Store(time.Now().UnixNano())
+}
+
+// Alive reports whether the subscriber loop has made progress recently,
+// whether or not Redis is reachable. A stuck loop stops reporting alive.
+func (s *Subscriber) Alive() bool {
+	last := s.heartbeat.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < 3*heartbeatInterval
+}
+
 // Stop gracefully stops the subscriber
 func (s *Subscriber) Stop() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.cancel != nil {
 		s.cancel()
 	}
 }
 
+// Healthy reports whether the subscription is currently established
+func (s *Subscriber) Healthy() bool {
+	return s.healthy.Load()
+}
+
+// LastMessageAt returns when the last pub/sub message was received, or the
+// zero time if none was
+func (s *Subscriber) LastMessageAt() time.Time {
+	nanos := s.lastMessage.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// setHealthy records the subscription state and reports changes
+func (s *Subscriber) setHealthy(healthy bool, err error) {
+	if s.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		subscriberHealthy.Set(1)
+	} else {
+		subscriberHealthy.Set(0)
+	}
+	if s.onStateChange != nil {
+		s.onStateChange(healthy, err)
+	}
+}
+
+// jitter returns a random duration in [d/2, d)
+func jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// Publish sends a notification to the pollers on every instance
+func (s *Subscriber) Publish(ctx context.Context, notification EventNotification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	return s.client.Publish(ctx, s.publishChannel(notification.ChannelID), payload).Err()
+}
+
+// isPattern reports whether the configured channel is a PSUBSCRIBE pattern
+func (s *Subscriber) isPattern() bool {
+	return strings.ContainsAny(s.channel, "*?[")
+}
+
+// publishChannel returns the Redis channel notifications for channelID are
+// published on. With a pattern like "longpoll:events:*" the channel ID takes
+// the place of the wildcard.
+func (s *Subscriber) publishChannel(channelID string) string {
+	if !s.isPattern() {
+		return s.channel
+	}
+	return strings.Replace(s.channel, "*", channelID, 1)
+}
+
+// channelSuffix returns the part of a Redis channel matched by the pattern's
+// wildcard, e.g. "orders" for "longpoll:events:orders"
+func (s *Subscriber) channelSuffix(redisChannel string) string {
+	i := strings.IndexAny(s.channel, "*?[")
+	if i < 0 || !strings.HasPrefix(redisChannel, s.channel[:i]) {
+		return ""
+	}
+	suffix := redisChannel[i:]
+	if tail := s.channel[i+1:]; s.channel[i] == '*' && !strings.ContainsAny(tail, "*?[") {
+		suffix = strings.TrimSuffix(suffix, tail)
+	}
+	return suffix
+}
+
 // Subscribe registers a channel to receive notifications for a specific channel ID
 func (s *Subscriber) Subscribe(channelID string) chan EventNotification {
 	s.mu.Lock()
@@ -86,12 +380,81 @@ func (s *Subscriber) Subscribe(channelID string) chan EventNotification {
 
 	ch := make(chan EventNotification, 10)
 	s.handlers[channelID] = append(s.handlers[channelID], ch)
+	s.deadLetters.Polled(channelID)
 
 	s.logger.Debug("subscribed to channel", "channel_id", channelID)
 
 	return ch
 }
 
+// TrySubscribe is like Subscribe but fails when the channel already has max
+// subscribers. max < 1 means no limit.
+func (s *Subscriber) TrySubscribe(channelID string, max int) (chan EventNotification, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if max > 0 && len(s.handlers[channelID]) >= max {
+		return nil, false
+	}
+
+	ch := make(chan EventNotification, 10)
+	s.handlers[channelID] = append(s.handlers[channelID], ch)
+	s.deadLetters.Polled(channelID)
+	return ch, true
+}
+
+// Subscriptions returns how many channels have pollers subscribed on this
+// instance and how many notification handlers they hold in total
+func (s *Subscriber) Subscriptions() (channels, handlers int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, channelHandlers := range s.handlers {
+		handlers += len(channelHandlers)
+	}
+	return len(s.handlers), handlers
+}
+
+// SharedWake wakes every poll coalesced onto a channel with a single close
+type SharedWake struct {
+	done         chan struct{}
+	notification EventNotification
+}
+
+// Done is closed by the next notification for the channel, broadcasts
+// included
+func (w *SharedWake) Done() <-chan struct{} {
+	return w.done
+}
+
+// Notification returns the notification that closed Done
+func (w *SharedWake) Notification() EventNotification {
+	<-w.done
+	return w.notification
+}
+
+// wake hands the notification to every waiter; sharedMu must be held
+func (w *SharedWake) wake(notification EventNotification) {
+	w.notification = notification
+	close(w.done)
+}
+
+// WaitShared returns the wake-up of the next notification for channelID.
+// Every caller waiting on a channel gets the same one, so waking them costs
+// a single close however many there are.
+func (s *Subscriber) WaitShared(channelID string) *SharedWake {
+	s.sharedMu.Lock()
+	defer s.sharedMu.Unlock()
+
+	wake, ok := s.shared[channelID]
+	if !ok {
+		wake = &SharedWake{done: make(chan struct{})}
+		s.shared[channelID] = wake
+	}
+	s.deadLetters.Polled(channelID)
+	return wake
+}
+
 // Unsubscribe removes a notification channel
 func (s *Subscriber) Unsubscribe(channelID string, ch chan EventNotification) {
 	s.mu.Lock()
@@ -109,34 +472,101 @@ func (s *Subscriber) Unsubscribe(channelID string, ch chan EventNotification) {
 	if len(s.handlers[channelID]) == 0 {
 		delete(s.handlers, channelID)
 	}
+	s.deadLetters.Polled(channelID)
 
 	s.logger.Debug("unsubscribed from channel", "channel_id", channelID)
 }
 
-// handleMessage processes an incoming Redis message
-func (s *Subscriber) handleMessage(payload string) {
+// broadcast hands a notification to every poller of every channel, including
+// the polls coalesced onto a shared wake-up
+func (s *Subscriber) broadcast(notification EventNotification) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.sharedMu.Lock()
+	for channelID, wake := range s.shared {
+		notification.ChannelID = channelID
+		wake.wake(notification)
+		delete(s.shared, channelID)
+	}
+	s.sharedMu.Unlock()
+
+	for channelID, handlers := range s.handlers {
+		notification.ChannelID = channelID
+		for _, handler := range handlers {
+			select {
+			case handler <- notification:
+			default:
+				s.logger.Warn("notification channel is full", "channel_id", channelID)
+			}
+		}
+	}
+}
+
+// handleMessage processes an incoming Redis message. With a pattern
+// subscription, notifications without a channel_id take it from the suffix
+// of the Redis channel they were published on.
+func (s *Subscriber) handleMessage(redisChannel, payload string) {
 	var notification EventNotification
 	if err := json.Unmarshal([]byte(payload), &notification); err != nil {
 		s.logger.Error("failed to parse notification", "error", err, "payload", payload)
 		return
 	}
+	if notification.Broadcast {
+		notification.ChannelID = ""
+		s.enqueue(notification)
+		return
+	}
+	if notification.ChannelID == "" && s.isPattern() {
+		notification.ChannelID = s.channelSuffix(redisChannel)
+	}
+	if notification.ChannelID == "" {
+		s.logger.Warn("notification without channel_id", "redis_channel", redisChannel)
+		return
+	}
 
 	s.logger.Debug("received notification",
 		"channel_id", notification.ChannelID,
 		"event_id", notification.EventID,
 	)
 
+	s.enqueue(notification)
+}
+
+// Dispatch delivers a notification to the local pollers of its channel
+func (s *Subscriber) Dispatch(notification EventNotification) {
+	if notification.Broadcast {
+		s.broadcast(notification)
+		return
+	}
+
+	if s.onNotify != nil {
+		s.onNotify(notification)
+	}
+
 	// Hold RLock for the entire duration to prevent channels from being closed
 	// while we're sending to them. This is safe because send with default doesn't block.
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	s.sharedMu.Lock()
+	wake, waiting := s.shared[notification.ChannelID]
+	if waiting {
+		wake.wake(notification)
+		delete(s.shared, notification.ChannelID)
+	}
+	s.sharedMu.Unlock()
+
 	handlers := s.handlers[notification.ChannelID]
+	if !waiting && len(handlers) == 0 && s.deadLetters.Idle(notification.ChannelID) {
+		s.deadLetters.Record(notification, DeadLetterNoPoller)
+	}
 	for _, handler := range handlers {
 		select {
 		case handler <- notification:
 		default:
 			s.logger.Warn("notification channel is full", "channel_id", notification.ChannelID)
+			s.deadLetters.Record(notification, DeadLetterPollerFull)
 		}
 	}
 }
@@ -0,0 +1,72 @@
+package redis
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+func newTestSubscriber() *Subscriber {
+	return NewSubscriber(nil, "test", 0, 0, nil, nil, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func requireWoken(t *testing.T, wake *SharedWake) EventNotification {
+	t.Helper()
+
+	select {
+	case <-wake.Done():
+		return wake.Notification()
+	case <-time.After(time.Second):
+		t.Fatal("shared wake-up was not closed")
+		return EventNotification{}
+	}
+}
+
+func TestSharedWakeCarriesNotification(t *testing.T) {
+	s := newTestSubscriber()
+	wake := s.WaitShared("orders.1")
+	if other := s.WaitShared("orders.1"); other != wake {
+		t.Fatal("waiters of one channel should share a wake-up")
+	}
+
+	s.Dispatch(EventNotification{ChannelID: "orders.1", EventID: 7})
+
+	if got := requireWoken(t, wake); got.EventID != 7 {
+		t.Fatalf("expected event 7, got %d", got.EventID)
+	}
+	if next := s.WaitShared("orders.1"); next == wake {
+		t.Fatal("a used wake-up should be replaced")
+	}
+}
+
+func TestBroadcastWakesCoalescedPolls(t *testing.T) {
+	s := newTestSubscriber()
+	orders := s.WaitShared("orders.1")
+	users := s.WaitShared("users.1")
+
+	s.Dispatch(EventNotification{
+		Broadcast: true,
+		Replay:    &core.Event{Event: map[string]interface{}{"type": "maintenance"}},
+	})
+
+	for channelID, wake := range map[string]*SharedWake{"orders.1": orders, "users.1": users} {
+		got := requireWoken(t, wake)
+		if !got.Broadcast || got.Replay == nil || got.ChannelID != channelID {
+			t.Fatalf("%s: expected the broadcast for the channel, got %+v", channelID, got)
+		}
+	}
+}
+
+func TestDrainReachesCoalescedPolls(t *testing.T) {
+	s := newTestSubscriber()
+	wake := s.WaitShared("orders.1")
+
+	s.Dispatch(EventNotification{Broadcast: true, ReconnectAfter: 500})
+
+	if got := requireWoken(t, wake); got.ReconnectAfter != 500 {
+		t.Fatalf("expected a reconnect after 500ms, got %+v", got)
+	}
+}
@@ -0,0 +1,348 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys holding the persisted state, relative to the key prefix
+const (
+	bannedKey        = "bans"
+	channelRevokeKey = "revocations:channels"
+	tokenRevokeKey   = "revocations:tokens"
+	maintenanceKey   = "maintenance"
+)
+
+// Control actions published on the control channel
+const (
+	ActionBan           = "ban"
+	ActionUnban         = "unban"
+	ActionRevokeChannel = "revoke_channel"
+	ActionRevokeToken   = "revoke_token"
+	ActionMaintenance   = "maintenance"
+	ActionResume        = "resume"
+)
+
+// resyncInterval is how often the full state is reloaded from Redis to recover
+// from control messages missed while disconnected
+const resyncInterval = time.Minute
+
+// ControlMessage is published to every instance when the state changes
+type ControlMessage struct {
+	Action    string `json:"action"`
+	ChannelID string `json:"channel_id,omitempty"`
+	TokenID   string `json:"token_id,omitempty"`
+	// Timestamp is in Unix seconds, except for ActionRevokeChannel where it
+	// is the revocation time in Unix milliseconds
+	Timestamp int64 `json:"timestamp"`
+
+	// Maintenance carries the settings of ActionMaintenance
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+// Maintenance describes an active maintenance window
+type Maintenance struct {
+	// RetryAfter is the delay suggested to clients, in seconds
+	RetryAfter int    `json:"retry_after"`
+	Message    string `json:"message,omitempty"`
+	Since      int64  `json:"since"`
+}
+
+// Registry keeps banned channels and revoked tokens. Changes are persisted in
+// Redis and propagated to all instances over a control channel.
+type Registry struct {
+	client         *redis.Client
+	keyPrefix      string
+	controlChannel string
+	logger         *slog.Logger
+
+	mu             sync.RWMutex
+	banned         map[string]struct{}
+	channelRevokes map[string]int64 // revocation time in Unix milliseconds
+	tokenRevokes   map[string]int64
+	maintenance    *Maintenance
+	cancel         context.CancelFunc
+}
+
+// NewRegistry creates a new revocation registry keeping its state under
+// keyPrefix (e.g. "longpoll:")
+func NewRegistry(client *redis.Client, keyPrefix, controlChannel string, logger *slog.Logger) *Registry {
+	return &Registry{
+		client:         client,
+		keyPrefix:      keyPrefix,
+		controlChannel: controlChannel,
+		logger:         logger,
+		banned:         make(map[string]struct{}),
+		channelRevokes: make(map[string]int64),
+		tokenRevokes:   make(map[string]int64),
+	}
+}
+
+// IsBanned reports whether a channel is banned
+func (r *Registry) IsBanned(channelID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.banned[channelID]
+	return ok
+}
+
+// IsRevoked reports whether a token was revoked, either individually by its ID
+// or because tokens for the channel issued before a point in time were revoked
+func (r *Registry) IsRevoked(tokenID, channelID string, issuedAt time.Time) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.tokenRevokes[tokenID]; ok && tokenID != "" {
+		return true
+	}
+	if revokedAt, ok := r.channelRevokes[channelID]; ok && issuedAt.UnixMilli() <= revokedAt {
+		return true
+	}
+	return false
+}
+
+// Ban bans a channel on every instance
+func (r *Registry) Ban(ctx context.Context, channelID string) error {
+	now := time.Now().Unix()
+	if err := r.client.HSet(ctx, r.keyPrefix+bannedKey, channelID, now).Err(); err != nil {
+		return err
+	}
+	return r.publish(ctx, ControlMessage{Action: ActionBan, ChannelID: channelID, Timestamp: now})
+}
+
+// Unban lifts a channel ban on every instance
+func (r *Registry) Unban(ctx context.Context, channelID string) error {
+	if err := r.client.HDel(ctx, r.keyPrefix+bannedKey, channelID).Err(); err != nil {
+		return err
+	}
+	return r.publish(ctx, ControlMessage{Action: ActionUnban, ChannelID: channelID, Timestamp: time.Now().Unix()})
+}
+
+// RevokeChannel revokes every token for a channel issued up to now
+func (r *Registry) RevokeChannel(ctx context.Context, channelID string) error {
+	now := time.Now().UnixMilli()
+	if err := r.client.HSet(ctx, r.keyPrefix+channelRevokeKey, channelID, now).Err(); err != nil {
+		return err
+	}
+	return r.publish(ctx, ControlMessage{Action: ActionRevokeChannel, ChannelID: channelID, Timestamp: now})
+}
+
+// RevokeToken revokes a single token by its ID until it expires
+func (r *Registry) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if err := r.client.HSet(ctx, r.keyPrefix+tokenRevokeKey, tokenID, expiresAt.Unix()).Err(); err != nil {
+		return err
+	}
+	return r.publish(ctx, ControlMessage{Action: ActionRevokeToken, TokenID: tokenID, Timestamp: expiresAt.Unix()})
+}
+
+// Maintenance returns the active maintenance window, if any
+func (r *Registry) Maintenance() (Maintenance, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.maintenance == nil {
+		return Maintenance{}, false
+	}
+	return *r.maintenance, true
+}
+
+// StartMaintenance puts every instance in maintenance mode until Resume
+func (r *Registry) StartMaintenance(ctx context.Context, retryAfter time.Duration, message string) error {
+	now := time.Now().Unix()
+	maintenance := &Maintenance{
+		RetryAfter: int(retryAfter.Seconds()),
+		Message:    message,
+		Since:      now,
+	}
+	payload, err := json.Marshal(maintenance)
+	if err != nil {
+		return err
+	}
+	if err := r.client.Set(ctx, r.keyPrefix+maintenanceKey, payload, 0).Err(); err != nil {
+		return err
+	}
+	return r.publish(ctx, ControlMessage{Action: ActionMaintenance, Maintenance: maintenance, Timestamp: now})
+}
+
+// Resume ends maintenance mode on every instance
+func (r *Registry) Resume(ctx context.Context) error {
+	if err := r.client.Del(ctx, r.keyPrefix+maintenanceKey).Err(); err != nil {
+		return err
+	}
+	return r.publish(ctx, ControlMessage{Action: ActionResume, Timestamp: time.Now().Unix()})
+}
+
+// Start loads the persisted state and applies control messages until ctx is done
+func (r *Registry) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+
+	if err := r.load(ctx); err != nil {
+		r.logger.Error("failed to load revocation state", "error", err)
+	}
+
+	pubsub := r.client.Subscribe(ctx, r.controlChannel)
+	defer pubsub.Close()
+
+	r.logger.Info("revocation registry started", "channel", r.controlChannel)
+
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.load(ctx); err != nil {
+				r.logger.Error("failed to reload revocation state", "error", err)
+			}
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			r.handleMessage(msg.Payload)
+		}
+	}
+}
+
+// Stop stops listening for control messages
+func (r *Registry) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+func (r *Registry) publish(ctx context.Context, msg ControlMessage) error {
+	r.apply(msg)
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, r.controlChannel, payload).Err()
+}
+
+func (r *Registry) handleMessage(payload string) {
+	var msg ControlMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		r.logger.Error("failed to parse control message", "error", err, "payload", payload)
+		return
+	}
+
+	r.logger.Info("control message received",
+		"action", msg.Action,
+		"channel_id", msg.ChannelID,
+		"token_id", msg.TokenID,
+	)
+	r.apply(msg)
+}
+
+func (r *Registry) apply(msg ControlMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch msg.Action {
+	case ActionBan:
+		r.banned[msg.ChannelID] = struct{}{}
+	case ActionUnban:
+		delete(r.banned, msg.ChannelID)
+	case ActionRevokeChannel:
+		r.channelRevokes[msg.ChannelID] = revokedAtMillis(msg.Timestamp)
+	case ActionRevokeToken:
+		r.tokenRevokes[msg.TokenID] = msg.Timestamp
+	case ActionMaintenance:
+		r.maintenance = msg.Maintenance
+	case ActionResume:
+		r.maintenance = nil
+	}
+}
+
+// load replaces the in-memory state with the persisted one, dropping
+// revocations of tokens that have already expired
+func (r *Registry) load(ctx context.Context) error {
+	bans, err := r.client.HGetAll(ctx, r.keyPrefix+bannedKey).Result()
+	if err != nil {
+		return err
+	}
+	channelRevokes, err := r.client.HGetAll(ctx, r.keyPrefix+channelRevokeKey).Result()
+	if err != nil {
+		return err
+	}
+	tokenRevokes, err := r.client.HGetAll(ctx, r.keyPrefix+tokenRevokeKey).Result()
+	if err != nil {
+		return err
+	}
+
+	var maintenance *Maintenance
+	value, err := r.client.Get(ctx, r.keyPrefix+maintenanceKey).Result()
+	switch {
+	case err == nil:
+		maintenance = &Maintenance{}
+		if err := json.Unmarshal([]byte(value), maintenance); err != nil {
+			r.logger.Error("invalid maintenance state", "error", err)
+			maintenance = nil
+		}
+	case !errors.Is(err, redis.Nil):
+		return err
+	}
+
+	banned := make(map[string]struct{}, len(bans))
+	for channelID := range bans {
+		banned[channelID] = struct{}{}
+	}
+
+	channels := make(map[string]int64, len(channelRevokes))
+	for channelID, value := range channelRevokes {
+		revokedAt, _ := strconv.ParseInt(value, 10, 64)
+		channels[channelID] = revokedAtMillis(revokedAt)
+	}
+
+	now := time.Now().Unix()
+	tokens := make(map[string]int64, len(tokenRevokes))
+	var expired []string
+	for tokenID, value := range tokenRevokes {
+		expiresAt, _ := strconv.ParseInt(value, 10, 64)
+		if expiresAt < now {
+			expired = append(expired, tokenID)
+			continue
+		}
+		tokens[tokenID] = expiresAt
+	}
+	if len(expired) > 0 {
+		if err := r.client.HDel(ctx, r.keyPrefix+tokenRevokeKey, expired...).Err(); err != nil {
+			r.logger.Warn("failed to prune expired token revocations", "error", err)
+		}
+	}
+
+	r.mu.Lock()
+	r.banned = banned
+	r.channelRevokes = channels
+	r.tokenRevokes = tokens
+	r.maintenance = maintenance
+	r.mu.Unlock()
+
+	return nil
+}
+
+// legacyRevocationLimit separates revocation times stored in Unix seconds,
+// before they carried milliseconds, from those in Unix milliseconds
+const legacyRevocationLimit = 1e12
+
+// revokedAtMillis returns a channel revocation time in Unix milliseconds. A
+// time in seconds covers its whole second, as it did when it was written.
+func revokedAtMillis(revokedAt int64) int64 {
+	if revokedAt < legacyRevocationLimit {
+		return revokedAt*1000 + 999
+	}
+	return revokedAt
+}
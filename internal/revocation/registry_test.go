@@ -0,0 +1,63 @@
+package revocation
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRegistry(t *testing.T) (*Registry, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewRegistry(client, "test:", "test:control", slog.New(slog.NewTextHandler(io.Discard, nil))), client
+}
+
+func TestRevokeChannelWithinTheSameSecond(t *testing.T) {
+	registry, _ := newTestRegistry(t)
+
+	before := time.Now()
+	if err := registry.RevokeChannel(context.Background(), "orders.1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	after := time.Now()
+
+	if !registry.IsRevoked("", "orders.1", before) {
+		t.Fatal("a token issued before the revocation should be revoked")
+	}
+	if registry.IsRevoked("", "orders.1", after) {
+		t.Fatal("a token issued after the revocation should stay valid")
+	}
+	if registry.IsRevoked("", "orders.2", before) {
+		t.Fatal("other channels should be unaffected")
+	}
+}
+
+func TestLoadKeepsRevocationsInSeconds(t *testing.T) {
+	registry, client := newTestRegistry(t)
+
+	revokedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	ctx := context.Background()
+	if err := client.HSet(ctx, "test:"+channelRevokeKey, "orders.1", strconv.FormatInt(revokedAt.Unix(), 10)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.load(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !registry.IsRevoked("", "orders.1", revokedAt.Add(500*time.Millisecond)) {
+		t.Fatal("a revocation in seconds should cover its whole second")
+	}
+	if registry.IsRevoked("", "orders.1", revokedAt.Add(time.Second)) {
+		t.Fatal("a token issued after the revoked second should stay valid")
+	}
+}
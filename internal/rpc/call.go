@@ -0,0 +1,262 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	longpollv1 "github.com/levskiy0/go-laravel-long-polling/api/longpoll/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// routedParam keeps CHANNEL_AFFINITY from redirecting the call: a gRPC
+// client can't follow a redirect, so the poll is served where it lands
+const routedParam = "routed"
+
+// apiError is the error object of an HTTP error response
+type apiError struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+}
+
+// callError is a failed call, as a gRPC status carrying the API error code
+type callError struct {
+	code    string
+	retry   int
+	wrapped error
+}
+
+func (e *callError) Error() string {
+	return e.wrapped.Error()
+}
+
+// GRPCStatus lets status.FromError see through callError
+func (e *callError) GRPCStatus() *status.Status {
+	s, _ := status.FromError(e.wrapped)
+	return s
+}
+
+// errorTrailer passes the API error code, and the delay for retryable
+// errors, to the client in the trailer
+func errorTrailer(err error) metadata.MD {
+	var callErr *callError
+	if !errors.As(err, &callErr) || callErr.code == "" {
+		return nil
+	}
+	md := metadata.Pairs("error-code", callErr.code)
+	if callErr.retry > 0 {
+		md.Set("retry-after", strconv.Itoa(callErr.retry))
+	}
+	return md
+}
+
+// call serves POST path through the HTTP handler and decodes the JSON
+// response into out
+func (s *Server) call(ctx context.Context, path string, query url.Values, body interface{}, out interface{}) error {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set(routedParam, "1")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.basePath+path+"?"+query.Encode(), reader)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to build request: %v", err)
+	}
+	req.Header = incomingHeader(ctx)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+	}
+
+	resp := &responseBuffer{header: make(http.Header)}
+	s.handler.ServeHTTP(resp, req)
+
+	var envelope struct {
+		Error *apiError `json:"error"`
+	}
+	raw := resp.body.Bytes()
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return status.Errorf(statusCode(resp.status), "unexpected %d response", resp.status)
+	}
+	if envelope.Error != nil {
+		return &callError{
+			code:    envelope.Error.Code,
+			retry:   envelope.Error.RetryAfter,
+			wrapped: status.Error(statusCode(resp.status), envelope.Error.Message),
+		}
+	}
+	if resp.status >= http.StatusMultipleChoices {
+		return status.Errorf(statusCode(resp.status), "unexpected %d response", resp.status)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return status.Errorf(codes.Internal, "failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// statusCode maps the status of an HTTP response to a gRPC code. Server
+// errors, the redirects of a draining instance and the errors a poll reports
+// with status 200 after its keep-alive bytes are all worth retrying.
+func statusCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	default:
+		return codes.Unavailable
+	}
+}
+
+// accessTokenQuery builds the query of POST /getAccessToken
+func accessTokenQuery(in *longpollv1.GetAccessTokenRequest) url.Values {
+	query := url.Values{"channel_id": in.GetChannelIds()}
+	if secret := in.GetSecret(); secret != "" {
+		query.Set("secret", secret)
+	}
+	return query
+}
+
+// updatesBody is the body of POST /getUpdates
+type updatesBody struct {
+	Token    string           `json:"token,omitempty"`
+	Channels []string         `json:"channels,omitempty"`
+	Offset   int64            `json:"offset,omitempty"`
+	Offsets  map[string]int64 `json:"offsets,omitempty"`
+	Limit    int32            `json:"limit,omitempty"`
+	Cursor   string           `json:"cursor,omitempty"`
+	ClientID string           `json:"client_id,omitempty"`
+}
+
+func newUpdatesBody(in *longpollv1.GetUpdatesRequest) *updatesBody {
+	return &updatesBody{
+		Token:    in.GetToken(),
+		Channels: in.GetChannels(),
+		Offset:   in.GetOffset(),
+		Offsets:  in.GetOffsets(),
+		Limit:    in.GetLimit(),
+		Cursor:   in.GetCursor(),
+		ClientID: in.GetClientId(),
+	}
+}
+
+// advance moves the body past the events of resp. The channels a cursor
+// named are kept once the cursor is replaced by offsets.
+func (b *updatesBody) advance(resp *updatesResponse) {
+	b.Offset = resp.NextOffset
+	b.Offsets = resp.NextOffsets
+	if len(resp.NextOffsets) > 0 {
+		if len(b.Channels) == 0 {
+			for channelID := range resp.NextOffsets {
+				b.Channels = append(b.Channels, channelID)
+			}
+			sort.Strings(b.Channels)
+		}
+	}
+	b.Cursor = ""
+}
+
+// updatesResponse is the response of POST /getUpdates
+type updatesResponse struct {
+	Events []struct {
+		ID        int64           `json:"id"`
+		ChannelID string          `json:"channel_id"`
+		Event     json.RawMessage `json:"event"`
+		CreatedAt int64           `json:"created_at"`
+	} `json:"events"`
+	NextOffset  int64            `json:"next_offset"`
+	NextOffsets map[string]int64 `json:"next_offsets"`
+	NextCursor  string           `json:"next_cursor"`
+}
+
+func (s *Server) poll(ctx context.Context, body *updatesBody) (*updatesResponse, error) {
+	var resp updatesResponse
+	if err := s.call(ctx, "/getUpdates", nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// reconnect reports whether the response asks the client to reconnect
+func (r *updatesResponse) reconnect() bool {
+	for _, event := range r.Events {
+		if event.ID != 0 {
+			continue
+		}
+		var payload struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(event.Event, &payload) == nil && payload.Type == "reconnect" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *updatesResponse) message() *longpollv1.GetUpdatesResponse {
+	events := make([]*longpollv1.Event, 0, len(r.Events))
+	for _, event := range r.Events {
+		events = append(events, &longpollv1.Event{
+			Id:        event.ID,
+			ChannelId: event.ChannelID,
+			Event:     event.Event,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	return &longpollv1.GetUpdatesResponse{
+		Events:      events,
+		NextOffset:  r.NextOffset,
+		NextOffsets: r.NextOffsets,
+		NextCursor:  r.NextCursor,
+	}
+}
+
+// responseBuffer holds a response of the HTTP handler in memory. Flush does
+// nothing: keep-alive bytes have no connection to keep alive here.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *responseBuffer) Write(data []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(data)
+}
+
+func (b *responseBuffer) Flush() {}
@@ -0,0 +1,175 @@
+// Package rpc serves the gRPC API of api/longpoll/v1 on GRPC_ADDR.
+//
+// Every call is handed to the HTTP handler in-process, as POST
+// /getAccessToken or POST /getUpdates, so tokens and polls go through the
+// same authorization, subscriber and upstream code as HTTP clients and show
+// up in the same access log and metrics. Only the network round trips of
+// HTTP polling are saved: StreamUpdates keeps polling on the server and
+// sends each batch as it arrives.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	longpollv1 "github.com/levskiy0/go-laravel-long-polling/api/longpoll/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type Server struct {
+	longpollv1.UnimplementedLongPollServer
+
+	addr       string
+	handler    http.Handler
+	basePath   string
+	grpcServer *grpc.Server
+	logger     *slog.Logger
+}
+
+// NewServer creates a gRPC server on addr serving the RPCs through handler,
+// whose routes are under basePath
+func NewServer(addr string, handler http.Handler, basePath string, logger *slog.Logger) *Server {
+	s := &Server{
+		addr:       addr,
+		handler:    handler,
+		basePath:   strings.TrimRight(basePath, "/"),
+		grpcServer: grpc.NewServer(),
+		logger:     logger,
+	}
+	longpollv1.RegisterLongPollServer(s.grpcServer, s)
+	return s
+}
+
+// Start listens on the address and serves until Stop is called
+func (s *Server) Start() error {
+	s.logger.Info("starting gRPC server", "addr", s.addr)
+
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to start gRPC server: %w", err)
+	}
+	if err := s.grpcServer.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("failed to start gRPC server: %w", err)
+	}
+	return nil
+}
+
+// Stop waits for the running calls to end, and cuts them off once ctx is
+// done. Streams end on their own once the service drains.
+func (s *Server) Stop(ctx context.Context) error {
+	s.logger.Info("stopping gRPC server")
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpcServer.Stop()
+		return ctx.Err()
+	}
+}
+
+// GetAccessToken issues a token like POST /getAccessToken
+func (s *Server) GetAccessToken(ctx context.Context, in *longpollv1.GetAccessTokenRequest) (*longpollv1.GetAccessTokenResponse, error) {
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := s.call(ctx, "/getAccessToken", accessTokenQuery(in), nil, &out); err != nil {
+		_ = grpc.SetTrailer(ctx, errorTrailer(err))
+		return nil, err
+	}
+	return &longpollv1.GetAccessTokenResponse{Token: out.Token}, nil
+}
+
+// GetUpdates holds one poll like POST /getUpdates
+func (s *Server) GetUpdates(ctx context.Context, in *longpollv1.GetUpdatesRequest) (*longpollv1.GetUpdatesResponse, error) {
+	resp, err := s.poll(ctx, newUpdatesBody(in))
+	if err != nil {
+		_ = grpc.SetTrailer(ctx, errorTrailer(err))
+		return nil, err
+	}
+	return resp.message(), nil
+}
+
+// StreamUpdates polls until the client goes away, sending every non-empty
+// batch. Each poll resumes from the offsets the previous one delivered. When
+// the server asks clients to reconnect, e.g. while the instance drains, the
+// reconnect event is sent and the stream ends with Unavailable, so the
+// client reconnects after retry_after_ms, to another instance if need be.
+func (s *Server) StreamUpdates(in *longpollv1.GetUpdatesRequest, stream longpollv1.LongPoll_StreamUpdatesServer) error {
+	ctx := stream.Context()
+	body := newUpdatesBody(in)
+
+	for {
+		resp, err := s.poll(ctx, body)
+		if err != nil {
+			stream.SetTrailer(errorTrailer(err))
+			return err
+		}
+
+		if len(resp.Events) > 0 {
+			if err := stream.Send(resp.message()); err != nil {
+				return err
+			}
+		}
+		if resp.reconnect() {
+			return status.Error(codes.Unavailable, "reconnect requested")
+		}
+
+		body.advance(resp)
+
+		// Polls answer empty at once in degraded short mode, so an empty
+		// answer is not polled again right away
+		if len(resp.Events) == 0 {
+			select {
+			case <-ctx.Done():
+				return status.FromContextError(ctx.Err()).Err()
+			case <-time.After(emptyPollDelay):
+			}
+		}
+	}
+}
+
+// emptyPollDelay spaces out polls of a stream that returned nothing
+const emptyPollDelay = 100 * time.Millisecond
+
+// forwardedMetadata are the metadata keys passed on to the HTTP handler as
+// headers: credentials for private channels and API keys, and request
+// correlation
+var forwardedMetadata = []string{
+	"authorization",
+	"cookie",
+	"x-api-key",
+	"x-request-id",
+	"traceparent",
+	"tracestate",
+	"x-forwarded-for",
+	"x-real-ip",
+	"user-agent",
+}
+
+// incomingHeader builds the request headers from the call's metadata
+func incomingHeader(ctx context.Context) http.Header {
+	header := make(http.Header)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range forwardedMetadata {
+		for _, value := range md.Get(key) {
+			header.Add(key, value)
+		}
+	}
+	return header
+}
@@ -0,0 +1,155 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	longpollv1 "github.com/levskiy0/go-laravel-long-polling/api/longpoll/v1"
+	"github.com/levskiy0/go-laravel-long-polling/internal/rpc"
+	"github.com/levskiy0/go-laravel-long-polling/pkg/longpolltest/harness"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newClient serves the stack's handler over an in-memory gRPC connection
+func newClient(t *testing.T, stack *harness.Stack) longpollv1.LongPollClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	longpollv1.RegisterLongPollServer(server, rpc.NewServer("", stack.Handler, "", slog.New(slog.NewTextHandler(io.Discard, nil))))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return longpollv1.NewLongPollClient(conn)
+}
+
+func newStack(t *testing.T) *harness.Stack {
+	t.Helper()
+
+	stack, err := harness.NewStack(harness.Options{PollTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stack.Close)
+	return stack
+}
+
+func requireIDs(t *testing.T, resp *longpollv1.GetUpdatesResponse, ids ...int64) {
+	t.Helper()
+
+	if len(resp.Events) != len(ids) {
+		t.Fatalf("expected events %v, got %d events", ids, len(resp.Events))
+	}
+	for i, event := range resp.Events {
+		if event.Id != ids[i] {
+			t.Fatalf("expected events %v, got ID %d at %d", ids, event.Id, i)
+		}
+	}
+}
+
+func TestGetAccessTokenAndUpdates(t *testing.T) {
+	stack := newStack(t)
+	client := newClient(t, stack)
+	ctx := context.Background()
+
+	issued, err := client.GetAccessToken(ctx, &longpollv1.GetAccessTokenRequest{
+		ChannelIds: []string{"orders.1"},
+		Secret:     stack.AccessSecret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stack.Upstream.Push("orders.1", map[string]interface{}{"type": "created"})
+	stack.Upstream.Push("orders.1", map[string]interface{}{"type": "paid"})
+
+	resp, err := client.GetUpdates(ctx, &longpollv1.GetUpdatesRequest{Token: issued.Token, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireIDs(t, resp, 1, 2)
+	if resp.NextOffset != 3 {
+		t.Fatalf("expected next_offset 3, got %d", resp.NextOffset)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(resp.Events[1].Event, &payload); err != nil || payload["type"] != "paid" {
+		t.Fatalf("expected the JSON payload, got %s (%v)", resp.Events[1].Event, err)
+	}
+}
+
+func TestErrorsCarryTheAPICode(t *testing.T) {
+	stack := newStack(t)
+	client := newClient(t, stack)
+
+	var trailer metadata.MD
+	_, err := client.GetAccessToken(context.Background(), &longpollv1.GetAccessTokenRequest{
+		ChannelIds: []string{"orders.1"},
+		Secret:     "wrong",
+	}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+	if got := trailer.Get("error-code"); len(got) != 1 || got[0] != "unauthorized" {
+		t.Fatalf("expected error-code unauthorized, got %v", got)
+	}
+
+	_, err = client.GetUpdates(context.Background(), &longpollv1.GetUpdatesRequest{Token: "invalid"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestStreamUpdatesResumesFromDeliveredOffsets(t *testing.T) {
+	stack := newStack(t)
+	client := newClient(t, stack)
+	token, err := stack.Token("orders.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stack.Upstream.Push("orders.1", nil)
+	stream, err := client.StreamUpdates(ctx, &longpollv1.GetUpdatesRequest{Token: token, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireIDs(t, resp, 1)
+
+	// The stream outlives POLL_TIMEOUT and picks up the next event only
+	time.Sleep(300 * time.Millisecond)
+	event := stack.Upstream.Push("orders.1", nil)
+	stack.Broker.Notify("orders.1", event.ID)
+
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requireIDs(t, resp, 2)
+}
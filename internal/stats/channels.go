@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// idleRetention is how long an idle channel's counters are kept once the
+// number of tracked channels exceeds maxChannels
+const (
+	idleRetention = time.Hour
+	maxChannels   = 10000
+)
+
+// ChannelStats is a snapshot of a channel's activity on this instance
+type ChannelStats struct {
+	ChannelID        string  `json:"channel_id"`
+	EventsDelivered  int64   `json:"events_delivered"`
+	ActivePollers    int     `json:"active_pollers"`
+	CompletedPolls   int64   `json:"completed_polls"`
+	AvgWaitSeconds   float64 `json:"avg_wait_seconds"`
+	LastNotification int64   `json:"last_notification,omitempty"`
+	UpstreamFetches  int64   `json:"upstream_fetches"`
+	UpstreamFailures int64   `json:"upstream_failures"`
+}
+
+type channelStats struct {
+	delivered        int64
+	active           int
+	polls            int64
+	totalWait        time.Duration
+	lastNotification time.Time
+	fetches          int64
+	failures         int64
+	lastActivity     time.Time
+}
+
+// Recorder collects per-channel polling statistics in memory
+type Recorder struct {
+	mu       sync.Mutex
+	channels map[string]*channelStats
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		channels: make(map[string]*channelStats),
+	}
+}
+
+// PollStarted marks a poll on a channel as in flight
+func (r *Recorder) PollStarted(channelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(channelID).active++
+}
+
+// PollFinished records a completed poll and how long it waited
+func (r *Recorder) PollFinished(channelID string, wait time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(channelID)
+	s.active--
+	s.polls++
+	s.totalWait += wait
+}
+
+// Delivered records events returned to a poller
+func (r *Recorder) Delivered(channelID string, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(channelID).delivered += int64(count)
+}
+
+// Notified records a pub/sub notification for a channel
+func (r *Recorder) Notified(channelID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.get(channelID).lastNotification = time.Now()
+}
+
+// Fetched records an upstream fetch for a channel
+func (r *Recorder) Fetched(channelID string, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(channelID)
+	s.fetches++
+	if failed {
+		s.failures++
+	}
+}
+
+// Snapshot returns the statistics of a channel. Unknown channels report zeros.
+func (r *Recorder) Snapshot(channelID string) ChannelStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := ChannelStats{ChannelID: channelID}
+	s, ok := r.channels[channelID]
+	if !ok {
+		return snapshot
+	}
+
+	snapshot.EventsDelivered = s.delivered
+	snapshot.ActivePollers = s.active
+	snapshot.CompletedPolls = s.polls
+	snapshot.UpstreamFetches = s.fetches
+	snapshot.UpstreamFailures = s.failures
+	if s.polls > 0 {
+		snapshot.AvgWaitSeconds = (s.totalWait / time.Duration(s.polls)).Seconds()
+	}
+	if !s.lastNotification.IsZero() {
+		snapshot.LastNotification = s.lastNotification.Unix()
+	}
+	return snapshot
+}
+
+// get returns the stats of a channel, creating them if needed. Must be
+// called with the lock held.
+func (r *Recorder) get(channelID string) *channelStats {
+	now := time.Now()
+	s, ok := r.channels[channelID]
+	if !ok {
+		if len(r.channels) >= maxChannels {
+			r.prune(now)
+		}
+		s = &channelStats{}
+		r.channels[channelID] = s
+	}
+	s.lastActivity = now
+	return s
+}
+
+// prune drops channels without pollers that have been idle for a while
+func (r *Recorder) prune(now time.Time) {
+	for channelID, s := range r.channels {
+		if s.active == 0 && now.Sub(s.lastActivity) > idleRetention {
+			delete(r.channels, channelID)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package systemd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/logging"
+)
+
+// journalSocket receives entries in journald's native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+// JournalWriter sends records to journald with a PRIORITY mapped from their
+// level and SYSLOG_IDENTIFIER set to the identifier it was created with
+type JournalWriter struct {
+	identifier string
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+// DialJournal connects to the journal socket
+func DialJournal(identifier string) (*JournalWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalWriter{identifier: identifier, conn: conn}, nil
+}
+
+func (w *JournalWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(slog.LevelInfo, p)
+}
+
+func (w *JournalWriter) WriteLevel(level slog.Level, p []byte) (int, error) {
+	var entry bytes.Buffer
+	writeJournalField(&entry, "PRIORITY", []byte(strconv.Itoa(logging.Severity(level))))
+	writeJournalField(&entry, "SYSLOG_IDENTIFIER", []byte(w.identifier))
+	writeJournalField(&entry, "MESSAGE", bytes.TrimRight(p, "\n"))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.conn.Write(entry.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection
+func (w *JournalWriter) Close() error {
+	return w.conn.Close()
+}
+
+// writeJournalField appends a field, length-prefixing values spanning several
+// lines as the protocol requires
+func writeJournalField(b *bytes.Buffer, name string, value []byte) {
+	b.WriteString(name)
+	if !bytes.ContainsRune(value, '\n') {
+		b.WriteByte('=')
+		b.Write(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.Write(size[:])
+	b.Write(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,367 @@
+// Package client is a Go client for the long-polling server. It manages
+// tokens, offsets and retries and delivers events over a channel.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnauthorized is returned when the server rejects the token
+var ErrUnauthorized = errors.New("client: token rejected")
+
+// Event is an event delivered by the server
+type Event struct {
+	ID        int64                  `json:"id"`
+	ChannelID string                 `json:"channel_id,omitempty"`
+	Event     map[string]interface{} `json:"event"`
+	CreatedAt int64                  `json:"created_at"`
+}
+
+// TokenSource returns a token authorizing the polled channels. It is called
+// again after the server rejects the current token.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken returns a TokenSource that always returns token
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+// Options configures a Client
+type Options struct {
+	// BaseURL of the server, including HTTP_BASE_PATH if any
+	BaseURL string
+	Token   TokenSource
+
+	// Channels to poll; empty polls every channel of the token
+	Channels []string
+	ClientID string
+	// Offset to start from; the first event delivered has an ID >= Offset
+	Offset int64
+	Limit  int
+	// Wait asks the server to hold polls this long (0 uses the server default)
+	Wait time.Duration
+
+	HTTPClient *http.Client
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	Logger     *slog.Logger
+}
+
+// Client polls the server and keeps track of the offsets
+type Client struct {
+	opts       Options
+	httpClient *http.Client
+	logger     *slog.Logger
+
+	mu      sync.Mutex
+	token   string
+	offset  int64
+	offsets map[string]int64
+}
+
+// New creates a client
+func New(opts Options) (*Client, error) {
+	if opts.BaseURL == "" {
+		return nil, errors.New("client: BaseURL is required")
+	}
+	if opts.Token == nil {
+		return nil, errors.New("client: Token is required")
+	}
+	if opts.Limit < 1 {
+		opts.Limit = 100
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	opts.BaseURL = strings.TrimRight(opts.BaseURL, "/")
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	offsets := make(map[string]int64, len(opts.Channels))
+	for _, channelID := range opts.Channels {
+		offsets[channelID] = opts.Offset
+	}
+
+	return &Client{
+		opts:       opts,
+		httpClient: httpClient,
+		logger:     logger,
+		offset:     opts.Offset,
+		offsets:    offsets,
+	}, nil
+}
+
+// Offset returns the offset the next poll starts from
+func (c *Client) Offset() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.offset
+}
+
+// Events polls until ctx is done, delivering events in order on the
+// returned channel. Failed polls are retried with jittered exponential
+// backoff. The channel is closed when ctx is done.
+func (c *Client) Events(ctx context.Context) <-chan Event {
+	out := make(chan Event, c.opts.Limit)
+
+	go func() {
+		defer close(out)
+
+		backoff := c.opts.MinBackoff
+		for ctx.Err() == nil {
+			events, err := c.Poll(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				wait := backoff
+				var statusErr *StatusError
+				if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+					wait = statusErr.RetryAfter
+				}
+				wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+				c.logger.Warn("poll failed, retrying", "error", err, "retry_in", wait)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+				backoff *= 2
+				if backoff > c.opts.MaxBackoff {
+					backoff = c.opts.MaxBackoff
+				}
+				continue
+			}
+			backoff = c.opts.MinBackoff
+
+			for _, event := range events {
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// StatusError is returned for unexpected HTTP responses. Code is the
+// server's stable error code, e.g. "invalid_token", to branch on rather than
+// Message.
+type StatusError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("client: server responded %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("client: server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// errorResponse is the error object of a failed request
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// statusError builds the StatusError for a response and its decoded error
+func statusError(resp *http.Response, body *errorResponse) *StatusError {
+	statusErr := &StatusError{StatusCode: resp.StatusCode}
+	if body != nil {
+		statusErr.Code = body.Code
+		statusErr.Message = body.Message
+		statusErr.RequestID = body.RequestID
+	}
+	if statusErr.RequestID == "" {
+		statusErr.RequestID = resp.Header.Get("X-Request-ID")
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		statusErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return statusErr
+}
+
+type pollRequest struct {
+	Token    string           `json:"token"`
+	Channels []string         `json:"channels,omitempty"`
+	Offset   int64            `json:"offset"`
+	Offsets  map[string]int64 `json:"offsets,omitempty"`
+	Limit    int              `json:"limit"`
+	ClientID string           `json:"client_id,omitempty"`
+	Wait     *int             `json:"wait,omitempty"`
+}
+
+type pollResponse struct {
+	Events      []Event          `json:"events"`
+	NextOffset  int64            `json:"next_offset"`
+	NextOffsets map[string]int64 `json:"next_offsets"`
+	Error       *errorResponse   `json:"error"`
+}
+
+// Poll performs a single long poll and advances the offsets past the
+// returned events
+func (c *Client) Poll(ctx context.Context) ([]Event, error) {
+	token, err := c.currentToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	req := pollRequest{
+		Token:    token,
+		Channels: c.opts.Channels,
+		Offset:   c.offset,
+		Limit:    c.opts.Limit,
+		ClientID: c.opts.ClientID,
+	}
+	if len(c.opts.Channels) > 1 {
+		req.Offsets = make(map[string]int64, len(c.offsets))
+		for channelID, offset := range c.offsets {
+			req.Offsets[channelID] = offset
+		}
+	}
+	c.mu.Unlock()
+	if c.opts.Wait > 0 {
+		seconds := int(c.opts.Wait / time.Second)
+		req.Wait = &seconds
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.BaseURL+"/getUpdates", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var decoded pollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("client: invalid response: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		return nil, ErrUnauthorized
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, decoded.Error)
+	}
+
+	c.mu.Lock()
+	if decoded.NextOffset > c.offset {
+		c.offset = decoded.NextOffset
+	}
+	for channelID, offset := range decoded.NextOffsets {
+		if offset > c.offsets[channelID] {
+			c.offsets[channelID] = offset
+		}
+	}
+	c.mu.Unlock()
+
+	return decoded.Events, nil
+}
+
+// currentToken returns the cached token, asking the TokenSource if needed
+func (c *Client) currentToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	if token != "" {
+		return token, nil
+	}
+
+	token, err := c.opts.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("client: failed to get token: %w", err)
+	}
+
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+	return token, nil
+}
+
+// SecretTokenSource returns a TokenSource that issues tokens from the
+// server's /getAccessToken endpoint using the shared ACCESS_TOKEN_SECRET.
+// Only use it in trusted backend services.
+func SecretTokenSource(baseURL, secret string, httpClient *http.Client, channelIDs ...string) TokenSource {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	return func(ctx context.Context) (string, error) {
+		query := url.Values{}
+		query.Set("secret", secret)
+		for _, channelID := range channelIDs {
+			query.Add("channel_id", channelID)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/getAccessToken?"+query.Encode(), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		var body struct {
+			Token string         `json:"token"`
+			Error *errorResponse `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return "", fmt.Errorf("invalid getAccessToken response: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", statusError(resp, body.Error)
+		}
+		return body.Token, nil
+	}
+}
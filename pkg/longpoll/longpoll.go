@@ -0,0 +1,195 @@
+// Package longpoll embeds the long-polling engine into an existing Go
+// service. It wires the same components as the longpoll-server binary and
+// exposes them as an http.Handler.
+package longpoll
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/engine"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Options configures an embedded server. Zero values keep the value from
+// the environment when LoadEnv is set, or the binary's defaults otherwise.
+type Options struct {
+	// LoadEnv reads the same environment variables as the binary before
+	// applying the options below
+	LoadEnv bool
+
+	// Redis is used for notifications, bans and revocations. Required.
+	Redis *goredis.Client
+
+	LaravelAddr       string
+	AccessTokenSecret string
+	JWTSecret         string
+	AdminSecret       string
+	RedisChannel      string
+	ControlChannel    string
+	BasePath          string
+	PollTimeout       time.Duration
+	MaxLimit          int
+
+	// Per-channel and global caps on waiting polls
+	MaxPollersPerChannel int
+	MaxWaitingPolls      int
+
+	// Secrets accepted besides AccessTokenSecret and JWTSecret while they
+	// are rotated
+	AccessTokenSecretNext string
+	JWTSecretNext         string
+
+	// JWT signing keys as "kid:secret" entries, and the one signing new
+	// tokens. Like JWTSecretNext, they replace the environment's when
+	// JWTSecret is set.
+	JWTKeys      []string
+	JWTActiveKID string
+
+	// AppID scopes the server to one tenant: issued tokens carry it as the
+	// app_id claim and tokens of other apps are rejected
+	AppID string
+
+	// KeyPrefix namespaces the Redis keys of stored events, bans,
+	// revocations and idempotency records. Defaults to "longpoll:".
+	KeyPrefix string
+
+	// Logger receives the engine's logs. Logs are discarded when nil.
+	Logger *slog.Logger
+
+	// LogLevel, when set, is the level Logger filters by. It can then be
+	// read and changed with GET and PUT /admin/loglevel.
+	LogLevel *slog.LevelVar
+
+	// AccessLog receives access log lines with ACCESS_LOG_FORMAT=combined.
+	// Defaults to stdout.
+	AccessLog io.Writer
+}
+
+// Server is an embedded long-polling engine
+type Server struct {
+	engine *engine.Engine
+	logger *slog.Logger
+}
+
+// New wires an embedded server. Call Start to begin receiving notifications.
+func New(opts Options) (*Server, error) {
+	if opts.Redis == nil {
+		return nil, errors.New("longpoll: Redis client is required")
+	}
+
+	cfg := config.Defaults()
+	if opts.LoadEnv {
+		var err error
+		if cfg, err = config.Load(); err != nil {
+			return nil, err
+		}
+	}
+	applyOptions(cfg, opts)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	// Recordings are replayed onto a single REDIS_CHANNEL, so tenants can't
+	// share one
+	if opts.AppID != "" {
+		cfg.RecordSink = ""
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	eng, err := engine.New(cfg, engine.Options{
+		Redis:     opts.Redis,
+		KeyPrefix: opts.KeyPrefix,
+		AppID:     opts.AppID,
+		Logger:    logger,
+		LogLevel:  opts.LogLevel,
+		AccessLog: opts.AccessLog,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := eng.Load(context.Background()); err != nil {
+		return nil, err
+	}
+	return &Server{engine: eng, logger: logger}, nil
+}
+
+// applyOptions overrides the configuration with the options that are set
+func applyOptions(cfg *config.Config, opts Options) {
+	if opts.LaravelAddr != "" {
+		// Keep a derived authorization endpoint on the overridden upstream
+		if cfg.PrivateChannelAuthURL == strings.TrimRight(cfg.LaravelAddr, "/")+"/broadcasting/auth" {
+			cfg.PrivateChannelAuthURL = strings.TrimRight(opts.LaravelAddr, "/") + "/broadcasting/auth"
+		}
+		cfg.LaravelAddr = opts.LaravelAddr
+	}
+	// An overridden secret is rotated with its own next secret, if any
+	if opts.AccessTokenSecret != "" {
+		cfg.AccessTokenSecret = opts.AccessTokenSecret
+		cfg.AccessTokenSecretNext = opts.AccessTokenSecretNext
+	}
+	if opts.JWTSecret != "" {
+		cfg.JWTSecret = opts.JWTSecret
+		cfg.JWTSecretNext = opts.JWTSecretNext
+		cfg.JWTKeys = opts.JWTKeys
+		cfg.JWTActiveKID = opts.JWTActiveKID
+	}
+	if opts.AdminSecret != "" {
+		cfg.AdminSecret = opts.AdminSecret
+	}
+	if opts.RedisChannel != "" {
+		cfg.RedisChannel = opts.RedisChannel
+	}
+	if opts.ControlChannel != "" {
+		cfg.ControlChannel = opts.ControlChannel
+	}
+	if opts.BasePath != "" {
+		cfg.HTTPBasePath = opts.BasePath
+	}
+	if opts.PollTimeout > 0 {
+		if cfg.MaxPollTimeout < opts.PollTimeout {
+			cfg.MaxPollTimeout = opts.PollTimeout
+		}
+		cfg.PollTimeout = opts.PollTimeout
+	}
+	if opts.MaxLimit > 0 {
+		cfg.MaxLimit = opts.MaxLimit
+	}
+	if opts.MaxPollersPerChannel > 0 {
+		cfg.MaxPollersPerChannel = opts.MaxPollersPerChannel
+	}
+	if opts.MaxWaitingPolls > 0 {
+		cfg.MaxWaitingPolls = opts.MaxWaitingPolls
+	}
+}
+
+// Handler returns the HTTP handler serving every long-polling route
+func (s *Server) Handler() http.Handler {
+	return s.engine.Server.Handler()
+}
+
+// Start runs the notification subscriber, presence sweeper and sync,
+// revocation sync, retention janitor, affinity heartbeat and recording in the
+// background until Stop is called or ctx is done
+func (s *Server) Start(ctx context.Context) {
+	s.engine.Start(ctx)
+}
+
+// Stop stops the background loops and closes the recording and audit log.
+// Waiting polls are first sent a reconnect event. The Redis client is left
+// open.
+func (s *Server) Stop() {
+	s.engine.Stop()
+	if err := s.engine.Close(); err != nil {
+		s.logger.Error("failed to close recording or audit log", "error", err)
+	}
+}
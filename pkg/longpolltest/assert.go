@@ -0,0 +1,53 @@
+package longpolltest
+
+import (
+	"testing"
+	"time"
+)
+
+// RequireEventIDs fails the test unless the response delivered exactly the given event IDs, in order
+func RequireEventIDs(t testing.TB, resp *Response, ids ...int64) {
+	t.Helper()
+
+	if resp.Status != 200 {
+		t.Fatalf("longpolltest: expected status 200, got %d (%s)", resp.Status, resp.Error)
+	}
+
+	got := make([]int64, len(resp.Events))
+	for i, event := range resp.Events {
+		got[i] = event.ID
+	}
+
+	if len(got) != len(ids) {
+		t.Fatalf("longpolltest: expected events %v, got %v", ids, got)
+	}
+	for i := range ids {
+		if got[i] != ids[i] {
+			t.Fatalf("longpolltest: expected events %v, got %v", ids, got)
+		}
+	}
+}
+
+// RequireDelivery waits for a background poll to complete and returns its response
+func RequireDelivery(t testing.TB, ch <-chan *Response, timeout time.Duration) *Response {
+	t.Helper()
+
+	select {
+	case resp := <-ch:
+		return resp
+	case <-time.After(timeout):
+		t.Fatalf("longpolltest: no delivery within %s", timeout)
+		return nil
+	}
+}
+
+// RequireNoDelivery fails the test if a background poll completes within d
+func RequireNoDelivery(t testing.TB, ch <-chan *Response, d time.Duration) {
+	t.Helper()
+
+	select {
+	case resp := <-ch:
+		t.Fatalf("longpolltest: unexpected delivery of %d events", len(resp.Events))
+	case <-time.After(d):
+	}
+}
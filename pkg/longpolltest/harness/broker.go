@@ -0,0 +1,40 @@
+package harness
+
+import (
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/redis"
+)
+
+// Broker delivers notifications straight to the server's pollers,
+// standing in for Redis pub/sub
+type Broker struct {
+	subscriber *redis.Subscriber
+}
+
+// Notify wakes the pollers of a channel as if Laravel had published an event
+func (b *Broker) Notify(channelID string, eventID int64) {
+	b.subscriber.Dispatch(redis.EventNotification{
+		ChannelID: channelID,
+		EventID:   eventID,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// Push wakes the pollers of a channel with events carried in the
+// notification, as pushed to /internal/events. The events also fill the push
+// buffer when Options.PushBufferSize is set.
+func (b *Broker) Push(channelID string, events ...Event) {
+	var maxID int64
+	for _, event := range events {
+		if event.ID > maxID {
+			maxID = event.ID
+		}
+	}
+	b.subscriber.Dispatch(redis.EventNotification{
+		ChannelID: channelID,
+		EventID:   maxID,
+		Timestamp: time.Now().Unix(),
+		Events:    events,
+	})
+}
@@ -0,0 +1,30 @@
+package harness
+
+import "time"
+
+// Step is one event of a scripted sequence
+type Step struct {
+	// After is the delay since the previous step
+	After   time.Duration
+	Payload map[string]interface{}
+}
+
+// Play pushes the steps to the upstream in order and notifies the channel's
+// pollers after each one, like Laravel storing and publishing events. The
+// returned channel is closed once every step has been played.
+func (s *Stack) Play(channelID string, steps ...Step) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for _, step := range steps {
+			if step.After > 0 {
+				time.Sleep(step.After)
+			}
+			event := s.Upstream.Push(channelID, step.Payload)
+			s.Broker.Notify(channelID, event.ID)
+		}
+	}()
+
+	return done
+}
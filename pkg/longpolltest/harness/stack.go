@@ -0,0 +1,167 @@
+// Package harness wires the long-polling server against a scriptable Laravel
+// upstream and an in-memory notification broker. It doesn't depend on the
+// testing package, so it also backs the demo command; the test helpers are
+// in pkg/longpolltest.
+package harness
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/auth"
+	"github.com/levskiy0/go-laravel-long-polling/internal/config"
+	"github.com/levskiy0/go-laravel-long-polling/internal/engine"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Options configures the in-process server. Zero values get test-friendly defaults.
+type Options struct {
+	PollTimeout  time.Duration
+	BatchWait    time.Duration
+	MaxLimit     int
+	AccessSecret string
+	AdminSecret  string
+
+	// UpstreamURL points the server at an upstream the caller serves itself,
+	// e.g. by mounting Stack.Upstream on the same listener. When empty the
+	// upstream runs on its own loopback listener.
+	UpstreamURL string
+
+	// PushBufferSize enables the push buffer, filled by Broker.Push
+	PushBufferSize int
+
+	// ConsumerOffsets keeps the offsets of polls without one in Redis
+	ConsumerOffsets bool
+
+	// CORS settings, "*" without credentials by default
+	CORSAllowedOrigins   string
+	CORSAllowCredentials bool
+
+	// Redis backs idempotency keys, acks, bans and revocations. When nil a
+	// client that is never dialed is used, and those features fail.
+	Redis *goredis.Client
+
+	// Logger receives the server logs. Logs are discarded when nil.
+	Logger *slog.Logger
+}
+
+// Stack is the fully wired server without a listener. It backs
+// longpolltest.NewServer and can be mounted on any http.Server.
+type Stack struct {
+	Handler      http.Handler
+	Upstream     *Upstream
+	Broker       *Broker
+	AccessSecret string
+
+	jwtService *auth.JWTService
+	ownRedis   *goredis.Client
+}
+
+// NewStack wires the server against a scriptable upstream and an in-memory broker
+func NewStack(opts Options) (*Stack, error) {
+	if opts.PollTimeout == 0 {
+		opts.PollTimeout = 2 * time.Second
+	}
+	if opts.MaxLimit == 0 {
+		opts.MaxLimit = 100
+	}
+	if opts.AccessSecret == "" {
+		opts.AccessSecret = "longpolltest-access-secret"
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+
+	var upstream *Upstream
+	upstreamURL := opts.UpstreamURL
+	if upstreamURL == "" {
+		upstream = NewUpstream()
+		upstreamURL = upstream.URL()
+	} else {
+		upstream = NewUpstreamHandler()
+	}
+
+	cfg := config.Defaults()
+	cfg.GinMode = "release"
+	cfg.LaravelAddr = upstreamURL
+	cfg.AccessTokenSecret = opts.AccessSecret
+	cfg.JWTSecret = "longpolltest-jwt-secret"
+	cfg.AdminSecret = opts.AdminSecret
+	cfg.PollTimeout = opts.PollTimeout
+	cfg.MaxPollTimeout = opts.PollTimeout
+	cfg.BatchWait = opts.BatchWait
+	cfg.MaxLimit = opts.MaxLimit
+	cfg.KeepAliveInterval = 0
+	cfg.MaxPollersPerChannel = 0
+	cfg.MaxWaitingPolls = 0
+	cfg.RetryAfter = 0
+	cfg.MaxChannelIDLength = 0
+	cfg.RedisChannel = "longpolltest"
+	cfg.ControlChannel = "longpolltest:control"
+	cfg.PushBufferSize = opts.PushBufferSize
+	cfg.ConsumerOffsets = opts.ConsumerOffsets
+	cfg.CORSAllowedOrigins = "*"
+	if opts.CORSAllowedOrigins != "" {
+		cfg.CORSAllowedOrigins = opts.CORSAllowedOrigins
+	}
+	cfg.CORSAllowCredentials = opts.CORSAllowCredentials
+	cfg.CORSAllowedMethods = "GET,POST,OPTIONS"
+	cfg.CORSAllowedHeaders = "Content-Type,Authorization"
+
+	redisClient := opts.Redis
+	var ownRedis *goredis.Client
+	if redisClient == nil {
+		// Never dialed unless a feature backed by Redis is exercised
+		ownRedis = goredis.NewClient(&goredis.Options{Addr: "127.0.0.1:0"})
+		redisClient = ownRedis
+	}
+
+	eng, err := engine.New(cfg, engine.Options{
+		Redis:     redisClient,
+		KeyPrefix: "longpolltest:",
+		Logger:    logger,
+		AccessLog: io.Discard,
+	})
+	if err != nil {
+		if ownRedis != nil {
+			_ = ownRedis.Close()
+		}
+		return nil, err
+	}
+
+	return &Stack{
+		Handler:      eng.Server.Handler(),
+		Upstream:     upstream,
+		Broker:       &Broker{subscriber: eng.Subscriber},
+		AccessSecret: opts.AccessSecret,
+		jwtService:   eng.JWTService,
+		ownRedis:     ownRedis,
+	}, nil
+}
+
+// Token mints a token for the given channels
+func (s *Stack) Token(channelIDs ...string) (string, error) {
+	if len(channelIDs) == 1 {
+		return s.jwtService.GenerateToken(channelIDs[0])
+	}
+	return s.jwtService.GenerateMultiChannelToken(channelIDs)
+}
+
+// Subscriptions returns the number of polls waiting for a notification
+func (s *Stack) Subscriptions() int {
+	_, handlers := s.Broker.subscriber.Subscriptions()
+	return handlers
+}
+
+// Close releases the resources held by the stack. A Redis client passed in
+// Options is left open.
+func (s *Stack) Close() {
+	s.Upstream.Close()
+	if s.ownRedis != nil {
+		_ = s.ownRedis.Close()
+	}
+}
@@ -0,0 +1,178 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/internal/core"
+)
+
+// Event is an event as delivered to polling clients
+type Event = core.Event
+
+// Upstream is a scriptable stand-in for Laravel's /getEvents endpoint
+type Upstream struct {
+	server *http.Server
+	url    string
+
+	mu       sync.Mutex
+	events   map[string][]core.Event
+	nextID   int64
+	latency  time.Duration
+	scripted []scriptedResponse
+	requests int
+}
+
+// scriptedResponse replaces the next /getEvents response
+type scriptedResponse struct {
+	status      int
+	contentType string
+	body        string
+}
+
+// NewUpstream starts a fake Laravel upstream on a loopback listener. Like
+// httptest.NewServer, it panics when no port can be bound.
+func NewUpstream() *Upstream {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("harness: failed to listen on a port: %v", err))
+	}
+
+	u := NewUpstreamHandler()
+	u.server = &http.Server{Handler: u}
+	u.url = "http://" + listener.Addr().String()
+	go u.server.Serve(listener)
+	return u
+}
+
+// NewUpstreamHandler creates a fake Laravel upstream without a listener,
+// to be mounted at /api/long-polling/getEvents on an existing server
+func NewUpstreamHandler() *Upstream {
+	return &Upstream{
+		events: make(map[string][]core.Event),
+	}
+}
+
+// URL returns the base URL to configure as LARAVEL_ADDR, or an empty string
+// when the upstream has no listener of its own
+func (u *Upstream) URL() string {
+	return u.url
+}
+
+// Close shuts the upstream listener down
+func (u *Upstream) Close() {
+	if u.server != nil {
+		u.server.Close()
+	}
+}
+
+// Push appends an event with the next ID to a channel and returns it
+func (u *Upstream) Push(channelID string, payload map[string]interface{}) core.Event {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.nextID++
+	event := core.Event{
+		ID:        u.nextID,
+		Event:     payload,
+		CreatedAt: time.Now().Unix(),
+	}
+	u.events[channelID] = append(u.events[channelID], event)
+	return event
+}
+
+// SetLatency delays every response by d
+func (u *Upstream) SetLatency(d time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.latency = d
+}
+
+// FailNext makes the next requests fail with the given status codes, in order
+func (u *Upstream) FailNext(statuses ...int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, status := range statuses {
+		u.scripted = append(u.scripted, scriptedResponse{
+			status:      status,
+			contentType: "text/plain; charset=utf-8",
+			body:        http.StatusText(status),
+		})
+	}
+}
+
+// RespondNext makes the next request return the given raw response, e.g. an
+// HTML error page or truncated JSON with status 200
+func (u *Upstream) RespondNext(status int, contentType, body string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.scripted = append(u.scripted, scriptedResponse{
+		status:      status,
+		contentType: contentType,
+		body:        body,
+	})
+}
+
+// Requests returns how many /getEvents requests were served
+func (u *Upstream) Requests() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.requests
+}
+
+// ServeHTTP serves Laravel's /api/long-polling/getEvents endpoint
+func (u *Upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/long-polling/getEvents" {
+		http.NotFound(w, r)
+		return
+	}
+
+	u.mu.Lock()
+	u.requests++
+	latency := u.latency
+	var scripted *scriptedResponse
+	if len(u.scripted) > 0 {
+		scripted = &u.scripted[0]
+		u.scripted = u.scripted[1:]
+	}
+	u.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if scripted != nil {
+		w.Header().Set("Content-Type", scripted.contentType)
+		w.WriteHeader(scripted.status)
+		_, _ = w.Write([]byte(scripted.body))
+		return
+	}
+
+	query := r.URL.Query()
+	offset, _ := strconv.ParseInt(query.Get("offset"), 10, 64)
+	limit, _ := strconv.Atoi(query.Get("limit"))
+
+	u.mu.Lock()
+	events := make([]core.Event, 0)
+	for _, event := range u.events[query.Get("channel_id")] {
+		if event.ID >= offset && (limit <= 0 || len(events) < limit) {
+			events = append(events, event)
+		}
+	}
+	u.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(core.LaravelResponse{
+		Events: events,
+		Count:  len(events),
+	})
+}
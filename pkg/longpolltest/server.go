@@ -0,0 +1,152 @@
+// Package longpolltest runs the long-polling server in-process against a
+// scriptable Laravel upstream and an in-memory notification broker, so
+// integration tests don't need Laravel, Redis or Docker. The wiring itself
+// is in the harness subpackage, which doesn't link the testing package.
+package longpolltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/levskiy0/go-laravel-long-polling/pkg/longpolltest/harness"
+)
+
+type (
+	// Event is an event as delivered to polling clients
+	Event = harness.Event
+	// Options configures the in-process server
+	Options = harness.Options
+	// Step is one event of a scripted sequence
+	Step = harness.Step
+)
+
+// asyncPollWait bounds how long PollAsync waits for the poll to subscribe
+const asyncPollWait = 5 * time.Second
+
+// Server is an in-process long-polling server
+type Server struct {
+	*harness.Stack
+	URL string
+
+	httpServer *httptest.Server
+}
+
+// NewServer starts an in-process server. It is shut down when the test ends.
+func NewServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+
+	stack, err := harness.NewStack(opts)
+	if err != nil {
+		t.Fatalf("longpolltest: %v", err)
+	}
+	t.Cleanup(stack.Close)
+
+	httpServer := httptest.NewServer(stack.Handler)
+	t.Cleanup(httpServer.Close)
+
+	return &Server{
+		Stack:      stack,
+		URL:        httpServer.URL,
+		httpServer: httpServer,
+	}
+}
+
+// Token mints a token for the given channels
+func (s *Server) Token(t testing.TB, channelIDs ...string) string {
+	t.Helper()
+
+	token, err := s.Stack.Token(channelIDs...)
+	if err != nil {
+		t.Fatalf("longpolltest: failed to generate token: %v", err)
+	}
+	return token
+}
+
+// Response is a decoded /getUpdates response
+type Response struct {
+	Status      int              `json:"-"`
+	Events      []Event          `json:"events"`
+	NextOffset  int64            `json:"next_offset"`
+	NextOffsets map[string]int64 `json:"next_offsets"`
+	NextCursor  string           `json:"next_cursor"`
+	Error       *ErrorBody       `json:"error"`
+}
+
+// ErrorBody is the error object of a failed response
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+func (e *ErrorBody) String() string {
+	if e == nil {
+		return ""
+	}
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Poll performs a blocking GET /getUpdates
+func (s *Server) Poll(t testing.TB, token string, offset int64) *Response {
+	t.Helper()
+
+	resp, err := s.poll(token, offset)
+	if err != nil {
+		t.Fatalf("longpolltest: %v", err)
+	}
+	return resp
+}
+
+// PollAsync starts a poll in the background and returns a channel receiving
+// its response, once the poll is waiting for a notification or has already
+// completed. Transport failures are reported with Status 0 and Error set.
+func (s *Server) PollAsync(token string, offset int64) <-chan *Response {
+	waiting := s.Subscriptions()
+	ch := make(chan *Response, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := s.poll(token, offset)
+		if err != nil {
+			resp = &Response{Error: &ErrorBody{Message: err.Error()}}
+		}
+		ch <- resp
+	}()
+
+	deadline := time.Now().Add(asyncPollWait)
+	for s.Subscriptions() <= waiting && time.Now().Before(deadline) {
+		select {
+		case <-done:
+			return ch
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return ch
+}
+
+func (s *Server) poll(token string, offset int64) (*Response, error) {
+	query := url.Values{}
+	query.Set("token", token)
+	query.Set("offset", strconv.FormatInt(offset, 10))
+
+	resp, err := http.Get(s.URL + "/getUpdates?" + query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("poll failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	decoded := &Response{Status: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(decoded); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return decoded, nil
+}